@@ -0,0 +1,317 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+var (
+	errAccessDenied = errors.New("access denied")
+
+	// errRoleHandled is returned by checkRole when admin is already told about missing rights.
+	errRoleHandled = errors.New("admin role too low")
+)
+
+// saveOwner saves admin from config as owner.
+func (b *Bot) saveOwner(ctx context.Context) error {
+	err := b.storage.SaveAdmin(ctx, storage.Admin{
+		UID:       b.owner,
+		Role:      domain.AdminRoleOwner,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("owner not saved: %w", err)
+	}
+	return nil
+}
+
+// adminRole returns role of user, empty role if user is not admin.
+func (b *Bot) adminRole(ctx context.Context, uid int64) (domain.AdminRole, error) {
+	a, err := b.storage.GetAdmin(ctx, uid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("admin not received: %w", err)
+	}
+	return a.Role, nil
+}
+
+// senderRole returns role of user who sent update, in admin group it is checked by sender, not by chat.
+func (b *Bot) senderRole(c tele.Context) (domain.AdminRole, error) {
+	uid := c.Chat().ID
+	if c.Sender() != nil {
+		uid = c.Sender().ID
+	}
+
+	role, err := b.adminRole(stdContext(c), uid)
+	if err != nil || role != "" {
+		return role, err
+	}
+
+	return b.forumMemberRole(c)
+}
+
+// checkRole returns errAccessDenied if user is not admin with role r or higher.
+// Admins with lower role are told that they don't have rights.
+func (b *Bot) checkRole(c tele.Context, r domain.AdminRole) error {
+	role, err := b.senderRole(c)
+	if err != nil {
+		return err
+	}
+
+	if role.Can(r) {
+		return nil
+	}
+
+	if role == "" {
+		return errAccessDenied
+	}
+
+	if c.Callback() != nil {
+		if err := c.Respond(&tele.CallbackResponse{Text: "Недостаточно прав", ShowAlert: true}); err != nil {
+			return err
+		}
+	} else if err := c.Send("Недостаточно прав"); err != nil {
+		return err
+	}
+
+	return errRoleHandled
+}
+
+func (b *Bot) roleMiddleware(r domain.AdminRole) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			err := b.checkRole(c, r)
+			if errors.Is(err, errRoleHandled) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// notifyAdmins sends message to every admin who processes orders and returns sent messages,
+// oid is order the message is about or 0. If admin group is set message is sent to its topic instead.
+// Error is returned only if message is sent to nobody.
+func (b *Bot) notifyAdmins(ctx context.Context, ev event, oid domain.OrderID, what any, opts ...any) ([]storage.AdminMessage, error) {
+	if b.forum != nil {
+		m, err := b.sendToForum(ctx, ev, oid, what, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return []storage.AdminMessage{{ChatID: m.Chat.ID, MessageID: m.ID}}, nil
+	}
+
+	admins, err := b.storage.ListAdmins(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("admins not listed: %w", err)
+	}
+
+	var (
+		msgs []storage.AdminMessage
+		errs []error
+	)
+
+	for _, a := range admins {
+		if !a.Role.Can(domain.AdminRoleOperator) {
+			continue
+		}
+
+		m, err := b.tele.Send(recipient(a.UID), what, opts...)
+		if err != nil {
+			slog.WarnContext(ctx, "notification not sent to admin", "admin_id", a.UID, "cause", err.Error())
+			errs = append(errs, err)
+			continue
+		}
+
+		msgs = append(msgs, storage.AdminMessage{ChatID: m.Chat.ID, MessageID: m.ID})
+	}
+
+	if len(msgs) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return msgs, nil
+}
+
+// notifyAdminsAboutOrder sends message with order actions to admins and remembers it
+// to edit in every admin chat when one of admins processes the order.
+func (b *Bot) notifyAdminsAboutOrder(ctx context.Context, ev event, oid domain.OrderID, what any, opts ...any) error {
+	msgs, err := b.notifyAdmins(ctx, ev, oid, what, opts...)
+	if err != nil {
+		return err
+	}
+
+	return b.rememberOrderMessages(ctx, oid, msgs...)
+}
+
+func (b *Bot) rememberOrderMessages(ctx context.Context, oid domain.OrderID, msgs ...storage.AdminMessage) error {
+	if err := b.storage.SaveAdminMessages(ctx, oid, msgs); err != nil {
+		return fmt.Errorf("admin messages not saved: %w", err)
+	}
+	return nil
+}
+
+// editOrderMessages edits message in which order is processed and
+// the same messages about the order in chats of other admins.
+func (b *Bot) editOrderMessages(c tele.Context, ctx context.Context, oid domain.OrderID, what any, opts ...any) error {
+	if err := c.Edit(what, opts...); err != nil {
+		return err
+	}
+
+	return b.editAdminMessages(ctx, oid, c.Message(), what, opts...)
+}
+
+// editAdminMessages edits messages about order in chats of admins except already edited one, which may be nil.
+func (b *Bot) editAdminMessages(ctx context.Context, oid domain.OrderID, edited *tele.Message, what any, opts ...any) error {
+	msgs, err := b.storage.PopAdminMessages(ctx, oid)
+	if err != nil {
+		return fmt.Errorf("admin messages not received: %w", err)
+	}
+
+	for _, m := range msgs {
+		if edited != nil && edited.ID == m.MessageID && edited.Chat.ID == m.ChatID {
+			continue
+		}
+
+		stored := tele.StoredMessage{MessageID: strconv.Itoa(m.MessageID), ChatID: m.ChatID}
+
+		if _, err := b.tele.Edit(stored, what, opts...); err != nil {
+			slog.WarnContext(ctx, "order msg not edited in admin chat", "admin_id", m.ChatID, "cause", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// handleAdmins lists admins or manages them: /admins add <id|@username> <role>, /admins remove <id|@username>.
+func (b *Bot) handleAdmins(c tele.Context) error {
+	args := c.Args()
+	ctx := stdContext(c)
+
+	if len(args) == 0 {
+		return b.sendAdmins(c, ctx)
+	}
+
+	usage := "Использование:\n/admins - список админов\n/admins add <id или @username> <owner|operator|viewer>\n/admins remove <id или @username>"
+
+	switch {
+	case args[0] == "add" && len(args) == 3:
+		role, err := domain.ParseAdminRole(args[2])
+		if err != nil {
+			return c.Send(usage)
+		}
+		return b.addAdmin(c, ctx, args[1], role)
+	case args[0] == "remove" && len(args) == 2:
+		return b.removeAdmin(c, ctx, args[1])
+	default:
+		return c.Send(usage)
+	}
+}
+
+func (b *Bot) sendAdmins(c tele.Context, ctx context.Context) error {
+	admins, err := b.storage.ListAdmins(ctx)
+	if err != nil {
+		return fmt.Errorf("admins not listed: %w", err)
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString("Админы:\n")
+
+	for _, a := range admins {
+		fmt.Fprintf(sb, "\n%d", a.UID)
+
+		if a.Username != "" {
+			fmt.Fprintf(sb, " @%s", a.Username)
+		}
+
+		fmt.Fprintf(sb, " - %s", a.Role)
+	}
+
+	return c.Send(sb.String())
+}
+
+// findUser returns id and username of user by id or @username.
+func (b *Bot) findUser(ctx context.Context, arg string) (int64, string, error) {
+	if uid, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		return uid, "", nil
+	}
+
+	username := strings.TrimPrefix(arg, "@")
+
+	uid, err := b.storage.FindUID(ctx, username)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return uid, username, nil
+}
+
+func (b *Bot) addAdmin(c tele.Context, ctx context.Context, arg string, role domain.AdminRole) error {
+	uid, username, err := b.findUser(ctx, arg)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Send(fmt.Sprintf("Пользователь %s не найден, укажи его ID", arg))
+	}
+	if err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+
+	if uid == b.owner {
+		return c.Send("Нельзя изменить роль владельца из настроек бота")
+	}
+
+	err = b.storage.SaveAdmin(ctx, storage.Admin{
+		UID:       uid,
+		Username:  username,
+		Role:      role,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("admin not saved: %w", err)
+	}
+
+	slog.InfoContext(ctx, "admin saved", "admin_id", uid, "role", role)
+
+	if _, err := b.tele.Send(recipient(uid), fmt.Sprintf("Тебе выдана роль админа %s", role)); err != nil {
+		slog.WarnContext(ctx, "role msg not sent to admin", "admin_id", uid, "cause", err.Error())
+	}
+
+	return c.Send(fmt.Sprintf("Пользователь %d теперь %s", uid, role))
+}
+
+func (b *Bot) removeAdmin(c tele.Context, ctx context.Context, arg string) error {
+	uid, _, err := b.findUser(ctx, arg)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Send(fmt.Sprintf("Пользователь %s не найден, укажи его ID", arg))
+	}
+	if err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+
+	if uid == b.owner {
+		return c.Send("Нельзя удалить владельца из настроек бота")
+	}
+
+	if err := b.storage.DeleteAdmin(ctx, uid); err != nil {
+		return fmt.Errorf("admin not deleted: %w", err)
+	}
+
+	slog.InfoContext(ctx, "admin deleted", "admin_id", uid)
+
+	return c.Send(fmt.Sprintf("Пользователь %d больше не админ", uid))
+}
@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const (
+	testOperatorID = 2
+	testViewerID   = 3
+)
+
+// withAdmins adds operator and viewer to admins.
+func (e *testEnv) withAdmins(t *testing.T) {
+	t.Helper()
+
+	for uid, role := range map[int64]domain.AdminRole{
+		testOperatorID: domain.AdminRoleOperator,
+		testViewerID:   domain.AdminRoleViewer,
+	} {
+		err := e.store.SaveAdmin(context.Background(), storage.Admin{UID: uid, Role: role, CreatedAt: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOwnerSaved(t *testing.T) {
+	env := newTestEnv(t)
+
+	a, err := env.store.GetAdmin(context.Background(), testAdminID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Role != domain.AdminRoleOwner {
+		t.Errorf("role = %q, want %q", a.Role, domain.AdminRoleOwner)
+	}
+}
+
+func TestNotifyAdminsAboutOrder(t *testing.T) {
+	env := newTestEnv(t)
+	env.withAdmins(t)
+
+	env.order(t, 1)
+
+	for _, uid := range []int64{testAdminID, testOperatorID} {
+		if env.sent("sendMessage", uid) != 1 {
+			t.Errorf("order msg not sent to admin %d", uid)
+		}
+	}
+
+	if env.sent("sendMessage", testViewerID) != 0 {
+		t.Error("order msg sent to viewer")
+	}
+}
+
+func TestOperatorApprovesOrder(t *testing.T) {
+	env := newTestEnv(t)
+	env.withAdmins(t)
+
+	oid := env.order(t, 1)
+
+	if err := env.bot.handleCallback(env.callback(testOperatorID, stepApproveOrder, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusApproved {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusApproved)
+	}
+
+	ownerMsg := env.tg.Calls("sendMessage", testAdminID)[0]
+
+	edits := env.tg.Calls("editMessageText", testAdminID)
+	if len(edits) != 1 || edits[0].MessageID != ownerMsg.MessageID {
+		t.Fatal("order msg not edited in owner chat")
+	}
+
+	if edits[0].Markup != nil {
+		t.Error("approve buttons left in owner chat")
+	}
+}
+
+func TestViewerCantApproveOrder(t *testing.T) {
+	env := newTestEnv(t)
+	env.withAdmins(t)
+
+	oid := env.order(t, 1)
+
+	if err := env.bot.handleCallback(env.callback(testViewerID, stepApproveOrder, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusAwaitingPayment {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusAwaitingPayment)
+	}
+
+	if len(env.outline.Keys()) != 0 {
+		t.Error("keys created by viewer")
+	}
+
+	// callback answers have no chat
+	if env.sent("answerCallbackQuery", 0) != 1 {
+		t.Error("viewer not told about missing rights")
+	}
+}
+
+func TestUserCantApproveOrder(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.order(t, 1)
+
+	if err := env.bot.handleCallback(env.callback(testUserID, stepApproveOrder, oid.String())); err == nil {
+		t.Fatal("user approved order")
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusAwaitingPayment {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusAwaitingPayment)
+	}
+}
+
+func TestManageAdmins(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	env.oldOrder(t, time.Hour)
+
+	if err := env.bot.handleAdmins(env.command(testAdminID, "/admins add @test_user operator")); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := env.store.GetAdmin(ctx, testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Role != domain.AdminRoleOperator || a.Username != "test_user" {
+		t.Errorf("admin = %+v", a)
+	}
+
+	if env.lastText(t, testUserID) != "Тебе выдана роль админа operator" {
+		t.Error("new admin not notified")
+	}
+
+	if err := env.bot.handleAdmins(env.command(testAdminID, "/admins remove 1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.store.GetAdmin(ctx, testAdminID); err != nil {
+		t.Error("owner removed")
+	}
+
+	if err := env.bot.handleAdmins(env.command(testAdminID, "/admins remove @test_user")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.store.GetAdmin(ctx, testUserID); err != storage.ErrNotFound {
+		t.Errorf("admin not removed: %v", err)
+	}
+}
+
+func TestOperatorCantManageAdmins(t *testing.T) {
+	env := newTestEnv(t)
+	env.withAdmins(t)
+
+	mw := env.bot.roleMiddleware(domain.AdminRoleOwner)
+
+	err := mw(env.bot.handleAdmins)(env.command(testOperatorID, "/admins add 5 owner"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if env.lastText(t, testOperatorID) != "Недостаточно прав" {
+		t.Error("operator not told about missing rights")
+	}
+
+	if _, err := env.store.GetAdmin(context.Background(), 5); err != storage.ErrNotFound {
+		t.Errorf("admin added by operator: %v", err)
+	}
+}
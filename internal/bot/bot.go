@@ -1,20 +1,26 @@
 package bot
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/golang-lru/v2/expirable"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 	tele "gopkg.in/telebot.v3"
 	"gopkg.in/telebot.v3/middleware"
 
 	"github.com/ysomad/outline-bot/internal/config"
 	"github.com/ysomad/outline-bot/internal/domain"
-	"github.com/ysomad/outline-bot/internal/outline"
+	"github.com/ysomad/outline-bot/internal/provisioner"
 	"github.com/ysomad/outline-bot/internal/storage"
 )
 
@@ -24,33 +30,92 @@ const (
 )
 
 type Bot struct {
-	tele    *tele.Bot
-	adminID int64
-	state   *expirable.LRU[string, State]
-	outline *outline.Client
-	storage *storage.Storage
+	tele     *tele.Bot
+	owner    int64 // admin from config, can't be removed
+	state    *expirable.LRU[string, State]
+	backends provisioner.Backends
+	backend  domain.Backend // new orders are provisioned on it
+	storage  storage.Storage
+	webhook  *webhook // nil if updates are received with long polling
+	forum    *forum   // nil if admins are notified in private chats
+	tracer   trace.Tracer
+	metrics  *metrics
+	reporter *errorReporter
+
+	broadcasting atomic.Bool // true while broadcast is sent
+
+	inviteOnly     bool // only members may order keys
+	invitesPerUser int
+	referralCredit int // 0 if referrals are disabled
+
+	trialTTL       time.Duration // 0 if trials are disabled
+	trialDataLimit int64         // bytes, 0 if unlimited
 }
 
-func New(conf config.TG, state *expirable.LRU[string, State], outline *outline.Client, storage *storage.Storage) (b *Bot, err error) {
+func New(conf config.TG, state *expirable.LRU[string, State], backends provisioner.Backends, backend domain.Backend, storage storage.Storage) (b *Bot, err error) {
+	if _, err = backends.Get(backend); err != nil {
+		return nil, err
+	}
+
 	b = &Bot{
-		adminID: conf.Admin,
-		storage: storage,
-		outline: outline,
-		state:   state,
+		owner:    conf.Admin,
+		storage:  storage,
+		backends: backends,
+		backend:  backend,
+		state:    state,
+		tracer:   otel.Tracer(instrumentationName),
+		reporter: newErrorReporter(),
+
+		inviteOnly:     conf.InviteOnly,
+		invitesPerUser: conf.InvitesPerUser,
+		referralCredit: conf.ReferralCredit,
+
+		trialTTL:       conf.TrialTTL,
+		trialDataLimit: conf.TrialDataLimit * gigabyte,
+	}
+
+	b.metrics, err = newMetrics(otel.Meter(instrumentationName), storage)
+	if err != nil {
+		return nil, fmt.Errorf("metrics not created: %w", err)
+	}
+
+	b.forum, err = newForum(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = b.saveOwner(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if err = b.loadKeyPrefix(context.Background()); err != nil {
+		return nil, err
+	}
+
+	var poller tele.Poller = &tele.LongPoller{Timeout: conf.PollerTimeout}
+
+	if conf.Webhook.URL != "" {
+		b.webhook, err = newWebhook(conf.Webhook)
+		if err != nil {
+			return nil, err
+		}
+
+		poller = b.webhook
 	}
 
 	b.tele, err = tele.NewBot(tele.Settings{
+		URL:     conf.APIURL,
 		Token:   conf.Token,
 		OnError: b.handleError,
 		Client:  &http.Client{Timeout: conf.HTTPTimeout},
-		Poller:  &tele.LongPoller{Timeout: conf.PollerTimeout},
+		Poller:  poller,
 		Verbose: conf.Verbose,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("telebot not created: %w", err)
 	}
 
-	err = b.tele.SetCommands([]tele.Command{
+	cmds := []tele.Command{
 		{
 			Text:        "order",
 			Description: "Разместить заказ на оплату",
@@ -59,30 +124,121 @@ func New(conf config.TG, state *expirable.LRU[string, State], outline *outline.C
 			Text:        "profile",
 			Description: "Узнать статус подписки",
 		},
-	})
-	if err != nil {
+		{
+			Text:        "support",
+			Description: "Написать в поддержку",
+		},
+	}
+
+	if b.trialTTL > 0 {
+		cmds = append(cmds, tele.Command{Text: "trial", Description: "Попробовать бесплатно"})
+	}
+
+	if b.inviteOnly {
+		cmds = append(cmds, tele.Command{Text: "invite", Description: "Пригласить друга"})
+	}
+
+	if err = b.tele.SetCommands(cmds); err != nil {
 		return nil, fmt.Errorf("telebot commands not set: %w", err)
 	}
 
+	// webhook is set before start, so bot doesn't run without updates,
+	// with long polling it's deleted, otherwise telegram rejects getUpdates
+	if b.webhook != nil {
+		if err = b.tele.SetWebhook(b.webhook.hook); err != nil {
+			return nil, fmt.Errorf("webhook not set: %w", err)
+		}
+
+		slog.Info("webhook set", "url", conf.Webhook.URL)
+	} else if err = b.tele.RemoveWebhook(); err != nil {
+		return nil, fmt.Errorf("webhook not deleted: %w", err)
+	}
+
 	b.tele.Use(middleware.Recover())
-	b.tele.Use(contextMiddleware())
+	b.tele.Use(traceMiddleware(b.tracer))
+	b.tele.Use(contextMiddleware(conf.UpdateTimeout))
+	b.tele.Use(banMiddleware(storage, b.owner))
+
+	users := b.tele.Group()
+	users.Use(privateMiddleware())
+	users.Handle("/start", b.handleStart)
+	users.Handle("/profile", b.handleProfile)
+	users.Handle("/support", b.handleSupport)
+
+	members := b.tele.Group()
+	members.Use(privateMiddleware(), b.memberMiddleware())
+	members.Handle("/order", b.handleOrder)
+	members.Handle("/invite", b.handleInvite)
+	members.Handle("/trial", b.handleTrial)
 
-	b.tele.Handle("/start", b.handleStart)
-	b.tele.Handle("/order", b.handleOrder)
-	b.tele.Handle("/profile", b.handleProfile)
 	b.tele.Handle(tele.OnCallback, b.handleCallback)
 	b.tele.Handle(tele.OnText, b.handleText)
-
-	adminOnly := b.tele.Group()
-	adminOnly.Use(adminMiddleware(b.adminID))
-	adminOnly.Handle("/renew", b.handleRenew)
-	adminOnly.Handle("/migrate", b.handleMigration)
+	b.tele.Handle(tele.OnMedia, b.handleMedia)
+
+	viewers := b.tele.Group()
+	viewers.Use(b.roleMiddleware(domain.AdminRoleViewer))
+	viewers.Handle("/stats", b.handleStats)
+	viewers.Handle("/user", b.handleUser)
+	viewers.Handle("/orders", b.handleOrders)
+	viewers.Handle("/tickets", b.handleTickets)
+	viewers.Handle("/invites", b.handleInvites)
+
+	operators := b.tele.Group()
+	operators.Use(b.roleMiddleware(domain.AdminRoleOperator))
+	operators.Handle("/renew", b.handleRenew)
+	operators.Handle("/migrate", b.handleMigration)
+	operators.Handle("/hostname", b.handleHostname)
+	operators.Handle("/prefix", b.handlePrefix)
+	operators.Handle("/pending", b.handlePending)
+	operators.Handle("/broadcast", b.handleBroadcast)
+	operators.Handle("/promo", b.handlePromo)
+	operators.Handle("/refund", b.handleRefund)
+
+	owners := b.tele.Group()
+	owners.Use(b.roleMiddleware(domain.AdminRoleOwner))
+	owners.Handle("/admins", b.handleAdmins)
 
 	return b, nil
 }
 
+// handleError reports error to admin and apologizes to user.
+// Commands and buttons of admins used by other users are ignored, they are not errors of the bot.
 func (b *Bot) handleError(err error, c tele.Context) {
-	slog.ErrorContext(stdContext(c), "unhandled error happen", "cause", err.Error())
+	ctx := stdContext(c)
+
+	if errors.Is(err, errAccessDenied) {
+		slog.DebugContext(ctx, "access denied")
+		return
+	}
+
+	where := "telebot"
+	if c != nil {
+		where = updateSpanName(c)
+	}
+
+	id := b.reportError(ctx, where, err)
+
+	slog.ErrorContext(ctx, "unhandled error happen", "cause", err.Error(), "correlation_id", id)
+	b.metrics.handlerErrors.Add(ctx, 1)
+
+	b.apologize(ctx, c, id)
+}
+
+// PingTelegram checks that telegram bot api is reachable and token is valid.
+func (b *Bot) PingTelegram(ctx context.Context) error {
+	errc := make(chan error, 1)
+
+	go func() {
+		_, err := b.tele.Raw("getMe", nil)
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (b *Bot) Start() {
@@ -104,43 +260,99 @@ func btnCancel(kb *tele.ReplyMarkup) tele.Btn {
 func (b *Bot) handleOrder(c tele.Context) error {
 	usr := newUser(c.Chat())
 
-	keys, err := b.storage.CountActiveKeys(usr.id)
+	ctx := stdContext(c)
+
+	keys, err := b.storage.CountActiveKeys(ctx, usr.id)
+	if err != nil {
+		return err
+	}
+
+	role, err := b.adminRole(ctx, usr.id)
 	if err != nil {
 		return err
 	}
 
-	if keys >= domain.MaxKeysPerUser && usr.id != b.adminID {
+	if keys >= domain.MaxKeysPerUser && role == "" {
 		return c.Send("У тебя уже слишком много ключей дружище, гуляй...")
 	}
 
+	b.state.Add(usr.ID(), State{step: stepSelectKeyAmount.String()})
+
+	return c.Send("Сколько ключей доступа к ВПНу хочешь?", keyAmountKeyboard(true))
+}
+
+// keyAmountKeyboard returns keyboard to select amount of keys in order, withPromo adds button to enter promo code.
+func keyAmountKeyboard(withPromo bool) *tele.ReplyMarkup {
 	step := stepSelectKeyAmount.String()
-	b.state.Add(usr.ID(), State{step: step})
 
 	kb := &tele.ReplyMarkup{}
-	kb.Inline(
+	rows := []tele.Row{
 		kb.Row(
 			kb.Data("1", step, "1"),
 			kb.Data("2", step, "2"),
 			kb.Data("3", step, "3")),
-		kb.Row(btnCancel(kb)),
-	)
+	}
+
+	if withPromo {
+		rows = append(rows, kb.Row(kb.Data("У меня есть промокод", stepEnterPromo.String())))
+	}
+
+	kb.Inline(append(rows, kb.Row(btnCancel(kb)))...)
 
-	return c.Send("Сколько ключей доступа к ВПНу хочешь?", kb)
+	return kb
 }
 
+// handleStart greets user, in invite only mode invite code is passed with deep link t.me/bot?start=<code>.
 func (b *Bot) handleStart(c tele.Context) error {
-	msg := "Это бот для доступа к ВПНу ДЛЯ СВОИХ \n\n/order - разместить заказ на доступ к ВПНу\n/profile - статус подписки\n\nКлиент ВПНа можно скачать тут - https://getoutline.org"
+	msg := "Это бот для доступа к ВПНу ДЛЯ СВОИХ \n\n/order - разместить заказ на доступ к ВПНу\n/profile - статус подписки"
+	payload := c.Message().Payload
+
+	if referrerID, ok := parseReferral(payload); ok {
+		if err := b.saveReferral(stdContext(c), newUser(c.Chat()), referrerID); err != nil {
+			return err
+		}
+
+		payload = ""
+	}
+
+	if b.inviteOnly {
+		admitted, err := b.admitUser(c, payload)
+		if err != nil || !admitted {
+			return err
+		}
+
+		msg += "\n/invite - пригласить друга"
+	}
+
+	// user receives broadcasts to all users even without orders, in invite only mode after admission
+	if err := b.storage.SaveUser(stdContext(c), c.Chat().ID, time.Now()); err != nil {
+		return fmt.Errorf("user not saved: %w", err)
+	}
+
+	msg += "\n\nКлиент ВПНа можно скачать тут - https://getoutline.org"
+
 	return c.Send(msg)
 }
 
 func (b *Bot) handleProfile(c tele.Context) error {
-	keys, err := b.storage.ListActiveUserKeys(c.Chat().ID)
+	ctx := stdContext(c)
+
+	keys, err := b.storage.ListActiveUserKeys(ctx, c.Chat().ID)
+	if err != nil {
+		return err
+	}
+
+	wallet, walletKb, err := b.walletMsg(ctx, c.Chat().ID)
 	if err != nil {
 		return err
 	}
 
 	if len(keys) == 0 {
-		return c.Send("У тебя нет активных ключей, используй /order для заказа")
+		if err := c.Send("У тебя нет активных ключей, используй /order для заказа"); err != nil {
+			return err
+		}
+
+		return c.Send(wallet, walletKb)
 	}
 
 	var (
@@ -176,7 +388,12 @@ func (b *Bot) handleProfile(c tele.Context) error {
 		}
 	}
 
-	return c.Send(sb.String(), tele.ModeMarkdown)
+	if err := c.Send(sb.String(), tele.ModeMarkdown); err != nil {
+		return err
+	}
+
+	// sent without markdown, referral link may contain underscores
+	return c.Send(wallet, walletKb)
 }
 
 func (b *Bot) handleRenew(c tele.Context) error {
@@ -192,24 +409,44 @@ func (b *Bot) handleRenew(c tele.Context) error {
 	}
 
 	oid := domain.OrderID(n)
+	ctx := stdContext(c)
+
+	order, err := b.storage.GetOrder(ctx, oid)
+	if err != nil {
+		return fmt.Errorf("order not found: %w", err)
+	}
+
+	trial, err := b.isTrialOrder(ctx, order)
+	if err != nil {
+		return err
+	}
 
-	if err = b.storage.RenewOrder(oid, domain.OrderTTL); err != nil {
+	if trial {
+		return c.Send(fmt.Sprintf(trialNotRenewedMsg, oid))
+	}
+
+	// part of price is paid with balance of user
+	credit, err := b.storage.RenewOrderWithCredit(ctx, oid, order.ExpiresAt.Time, domain.OrderTTL, false, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Send(fmt.Sprintf(orderNotRenewedMsg, oid))
+	}
+	if err != nil {
 		return fmt.Errorf("order not renewed: %w", err)
 	}
 
-	order, err := b.storage.GetOrder(oid)
+	order, err = b.storage.GetOrder(ctx, oid)
 	if err != nil {
 		return fmt.Errorf("order not found: %w", err)
 	}
 
-	ctx := stdContext(c)
 	ctx = withUser(ctx, order.UID, order.Username.String)
 
-	slog.InfoContext(ctx, "order renewed by admin", "order_id", oid)
+	slog.InfoContext(ctx, "order renewed by admin", "order_id", oid, "credit", credit)
 
-	sb := &strings.Builder{}
+	b.metrics.revenue.Add(ctx, int64(order.Price-credit), metric.WithAttributes(attrBackend(order.Backend)))
 
-	fmt.Fprintf(sb, "Заказ №%d продлен до %s\n\nКлючей %d шт.\nОплачено %d руб.", order.ID, order.ExpiresAt.Time.Format("02.01.2006"), order.KeyAmount, order.Price)
+	sb := &strings.Builder{}
+	writeRenewedOrder(sb, order, credit)
 
 	if _, err = b.tele.Send(recipient(order.UID), sb.String()); err != nil {
 		return fmt.Errorf("renew msg not sent to user: %w", err)
@@ -234,3 +471,117 @@ func (b *Bot) handleMigration(c tele.Context) error {
 	b.state.Add(usr.ID(), State{step: stepMigrateKeys.String()})
 	return c.Send("Отправь мне новый Management API URL из Outline Manager")
 }
+
+func (b *Bot) handleHostname(c tele.Context) error {
+	usr := newUser(c.Chat())
+	b.state.Add(usr.ID(), State{step: stepChangeHostname.String()})
+	return c.Send("Отправь мне новый хостнейм или IP для ключей доступа")
+}
+
+// outlineBackend returns outline backend for outline specific admin flows.
+func (b *Bot) outlineBackend() (*provisioner.Outline, error) {
+	backend, err := b.backends.Get(domain.BackendOutline)
+	if err != nil {
+		return nil, err
+	}
+
+	ol, ok := backend.(*provisioner.Outline)
+	if !ok {
+		return nil, errors.New("unexpected outline backend")
+	}
+
+	return ol, nil
+}
+
+// handlePrefix sets new key prefix and reissues all active outline keys with it.
+// Prefix is removed from keys if called without args.
+func (b *Bot) handlePrefix(c tele.Context) error {
+	args := c.Args()
+
+	if len(args) > 1 {
+		return errors.New("/prefix - invalid args")
+	}
+
+	var prefix string
+
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	ol, err := b.outlineBackend()
+	if err != nil {
+		return err
+	}
+
+	if err := domain.ValidateKeyPrefix(prefix); err != nil {
+		return c.Send(err.Error())
+	}
+
+	ctx := stdContext(c)
+
+	if err := b.storage.SaveKeyPrefix(ctx, domain.BackendOutline, prefix, time.Now()); err != nil {
+		return fmt.Errorf("key prefix not saved: %w", err)
+	}
+
+	if err := ol.SetPrefix(prefix); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "key prefix changed", "prefix", prefix)
+
+	keys, err := b.storage.AllActiveKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("active keys not listed: %w", err)
+	}
+
+	updated := newUpdatedKeys()
+
+	for _, k := range keys {
+		if k.Backend != domain.BackendOutline {
+			continue
+		}
+
+		keyURL, err := ol.KeyURL(k.URL)
+		if err != nil {
+			return fmt.Errorf("key with id %s url not prefixed: %w", k.ID, err)
+		}
+
+		if keyURL == k.URL {
+			continue
+		}
+
+		if err := b.storage.UpdateKeyURL(ctx, k.ID, keyURL); err != nil {
+			return fmt.Errorf("key with id %s url not updated: %w", k.ID, err)
+		}
+
+		k.URL = keyURL
+		updated.add(k)
+	}
+
+	b.sendUpdatedKeys(ctx, updated, "Ключи по заказу №%d перевыпущены с новыми настройками маскировки трафика (до %s)\n")
+
+	return c.Send(fmt.Sprintf("Префикс ключей изменен на %q, обновлено заказов %d шт.", prefix, len(updated.oids)))
+}
+
+// loadKeyPrefix sets prefix of outline keys saved with /prefix, which overrides OUTLINE_KEY_PREFIX.
+func (b *Bot) loadKeyPrefix(ctx context.Context) error {
+	ol, err := b.outlineBackend()
+	if err != nil {
+		// outline is not configured
+		return nil
+	}
+
+	prefix, err := b.storage.GetKeyPrefix(ctx, domain.BackendOutline)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("key prefix not received: %w", err)
+	}
+
+	if err := ol.SetPrefix(prefix); err != nil {
+		return fmt.Errorf("saved key prefix: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,298 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const (
+	// broadcastRate is max amount of broadcast messages sent per second, telegram allows about 30.
+	broadcastRate = 20
+	// broadcastProgressStep is amount of sent messages after which progress is updated.
+	broadcastProgressStep = 100
+	// broadcastRetries is max amount of retries of message to user after flood wait.
+	broadcastRetries = 3
+)
+
+// broadcastExpiredDays are periods of expired subscriptions admin can choose segment by.
+var broadcastExpiredDays = []int{7, 30}
+
+// handleBroadcast starts composing of message sent to users.
+func (b *Bot) handleBroadcast(c tele.Context) error {
+	if b.broadcasting.Load() {
+		return c.Send("Рассылка уже идет, дождись отчета")
+	}
+
+	b.state.Add(newUser(c.Chat()).ID(), State{step: stepBroadcastMessage.String()})
+
+	kb := &tele.ReplyMarkup{}
+	kb.Inline(kb.Row(btnCancel(kb)))
+
+	return c.Send("Отправь текст, фото, видео или документ для рассылки", kb)
+}
+
+// handleMedia handles media sent to the bot, it is accepted by broadcast and relayed to admins by open ticket.
+func (b *Bot) handleMedia(c tele.Context) error {
+	if state, ok := b.state.Get(newUser(c.Chat()).ID()); ok {
+		switch step(state.step) {
+		case stepBroadcastMessage, stepBroadcastSegment:
+			return b.composeBroadcast(c)
+		}
+	}
+
+	if b.isForum(c) {
+		return nil
+	}
+
+	_, err := b.relayTicketMessage(c)
+
+	return err
+}
+
+// composeBroadcast remembers message to broadcast and asks admin to choose users receiving it.
+func (b *Bot) composeBroadcast(c tele.Context) error {
+	msg := c.Message()
+
+	b.state.Add(newUser(c.Chat()).ID(), State{
+		step: stepBroadcastSegment.String(),
+		data: tele.StoredMessage{MessageID: strconv.Itoa(msg.ID), ChatID: msg.Chat.ID},
+	})
+
+	kb := &tele.ReplyMarkup{}
+
+	rows := []tele.Row{
+		kb.Row(kb.Data("Все пользователи", stepBroadcastSegment.String(), "all")),
+		kb.Row(kb.Data("Активные подписчики", stepBroadcastSegment.String(), "active")),
+	}
+
+	var expired []tele.Btn
+
+	for _, days := range broadcastExpiredDays {
+		data := fmt.Sprintf("expired:%d", days)
+		expired = append(expired, kb.Data(fmt.Sprintf("Истекли за %d дн.", days), stepBroadcastSegment.String(), data))
+	}
+
+	rows = append(rows, kb.Row(expired...))
+
+	backends := make([]string, 0, len(b.backends))
+	for name := range b.backends {
+		backends = append(backends, string(name))
+	}
+
+	sort.Strings(backends)
+
+	for _, name := range backends {
+		rows = append(rows, kb.Row(kb.Data("Активные на "+name, stepBroadcastSegment.String(), "backend:"+name)))
+	}
+
+	rows = append(rows, kb.Row(btnCancel(kb)))
+	kb.Inline(rows...)
+
+	return c.Send("Кому отправить?", kb)
+}
+
+// parseSegment returns users segment and its description from callback data.
+func parseSegment(data string, now time.Time) (storage.UserSegment, string, error) {
+	seg := storage.UserSegment{Now: now}
+
+	kind, arg, _ := strings.Cut(data, ":")
+
+	switch kind {
+	case "all":
+		return seg, "все пользователи", nil
+	case "active":
+		seg.Active = true
+		return seg, "активные подписчики", nil
+	case "expired":
+		days, err := strconv.Atoi(arg)
+		if err != nil {
+			return seg, "", fmt.Errorf("days not found in segment: %w", err)
+		}
+
+		seg.ExpiredSince = now.AddDate(0, 0, -days)
+
+		return seg, fmt.Sprintf("подписка истекла за %d дн.", days), nil
+	case "backend":
+		seg.Backend = domain.Backend(arg)
+		return seg, "активные на " + arg, nil
+	default:
+		return seg, "", fmt.Errorf("unsupported segment: %s", data)
+	}
+}
+
+// broadcastDraft returns message to broadcast from state of admin.
+func (b *Bot) broadcastDraft(c tele.Context) (tele.StoredMessage, bool) {
+	state, ok := b.state.Get(newUser(c.Chat()).ID())
+	if !ok {
+		return tele.StoredMessage{}, false
+	}
+
+	msg, ok := state.data.(tele.StoredMessage)
+
+	return msg, ok
+}
+
+// previewBroadcast triggers after admin chose segment, sends message as users will see it and asks to confirm.
+func (b *Bot) previewBroadcast(c tele.Context, ctx context.Context, cb btnCallback) error {
+	draft, ok := b.broadcastDraft(c)
+	if !ok {
+		return c.Edit("Рассылка устарела, начни заново с /broadcast")
+	}
+
+	seg, name, err := parseSegment(cb.data, time.Now())
+	if err != nil {
+		return err
+	}
+
+	uids, err := b.storage.ListUserIDs(ctx, seg)
+	if err != nil {
+		return fmt.Errorf("users not listed: %w", err)
+	}
+
+	if err := c.Edit("Получатели: " + name); err != nil {
+		return err
+	}
+
+	if _, err := b.tele.Copy(c.Chat(), draft); err != nil {
+		return fmt.Errorf("broadcast preview not sent: %w", err)
+	}
+
+	kb := &tele.ReplyMarkup{}
+	kb.Inline(kb.Row(
+		kb.Data("Отправить", stepBroadcastSend.String(), cb.data),
+		btnCancel(kb),
+	))
+
+	return c.Send(fmt.Sprintf("Сообщение выше получат %d чел. (%s). Отправить?", len(uids), name), kb)
+}
+
+// startBroadcast triggers after admin confirmed broadcast, messages are sent in background.
+func (b *Bot) startBroadcast(c tele.Context, ctx context.Context, cb btnCallback) error {
+	draft, ok := b.broadcastDraft(c)
+	if !ok {
+		return c.Edit("Рассылка устарела, начни заново с /broadcast")
+	}
+
+	if !b.broadcasting.CompareAndSwap(false, true) {
+		return c.Edit("Рассылка уже идет, дождись отчета")
+	}
+
+	seg, _, err := parseSegment(cb.data, time.Now())
+	if err != nil {
+		b.broadcasting.Store(false)
+		return err
+	}
+
+	uids, err := b.storage.ListUserIDs(ctx, seg)
+	if err != nil {
+		b.broadcasting.Store(false)
+		return fmt.Errorf("users not listed: %w", err)
+	}
+
+	b.state.Remove(newUser(c.Chat()).ID())
+
+	if err := c.Edit(fmt.Sprintf("Рассылка началась, отправлено 0 из %d", len(uids))); err != nil {
+		b.broadcasting.Store(false)
+		return err
+	}
+
+	slog.InfoContext(ctx, "broadcast started", "segment", cb.data, "users", len(uids))
+
+	go func() {
+		defer b.broadcasting.Store(false)
+		b.broadcast(context.WithoutCancel(ctx), draft, uids, c.Message())
+	}()
+
+	return nil
+}
+
+type broadcastReport struct {
+	delivered int
+	failed    int
+	blocked   int
+}
+
+func (r broadcastReport) String() string {
+	return fmt.Sprintf("доставлено %d, ошибок %d, заблокировали бота %d", r.delivered, r.failed, r.blocked)
+}
+
+// broadcast copies message to users with rate limit, updates progress in status message and reports result to admin.
+func (b *Bot) broadcast(ctx context.Context, msg tele.StoredMessage, uids []int64, status *tele.Message) {
+	tick := time.NewTicker(time.Second / broadcastRate)
+	defer tick.Stop()
+
+	r := broadcastReport{}
+
+	for i, uid := range uids {
+		<-tick.C
+
+		err := b.copyBroadcast(ctx, uid, msg)
+
+		switch {
+		case err == nil:
+			r.delivered++
+		case isBlockedErr(err):
+			r.blocked++
+		default:
+			r.failed++
+			slog.WarnContext(ctx, "broadcast msg not sent", "uid", uid, "cause", err.Error())
+		}
+
+		if sent := i + 1; sent%broadcastProgressStep == 0 && sent < len(uids) {
+			progress := fmt.Sprintf("Рассылка идет, отправлено %d из %d: %s", sent, len(uids), r)
+
+			if _, err := b.tele.Edit(status, progress); err != nil {
+				slog.WarnContext(ctx, "broadcast progress not updated", "cause", err.Error())
+			}
+		}
+	}
+
+	slog.InfoContext(ctx, "broadcast finished", "delivered", r.delivered, "failed", r.failed, "blocked", r.blocked)
+
+	if _, err := b.tele.Edit(status, fmt.Sprintf("Рассылка завершена, отправлено %d из %d", len(uids), len(uids))); err != nil {
+		slog.WarnContext(ctx, "broadcast progress not updated", "cause", err.Error())
+	}
+
+	if _, err := b.tele.Send(status.Chat, "Отчет о рассылке: "+r.String()); err != nil {
+		slog.ErrorContext(ctx, "broadcast report not sent", "cause", err.Error())
+	}
+}
+
+// copyBroadcast copies message to user, when telegram asks to slow down it waits and retries.
+func (b *Bot) copyBroadcast(ctx context.Context, uid int64, msg tele.StoredMessage) error {
+	for i := 0; ; i++ {
+		_, err := b.tele.Copy(recipient(uid), msg)
+
+		var flood tele.FloodError
+		if !errors.As(err, &flood) || i == broadcastRetries {
+			return err
+		}
+
+		slog.WarnContext(ctx, "broadcast flood wait", "uid", uid, "retry_after", flood.RetryAfter)
+
+		select {
+		case <-time.After(time.Duration(flood.RetryAfter) * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// isBlockedErr returns true if message can't be sent because user blocked the bot or deleted account.
+func isBlockedErr(err error) bool {
+	return errors.Is(err, tele.ErrBlockedByUser) ||
+		errors.Is(err, tele.ErrUserIsDeactivated) ||
+		errors.Is(err, tele.ErrNotStartedByUser) ||
+		errors.Is(err, tele.ErrChatNotFound)
+}
@@ -0,0 +1,182 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const (
+	testExpiredUserID = 101
+	testBannedUserID  = 102
+)
+
+// withBroadcastUsers adds user with active order, user with order expired yesterday and banned user.
+func (e *testEnv) withBroadcastUsers(t *testing.T) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	e.approvedOrder(t, 1)
+
+	for _, uid := range []int64{testExpiredUserID, testBannedUserID} {
+		oid, err := e.store.CreateOrder(ctx, storage.CreateOrderParams{
+			UID:       uid,
+			KeyAmount: 1,
+			Price:     domain.PricePerKey,
+			CreatedAt: time.Now().AddDate(0, -1, 0),
+			Status:    domain.OrderStatusExpired,
+			Backend:   domain.BackendOutline,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := e.store.CloseOrder(ctx, oid, domain.OrderStatusExpired, time.Now().AddDate(0, 0, -1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := e.store.BanUser(ctx, testBannedUserID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// composeBroadcast sends /broadcast and message to broadcast as admin.
+func (e *testEnv) composeBroadcast(t *testing.T) {
+	t.Helper()
+
+	if err := e.bot.handleBroadcast(e.command(testAdminID, "/broadcast")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.bot.handleText(e.text(testAdminID, "Сервер переезжает")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBroadcastSegments(t *testing.T) {
+	tests := []struct {
+		segment string
+		want    string
+	}{
+		{segment: "all", want: "Сообщение выше получат 2 чел. (все пользователи). Отправить?"},
+		{segment: "active", want: "Сообщение выше получат 1 чел. (активные подписчики). Отправить?"},
+		{segment: "expired:7", want: "Сообщение выше получат 1 чел. (подписка истекла за 7 дн.). Отправить?"},
+		{segment: "backend:outline", want: "Сообщение выше получат 1 чел. (активные на outline). Отправить?"},
+		{segment: "backend:wireguard", want: "Сообщение выше получат 0 чел. (активные на wireguard). Отправить?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.segment, func(t *testing.T) {
+			env := newTestEnv(t)
+			env.withBroadcastUsers(t)
+			env.composeBroadcast(t)
+
+			if err := env.bot.handleCallback(env.callback(testAdminID, stepBroadcastSegment, tt.segment)); err != nil {
+				t.Fatal(err)
+			}
+
+			if env.sent("copyMessage", testAdminID) != 1 {
+				t.Error("preview not sent to admin")
+			}
+
+			if got := env.lastText(t, testAdminID); got != tt.want {
+				t.Errorf("confirmation = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	env := newTestEnv(t)
+	env.withBroadcastUsers(t)
+	env.composeBroadcast(t)
+
+	env.tg.Block(testExpiredUserID)
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepBroadcastSegment, "all")); err != nil {
+		t.Fatal(err)
+	}
+
+	before := env.sent("sendMessage", testAdminID)
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepBroadcastSend, "all")); err != nil {
+		t.Fatal(err)
+	}
+
+	calls, err := env.tg.Wait("sendMessage", testAdminID, before+1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := calls[before].Text, "Отчет о рассылке: доставлено 1, ошибок 0, заблокировали бота 1"; got != want {
+		t.Errorf("report = %q, want %q", got, want)
+	}
+
+	if env.sent("copyMessage", testUserID) != 1 {
+		t.Error("broadcast not sent to active user")
+	}
+
+	if env.sent("copyMessage", testBannedUserID) != 0 {
+		t.Error("broadcast sent to banned user")
+	}
+
+	if _, ok := env.bot.state.Get(fmt.Sprint(testAdminID)); ok {
+		t.Error("broadcast state not removed")
+	}
+}
+
+func TestBroadcastFloodWait(t *testing.T) {
+	env := newTestEnv(t)
+	env.withBroadcastUsers(t)
+	env.composeBroadcast(t)
+
+	env.tg.Flood(testUserID, broadcastRetries)
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepBroadcastSegment, "all")); err != nil {
+		t.Fatal(err)
+	}
+
+	before := env.sent("sendMessage", testAdminID)
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepBroadcastSend, "all")); err != nil {
+		t.Fatal(err)
+	}
+
+	calls, err := env.tg.Wait("sendMessage", testAdminID, before+1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := calls[before].Text, "Отчет о рассылке: доставлено 2, ошибок 0, заблокировали бота 0"; got != want {
+		t.Errorf("report = %q, want %q", got, want)
+	}
+
+	if env.sent("copyMessage", testUserID) != 1 {
+		t.Error("broadcast not retried after flood wait")
+	}
+}
+
+func TestBroadcastStartedUser(t *testing.T) {
+	env := newTestEnv(t)
+
+	const startedUID = 200
+
+	if err := env.bot.handleStart(env.command(startedUID, "/start")); err != nil {
+		t.Fatal(err)
+	}
+
+	uids, err := env.store.ListUserIDs(context.Background(), storage.UserSegment{Now: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uids) != 1 || uids[0] != startedUID {
+		t.Errorf("users = %v, want user without orders who started the bot", uids)
+	}
+}
@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -10,10 +11,12 @@ import (
 	"time"
 
 	"github.com/goombaio/namegenerator"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	tele "gopkg.in/telebot.v3"
 
 	"github.com/ysomad/outline-bot/internal/domain"
-	"github.com/ysomad/outline-bot/internal/outline"
+	"github.com/ysomad/outline-bot/internal/provisioner"
 	"github.com/ysomad/outline-bot/internal/storage"
 )
 
@@ -36,6 +39,28 @@ func parseCallback(data string) (btnCallback, error) {
 	}
 }
 
+// stepRoles are admin roles required to press buttons of steps.
+var stepRoles = map[step]domain.AdminRole{
+	stepApproveOrder:       domain.AdminRoleOperator,
+	stepRejectOrder:        domain.AdminRoleOperator,
+	stepOrderRenewApproved: domain.AdminRoleOperator,
+	stepRejectOrderRenewal: domain.AdminRoleOperator,
+	stepUserExtend:         domain.AdminRoleOperator,
+	stepUserRevoke:         domain.AdminRoleOperator,
+	stepUserGrant:          domain.AdminRoleOperator,
+	stepUserMessage:        domain.AdminRoleOperator,
+	stepUserBan:            domain.AdminRoleOperator,
+	stepUserUnban:          domain.AdminRoleOperator,
+	stepOrdersPage:         domain.AdminRoleViewer,
+	stepBroadcastSegment:   domain.AdminRoleOperator,
+	stepBroadcastSend:      domain.AdminRoleOperator,
+	stepApproveJoin:        domain.AdminRoleOperator,
+	stepDenyJoin:           domain.AdminRoleOperator,
+	stepApproveTopUp:       domain.AdminRoleOperator,
+	stepRejectTopUp:        domain.AdminRoleOperator,
+	stepRefundOrder:        domain.AdminRoleOperator,
+}
+
 func (b *Bot) handleCallback(c tele.Context) error {
 	usr := newUser(c.Chat())
 	telecb := c.Callback()
@@ -47,19 +72,54 @@ func (b *Bot) handleCallback(c tele.Context) error {
 	}
 
 	ctx = withCallback(ctx, cb)
+	c.Set(ctxKey, ctx) // to report error with callback and order
 	now := time.Now()
 
 	slog.InfoContext(ctx, "callback received")
 
+	if role, ok := stepRoles[step(cb.unique)]; ok {
+		err := b.checkRole(c, role)
+		if errors.Is(err, errRoleHandled) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
 	switch step(cb.unique) {
 	case stepSelectKeyAmount:
 		return b.selectKeyAmount(c, ctx, cb, usr, now)
+	case stepEnterPromo:
+		return b.enterPromo(c)
 	case stepApproveOrder:
 		return b.approveOrder(c, ctx, cb)
 	case stepOrderRenewApproved:
 		return b.renewOrder(c, ctx, cb)
 	case stepRejectOrder, stepRejectOrderRenewal:
 		return b.rejectOrder(c, ctx, cb, now)
+	case stepUserExtend, stepUserRevoke, stepUserGrant, stepUserMessage, stepUserBan, stepUserUnban:
+		return b.handleUserAction(c, ctx, cb)
+	case stepOrdersPage:
+		return b.showOrdersPage(c, ctx, cb)
+	case stepBroadcastSegment:
+		return b.previewBroadcast(c, ctx, cb)
+	case stepBroadcastSend:
+		return b.startBroadcast(c, ctx, cb)
+	case stepCloseTicket:
+		return b.closeTicket(c, ctx, cb)
+	case stepApproveJoin, stepDenyJoin:
+		return b.processJoin(c, ctx, cb)
+	case stepTopUp:
+		return b.topUp(c, ctx, cb, usr)
+	case stepApproveTopUp, stepRejectTopUp:
+		return b.processTopUp(c, ctx, cb)
+	case stepAutoRenew:
+		return b.toggleAutoRenew(c, ctx, cb, usr)
+	case stepCancelOrder:
+		return b.cancelOrder(c, ctx, cb, usr)
+	case stepRefundOrder:
+		return b.refundOrder(c, ctx, cb)
 	case stepCancel:
 		if err := c.Delete(); err != nil {
 			return fmt.Errorf("step cancel: %w", err)
@@ -83,9 +143,17 @@ func (b *Bot) selectKeyAmount(c tele.Context, ctx context.Context, cb btnCallbac
 		return fmt.Errorf("msg not deleted: %w", err)
 	}
 
+	promo, err := b.orderPromo(c, ctx, usr)
+	if err != nil {
+		return err
+	}
+
+	b.state.Remove(usr.ID())
+
 	price := keyAmount * domain.PricePerKey
+	discount := promoDiscount(promo, price)
 
-	orderID, err := b.storage.CreateOrder(storage.CreateOrderParams{
+	orderID, err := b.storage.CreateOrder(ctx, storage.CreateOrderParams{
 		Status:    domain.OrderStatusAwaitingPayment,
 		UID:       usr.id,
 		Username:  usr.username,
@@ -94,57 +162,92 @@ func (b *Bot) selectKeyAmount(c tele.Context, ctx context.Context, cb btnCallbac
 		KeyAmount: keyAmount,
 		Price:     price,
 		CreatedAt: now,
+		Backend:   b.backend,
+		PromoCode: promo.Code,
+		Discount:  discount,
+		BonusDays: promo.Days,
 	})
 	if err != nil {
 		return fmt.Errorf("order not created: %w", err)
 	}
 
 	ctx = withOrderID(ctx, orderID)
+	c.Set(ctxKey, ctx)
 	slog.InfoContext(ctx, "order created by user")
 
-	adminKb := &tele.ReplyMarkup{}
-	adminKb.Inline(
-		adminKb.Row(adminKb.Data("Одобрить", stepApproveOrder.String(), orderID.String())),
-		adminKb.Row(adminKb.Data("Отклонить", stepRejectOrder.String(), orderID.String())),
-	)
+	b.metrics.ordersCreated.Add(ctx, 1, metric.WithAttributes(attrBackend(b.backend)))
 
-	_, err = b.tele.Send(recipient(b.adminID), orderCreatedMsg(orderID, price, keyAmount, usr), adminKb)
+	err = b.notifyAdminsAboutOrder(ctx, eventOrder, orderID, orderCreatedMsg(orderID, price-discount, keyAmount, promoNote(promo.Code, discount, promo.Days), usr), orderAdminKeyboard(orderID))
 	if err != nil {
 		return fmt.Errorf("order not sent to admin: %w", err)
 	}
 
+	if note := promoNote(promo.Code, discount, promo.Days); note != "" {
+		if err := c.Send(note); err != nil {
+			return fmt.Errorf("promo note not sent: %w", err)
+		}
+	}
+
 	qr := &tele.Photo{
-		Caption: fmt.Sprintf("Заказ №%d размещен, к оплате %d₽, оплата по QR коду или кнопке ниже. После оплаты админ одобрит заказ и я пришлю тебе ключи доступа к ВПНу", orderID, price),
+		Caption: fmt.Sprintf("Заказ №%d размещен, к оплате %d₽, оплата по QR коду или кнопке ниже. После оплаты админ одобрит заказ и я пришлю тебе ключи доступа к ВПНу", orderID, price-discount),
 		File:    tele.FromDisk(paymentQR),
 	}
 
-	return c.Send(qr, paymentKeyboard())
+	return c.Send(qr, orderPaymentKeyboard(orderID))
 }
 
 // renewOrder triggers when order renew approved.
 // Receives order id from callback, sets new expiration timestamp and returns it to user and admin.
 func (b *Bot) renewOrder(c tele.Context, ctx context.Context, cb btnCallback) error {
-	orderID, err := domain.OrderIDFromString(cb.data)
+	orderID, expiresAt, err := parseRenewalData(cb.data)
 	if err != nil {
-		return fmt.Errorf("order id not found in callback data: %w", err)
+		return err
 	}
 
 	ctx = withOrderID(ctx, orderID)
+	c.Set(ctxKey, ctx)
+
+	order, err := b.storage.GetOrder(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("order not found: %w", err)
+	}
+
+	trial, err := b.isTrialOrder(ctx, order)
+	if err != nil {
+		return err
+	}
+
+	if trial {
+		slog.InfoContext(ctx, "trial order not renewed")
+		return b.editOrderMessages(c, ctx, orderID, fmt.Sprintf(trialNotRenewedMsg, orderID))
+	}
+
+	// buttons sent before expiration was put into them renew current expiration
+	if expiresAt.IsZero() {
+		expiresAt = order.ExpiresAt.Time
+	}
 
-	if err = b.storage.RenewOrder(orderID, domain.OrderTTL); err != nil {
+	// part of price is paid with balance of user
+	credit, err := b.storage.RenewOrderWithCredit(ctx, orderID, expiresAt, domain.OrderTTL, false, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		slog.InfoContext(ctx, "already renewed or closed order not renewed")
+		return b.editOrderMessages(c, ctx, orderID, fmt.Sprintf(orderNotRenewedMsg, orderID))
+	}
+	if err != nil {
 		return fmt.Errorf("order not renewed: %w", err)
 	}
 
-	slog.InfoContext(ctx, "order renewed", "ttl", domain.OrderTTL)
+	slog.InfoContext(ctx, "order renewed", "ttl", domain.OrderTTL, "credit", credit)
 
-	order, err := b.storage.GetOrder(orderID)
+	order, err = b.storage.GetOrder(ctx, orderID)
 	if err != nil {
 		return fmt.Errorf("order not found: %w", err)
 	}
 
-	sb := &strings.Builder{}
+	b.metrics.revenue.Add(ctx, int64(order.Price-credit), metric.WithAttributes(attrBackend(order.Backend)))
 
-	fmt.Fprintf(sb, "Заказ №%d продлен до %s\n\nКлючей %d шт.\nОплачено %d руб.", order.ID, order.ExpiresAt.Time.Format("02.01.2006"), order.KeyAmount, order.Price)
+	sb := &strings.Builder{}
+	writeRenewedOrder(sb, order, credit)
 
 	// send to user
 	if _, err = b.tele.Send(recipient(order.UID), sb.String()); err != nil {
@@ -163,7 +266,16 @@ func (b *Bot) renewOrder(c tele.Context, ctx context.Context, cb btnCallback) er
 	orderUser.write(sb)
 
 	// sent to admin
-	return c.Edit(sb.String())
+	return b.editOrderMessages(c, ctx, orderID, sb.String())
+}
+
+// writeRenewedOrder writes renewed order, credit is part of price paid with balance.
+func writeRenewedOrder(sb *strings.Builder, order storage.Order, credit int) {
+	fmt.Fprintf(sb, "Заказ №%d продлен до %s\n\nКлючей %d шт.\nОплачено %d руб.", order.ID, order.ExpiresAt.Time.Format("02.01.2006"), order.KeyAmount, order.Price-credit)
+
+	if credit > 0 {
+		fmt.Fprintf(sb, "\nСписано с баланса %d руб.", credit)
+	}
 }
 
 // rejectOrder trigger when admin rejects order renew operation.
@@ -175,21 +287,37 @@ func (b *Bot) rejectOrder(c tele.Context, ctx context.Context, cb btnCallback, n
 	}
 
 	ctx = withOrderID(ctx, orderID)
+	c.Set(ctxKey, ctx)
 
-	order, err := b.storage.GetOrder(orderID)
+	order, err := b.storage.GetOrder(ctx, orderID)
 	if err != nil {
 		return fmt.Errorf("order not found on reject: %w", err)
 	}
 
-	if err = b.storage.CloseOrder(orderID, domain.OrderStatusRejected, now); err != nil {
+	if step(cb.unique) == stepRejectOrder && order.Status.String != string(domain.OrderStatusAwaitingPayment) {
+		slog.InfoContext(ctx, "already processed order not rejected", "status", order.Status.String)
+		return b.editOrderMessages(c, ctx, orderID, fmt.Sprintf("Заказ №%d уже обработан (%s)", orderID, order.Status.String))
+	}
+
+	if err = b.storage.CloseOrder(ctx, orderID, domain.OrderStatusRejected, now); err != nil {
 		return fmt.Errorf("order not closed on reject: %w", err)
 	}
 
 	slog.InfoContext(ctx, "order rejected by admin")
 
+	b.metrics.ordersRejected.Add(ctx, 1, metric.WithAttributes(
+		attrBackend(order.Backend),
+		attribute.Bool("renewal", step(cb.unique) == stepRejectOrderRenewal)))
+
 	sb := &strings.Builder{}
 
-	fmt.Fprintf(sb, "Заказ №%d на сумму %d руб. отклонен", order.ID, order.Price)
+	amount := order.Price
+	if step(cb.unique) == stepRejectOrder {
+		// discount applies only to the first payment
+		amount -= order.Discount
+	}
+
+	fmt.Fprintf(sb, "Заказ №%d на сумму %d руб. отклонен", order.ID, amount)
 
 	orderUser := &user{
 		id:        order.UID,
@@ -205,7 +333,7 @@ func (b *Bot) rejectOrder(c tele.Context, ctx context.Context, cb btnCallback, n
 	sb.WriteString("\n\n")
 	orderUser.write(sb)
 
-	return c.Edit(sb.String())
+	return b.editOrderMessages(c, ctx, orderID, sb.String())
 }
 
 // aproveOrder approved order and creates access keys to outline, sends them to user and admin.
@@ -217,81 +345,199 @@ func (b *Bot) approveOrder(c tele.Context, ctx context.Context, cb btnCallback)
 	}
 
 	ctx = withOrderID(ctx, orderID)
+	c.Set(ctxKey, ctx)
 
-	order, err := b.storage.GetOrder(orderID)
+	order, err := b.storage.GetOrder(ctx, orderID)
 	if err != nil {
 		return err
 	}
 
 	ctx = withUser(ctx, order.UID, order.Username.String)
 
+	if order.Status.String != string(domain.OrderStatusAwaitingPayment) {
+		slog.InfoContext(ctx, "already processed order not approved", "status", order.Status.String)
+		return b.editOrderMessages(c, ctx, orderID, fmt.Sprintf("Заказ №%d уже обработан (%s)", orderID, order.Status.String))
+	}
+
+	msg, err := b.provisionOrder(ctx, order, domain.OrderTTL+time.Duration(order.BonusDays)*24*time.Hour, 0)
+	if errors.Is(err, storage.ErrNotFound) {
+		slog.InfoContext(ctx, "order processed while keys were created")
+		return b.editOrderMessages(c, ctx, orderID, fmt.Sprintf("Заказ №%d уже обработан", orderID))
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "order approved by admin")
+
+	if err := b.usePromo(ctx, order); err != nil {
+		slog.ErrorContext(ctx, "promo use not counted", "cause", err.Error())
+	}
+
+	if err := b.rewardReferrer(ctx, order); err != nil {
+		slog.ErrorContext(ctx, "referrer not rewarded", "cause", err.Error())
+	}
+
+	b.metrics.ordersApproved.Add(ctx, 1, metric.WithAttributes(attrBackend(order.Backend)))
+	b.metrics.revenue.Add(ctx, int64(order.Price-order.Discount), metric.WithAttributes(attrBackend(order.Backend)))
+
+	slog.InfoContext(ctx, "msg to admin", "msg", msg)
+
+	// send to admin
+	if err := b.editOrderMessages(c, ctx, orderID, msg, "", tele.ModeMarkdown); err != nil {
+		return fmt.Errorf("order approve msg not sent to admin: %w", err)
+	}
+
+	return nil
+}
+
+// provisionOrder creates access keys of order on its backend, approves order for ttl and sends keys to user.
+// Data limit of keys is set in bytes if limit is not 0. Returns message with keys and user for admin.
+func (b *Bot) provisionOrder(ctx context.Context, order storage.Order, ttl time.Duration, limit int64) (string, error) {
+	backend, err := b.backends.Get(order.Backend)
+	if err != nil {
+		return "", err
+	}
+
 	now := time.Now()
 	gen := namegenerator.NewNameGenerator(now.UnixNano())
 
 	keys := make([]storage.Key, order.KeyAmount)
-	expiresAt := now.Add(domain.OrderTTL)
+	expiresAt := now.Add(ttl)
 
+	configs := make([]provisioner.ClientConfig, order.KeyAmount)
 	sb := &strings.Builder{}
 
-	fmt.Fprintf(sb, "Заказ №%d одобрен (до %s)\n", orderID, expiresAt.Format("02.01.2006"))
+	fmt.Fprintf(sb, "Заказ №%d одобрен (до %s)\n", order.ID, expiresAt.Format("02.01.2006"))
 
 	for i := range order.KeyAmount {
-		keyName := gen.Generate()
-
-		key, err := b.outline.AccessKeysPost(ctx, outline.NewOptAccessKeysPostReq(outline.AccessKeysPostReq{
-			Name: outline.NewOptString(keyName),
-		}))
+		key, err := backend.CreateKey(ctx, gen.Generate())
 		if err != nil {
-			return fmt.Errorf("outline key not created: %w", err)
+			b.deleteUnapprovedKeys(ctx, backend, keys[:i])
+			return "", fmt.Errorf("%s key not created: %w", order.Backend, err)
 		}
 
-		slog.InfoContext(ctx, "created key in outline", "key_id", key.ID, "key_name", key.Name)
-
-		fmt.Fprintf(sb, "\n%s %s\n```\n%s\n```", key.ID, key.Name.Value, key.AccessUrl.Value)
+		slog.InfoContext(ctx, "created key", "key_id", key.ID, "key_name", key.Name, "backend", order.Backend)
 
 		keys[i] = storage.Key{
 			ID:   key.ID,
-			Name: key.Name.Value,
-			URL:  key.AccessUrl.Value,
+			Name: key.Name,
+			URL:  key.URL,
+		}
+
+		if limit > 0 {
+			err := backend.SetLimit(ctx, key.ID, limit)
+			if errors.Is(err, provisioner.ErrNotSupported) {
+				slog.WarnContext(ctx, "data limit not supported", "backend", order.Backend)
+			} else if err != nil {
+				b.deleteUnapprovedKeys(ctx, backend, keys[:i+1])
+				return "", fmt.Errorf("%s key limit not set: %w", order.Backend, err)
+			}
+		}
+
+		configs[i], err = backend.RenderConfig(ctx, key)
+		if err != nil {
+			b.deleteUnapprovedKeys(ctx, backend, keys[:i+1])
+			return "", fmt.Errorf("client config not rendered: %w", err)
 		}
+
+		fmt.Fprintf(sb, "\n%s %s\n```\n%s\n```", key.ID, key.Name, configs[i].Text)
 	}
 
-	err = b.storage.ApproveOrder(orderID, keys, expiresAt)
+	// order may be approved, canceled or closed by someone else while keys were created
+	err = b.storage.ApproveOrder(ctx, order.ID, keys, expiresAt)
 	if err != nil {
-		return fmt.Errorf("order not approved: %w", err)
+		b.deleteUnapprovedKeys(ctx, backend, keys)
+		return "", fmt.Errorf("order not approved: %w", err)
 	}
 
-	slog.InfoContext(ctx, "order approved by admin")
-
 	// to write user from order to msg
-	usr := &user{
-		id:        order.UID,
-		username:  order.Username.String,
-		firstName: order.FirstName.String,
-		lastName:  order.LastName.String,
-	}
+	usr := orderUser(order)
 
 	// send to user
 	if _, err := b.tele.Send(usr, sb.String(), tele.ModeMarkdown); err != nil {
-		return fmt.Errorf("order approve msg not sent to user: %w", err)
+		return "", fmt.Errorf("order approve msg not sent to user: %w", err)
+	}
+
+	if err := b.sendClientConfigs(usr, configs); err != nil {
+		return "", fmt.Errorf("client configs not sent to user: %w", err)
 	}
 
 	sb.WriteString("\n")
 	usr.write(sb)
 
-	slog.InfoContext(ctx, "msg to admin", "msg", sb.String())
+	return sb.String(), nil
+}
 
-	// send to admin
-	if err := c.Edit(sb.String(), "", tele.ModeMarkdown); err != nil {
-		return fmt.Errorf("order approve msg not sent to admin: %w", err)
+// cancelUnprovisionedOrder closes free order whose keys were not provisioned, so it doesn't hang awaiting payment.
+func (b *Bot) cancelUnprovisionedOrder(ctx context.Context, oid domain.OrderID) {
+	err := b.storage.CancelOrder(ctx, oid, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		// approved before provisioning failed
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "unprovisioned order not canceled", "cause", err.Error())
+		return
+	}
+
+	slog.InfoContext(ctx, "unprovisioned order canceled")
+}
+
+// deleteUnapprovedKeys deletes keys created for order which wasn't approved, so they don't work for free.
+func (b *Bot) deleteUnapprovedKeys(ctx context.Context, backend provisioner.Backend, keys []storage.Key) {
+	for _, k := range keys {
+		if err := backend.DeleteKey(ctx, k.ID); err != nil {
+			slog.ErrorContext(ctx, "unapproved key not deleted", "key_id", k.ID, "backend", backend.Name(), "cause", err.Error())
+			continue
+		}
+
+		slog.InfoContext(ctx, "unapproved key deleted", "key_id", k.ID, "backend", backend.Name())
+	}
+}
+
+// sendClientConfigs sends config files and qr codes of keys to user if backend provides them.
+func (b *Bot) sendClientConfigs(to tele.Recipient, configs []provisioner.ClientConfig) error {
+	for _, cfg := range configs {
+		if cfg.File != nil {
+			doc := &tele.Document{
+				File:     tele.FromReader(bytes.NewReader(cfg.File)),
+				FileName: cfg.FileName,
+			}
+
+			if _, err := b.tele.Send(to, doc); err != nil {
+				return fmt.Errorf("config file not sent: %w", err)
+			}
+		}
+
+		if cfg.QR != nil {
+			if _, err := b.tele.Send(to, &tele.Photo{File: tele.FromReader(bytes.NewReader(cfg.QR))}); err != nil {
+				return fmt.Errorf("config qr not sent: %w", err)
+			}
+		}
 	}
 
 	return nil
 }
 
-func orderCreatedMsg(oid domain.OrderID, price, keys int, usr *user) string {
+// orderAdminKeyboard returns keyboard for admin to approve or reject new order.
+func orderAdminKeyboard(oid domain.OrderID) *tele.ReplyMarkup {
+	kb := &tele.ReplyMarkup{}
+	kb.Inline(
+		kb.Row(kb.Data("Одобрить", stepApproveOrder.String(), oid.String())),
+		kb.Row(kb.Data("Отклонить", stepRejectOrder.String(), oid.String())),
+	)
+	return kb
+}
+
+// orderCreatedMsg returns new order message for admin, promo is note about applied promo code.
+func orderCreatedMsg(oid domain.OrderID, price, keys int, promo string, usr *user) string {
 	sb := &strings.Builder{}
-	fmt.Fprintf(sb, "Новый заказ №%d\n\nК оплате: %d₽\nКлючей: %d\n\n", oid, price, keys)
+	fmt.Fprintf(sb, "Новый заказ №%d\n\nК оплате: %d₽\nКлючей: %d\n", oid, price, keys)
+	if promo != "" {
+		sb.WriteString(promo + "\n")
+	}
+	sb.WriteString("\n")
 	usr.write(sb)
 	return sb.String()
 }
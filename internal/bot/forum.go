@@ -0,0 +1,163 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/config"
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+// event is type of admin notification.
+type event string
+
+const (
+	eventOrder      event = "order"
+	eventRenewal    event = "renewal"
+	eventExpiration event = "expiration"
+	eventPending    event = "pending"
+	eventError      event = "error"
+	eventSupport    event = "support"
+	eventJoin       event = "join"
+	eventTopUp      event = "topup"
+)
+
+// eventTopics are names of forum topics per event.
+var eventTopics = map[event]string{
+	eventOrder:      "Новые заказы",
+	eventRenewal:    "Продления",
+	eventExpiration: "Отключения",
+	eventPending:    "Неоплаченные заказы",
+	eventError:      "Ошибки",
+	eventSupport:    "Поддержка",
+	eventJoin:       "Заявки на доступ",
+	eventTopUp:      "Пополнения баланса",
+}
+
+const (
+	forumTopicsEvents = "events"
+	forumTopicsOrders = "orders"
+)
+
+// forum is supergroup with topics which receives admin notifications.
+type forum struct {
+	chat *tele.Chat
+	// byOrder is true if every order has its own topic, otherwise topic is created per event.
+	byOrder bool
+
+	mu sync.Mutex // serializes creation of topics
+}
+
+func newForum(conf config.TG) (*forum, error) {
+	if conf.AdminGroup == 0 {
+		return nil, nil
+	}
+
+	switch conf.AdminTopics {
+	case forumTopicsEvents, forumTopicsOrders:
+	default:
+		return nil, fmt.Errorf("unsupported admin topics: %s", conf.AdminTopics)
+	}
+
+	return &forum{
+		chat:    &tele.Chat{ID: conf.AdminGroup, Type: tele.ChatSuperGroup},
+		byOrder: conf.AdminTopics == forumTopicsOrders,
+	}, nil
+}
+
+// sendToForum sends admin notification into topic of event or order, order is 0 if notification is not about order.
+func (b *Bot) sendToForum(ctx context.Context, ev event, oid domain.OrderID, what any, opts ...any) (*tele.Message, error) {
+	threadID, err := b.forumTopic(ctx, ev, oid)
+	if err != nil {
+		return nil, err
+	}
+
+	// send options replace options passed before them
+	opts = append([]any{&tele.SendOptions{ThreadID: threadID}}, opts...)
+
+	m, err := b.tele.Send(b.forum.chat, what, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("notification not sent to admin group: %w", err)
+	}
+
+	return m, nil
+}
+
+// forumTopic returns thread id of topic for notification, topic is created on first notification.
+func (b *Bot) forumTopic(ctx context.Context, ev event, oid domain.OrderID) (int, error) {
+	key, name := "event:"+string(ev), eventTopics[ev]
+
+	if b.forum.byOrder && oid != 0 {
+		key, name = "order:"+oid.String(), fmt.Sprintf("Заказ №%d", oid)
+	}
+
+	b.forum.mu.Lock()
+	defer b.forum.mu.Unlock()
+
+	threadID, err := b.storage.GetAdminTopic(ctx, key)
+	if err == nil {
+		return threadID, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return 0, fmt.Errorf("admin topic not received: %w", err)
+	}
+
+	topic, err := b.tele.CreateTopic(b.forum.chat, &tele.Topic{Name: name})
+	if err != nil {
+		return 0, fmt.Errorf("admin topic not created: %w", err)
+	}
+
+	if err := b.storage.SaveAdminTopic(ctx, key, topic.ThreadID); err != nil {
+		return 0, fmt.Errorf("admin topic not saved: %w", err)
+	}
+
+	slog.InfoContext(ctx, "admin topic created", "topic", key, "thread_id", topic.ThreadID)
+
+	return topic.ThreadID, nil
+}
+
+// isForum returns true if update is from admin group.
+func (b *Bot) isForum(c tele.Context) bool {
+	return b.forum != nil && c.Chat() != nil && c.Chat().ID == b.forum.chat.ID
+}
+
+// forumSteps are order buttons which administrators of admin group may press
+// without being admins of the bot.
+var forumSteps = map[step]bool{
+	stepApproveOrder:       true,
+	stepRejectOrder:        true,
+	stepOrderRenewApproved: true,
+	stepRejectOrderRenewal: true,
+}
+
+// forumMemberRole returns operator role for administrators of admin group
+// who are not admins of the bot, so they can process orders from the group.
+// Role is granted only for order buttons, commands and other buttons require admin of the bot.
+func (b *Bot) forumMemberRole(c tele.Context) (domain.AdminRole, error) {
+	if !b.isForum(c) || c.Sender() == nil || c.Callback() == nil {
+		return "", nil
+	}
+
+	cb, err := parseCallback(c.Callback().Data)
+	if err != nil || !forumSteps[step(cb.unique)] {
+		return "", nil
+	}
+
+	m, err := b.tele.ChatMemberOf(b.forum.chat, c.Sender())
+	if err != nil {
+		return "", fmt.Errorf("admin group member not received: %w", err)
+	}
+
+	switch m.Role {
+	case tele.Creator, tele.Administrator:
+		return domain.AdminRoleOperator, nil
+	default:
+		return "", nil
+	}
+}
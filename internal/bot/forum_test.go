@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/config"
+	"github.com/ysomad/outline-bot/internal/domain"
+)
+
+const testGroupID = -100
+
+func withForum(topics string) func(*config.TG) {
+	return func(conf *config.TG) {
+		conf.AdminGroup = testGroupID
+		conf.AdminTopics = topics
+	}
+}
+
+// groupCallback returns context of button pressed by user on message in admin group.
+func (e *testEnv) groupCallback(uid int64, msgID int, s step, data string) tele.Context {
+	return e.bot.tele.NewContext(tele.Update{Callback: &tele.Callback{
+		ID:      "1",
+		Data:    "\f" + s.String() + "|" + data,
+		Sender:  &tele.User{ID: uid},
+		Message: &tele.Message{ID: msgID, Chat: &tele.Chat{ID: testGroupID, Type: tele.ChatSuperGroup}},
+	}})
+}
+
+func TestForumEventTopics(t *testing.T) {
+	env := newTestEnv(t, withForum(forumTopicsEvents))
+
+	env.order(t, 1)
+	env.order(t, 2)
+
+	topics := env.tg.Calls("createForumTopic", testGroupID)
+	if len(topics) != 1 || topics[0].Params["name"] != eventTopics[eventOrder] {
+		t.Fatalf("created topics = %+v, want one topic of orders", topics)
+	}
+
+	msgs := env.tg.Calls("sendMessage", testGroupID)
+	if len(msgs) != 2 {
+		t.Fatalf("sent %d messages to group, want 2", len(msgs))
+	}
+
+	for _, m := range msgs {
+		if m.ThreadID != topics[0].MessageID {
+			t.Errorf("message sent to thread %d, want %d", m.ThreadID, topics[0].MessageID)
+		}
+	}
+
+	if env.sent("sendMessage", testAdminID) != 0 {
+		t.Error("order msg sent to private chat of owner")
+	}
+}
+
+func TestForumOrderTopics(t *testing.T) {
+	env := newTestEnv(t, withForum(forumTopicsOrders))
+
+	oid := env.order(t, 1)
+
+	if err := env.bot.cancelUnpaidOrders(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	topics := env.tg.Calls("createForumTopic", testGroupID)
+	if len(topics) != 1 || topics[0].Params["name"] != "Заказ №"+oid.String() {
+		t.Fatalf("created topics = %+v, want one topic of order", topics)
+	}
+
+	msgs := env.tg.Calls("sendMessage", testGroupID)
+	if len(msgs) != 2 {
+		t.Fatalf("sent %d messages to group, want order and its cancellation", len(msgs))
+	}
+
+	if msgs[0].ThreadID != msgs[1].ThreadID {
+		t.Error("messages about order sent to different topics")
+	}
+}
+
+func TestForumApproveOrder(t *testing.T) {
+	env := newTestEnv(t, withForum(forumTopicsEvents))
+
+	const (
+		groupAdminID  = 50
+		groupMemberID = 51
+	)
+
+	env.tg.SetMember(testGroupID, groupAdminID, tele.Administrator)
+	env.tg.SetMember(testGroupID, groupMemberID, tele.Member)
+
+	oid := env.order(t, 1)
+	msg := env.tg.Calls("sendMessage", testGroupID)[0]
+
+	if err := env.bot.handleCallback(env.groupCallback(groupMemberID, msg.MessageID, stepApproveOrder, oid.String())); err == nil {
+		t.Fatal("order approved by group member")
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusAwaitingPayment {
+		t.Fatalf("status = %q, want %q", got, domain.OrderStatusAwaitingPayment)
+	}
+
+	if err := env.bot.handleCallback(env.groupCallback(groupAdminID, msg.MessageID, stepApproveOrder, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusApproved {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusApproved)
+	}
+
+	edits := env.tg.Calls("editMessageText", testGroupID)
+	if len(edits) != 1 || edits[0].MessageID != msg.MessageID {
+		t.Error("order msg not edited in group")
+	}
+
+	// group admin isn't operator of the bot outside of order buttons
+	revoke := env.groupCallback(groupAdminID, msg.MessageID, stepUserRevoke, oid.String())
+	if err := env.bot.handleCallback(revoke); err == nil {
+		t.Error("order revoked by group admin")
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusApproved {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusApproved)
+	}
+
+	stats := env.bot.tele.NewContext(tele.Update{Message: &tele.Message{
+		ID:     1,
+		Text:   "/stats",
+		Sender: &tele.User{ID: groupAdminID},
+		Chat:   &tele.Chat{ID: testGroupID, Type: tele.ChatSuperGroup},
+	}})
+
+	if err := env.bot.checkRole(stats, domain.AdminRoleViewer); !errors.Is(err, errAccessDenied) {
+		t.Errorf("command of group admin: err = %v, want %v", err, errAccessDenied)
+	}
+}
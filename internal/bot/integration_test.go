@@ -0,0 +1,603 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/config"
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/outline"
+	"github.com/ysomad/outline-bot/internal/outlinetest"
+	"github.com/ysomad/outline-bot/internal/provisioner"
+	"github.com/ysomad/outline-bot/internal/storage"
+	"github.com/ysomad/outline-bot/internal/storage/memstore"
+	"github.com/ysomad/outline-bot/internal/telegramtest"
+)
+
+const (
+	testAdminID = 1
+	testUserID  = 100
+)
+
+func TestMain(m *testing.M) {
+	// payment qr code is read from disk relative to repository root
+	if err := os.Chdir("../.."); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Exit(m.Run())
+}
+
+type testEnv struct {
+	bot     *Bot
+	store   *memstore.Storage
+	outline *outlinetest.Server
+	tg      *telegramtest.Server
+}
+
+func newOutlineBackend(t *testing.T, url string) *provisioner.Outline {
+	t.Helper()
+
+	cli, err := outline.NewClient(url, outline.WithClient(&http.Client{Timeout: 200 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ol, err := provisioner.NewOutline(cli, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ol
+}
+
+func newTestEnv(t *testing.T, opts ...func(*config.TG)) *testEnv {
+	t.Helper()
+
+	env := &testEnv{
+		store:   memstore.New(),
+		outline: outlinetest.NewServer(),
+		tg:      telegramtest.NewServer(),
+	}
+	t.Cleanup(env.outline.Close)
+	t.Cleanup(env.tg.Close)
+
+	var err error
+
+	conf := config.TG{
+		APIURL:        env.tg.URL,
+		Token:         "test",
+		PollerTimeout: time.Second,
+		HTTPTimeout:   5 * time.Second,
+		Admin:         testAdminID,
+	}
+
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	env.bot, err = New(conf,
+		expirable.NewLRU[string, State](100, nil, time.Hour),
+		provisioner.NewBackends(newOutlineBackend(t, env.outline.URL)),
+		domain.BackendOutline,
+		env.store,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return env
+}
+
+// sent returns amount of calls of method to chat.
+func (e *testEnv) sent(method string, chatID int64) int {
+	return len(e.tg.Calls(method, chatID))
+}
+
+func (e *testEnv) callback(chatID int64, s step, data string) tele.Context {
+	return e.bot.tele.NewContext(tele.Update{Callback: &tele.Callback{
+		ID:      "1",
+		Data:    "\f" + s.String() + "|" + data,
+		Sender:  &tele.User{ID: chatID},
+		Message: &tele.Message{ID: 1, Chat: &tele.Chat{ID: chatID}},
+	}})
+}
+
+func (e *testEnv) text(chatID int64, text string) tele.Context {
+	return e.bot.tele.NewContext(tele.Update{Message: &tele.Message{
+		ID:     1,
+		Text:   text,
+		Sender: &tele.User{ID: chatID},
+		Chat:   &tele.Chat{ID: chatID},
+	}})
+}
+
+// command returns context of command message with args as telebot parses them.
+func (e *testEnv) command(chatID int64, text string) tele.Context {
+	c := e.text(chatID, text)
+	_, c.Message().Payload, _ = strings.Cut(text, " ")
+	return c
+}
+
+// lastText returns text of the last message sent to chat.
+func (e *testEnv) lastText(t *testing.T, chatID int64) string {
+	t.Helper()
+
+	calls := e.tg.Calls("sendMessage", chatID)
+	if len(calls) == 0 {
+		t.Fatalf("no messages sent to %d", chatID)
+	}
+
+	return calls[len(calls)-1].Text
+}
+
+// order creates order of user with key amount as user does in /order.
+func (e *testEnv) order(t *testing.T, keyAmount int) domain.OrderID {
+	t.Helper()
+
+	if err := e.bot.handleCallback(e.callback(testUserID, stepSelectKeyAmount, fmt.Sprint(keyAmount))); err != nil {
+		t.Fatalf("order not created: %s", err)
+	}
+
+	return domain.OrderID(1)
+}
+
+// approvedOrder creates order and approves it as admin does.
+func (e *testEnv) approvedOrder(t *testing.T, keyAmount int) domain.OrderID {
+	t.Helper()
+
+	oid := e.order(t, keyAmount)
+
+	if err := e.bot.handleCallback(e.callback(testAdminID, stepApproveOrder, oid.String())); err != nil {
+		t.Fatalf("order not approved: %s", err)
+	}
+
+	return oid
+}
+
+func (e *testEnv) orderStatus(t *testing.T, oid domain.OrderID) domain.OrderStatus {
+	t.Helper()
+
+	o, err := e.store.GetOrder(context.Background(), oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return domain.OrderStatus(o.Status.String)
+}
+
+func TestOrder(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.order(t, 2)
+
+	o, err := env.store.GetOrder(context.Background(), oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if o.UID != testUserID || o.KeyAmount != 2 || o.Price != 2*domain.PricePerKey {
+		t.Errorf("unexpected order: %+v", o)
+	}
+
+	if got := domain.OrderStatus(o.Status.String); got != domain.OrderStatusAwaitingPayment {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusAwaitingPayment)
+	}
+
+	if env.sent("sendMessage", testAdminID) != 1 {
+		t.Error("new order not sent to admin")
+	}
+
+	if env.sent("sendPhoto", testUserID) != 1 {
+		t.Error("payment details not sent to user")
+	}
+}
+
+func TestApproveOrder(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.approvedOrder(t, 2)
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusApproved {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusApproved)
+	}
+
+	outlineKeys := env.outline.Keys()
+	if len(outlineKeys) != 2 {
+		t.Fatalf("outline keys = %d, want 2", len(outlineKeys))
+	}
+
+	keys, err := env.store.ListActiveUserKeys(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("stored keys = %d, want 2", len(keys))
+	}
+
+	for i, k := range keys {
+		if k.ID != outlineKeys[i].ID || k.URL != outlineKeys[i].AccessUrl.Value {
+			t.Errorf("stored key %+v doesn't match outline key %+v", k, outlineKeys[i])
+		}
+	}
+
+	if env.sent("sendMessage", testUserID) != 1 {
+		t.Error("keys not sent to user")
+	}
+
+	if env.sent("editMessageText", testAdminID) != 1 {
+		t.Error("approve msg not edited for admin")
+	}
+}
+
+func TestApproveOrderOutlineFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault outlinetest.Fault
+	}{
+		{
+			name:  "internal error",
+			fault: outlinetest.Fault{Route: "POST /access-keys", Status: http.StatusInternalServerError},
+		},
+		{
+			name:  "timeout",
+			fault: outlinetest.Fault{Route: "POST /access-keys", Delay: time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			oid := env.order(t, 1)
+
+			env.outline.Inject(tt.fault)
+
+			if err := env.bot.handleCallback(env.callback(testAdminID, stepApproveOrder, oid.String())); err == nil {
+				t.Fatal("expected error")
+			}
+
+			if got := env.orderStatus(t, oid); got != domain.OrderStatusAwaitingPayment {
+				t.Errorf("status = %q, want %q", got, domain.OrderStatusAwaitingPayment)
+			}
+
+			keys, err := env.store.ListActiveUserKeys(context.Background(), testUserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(keys) != 0 {
+				t.Errorf("stored keys = %d, want 0", len(keys))
+			}
+
+			// admin retries after outline is back
+			env.outline.ClearFaults()
+
+			if err := env.bot.handleCallback(env.callback(testAdminID, stepApproveOrder, oid.String())); err != nil {
+				t.Fatalf("order not approved on retry: %s", err)
+			}
+
+			if got := env.orderStatus(t, oid); got != domain.OrderStatusApproved {
+				t.Errorf("status = %q, want %q", got, domain.OrderStatusApproved)
+			}
+		})
+	}
+}
+
+func TestRejectOrder(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.order(t, 1)
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepRejectOrder, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusRejected {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusRejected)
+	}
+
+	if len(env.outline.Keys()) != 0 {
+		t.Error("keys created for rejected order")
+	}
+}
+
+func TestRenewOrder(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.approvedOrder(t, 1)
+
+	before, err := env.store.GetOrder(context.Background(), oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepOrderRenewApproved, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := env.store.GetOrder(context.Background(), oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := after.ExpiresAt.Time.Sub(before.ExpiresAt.Time); got != domain.OrderTTL {
+		t.Errorf("order prolonged by %s, want %s", got, domain.OrderTTL)
+	}
+
+	if env.sent("sendMessage", testUserID) != 2 {
+		t.Error("renewal not sent to user")
+	}
+}
+
+func TestRenewOrderTwice(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.approvedOrder(t, 1)
+
+	before, err := env.store.GetOrder(context.Background(), oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both operators press renewal button sent for the same expiration
+	kb := renewalAdminKeyboard(oid, before.ExpiresAt.Time)
+	data := strings.TrimPrefix(kb.InlineKeyboard[0][0].Data, "\f"+stepOrderRenewApproved.String()+"|")
+
+	for range 2 {
+		if err := env.bot.handleCallback(env.callback(testAdminID, stepOrderRenewApproved, data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	after, err := env.store.GetOrder(context.Background(), oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := after.ExpiresAt.Time.Sub(before.ExpiresAt.Time); got != domain.OrderTTL {
+		t.Errorf("order prolonged by %s, want %s", got, domain.OrderTTL)
+	}
+
+	edits := env.tg.Calls("editMessageText", testAdminID)
+	if len(edits) == 0 || edits[len(edits)-1].Text != "Заказ №1 уже продлен или закрыт" {
+		t.Errorf("second press edits = %+v, want already renewed", edits)
+	}
+}
+
+func TestApproveOrderProcessedMeanwhile(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	oid := env.order(t, 2)
+
+	order, err := env.store.GetOrder(ctx, oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// user cancels order while keys are created for approval
+	if err := env.store.CancelOrder(ctx, oid, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.bot.provisionOrder(ctx, order, domain.OrderTTL, 0); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("provision err = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusCanceled {
+		t.Errorf("status = %s, want %s", got, domain.OrderStatusCanceled)
+	}
+
+	if n := len(env.outline.Keys()); n != 0 {
+		t.Errorf("outline keys = %d, want created keys deleted", n)
+	}
+
+	if n := env.sent("sendMessage", testUserID); n != 0 {
+		t.Errorf("msgs to user = %d, want no keys sent", n)
+	}
+}
+
+func TestNotifyExpiringOrders(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.approvedOrder(t, 1)
+
+	// order expires in a day
+	env.store.Now = func() time.Time { return time.Now().Add(domain.OrderTTL - 24*time.Hour) }
+
+	if err := env.bot.notifyExpiringOrders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.sent("sendPhoto", testUserID) != 2 {
+		t.Error("payment details for renewal not sent to user")
+	}
+
+	if env.sent("sendMessage", testAdminID) != 2 {
+		t.Error("renewal request not sent to admin")
+	}
+}
+
+func TestDeactivateExpiredKeys(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.approvedOrder(t, 2)
+
+	if err := env.bot.deactivateExpiredKeys(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(env.outline.Keys()) != 2 {
+		t.Fatal("keys of active order deleted")
+	}
+
+	env.store.Now = func() time.Time { return time.Now().Add(domain.OrderTTL + time.Minute) }
+
+	if err := env.bot.deactivateExpiredKeys(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusExpired {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusExpired)
+	}
+
+	if n := len(env.outline.Keys()); n != 0 {
+		t.Errorf("outline keys = %d, want 0", n)
+	}
+
+	if env.outline.Requests("DELETE /access-keys/{id}") != 2 {
+		t.Error("expired keys not deleted from outline")
+	}
+}
+
+func TestMigration(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.approvedOrder(t, 2)
+
+	newOutline := outlinetest.NewServer()
+	t.Cleanup(newOutline.Close)
+
+	if err := env.bot.handleMigration(env.text(testAdminID, "/migrate")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.handleText(env.text(testAdminID, newOutline.URL)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(newOutline.Keys()); n != 2 {
+		t.Fatalf("keys on new server = %d, want 2", n)
+	}
+
+	keys, err := env.store.ListActiveUserKeys(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make(map[string]string)
+	for _, k := range newOutline.Keys() {
+		want[k.ID] = k.AccessUrl.Value
+	}
+
+	for _, k := range keys {
+		if k.OrderID != oid || want[k.ID] != k.URL {
+			t.Errorf("key %+v not migrated to new server", k)
+		}
+	}
+
+	if env.sent("sendMessage", testUserID) != 2 {
+		t.Error("migrated keys not sent to user")
+	}
+
+	if _, ok := env.bot.state.Get(fmt.Sprint(testAdminID)); ok {
+		t.Error("migration state not removed")
+	}
+}
+
+func TestChangeHostname(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.approvedOrder(t, 1)
+
+	if err := env.bot.handleHostname(env.text(testAdminID, "/hostname")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.handleText(env.text(testAdminID, "vpn.example.org")); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := env.store.AllActiveKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || !strings.Contains(keys[0].URL, "@vpn.example.org:") {
+		t.Errorf("key url not updated: %+v", keys)
+	}
+
+	if env.sent("sendMessage", testUserID) != 2 {
+		t.Error("updated key not sent to user")
+	}
+}
+
+func TestChangeHostnameKeyFailure(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.approvedOrderOf(t, testUserID)
+	env.approvedOrderOf(t, testInvitedUserID)
+
+	if err := env.bot.handleHostname(env.text(testAdminID, "/hostname")); err != nil {
+		t.Fatal(err)
+	}
+
+	env.outline.Inject(outlinetest.Fault{Route: "GET /access-keys/{id}", Status: http.StatusInternalServerError, Times: 1})
+
+	if err := env.bot.handleText(env.text(testAdminID, "vpn.example.org")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testAdminID); !strings.Contains(got, "обновлено заказов 1 шт.") || !strings.Contains(got, "Не удалось обновить ключей: 1 шт.") {
+		t.Errorf("admin msg = %q, want one updated and one failed order", got)
+	}
+
+	if env.sent("sendMessage", testUserID)+env.sent("sendMessage", testInvitedUserID) != 3 {
+		t.Error("updated key not sent to user of the second order")
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.approvedOrder(t, 1)
+
+	if err := env.bot.handlePrefix(env.command(testAdminID, "/prefix POST&x")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testAdminID); !strings.Contains(got, "must be percent encoded") {
+		t.Errorf("reserved character = %q, want rejection", got)
+	}
+
+	if err := env.bot.handlePrefix(env.command(testAdminID, "/prefix %16%03%01")); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := env.store.AllActiveKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || !strings.HasSuffix(keys[0].URL, "prefix=%16%03%01") {
+		t.Errorf("key url not prefixed: %+v", keys)
+	}
+
+	// prefix survives restart
+	ol := newOutlineBackend(t, env.outline.URL)
+
+	_, err = New(config.TG{APIURL: env.tg.URL, Token: "test", HTTPTimeout: time.Second, Admin: testAdminID},
+		expirable.NewLRU[string, State](100, nil, time.Hour),
+		provisioner.NewBackends(ol),
+		domain.BackendOutline,
+		env.store,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ol.Prefix(); got != "%16%03%01" {
+		t.Errorf("prefix after restart = %q, want saved one", got)
+	}
+}
@@ -0,0 +1,353 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const waitlistMsg = "Это ВПН ДЛЯ СВОИХ, доступ только по инвайту. Попроси у друга ссылку-приглашение или дождись, пока админ одобрит заявку, я ее уже отправил"
+
+// newInviteCode returns random code used in deep link t.me/bot?start=<code>.
+func newInviteCode() string {
+	b := make([]byte, 5)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// memberMiddleware lets only members use handlers in invite only mode, others are put on waitlist.
+func (b *Bot) memberMiddleware() tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if !b.inviteOnly {
+				return next(c)
+			}
+
+			admitted, err := b.admitUser(c, "")
+			if err != nil || !admitted {
+				return err
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// isMember returns true if user may use the bot in invite only mode.
+// Users who had approved orders before the mode was enabled become members on first check.
+func (b *Bot) isMember(ctx context.Context, usr *user) (bool, error) {
+	role, err := b.adminRole(ctx, usr.id)
+	if err != nil {
+		return false, err
+	}
+
+	if role != "" {
+		return true, nil
+	}
+
+	_, err = b.storage.GetMember(ctx, usr.id)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return false, fmt.Errorf("member not received: %w", err)
+	}
+
+	orders, err := b.storage.ListUserOrders(ctx, usr.id)
+	if err != nil {
+		return false, fmt.Errorf("user orders not listed: %w", err)
+	}
+
+	for _, o := range orders {
+		if !o.ExpiresAt.Valid {
+			continue
+		}
+
+		err := b.storage.SaveMember(ctx, storage.Member{UID: usr.id, Username: usr.username, JoinedAt: time.Now()})
+		if err != nil {
+			return false, fmt.Errorf("member not saved: %w", err)
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// admitUser returns true if user is member or became member with invite code,
+// otherwise user is put on waitlist and admins receive join request.
+func (b *Bot) admitUser(c tele.Context, code string) (bool, error) {
+	ctx := stdContext(c)
+	usr := newUser(c.Chat())
+
+	member, err := b.isMember(ctx, usr)
+	if err != nil || member {
+		return member, err
+	}
+
+	if code == "" {
+		return false, b.requestJoin(c, ctx, usr)
+	}
+
+	m, err := b.storage.UseInvite(ctx, code, storage.Member{UID: usr.id, Username: usr.username, JoinedAt: time.Now()})
+	if errors.Is(err, storage.ErrNotFound) {
+		if err := c.Send("Инвайт недействителен или уже использован"); err != nil {
+			return false, err
+		}
+
+		return false, b.requestJoin(c, ctx, usr)
+	}
+	if err != nil {
+		return false, fmt.Errorf("invite not used: %w", err)
+	}
+
+	slog.InfoContext(ctx, "user joined by invite", "invited_by", m.InvitedBy)
+
+	// inviter is referrer, he is credited when friend pays
+	if err := b.saveReferral(ctx, usr, m.InvitedBy); err != nil {
+		return false, err
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString("По твоему инвайту присоединился друг\n\n")
+	usr.write(sb)
+
+	if _, err := b.tele.Send(recipient(m.InvitedBy), sb.String()); err != nil {
+		slog.WarnContext(ctx, "join msg not sent to inviter", "cause", err.Error())
+	}
+
+	return true, nil
+}
+
+// requestJoin puts user on waitlist and sends join request to admins once.
+func (b *Bot) requestJoin(c tele.Context, ctx context.Context, usr *user) error {
+	r, err := b.storage.GetJoinRequest(ctx, usr.id)
+	if err == nil {
+		if r.Status == domain.JoinRequestDenied {
+			return c.Send("Заявка на доступ отклонена")
+		}
+
+		return c.Send(waitlistMsg)
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("join request not received: %w", err)
+	}
+
+	err = b.storage.SaveJoinRequest(ctx, storage.JoinRequest{
+		UID:       usr.id,
+		Username:  usr.username,
+		FirstName: usr.firstName,
+		LastName:  usr.lastName,
+		Status:    domain.JoinRequestPending,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("join request not saved: %w", err)
+	}
+
+	slog.InfoContext(ctx, "join requested")
+
+	sb := &strings.Builder{}
+	sb.WriteString("Заявка на доступ без инвайта\n\n")
+	usr.write(sb)
+
+	uid := strconv.FormatInt(usr.id, 10)
+
+	kb := &tele.ReplyMarkup{}
+	kb.Inline(kb.Row(
+		kb.Data("Одобрить", stepApproveJoin.String(), uid),
+		kb.Data("Отклонить", stepDenyJoin.String(), uid),
+	))
+
+	if _, err := b.notifyAdmins(ctx, eventJoin, 0, sb.String(), kb); err != nil {
+		return fmt.Errorf("join request not sent to admin: %w", err)
+	}
+
+	return c.Send(waitlistMsg)
+}
+
+// processJoin triggers after admin approved or denied join request.
+func (b *Bot) processJoin(c tele.Context, ctx context.Context, cb btnCallback) error {
+	uid, err := strconv.ParseInt(cb.data, 10, 64)
+	if err != nil {
+		return fmt.Errorf("uid not found in callback data: %w", err)
+	}
+
+	r, err := b.storage.GetJoinRequest(ctx, uid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Edit(c.Message().Text + "\n\nЗаявка уже обработана")
+	}
+	if err != nil {
+		return fmt.Errorf("join request not received: %w", err)
+	}
+
+	ctx = withUser(ctx, r.UID, r.Username)
+
+	if step(cb.unique) == stepDenyJoin {
+		r.Status = domain.JoinRequestDenied
+
+		if err := b.storage.SaveJoinRequest(ctx, r); err != nil {
+			return fmt.Errorf("join request not denied: %w", err)
+		}
+
+		slog.InfoContext(ctx, "join denied")
+
+		if _, err := b.tele.Send(recipient(uid), "Заявка на доступ отклонена"); err != nil {
+			slog.WarnContext(ctx, "join denied msg not sent to user", "cause", err.Error())
+		}
+
+		return c.Edit(c.Message().Text + "\n\nОтклонено")
+	}
+
+	err = b.storage.SaveMember(ctx, storage.Member{UID: uid, Username: r.Username, InvitedBy: c.Sender().ID, JoinedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("member not saved: %w", err)
+	}
+
+	if err := b.storage.DeleteJoinRequest(ctx, uid); err != nil {
+		return fmt.Errorf("join request not deleted: %w", err)
+	}
+
+	slog.InfoContext(ctx, "join approved")
+
+	if _, err := b.tele.Send(recipient(uid), "Доступ открыт, используй /order для заказа"); err != nil {
+		return fmt.Errorf("join approved msg not sent to user: %w", err)
+	}
+
+	return c.Edit(c.Message().Text + "\n\nОдобрено")
+}
+
+// handleInvite creates one-time invite link, members have limited amount of invites, admins don't.
+func (b *Bot) handleInvite(c tele.Context) error {
+	if !b.inviteOnly {
+		return c.Send("Бот открыт для всех, инвайт не нужен")
+	}
+
+	ctx := stdContext(c)
+	usr := newUser(c.Chat())
+
+	role, err := b.adminRole(ctx, usr.id)
+	if err != nil {
+		return err
+	}
+
+	limit := 0
+
+	// invites are given by subscribers, so friends are vouched by someone who pays
+	if role == "" {
+		limit = b.invitesPerUser
+
+		keys, err := b.storage.CountActiveKeys(ctx, usr.id)
+		if err != nil {
+			return fmt.Errorf("active keys not counted: %w", err)
+		}
+
+		if keys == 0 {
+			return c.Send("Инвайты создают только пользователи с действующим заказом, размести заказ /order")
+		}
+	}
+
+	inv := storage.Invite{
+		Code:      newInviteCode(),
+		CreatedBy: usr.id,
+		CreatedAt: time.Now(),
+	}
+
+	err = b.storage.CreateInvite(ctx, inv, limit)
+	if errors.Is(err, storage.ErrNotFound) {
+		if role != "" {
+			// slot is taken by invite created at the same time
+			return c.Send("Инвайт не создан, попробуй еще раз")
+		}
+
+		return c.Send(fmt.Sprintf("Ты уже создал %d из %d инвайтов", limit, limit))
+	}
+	if err != nil {
+		return fmt.Errorf("invite not created: %w", err)
+	}
+
+	slog.InfoContext(ctx, "invite created")
+
+	msg := fmt.Sprintf("Одноразовая ссылка-приглашение для друга:\nhttps://t.me/%s?start=%s", b.tele.Me.Username, inv.Code)
+
+	if role == "" {
+		created, err := b.storage.CountInvites(ctx, usr.id)
+		if err != nil {
+			return fmt.Errorf("invites not counted: %w", err)
+		}
+
+		msg += fmt.Sprintf("\n\nОсталось инвайтов: %d", max(limit-created, 0))
+	}
+
+	return c.Send(msg)
+}
+
+// handleInvites sends tree of members by who invited them.
+func (b *Bot) handleInvites(c tele.Context) error {
+	members, err := b.storage.ListMembers(stdContext(c))
+	if err != nil {
+		return fmt.Errorf("members not listed: %w", err)
+	}
+
+	if len(members) == 0 {
+		return c.Send("Участников нет")
+	}
+
+	var (
+		isMember = make(map[int64]bool, len(members))
+		invited  = make(map[int64][]storage.Member)
+		roots    []int64
+	)
+
+	for _, m := range members {
+		isMember[m.UID] = true
+	}
+
+	for _, m := range members {
+		if _, ok := invited[m.InvitedBy]; !ok && !isMember[m.InvitedBy] {
+			roots = append(roots, m.InvitedBy)
+		}
+
+		invited[m.InvitedBy] = append(invited[m.InvitedBy], m)
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Дерево инвайтов, участников %d:\n", len(members))
+
+	for _, root := range roots {
+		if root == 0 {
+			sb.WriteString("\nБыли до инвайтов\n")
+		} else {
+			fmt.Fprintf(sb, "\nПригласил ID: %d\n", root)
+		}
+
+		writeInviteTree(sb, invited, root, 0)
+	}
+
+	return c.Send(sb.String())
+}
+
+func writeInviteTree(sb *strings.Builder, invited map[int64][]storage.Member, uid int64, depth int) {
+	for _, m := range invited[uid] {
+		fmt.Fprintf(sb, "%s- %d", strings.Repeat("  ", depth), m.UID)
+
+		if m.Username != "" {
+			fmt.Fprintf(sb, " @%s", m.Username)
+		}
+
+		sb.WriteString("\n")
+
+		writeInviteTree(sb, invited, m.UID, depth+1)
+	}
+}
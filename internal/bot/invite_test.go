@@ -0,0 +1,182 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ysomad/outline-bot/internal/config"
+	"github.com/ysomad/outline-bot/internal/domain"
+)
+
+const testInvitedUserID = 103
+
+func withInviteOnly(c *config.TG) {
+	c.InviteOnly = true
+	c.InvitesPerUser = 1
+}
+
+// inviteCode creates invite as user and returns its code from the link.
+func (e *testEnv) inviteCode(t *testing.T, uid int64) string {
+	t.Helper()
+
+	if err := e.bot.memberMiddleware()(e.bot.handleInvite)(e.command(uid, "/invite")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, code, ok := strings.Cut(e.lastText(t, uid), "?start=")
+	if !ok {
+		t.Fatalf("invite link not sent: %q", e.lastText(t, uid))
+	}
+
+	code, _, _ = strings.Cut(code, "\n")
+
+	return code
+}
+
+func TestInviteOnlyWaitlist(t *testing.T) {
+	env := newTestEnv(t, withInviteOnly)
+	order := env.bot.memberMiddleware()(env.bot.handleOrder)
+
+	for range 2 {
+		if err := order(env.command(testUserID, "/order")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := env.lastText(t, testUserID); got != waitlistMsg {
+		t.Errorf("user message = %q, want waitlist", got)
+	}
+
+	if env.sent("sendMessage", testAdminID) != 1 {
+		t.Errorf("join requests sent to admin = %d, want 1", env.sent("sendMessage", testAdminID))
+	}
+
+	r, err := env.store.GetJoinRequest(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Status != domain.JoinRequestPending {
+		t.Errorf("join request status = %s, want %s", r.Status, domain.JoinRequestPending)
+	}
+}
+
+func TestJoinApproved(t *testing.T) {
+	env := newTestEnv(t, withInviteOnly)
+	order := env.bot.memberMiddleware()(env.bot.handleOrder)
+
+	if err := order(env.command(testUserID, "/order")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepApproveJoin, "100")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := env.lastText(t, testUserID), "Доступ открыт, используй /order для заказа"; got != want {
+		t.Errorf("user message = %q, want %q", got, want)
+	}
+
+	if err := order(env.command(testUserID, "/order")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := env.lastText(t, testUserID), "Сколько ключей доступа к ВПНу хочешь?"; got != want {
+		t.Errorf("order message = %q, want %q", got, want)
+	}
+
+	m, err := env.store.GetMember(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.InvitedBy != testAdminID {
+		t.Errorf("invited by = %d, want %d", m.InvitedBy, testAdminID)
+	}
+}
+
+func TestJoinDenied(t *testing.T) {
+	env := newTestEnv(t, withInviteOnly)
+
+	if err := env.bot.handleStart(env.command(testUserID, "/start")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepDenyJoin, "100")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.handleStart(env.command(testUserID, "/start")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := env.lastText(t, testUserID), "Заявка на доступ отклонена"; got != want {
+		t.Errorf("user message = %q, want %q", got, want)
+	}
+
+	if env.sent("sendMessage", testAdminID) != 1 {
+		t.Error("join request sent again after deny")
+	}
+}
+
+func TestInvite(t *testing.T) {
+	env := newTestEnv(t, withInviteOnly)
+
+	// subscriber before invite only mode is member
+	env.approvedOrder(t, 1)
+
+	code := env.inviteCode(t, testUserID)
+
+	if err := env.bot.handleStart(env.command(testInvitedUserID, "/start "+code)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testInvitedUserID); !strings.HasPrefix(got, "Это бот для доступа к ВПНу") {
+		t.Errorf("invited user message = %q, want greeting", got)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasPrefix(got, "По твоему инвайту присоединился друг") {
+		t.Errorf("inviter message = %q, want join notification", got)
+	}
+
+	// member without order can't invite
+	if err := env.bot.memberMiddleware()(env.bot.handleInvite)(env.command(testInvitedUserID, "/invite")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testInvitedUserID); !strings.HasPrefix(got, "Инвайты создают только пользователи с действующим заказом") {
+		t.Errorf("invite of member without order = %q, want order required", got)
+	}
+
+	// invite is one-time
+	if err := env.bot.handleStart(env.command(testExpiredUserID, "/start "+code)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testExpiredUserID); got != waitlistMsg {
+		t.Errorf("message = %q, want waitlist", got)
+	}
+
+	// limit of invites is reached
+	if err := env.bot.memberMiddleware()(env.bot.handleInvite)(env.command(testUserID, "/invite")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := env.lastText(t, testUserID), "Ты уже создал 1 из 1 инвайтов"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+
+	// admin is not limited
+	env.inviteCode(t, testAdminID)
+	env.inviteCode(t, testAdminID)
+
+	if err := env.bot.handleInvites(env.command(testAdminID, "/invites")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Дерево инвайтов, участников 2:\n\nБыли до инвайтов\n- 100\n  - 103\n"
+	if got := env.lastText(t, testAdminID); got != want {
+		t.Errorf("invites = %q, want %q", got, want)
+	}
+}
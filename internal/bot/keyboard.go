@@ -2,6 +2,8 @@ package bot
 
 import (
 	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
 )
 
 func paymentKeyboard() *tele.ReplyMarkup {
@@ -9,3 +11,13 @@ func paymentKeyboard() *tele.ReplyMarkup {
 	kb.Inline(kb.Row(kb.URL("Оплатить", paymentURL)))
 	return kb
 }
+
+// orderPaymentKeyboard returns payment keyboard of new order with button to cancel it.
+func orderPaymentKeyboard(oid domain.OrderID) *tele.ReplyMarkup {
+	kb := &tele.ReplyMarkup{}
+	kb.Inline(
+		kb.Row(kb.URL("Оплатить", paymentURL)),
+		kb.Row(kb.Data("Отменить заказ", stepCancelOrder.String(), oid.String())),
+	)
+	return kb
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"log/slog"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/ysomad/outline-bot/internal/domain"
 )
 
 type logCtxKey struct{}
 
+// logCtx values are added to log records and set as attributes of current span.
 type logCtx struct {
 	UID            int64
 	Username       string
@@ -18,6 +22,10 @@ type logCtx struct {
 }
 
 func withUser(ctx context.Context, uid int64, username string) context.Context {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int64("user_id", uid),
+		attribute.String("username", username))
+
 	if c, ok := ctx.Value(logCtxKey{}).(logCtx); ok {
 		c.UID = uid
 		c.Username = username
@@ -31,6 +39,8 @@ func withUser(ctx context.Context, uid int64, username string) context.Context {
 }
 
 func withOrderID(ctx context.Context, oid domain.OrderID) context.Context {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("order_id", int(oid)))
+
 	if c, ok := ctx.Value(logCtxKey{}).(logCtx); ok {
 		c.OrderID = oid
 		return context.WithValue(ctx, logCtxKey{}, c)
@@ -40,6 +50,10 @@ func withOrderID(ctx context.Context, oid domain.OrderID) context.Context {
 }
 
 func withCallback(ctx context.Context, cb btnCallback) context.Context {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("callback_unique", cb.unique),
+		attribute.String("callback_data", cb.data))
+
 	if c, ok := ctx.Value(logCtxKey{}).(logCtx); ok {
 		c.CallbackData = cb.data
 		c.CallbackUnique = cb.unique
@@ -2,7 +2,7 @@ package bot
 
 import (
 	"context"
-	"errors"
+	"time"
 
 	tele "gopkg.in/telebot.v3"
 )
@@ -11,6 +11,9 @@ const ctxKey = "stdcontext"
 
 // stdContext returns context.Context from telebot context.
 func stdContext(c tele.Context) context.Context {
+	if c == nil {
+		return context.Background()
+	}
 	v := c.Get(ctxKey)
 	if v == nil {
 		return context.Background()
@@ -21,11 +24,19 @@ func stdContext(c tele.Context) context.Context {
 	return context.Background()
 }
 
-// contextMiddleware saves user information from tele.Context to context.Context.
-func contextMiddleware() tele.MiddlewareFunc {
+// contextMiddleware saves user information from tele.Context to context.Context
+// and limits handling of update by timeout, 0 timeout is unlimited.
+func contextMiddleware(timeout time.Duration) tele.MiddlewareFunc {
 	return func(next tele.HandlerFunc) tele.HandlerFunc {
 		return func(c tele.Context) error {
-			ctx := context.Background()
+			ctx := stdContext(c)
+
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
 			ch := c.Chat()
 			ctx = withUser(ctx, ch.ID, ch.Username)
 			c.Set(ctxKey, ctx)
@@ -34,11 +45,12 @@ func contextMiddleware() tele.MiddlewareFunc {
 	}
 }
 
-func adminMiddleware(admin int64) tele.MiddlewareFunc {
+// privateMiddleware ignores updates from group chats, e.g. user commands sent into admin group.
+func privateMiddleware() tele.MiddlewareFunc {
 	return func(next tele.HandlerFunc) tele.HandlerFunc {
 		return func(c tele.Context) error {
-			if c.Chat().ID != admin {
-				return errors.New("access denied")
+			if c.Chat().Type != tele.ChatPrivate {
+				return nil
 			}
 			return next(c)
 		}
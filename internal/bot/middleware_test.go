@@ -0,0 +1,47 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestContextMiddlewareTimeout(t *testing.T) {
+	env := newTestEnv(t)
+
+	var ctx context.Context
+
+	h := contextMiddleware(time.Minute)(func(c tele.Context) error {
+		ctx = stdContext(c)
+
+		deadline, ok := ctx.Deadline()
+		if !ok || time.Until(deadline) > time.Minute {
+			t.Errorf("deadline = %v, %t, want in a minute", deadline, ok)
+		}
+
+		return nil
+	})
+
+	if err := h(env.text(testUserID, "hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	if ctx.Err() == nil {
+		t.Error("context of handled update not canceled")
+	}
+}
+
+func TestWorkerTimeout(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.bot.runWorker(context.Background(), time.Millisecond, func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Error("worker tick not canceled by timeout")
+		}
+		return ctx.Err()
+	}, "test")
+}
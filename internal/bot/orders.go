@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const ordersPageSize = 10
+
+// handleOrders sends first page of orders with status from args, all orders are listed without args.
+func (b *Bot) handleOrders(c tele.Context) error {
+	var status domain.OrderStatus
+
+	if args := c.Args(); len(args) > 0 {
+		var err error
+
+		status, err = domain.ParseOrderStatus(strings.Join(args, " "))
+		if errors.Is(err, domain.ErrInvalidOrderStatus) {
+			statuses := make([]string, len(domain.OrderStatuses))
+			for i, s := range domain.OrderStatuses {
+				statuses[i] = string(s)
+			}
+
+			return c.Send("Неизвестный статус, доступные: " + strings.Join(statuses, ", "))
+		}
+	}
+
+	msg, kb, err := b.ordersPage(stdContext(c), status, 0)
+	if err != nil {
+		return err
+	}
+
+	return c.Send(msg, kb)
+}
+
+// showOrdersPage triggers on navigation over orders pages, data is status and page separated by colon.
+func (b *Bot) showOrdersPage(c tele.Context, ctx context.Context, cb btnCallback) error {
+	i := strings.LastIndex(cb.data, ":")
+	if i == -1 {
+		return fmt.Errorf("invalid orders page callback data: %s", cb.data)
+	}
+
+	page, err := strconv.Atoi(cb.data[i+1:])
+	if err != nil {
+		return fmt.Errorf("page not found in callback data: %w", err)
+	}
+
+	msg, kb, err := b.ordersPage(ctx, domain.OrderStatus(cb.data[:i]), page)
+	if err != nil {
+		return err
+	}
+
+	return c.Edit(msg, kb)
+}
+
+func (b *Bot) ordersPage(ctx context.Context, status domain.OrderStatus, page int) (string, *tele.ReplyMarkup, error) {
+	total, err := b.storage.CountOrders(ctx, status)
+	if err != nil {
+		return "", nil, fmt.Errorf("orders not counted: %w", err)
+	}
+
+	orders, err := b.storage.ListOrders(ctx, storage.ListOrdersParams{
+		Status: status,
+		Offset: page * ordersPageSize,
+		Limit:  ordersPageSize,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("orders not listed: %w", err)
+	}
+
+	pages := (total + ordersPageSize - 1) / ordersPageSize
+	sb := &strings.Builder{}
+
+	sb.WriteString("Заказы")
+
+	if status != "" {
+		fmt.Fprintf(sb, " (%s)", status)
+	}
+
+	if total == 0 {
+		sb.WriteString("\n\nЗаказов нет")
+		return sb.String(), nil, nil
+	}
+
+	fmt.Fprintf(sb, ", всего %d, страница %d из %d\n", total, page+1, pages)
+
+	for _, o := range orders {
+		sb.WriteString("\n")
+		writeOrderLine(sb, o)
+		fmt.Fprintf(sb, ", ID: %d", o.UID)
+
+		if o.Username.String != "" {
+			fmt.Fprintf(sb, " @%s", o.Username.String)
+		}
+	}
+
+	kb := &tele.ReplyMarkup{}
+
+	var nav []tele.Btn
+
+	if page > 0 {
+		nav = append(nav, kb.Data("« Назад", stepOrdersPage.String(), fmt.Sprintf("%s:%d", status, page-1)))
+	}
+
+	if page+1 < pages {
+		nav = append(nav, kb.Data("Вперед »", stepOrdersPage.String(), fmt.Sprintf("%s:%d", status, page+1)))
+	}
+
+	if len(nav) == 0 {
+		return sb.String(), nil, nil
+	}
+
+	kb.Inline(kb.Row(nav...))
+
+	return sb.String(), kb, nil
+}
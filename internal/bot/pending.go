@@ -0,0 +1,194 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+// pendingLimit is max amount of orders and renewals sent by /pending each.
+const pendingLimit = 20
+
+// handlePending sends every order awaiting payment and every expiring order awaiting renewal
+// in separate message with approve and reject buttons, the same as admin receives them first time.
+func (b *Bot) handlePending(c tele.Context) error {
+	ctx := stdContext(c)
+	now := time.Now()
+
+	orders, err := b.storage.ListPendingOrders(ctx, now)
+	if err != nil {
+		return fmt.Errorf("pending orders not listed: %w", err)
+	}
+
+	keys, err := b.storage.ListExpiringKeys(ctx, domain.BeforeOrderExpiration)
+	if err != nil {
+		return fmt.Errorf("expiring keys not listed: %w", err)
+	}
+
+	var renewals []order
+
+	for o := range groupExpiringKeys(keys) {
+		// expired orders are closed by deactivation worker, trials are not renewed
+		if o.expiresAt.After(now) && !o.trial {
+			renewals = append(renewals, o)
+		}
+	}
+
+	sort.Slice(renewals, func(i, j int) bool { return renewals[i].expiresAt.Before(renewals[j].expiresAt) })
+
+	if len(orders) == 0 && len(renewals) == 0 {
+		return c.Send("Нет заказов и продлений, ожидающих одобрения")
+	}
+
+	msg := fmt.Sprintf("Ожидают одобрения заказов %d, продлений %d", len(orders), len(renewals))
+	if len(orders) > pendingLimit || len(renewals) > pendingLimit {
+		msg += fmt.Sprintf(", показаны первые %d", pendingLimit)
+	}
+
+	if err := c.Send(msg); err != nil {
+		return err
+	}
+
+	for i, o := range orders {
+		if i == pendingLimit {
+			break
+		}
+
+		msg := orderCreatedMsg(o.ID, o.Price-o.Discount, o.KeyAmount, promoNote(o.PromoCode.String, o.Discount, o.BonusDays), orderUser(o))
+		msg += fmt.Sprintf("\n\nСоздан %s", o.CreatedAt.Time.Format("02.01.2006 15:04"))
+
+		m, err := b.tele.Send(c.Chat(), msg, orderAdminKeyboard(o.ID))
+		if err != nil {
+			return fmt.Errorf("pending order not sent: %w", err)
+		}
+
+		if err := b.rememberOrderMessages(ctx, o.ID, storage.AdminMessage{ChatID: m.Chat.ID, MessageID: m.ID}); err != nil {
+			return err
+		}
+	}
+
+	for i, o := range renewals {
+		if i == pendingLimit {
+			break
+		}
+
+		m, err := b.tele.Send(c.Chat(), renewalMsg(o), renewalAdminKeyboard(o.id, o.expiresAt))
+		if err != nil {
+			return fmt.Errorf("pending renewal not sent: %w", err)
+		}
+
+		if err := b.rememberOrderMessages(ctx, o.id, storage.AdminMessage{ChatID: m.Chat.ID, MessageID: m.ID}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remindPendingOrders sends to admin list of orders awaiting payment longer than after.
+func (b *Bot) remindPendingOrders(ctx context.Context, after time.Duration) error {
+	orders, err := b.storage.ListPendingOrders(ctx, time.Now().Add(-after))
+	if err != nil {
+		return fmt.Errorf("pending orders not listed: %w", err)
+	}
+
+	if len(orders) == 0 {
+		return nil
+	}
+
+	sb := &strings.Builder{}
+
+	fmt.Fprintf(sb, "Заказы ждут одобрения дольше %s:\n", formatDuration(after))
+
+	for _, o := range orders {
+		fmt.Fprintf(sb, "\n№%d от %s, %d руб., ID: %d", o.ID, o.CreatedAt.Time.Format("02.01.2006 15:04"), o.Price-o.Discount, o.UID)
+
+		if o.Username.String != "" {
+			fmt.Fprintf(sb, " @%s", o.Username.String)
+		}
+	}
+
+	sb.WriteString("\n\n/pending - одобрить или отклонить")
+
+	if _, err = b.notifyAdmins(ctx, eventPending, 0, sb.String()); err != nil {
+		return fmt.Errorf("pending orders reminder not sent to admin: %w", err)
+	}
+
+	slog.InfoContext(ctx, "admin reminded about pending orders", "amount", len(orders))
+
+	return nil
+}
+
+// cancelUnpaidOrders closes orders awaiting payment longer than ttl and tells users about it.
+func (b *Bot) cancelUnpaidOrders(ctx context.Context, ttl time.Duration) error {
+	now := time.Now()
+
+	orders, err := b.storage.ListPendingOrders(ctx, now.Add(-ttl))
+	if err != nil {
+		return fmt.Errorf("unpaid orders not listed: %w", err)
+	}
+
+	for _, o := range orders {
+		if err := b.cancelUnpaidOrder(withOrderID(ctx, o.ID), o, ttl, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Bot) cancelUnpaidOrder(ctx context.Context, o storage.Order, ttl time.Duration, now time.Time) error {
+	err := b.storage.CancelOrder(ctx, o.ID, now)
+	if errors.Is(err, storage.ErrNotFound) {
+		// paid or canceled since orders were listed
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("order %d not canceled: %w", o.ID, err)
+	}
+
+	slog.InfoContext(ctx, "unpaid order canceled")
+
+	b.metrics.ordersCanceled.Add(ctx, 1, metric.WithAttributes(attrBackend(o.Backend)))
+
+	usr := orderUser(o)
+
+	msg := fmt.Sprintf("Заказ №%d отменен, потому что не был оплачен в течение %s. Если ВПН еще нужен, размести новый заказ через /order", o.ID, formatDuration(ttl))
+
+	if _, err := b.tele.Send(usr, msg); err != nil {
+		slog.WarnContext(ctx, "cancel msg not sent to user", "cause", err.Error())
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Заказ №%d на сумму %d руб. отменен, не оплачен в течение %s\n\n", o.ID, o.Price-o.Discount, formatDuration(ttl))
+	usr.write(sb)
+
+	// remove approve and reject buttons of canceled order
+	if err := b.editAdminMessages(ctx, o.ID, nil, sb.String()); err != nil {
+		return err
+	}
+
+	if _, err := b.notifyAdmins(ctx, eventPending, o.ID, sb.String()); err != nil {
+		return fmt.Errorf("canceled order not sent to admin: %w", err)
+	}
+
+	return nil
+}
+
+// formatDuration formats duration in days or hours.
+func formatDuration(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d дн.", d/(24*time.Hour))
+	}
+	return fmt.Sprintf("%d ч.", int(d.Round(time.Hour).Hours()))
+}
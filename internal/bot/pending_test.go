@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+// oldOrder creates order of test user awaiting payment since age ago.
+func (e *testEnv) oldOrder(t *testing.T, age time.Duration) domain.OrderID {
+	t.Helper()
+
+	oid, err := e.store.CreateOrder(context.Background(), storage.CreateOrderParams{
+		UID:       testUserID,
+		Username:  "test_user",
+		KeyAmount: 1,
+		Price:     domain.PricePerKey,
+		CreatedAt: time.Now().Add(-age),
+		Status:    domain.OrderStatusAwaitingPayment,
+		Backend:   domain.BackendOutline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return oid
+}
+
+func TestPending(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.approvedOrder(t, 1)
+	env.store.Now = func() time.Time { return time.Now().Add(domain.OrderTTL - 24*time.Hour) }
+	pending := env.oldOrder(t, time.Hour)
+
+	before := env.sent("sendMessage", testAdminID)
+
+	if err := env.bot.handlePending(env.command(testAdminID, "/pending")); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := env.tg.Calls("sendMessage", testAdminID)[before:]
+	if len(calls) != 3 {
+		t.Fatalf("sent %d messages, want header, order and renewal", len(calls))
+	}
+
+	if calls[0].Text != "Ожидают одобрения заказов 1, продлений 1" {
+		t.Errorf("header = %q", calls[0].Text)
+	}
+
+	if !strings.HasPrefix(calls[1].Text, "Новый заказ №2") {
+		t.Errorf("order msg = %q", calls[1].Text)
+	}
+
+	if btn, ok := calls[1].Button("Одобрить"); !ok || !strings.HasSuffix(btn.Data, "|"+pending.String()) {
+		t.Error("order msg has no approve button")
+	}
+
+	if !strings.HasPrefix(calls[2].Text, "Заказ №1 истекает") {
+		t.Errorf("renewal msg = %q", calls[2].Text)
+	}
+
+	if _, ok := calls[2].Button("Продлить на 1 месяц"); !ok {
+		t.Error("renewal msg has no renew button")
+	}
+}
+
+func TestRemindPendingOrders(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.oldOrder(t, time.Hour)
+	env.oldOrder(t, 25*time.Hour)
+
+	if err := env.bot.remindPendingOrders(context.Background(), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := env.lastText(t, testAdminID)
+
+	if !strings.HasPrefix(msg, "Заказы ждут одобрения дольше 1 дн.:\n\n№2 от ") || strings.Contains(msg, "№1") {
+		t.Errorf("unexpected reminder:\n%s", msg)
+	}
+
+	env = newTestEnv(t)
+	env.oldOrder(t, time.Hour)
+
+	if err := env.bot.remindPendingOrders(context.Background(), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.sent("sendMessage", testAdminID) != 0 {
+		t.Error("reminder sent without stale orders")
+	}
+}
+
+func TestCancelUnpaidOrderPaidMeanwhile(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	oid := env.oldOrder(t, 73*time.Hour)
+
+	listed, err := env.store.GetOrder(ctx, oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// approved after worker listed unpaid orders
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepApproveOrder, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := env.sent("sendMessage", testUserID)
+
+	if err := env.bot.cancelUnpaidOrder(ctx, listed, 72*time.Hour, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusApproved {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusApproved)
+	}
+
+	if env.sent("sendMessage", testUserID) != sent {
+		t.Error("paid order canceled for user")
+	}
+}
+
+func TestCancelUnpaidOrders(t *testing.T) {
+	env := newTestEnv(t)
+
+	unpaid := env.oldOrder(t, 73*time.Hour)
+	fresh := env.oldOrder(t, time.Hour)
+
+	// admin receives order messages with buttons
+	if err := env.bot.handlePending(env.command(testAdminID, "/pending")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.cancelUnpaidOrders(context.Background(), 72*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, unpaid); got != domain.OrderStatusCanceled {
+		t.Errorf("unpaid status = %q, want %q", got, domain.OrderStatusCanceled)
+	}
+
+	if got := env.orderStatus(t, fresh); got != domain.OrderStatusAwaitingPayment {
+		t.Errorf("fresh status = %q, want %q", got, domain.OrderStatusAwaitingPayment)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasPrefix(got, "Заказ №1 отменен, потому что не был оплачен в течение 3 дн.") {
+		t.Errorf("cancel msg = %q", got)
+	}
+
+	if !strings.HasPrefix(env.lastText(t, testAdminID), "Заказ №1 на сумму 150 руб. отменен") {
+		t.Error("canceled order not sent to admin")
+	}
+
+	edits := env.tg.Calls("editMessageText", testAdminID)
+	if len(edits) != 1 || !strings.HasPrefix(edits[0].Text, "Заказ №1 на сумму 150 руб. отменен") {
+		t.Fatalf("order msg of admin not edited: %+v", edits)
+	}
+
+	if _, ok := edits[0].Button("Одобрить"); ok {
+		t.Error("canceled order msg has approve button")
+	}
+
+	// admin presses approve in message received before cancel
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepApproveOrder, unpaid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(env.outline.Keys()) != 0 {
+		t.Error("keys created for canceled order")
+	}
+
+	edits = env.tg.Calls("editMessageText", testAdminID)
+	if len(edits) != 2 || edits[1].Text != "Заказ №1 уже обработан (canceled)" {
+		t.Errorf("unexpected edits: %+v", edits)
+	}
+}
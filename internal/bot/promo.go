@@ -0,0 +1,345 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const promoUsage = "Использование:\n/promo - промокоды и их статистика\n/promo add <код> [percent=N] [amount=N] [days=N] [uses=N] [until=ДД.ММ.ГГГГ] [first]\n/promo disable <код>\n\npercent - скидка в процентах, amount - скидка в рублях, days - дни в подарок, uses - число использований, until - последний день действия, first - только на первый заказ"
+
+var promoCodeRe = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// parsePromo parses options of /promo add.
+func parsePromo(code string, opts []string, now time.Time) (storage.Promo, error) {
+	p := storage.Promo{
+		Code:      strings.ToUpper(code),
+		CreatedAt: now,
+	}
+
+	if !promoCodeRe.MatchString(p.Code) {
+		return p, fmt.Errorf("invalid code %q", code)
+	}
+
+	for _, opt := range opts {
+		if opt == "first" {
+			p.FirstOrderOnly = true
+			continue
+		}
+
+		name, val, _ := strings.Cut(opt, "=")
+
+		if name == "until" {
+			until, err := time.ParseInLocation("02.01.2006", val, now.Location())
+			if err != nil {
+				return p, fmt.Errorf("invalid until %q: %w", val, err)
+			}
+
+			// promo works until the end of the day
+			p.ExpiresAt = sql.NullTime{Time: until.AddDate(0, 0, 1), Valid: true}
+
+			continue
+		}
+
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("invalid option %q", opt)
+		}
+
+		switch name {
+		case "percent":
+			if n > 100 {
+				return p, fmt.Errorf("invalid percent %d", n)
+			}
+			p.Percent = n
+		case "amount":
+			p.Amount = n
+		case "days":
+			p.Days = n
+		case "uses":
+			p.MaxUses = n
+		default:
+			return p, fmt.Errorf("unsupported option %q", opt)
+		}
+	}
+
+	if p.Percent == 0 && p.Amount == 0 && p.Days == 0 {
+		return p, errors.New("promo gives nothing, set percent, amount or days")
+	}
+
+	return p, nil
+}
+
+// promoBenefit returns description of what promo gives.
+func promoBenefit(p storage.Promo) string {
+	var parts []string
+
+	if p.Percent > 0 {
+		parts = append(parts, fmt.Sprintf("скидка %d%%", p.Percent))
+	}
+
+	if p.Amount > 0 {
+		parts = append(parts, fmt.Sprintf("скидка %d₽", p.Amount))
+	}
+
+	if p.Days > 0 {
+		parts = append(parts, fmt.Sprintf("+%d дн. в подарок", p.Days))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// promoDiscount returns discount of promo for price, it never exceeds the price.
+func promoDiscount(p storage.Promo, price int) int {
+	return min(price*p.Percent/100+p.Amount, price)
+}
+
+// promoNote returns promo applied to order, empty if order is placed without promo.
+func promoNote(code string, discount, days int) string {
+	if code == "" {
+		return ""
+	}
+
+	note := fmt.Sprintf("Промокод %s: скидка %d₽", code, discount)
+
+	if days > 0 {
+		note += fmt.Sprintf(", +%d дн.", days)
+	}
+
+	return note
+}
+
+func (b *Bot) handlePromo(c tele.Context) error {
+	args := c.Args()
+	ctx := stdContext(c)
+
+	switch {
+	case len(args) == 0:
+		return b.sendPromos(c, ctx)
+	case args[0] == "add" && len(args) >= 3:
+		return b.addPromo(c, ctx, args[1], args[2:])
+	case args[0] == "disable" && len(args) == 2:
+		return b.disablePromo(c, ctx, strings.ToUpper(args[1]))
+	default:
+		return c.Send(promoUsage)
+	}
+}
+
+func (b *Bot) addPromo(c tele.Context, ctx context.Context, code string, opts []string) error {
+	p, err := parsePromo(code, opts, time.Now())
+	if err != nil {
+		return c.Send(err.Error() + "\n\n" + promoUsage)
+	}
+
+	_, err = b.storage.GetPromo(ctx, p.Code)
+	if err == nil {
+		return c.Send(fmt.Sprintf("Промокод %s уже существует", p.Code))
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("promo not received: %w", err)
+	}
+
+	if err := b.storage.CreatePromo(ctx, p); err != nil {
+		return fmt.Errorf("promo not created: %w", err)
+	}
+
+	slog.InfoContext(ctx, "promo created", "promo_code", p.Code)
+
+	sb := &strings.Builder{}
+	sb.WriteString("Промокод создан\n\n")
+	writePromo(sb, storage.PromoStats{Promo: p})
+
+	return c.Send(sb.String())
+}
+
+func (b *Bot) disablePromo(c tele.Context, ctx context.Context, code string) error {
+	_, err := b.storage.GetPromo(ctx, code)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Send(fmt.Sprintf("Промокод %s не найден", code))
+	}
+	if err != nil {
+		return fmt.Errorf("promo not received: %w", err)
+	}
+
+	if err := b.storage.DisablePromo(ctx, code); err != nil {
+		return fmt.Errorf("promo not disabled: %w", err)
+	}
+
+	slog.InfoContext(ctx, "promo disabled", "promo_code", code)
+
+	return c.Send(fmt.Sprintf("Промокод %s отключен", code))
+}
+
+// sendPromos sends promos with their usage stats.
+func (b *Bot) sendPromos(c tele.Context, ctx context.Context) error {
+	promos, err := b.storage.ListPromos(ctx)
+	if err != nil {
+		return fmt.Errorf("promos not listed: %w", err)
+	}
+
+	if len(promos) == 0 {
+		return c.Send("Промокодов нет\n\n" + promoUsage)
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString("Промокоды:")
+
+	for _, p := range promos {
+		sb.WriteString("\n\n")
+		writePromo(sb, p)
+	}
+
+	return c.Send(sb.String())
+}
+
+func writePromo(sb *strings.Builder, p storage.PromoStats) {
+	fmt.Fprintf(sb, "%s - %s", p.Code, promoBenefit(p.Promo))
+
+	if p.FirstOrderOnly {
+		sb.WriteString(", только первый заказ")
+	}
+
+	if p.ExpiresAt.Valid {
+		fmt.Fprintf(sb, ", до %s", p.ExpiresAt.Time.AddDate(0, 0, -1).Format("02.01.2006"))
+	}
+
+	if p.Disabled {
+		sb.WriteString(", отключен")
+	}
+
+	fmt.Fprintf(sb, "\nИспользован %d", p.Uses)
+
+	if p.MaxUses > 0 {
+		fmt.Fprintf(sb, " из %d", p.MaxUses)
+	}
+
+	fmt.Fprintf(sb, " раз, оплачено заказов %d, скидка %d₽", p.PaidOrders, p.Discount)
+}
+
+// enterPromo triggers after user pressed promo button in /order.
+func (b *Bot) enterPromo(c tele.Context) error {
+	b.state.Add(newUser(c.Chat()).ID(), State{step: stepEnterPromo.String()})
+
+	kb := &tele.ReplyMarkup{}
+	kb.Inline(kb.Row(btnCancel(kb)))
+
+	return c.Edit("Отправь промокод", kb)
+}
+
+// applyPromo checks promo sent by user and asks amount of keys to order with it.
+func (b *Bot) applyPromo(c tele.Context, ctx context.Context, usr *user) error {
+	p, reason, err := b.checkPromo(ctx, strings.ToUpper(strings.TrimSpace(c.Text())), usr.id)
+	if err != nil {
+		return err
+	}
+
+	if reason != "" {
+		kb := &tele.ReplyMarkup{}
+		kb.Inline(kb.Row(btnCancel(kb)))
+
+		return c.Send(reason+", попробуй еще раз", kb)
+	}
+
+	b.state.Add(usr.ID(), State{step: stepSelectKeyAmount.String(), data: p.Code})
+
+	return c.Send(fmt.Sprintf("Промокод %s применен: %s\n\nСколько ключей доступа к ВПНу хочешь?", p.Code, promoBenefit(p)), keyAmountKeyboard(false))
+}
+
+// checkPromo returns promo by code and reason why user can't use it, reason is empty if promo may be used.
+func (b *Bot) checkPromo(ctx context.Context, code string, uid int64) (storage.Promo, string, error) {
+	p, err := b.storage.GetPromo(ctx, code)
+	if errors.Is(err, storage.ErrNotFound) {
+		return p, "Промокод не найден", nil
+	}
+	if err != nil {
+		return p, "", fmt.Errorf("promo not received: %w", err)
+	}
+
+	switch {
+	case p.Disabled:
+		return p, "Промокод не найден", nil
+	case p.ExpiresAt.Valid && !time.Now().Before(p.ExpiresAt.Time):
+		return p, "Срок действия промокода истек", nil
+	case p.MaxUses > 0 && p.Uses >= p.MaxUses:
+		return p, "Промокод закончился", nil
+	}
+
+	if !p.FirstOrderOnly {
+		return p, "", nil
+	}
+
+	orders, err := b.storage.ListUserOrders(ctx, uid)
+	if err != nil {
+		return p, "", fmt.Errorf("user orders not listed: %w", err)
+	}
+
+	for _, o := range orders {
+		// order is paid if it was approved, free trials and granted keys are not paid,
+		// order awaiting payment is counted too, otherwise promo may be applied to several first orders
+		pending := o.Status.String == string(domain.OrderStatusAwaitingPayment)
+		if (o.ExpiresAt.Valid || pending) && o.Price > 0 {
+			return p, "Промокод действует только на первый заказ", nil
+		}
+	}
+
+	return p, "", nil
+}
+
+// orderPromo returns promo applied by user in /order, zero promo if there is no promo or it can't be used.
+// Use of promo is counted when order is approved.
+func (b *Bot) orderPromo(c tele.Context, ctx context.Context, usr *user) (storage.Promo, error) {
+	state, ok := b.state.Get(usr.ID())
+	if !ok {
+		return storage.Promo{}, nil
+	}
+
+	code, ok := state.data.(string)
+	if !ok {
+		return storage.Promo{}, nil
+	}
+
+	p, reason, err := b.checkPromo(ctx, code, usr.id)
+	if err != nil {
+		return storage.Promo{}, err
+	}
+
+	if reason != "" {
+		return storage.Promo{}, c.Send(reason + ", заказ размещен без него")
+	}
+
+	slog.InfoContext(ctx, "promo applied", "promo_code", code)
+
+	return p, nil
+}
+
+// usePromo counts use of promo applied to order when the order is approved.
+// Spent promo is still honored, because user has already paid discounted price.
+func (b *Bot) usePromo(ctx context.Context, order storage.Order) error {
+	if !order.PromoCode.Valid {
+		return nil
+	}
+
+	err := b.storage.UsePromo(ctx, order.PromoCode.String)
+	if errors.Is(err, storage.ErrNotFound) {
+		slog.WarnContext(ctx, "spent promo used by approved order", "promo_code", order.PromoCode.String)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("promo not used: %w", err)
+	}
+
+	slog.InfoContext(ctx, "promo used", "promo_code", order.PromoCode.String)
+
+	return nil
+}
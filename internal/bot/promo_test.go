@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+)
+
+// enterPromo sends promo code in /order as user does.
+func (e *testEnv) enterPromo(t *testing.T, uid int64, code string) {
+	t.Helper()
+
+	if err := e.bot.handleCallback(e.callback(uid, stepEnterPromo, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.bot.handleText(e.text(uid, code)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPromo(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := env.bot.handlePromo(env.command(testAdminID, "/promo add spring percent=10 days=7 uses=1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testAdminID); !strings.HasPrefix(got, "Промокод создан\n\nSPRING - скидка 10%, +7 дн. в подарок") {
+		t.Errorf("created = %q, want promo description", got)
+	}
+
+	env.enterPromo(t, testUserID, "spring")
+
+	if got := env.lastText(t, testUserID); !strings.HasPrefix(got, "Промокод SPRING применен") {
+		t.Errorf("applied = %q, want promo applied", got)
+	}
+
+	oid := env.order(t, 2)
+
+	// use is counted on approval
+	p, err := env.store.GetPromo(context.Background(), "SPRING")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Uses != 0 {
+		t.Errorf("uses before approval = %d, want 0", p.Uses)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepApproveOrder, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := env.store.GetOrder(context.Background(), oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if o.PromoCode.String != "SPRING" || o.Discount != 30 || o.BonusDays != 7 {
+		t.Errorf("order promo = %q, discount = %d, bonus days = %d, want SPRING, 30, 7", o.PromoCode.String, o.Discount, o.BonusDays)
+	}
+
+	if want := time.Now().Add(domain.OrderTTL + 6*24*time.Hour); o.ExpiresAt.Time.Before(want) {
+		t.Errorf("expires at = %s, want bonus days added", o.ExpiresAt.Time)
+	}
+
+	calls := env.tg.Calls("sendPhoto", testUserID)
+	if got := calls[len(calls)-1].Text; !strings.Contains(got, "к оплате 270₽") {
+		t.Errorf("payment = %q, want discounted price", got)
+	}
+
+	// promo is spent
+	env.enterPromo(t, testInvitedUserID, "SPRING")
+
+	if got, want := env.lastText(t, testInvitedUserID), "Промокод закончился, попробуй еще раз"; got != want {
+		t.Errorf("spent = %q, want %q", got, want)
+	}
+
+	if err := env.bot.handlePromo(env.command(testAdminID, "/promo")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testAdminID); !strings.Contains(got, "Использован 1 из 1 раз, оплачено заказов 1, скидка 30₽") {
+		t.Errorf("stats = %q, want promo usage", got)
+	}
+}
+
+func TestPromoFirstOrderOnly(t *testing.T) {
+	env := newTestEnv(t)
+	env.approvedOrder(t, 1)
+
+	if err := env.bot.handlePromo(env.command(testAdminID, "/promo add NEW amount=50 first")); err != nil {
+		t.Fatal(err)
+	}
+
+	env.enterPromo(t, testUserID, "NEW")
+
+	if got, want := env.lastText(t, testUserID), "Промокод действует только на первый заказ, попробуй еще раз"; got != want {
+		t.Errorf("first order = %q, want %q", got, want)
+	}
+
+	if err := env.bot.handlePromo(env.command(testAdminID, "/promo disable new")); err != nil {
+		t.Fatal(err)
+	}
+
+	env.enterPromo(t, testInvitedUserID, "NEW")
+
+	if got, want := env.lastText(t, testInvitedUserID), "Промокод не найден, попробуй еще раз"; got != want {
+		t.Errorf("disabled = %q, want %q", got, want)
+	}
+}
+
+func TestPromoFirstOrderPending(t *testing.T) {
+	env := newTestEnv(t)
+	env.order(t, 1)
+
+	if err := env.bot.handlePromo(env.command(testAdminID, "/promo add NEW amount=50 first")); err != nil {
+		t.Fatal(err)
+	}
+
+	env.enterPromo(t, testUserID, "NEW")
+
+	if got, want := env.lastText(t, testUserID), "Промокод действует только на первый заказ, попробуй еще раз"; got != want {
+		t.Errorf("pending order = %q, want %q", got, want)
+	}
+}
+
+func TestParsePromo(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	for _, opts := range [][]string{
+		nil,
+		{"percent=101"},
+		{"amount=-1"},
+		{"days=x"},
+		{"until=31.02.2026"},
+		{"bonus=1"},
+	} {
+		if _, err := parsePromo("CODE", opts, now); err == nil {
+			t.Errorf("parsePromo(%v) error = nil, want error", opts)
+		}
+	}
+
+	p, err := parsePromo("code", []string{"amount=50", "until=31.10.2026", "first"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Code != "CODE" || p.Amount != 50 || !p.FirstOrderOnly || !p.ExpiresAt.Time.Equal(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("parsePromo = %+v", p)
+	}
+}
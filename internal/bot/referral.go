@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+// referralPrefix starts payload of referral deep link t.me/bot?start=ref<uid>, invite codes never start with it.
+const referralPrefix = "ref"
+
+func (b *Bot) referralLink(uid int64) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s%d", b.tele.Me.Username, referralPrefix, uid)
+}
+
+// parseReferral returns id of referrer from payload of /start.
+func parseReferral(payload string) (int64, bool) {
+	s, ok := strings.CutPrefix(payload, referralPrefix)
+	if !ok {
+		return 0, false
+	}
+
+	uid, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return uid, true
+}
+
+// saveReferral remembers referrer of user if user is new, i.e. has no orders.
+func (b *Bot) saveReferral(ctx context.Context, usr *user, referrerID int64) error {
+	if b.referralCredit == 0 || referrerID == usr.id {
+		return nil
+	}
+
+	orders, err := b.storage.ListUserOrders(ctx, usr.id)
+	if err != nil {
+		return fmt.Errorf("user orders not listed: %w", err)
+	}
+
+	if len(orders) > 0 {
+		return nil
+	}
+
+	err = b.storage.SaveReferral(ctx, storage.Referral{UID: usr.id, ReferrerID: referrerID, CreatedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("referral not saved: %w", err)
+	}
+
+	slog.InfoContext(ctx, "referral saved", "referrer_id", referrerID)
+
+	return nil
+}
+
+// rewardReferrer credits referrer of user when first order of the user is approved.
+func (b *Bot) rewardReferrer(ctx context.Context, order storage.Order) error {
+	if b.referralCredit == 0 {
+		return nil
+	}
+
+	r, err := b.storage.RewardReferral(ctx, order.UID, b.referralCredit, order.ID, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("referral not rewarded: %w", err)
+	}
+
+	slog.InfoContext(ctx, "referrer rewarded", "referrer_id", r.ReferrerID, "credit", b.referralCredit)
+
+	msg := fmt.Sprintf("Друг по твоей ссылке оплатил заказ, тебе начислено %d руб. на продление подписки", b.referralCredit)
+
+	if _, err := b.tele.Send(recipient(r.ReferrerID), msg); err != nil {
+		slog.WarnContext(ctx, "reward msg not sent to referrer", "cause", err.Error())
+	}
+
+	return nil
+}
+
+// referralsMsg returns referral link of user, amount of referred friends and credit, empty if referrals are disabled.
+// Link is sent without markdown, username of the bot may contain underscores.
+func (b *Bot) referralsMsg(ctx context.Context, uid int64) (string, error) {
+	if b.referralCredit == 0 {
+		return "", nil
+	}
+
+	referrals, err := b.storage.ListReferrals(ctx, uid)
+	if err != nil {
+		return "", fmt.Errorf("referrals not listed: %w", err)
+	}
+
+	rewarded := 0
+
+	for _, r := range referrals {
+		if r.RewardedAt.Valid {
+			rewarded++
+		}
+	}
+
+	msg := fmt.Sprintf("Приглашай друзей и получай %d руб. на баланс за каждого, кто оплатит заказ:\n%s\n\nПриглашено друзей %d, оплатили %d",
+		b.referralCredit, b.referralLink(uid), len(referrals), rewarded)
+
+	return msg, nil
+}
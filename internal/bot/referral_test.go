@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/config"
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const testReferralCredit = 50
+
+func withReferrals(c *config.TG) {
+	c.ReferralCredit = testReferralCredit
+}
+
+// approvedOrderOf creates and approves order of user as admin does.
+func (e *testEnv) approvedOrderOf(t *testing.T, uid int64) domain.OrderID {
+	t.Helper()
+
+	if err := e.bot.handleCallback(e.callback(uid, stepSelectKeyAmount, "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := e.store.ListUserOrders(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.bot.handleCallback(e.callback(testAdminID, stepApproveOrder, orders[0].ID.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	return orders[0].ID
+}
+
+func (e *testEnv) balance(t *testing.T, uid int64) int {
+	t.Helper()
+
+	balance, err := e.store.GetBalance(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return balance
+}
+
+func TestReferralReward(t *testing.T) {
+	env := newTestEnv(t, withReferrals)
+	env.approvedOrder(t, 1)
+
+	if err := env.bot.handleStart(env.command(testInvitedUserID, "/start ref100")); err != nil {
+		t.Fatal(err)
+	}
+
+	// user with orders is not referred
+	if err := env.bot.handleStart(env.command(testUserID, "/start ref103")); err != nil {
+		t.Fatal(err)
+	}
+
+	env.approvedOrderOf(t, testInvitedUserID)
+	env.approvedOrderOf(t, testInvitedUserID)
+
+	if got := env.balance(t, testUserID); got != testReferralCredit {
+		t.Errorf("referrer balance = %d, want %d", got, testReferralCredit)
+	}
+
+	if got := env.balance(t, testInvitedUserID); got != 0 {
+		t.Errorf("referred user balance = %d, want 0", got)
+	}
+
+	if got, want := env.lastText(t, testUserID), "Друг по твоей ссылке оплатил заказ, тебе начислено 50 руб. на продление подписки"; got != want {
+		t.Errorf("referrer message = %q, want %q", got, want)
+	}
+
+	if err := env.bot.handleProfile(env.command(testUserID, "/profile")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := env.lastText(t, testUserID)
+	if !strings.Contains(got, "https://t.me/telegramtest_bot?start=ref100") || !strings.HasPrefix(got, "Баланс 50 руб.") || !strings.Contains(got, "Приглашено друзей 1, оплатили 1") {
+		t.Errorf("profile = %q, want referral link and earnings", got)
+	}
+}
+
+func TestReferralCreditOnRenewal(t *testing.T) {
+	env := newTestEnv(t, withReferrals)
+	oid := env.approvedOrder(t, 1)
+
+	err := env.store.AddLedgerEntry(context.Background(), storage.LedgerEntry{
+		UID:       testUserID,
+		Amount:    testReferralCredit,
+		Kind:      domain.LedgerReferral,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.store.Now = func() time.Time { return time.Now().Add(domain.OrderTTL - 24*time.Hour) }
+
+	if err := env.bot.notifyExpiringOrders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := env.tg.Calls("sendPhoto", testUserID)
+	if got := calls[len(calls)-1].Text; !strings.Contains(got, "К оплате 100 руб. (еще 50 руб. будет списано с баланса)") {
+		t.Errorf("renewal = %q, want price with credit", got)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepOrderRenewApproved, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasSuffix(got, "Оплачено 100 руб.\nСписано с баланса 50 руб.") {
+		t.Errorf("renewed = %q, want credit charged", got)
+	}
+
+	if got := env.balance(t, testUserID); got != 0 {
+		t.Errorf("balance = %d, want 0", got)
+	}
+}
@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const refundUsage = "Использование: /refund <номер заказа>\n\nКлючи заказа будут удалены, а стоимость неиспользованных дней вернется на баланс пользователя"
+
+// refundable reports whether order is paid and not closed yet.
+func refundable(o storage.Order) bool {
+	switch domain.OrderStatus(o.Status.String) {
+	case domain.OrderStatusApproved, domain.OrderStatusAwaitingRenewal, domain.OrderStatusRenewed:
+		return true
+	default:
+		return false
+	}
+}
+
+// refundAmount returns cost of unused days of the last paid period of order.
+// The first period is longer by bonus days and costs price minus discount,
+// order which expires later than two periods after creation is considered renewed for full price.
+func refundAmount(o storage.Order, now time.Time) int {
+	if !o.ExpiresAt.Valid || !o.ExpiresAt.Time.After(now) {
+		return 0
+	}
+
+	paid, period := o.Price, domain.OrderTTL
+
+	first := domain.OrderTTL + time.Duration(o.BonusDays)*24*time.Hour
+	if o.ExpiresAt.Time.Sub(o.CreatedAt.Time) < first+domain.OrderTTL {
+		paid, period = o.Price-o.Discount, first
+	}
+
+	left := min(o.ExpiresAt.Time.Sub(now), period)
+
+	return int(int64(paid) * int64(left) / int64(period))
+}
+
+// handleRefund shows amount to refund for order with button to confirm refund.
+func (b *Bot) handleRefund(c tele.Context) error {
+	args := c.Args()
+	if len(args) != 1 {
+		return c.Send(refundUsage)
+	}
+
+	oid, err := domain.OrderIDFromString(args[0])
+	if err != nil {
+		return c.Send(refundUsage)
+	}
+
+	ctx := withOrderID(stdContext(c), oid)
+
+	order, err := b.storage.GetOrder(ctx, oid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Send(fmt.Sprintf("Заказ №%d не найден", oid))
+	}
+	if err != nil {
+		return fmt.Errorf("order not found: %w", err)
+	}
+
+	if !refundable(order) {
+		return c.Send(fmt.Sprintf("Заказ №%d нельзя вернуть (%s)", oid, order.Status.String))
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Возврат по заказу №%d\n\nКлючей %d шт., действует до %s\nК возврату на баланс %d руб. за неиспользованные дни\n\n",
+		oid, order.KeyAmount, order.ExpiresAt.Time.Format("02.01.2006"), refundAmount(order, time.Now()))
+	orderUser(order).write(sb)
+
+	kb := &tele.ReplyMarkup{}
+	kb.Inline(
+		kb.Row(kb.Data("Удалить ключи и вернуть", stepRefundOrder.String(), oid.String())),
+		kb.Row(btnCancel(kb)),
+	)
+
+	return c.Send(sb.String(), kb)
+}
+
+// refundOrder triggers after admin confirmed refund of order.
+// Deletes keys of order, closes it as refunded and credits unused days to balance of user.
+func (b *Bot) refundOrder(c tele.Context, ctx context.Context, cb btnCallback) error {
+	oid, err := domain.OrderIDFromString(cb.data)
+	if err != nil {
+		return fmt.Errorf("order id not found in callback data: %w", err)
+	}
+
+	ctx = withOrderID(ctx, oid)
+
+	order, err := b.storage.GetOrder(ctx, oid)
+	if err != nil {
+		return fmt.Errorf("order not found: %w", err)
+	}
+
+	if !refundable(order) {
+		return c.Edit(c.Message().Text + fmt.Sprintf("\n\nЗаказ уже закрыт (%s)", order.Status.String))
+	}
+
+	now := time.Now()
+	amount := refundAmount(order, now)
+
+	if err := b.deleteOrderKeys(ctx, order); err != nil {
+		return err
+	}
+
+	err = b.storage.RefundOrder(ctx, oid, amount, now)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Edit(c.Message().Text + "\n\nЗаказ уже закрыт")
+	}
+	if err != nil {
+		return fmt.Errorf("order not refunded: %w", err)
+	}
+
+	ctx = withUser(ctx, order.UID, order.Username.String)
+	slog.InfoContext(ctx, "order refunded by admin", "amount", amount)
+
+	msg := fmt.Sprintf("Заказ №%d отменен админом, ключи по нему больше не работают", oid)
+	if amount > 0 {
+		msg += fmt.Sprintf(". На баланс возвращено %d руб. за неиспользованные дни", amount)
+	}
+
+	if _, err := b.tele.Send(recipient(order.UID), msg); err != nil {
+		slog.WarnContext(ctx, "refund msg not sent to user", "cause", err.Error())
+	}
+
+	return c.Edit(c.Message().Text + fmt.Sprintf("\n\nКлючи удалены, возвращено %d руб.", amount))
+}
+
+// cancelOrder triggers after user canceled his order awaiting payment.
+func (b *Bot) cancelOrder(c tele.Context, ctx context.Context, cb btnCallback, usr *user) error {
+	oid, err := domain.OrderIDFromString(cb.data)
+	if err != nil {
+		return fmt.Errorf("order id not found in callback data: %w", err)
+	}
+
+	ctx = withOrderID(ctx, oid)
+
+	order, err := b.storage.GetOrder(ctx, oid)
+	if err != nil {
+		return fmt.Errorf("order not found: %w", err)
+	}
+
+	if order.UID != usr.id {
+		return fmt.Errorf("order of user %d canceled by user %d", order.UID, usr.id)
+	}
+
+	if domain.OrderStatus(order.Status.String) != domain.OrderStatusAwaitingPayment {
+		return c.Send(fmt.Sprintf("Заказ №%d уже обработан (%s), отменить его нельзя", oid, order.Status.String))
+	}
+
+	err = b.storage.CancelOrder(ctx, oid, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Send(fmt.Sprintf("Заказ №%d уже обработан, отменить его нельзя", oid))
+	}
+	if err != nil {
+		return fmt.Errorf("order not canceled: %w", err)
+	}
+
+	slog.InfoContext(ctx, "order canceled by user")
+
+	b.metrics.ordersCanceled.Add(ctx, 1, metric.WithAttributes(attrBackend(order.Backend)))
+
+	if err := c.Delete(); err != nil {
+		slog.WarnContext(ctx, "payment msg not deleted", "cause", err.Error())
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Заказ №%d на сумму %d руб. отменен пользователем\n\n", oid, order.Price-order.Discount)
+	usr.write(sb)
+
+	if err := b.editAdminMessages(ctx, oid, nil, sb.String()); err != nil {
+		return err
+	}
+
+	return c.Send(fmt.Sprintf("Заказ №%d отменен", oid))
+}
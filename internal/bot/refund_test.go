@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+func TestRefundAmount(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	order := func(created, expires time.Time, price, discount, bonusDays int) storage.Order {
+		return storage.Order{
+			Price:     price,
+			Discount:  discount,
+			BonusDays: bonusDays,
+			CreatedAt: sql.NullTime{Time: created, Valid: true},
+			ExpiresAt: sql.NullTime{Time: expires, Valid: true},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		order storage.Order
+		want  int
+	}{
+		{
+			name:  "unpaid",
+			order: storage.Order{Price: 300},
+			want:  0,
+		},
+		{
+			name:  "expired",
+			order: order(now.Add(-domain.OrderTTL-day), now.Add(-day), 300, 0, 0),
+			want:  0,
+		},
+		{
+			name:  "half of first period",
+			order: order(now.Add(-15*day), now.Add(15*day), 300, 0, 0),
+			want:  150,
+		},
+		{
+			name:  "first period with discount and bonus days",
+			order: order(now.Add(-20*day), now.Add(20*day), 300, 100, 10),
+			want:  100,
+		},
+		{
+			name:  "renewed for full price",
+			order: order(now.Add(-45*day), now.Add(15*day), 300, 100, 0),
+			want:  150,
+		},
+		{
+			name:  "free",
+			order: order(now, now.Add(3*day), 0, 0, 0),
+			want:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refundAmount(tt.order, now); got != tt.want {
+				t.Errorf("refundAmount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.order(t, 2)
+
+	if err := env.bot.handleCallback(env.callback(testInvitedUserID, stepCancelOrder, oid.String())); err == nil {
+		t.Error("order canceled by another user")
+	}
+
+	if err := env.bot.handleCallback(env.callback(testUserID, stepCancelOrder, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusCanceled {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusCanceled)
+	}
+
+	if got, want := env.lastText(t, testUserID), "Заказ №1 отменен"; got != want {
+		t.Errorf("user msg = %q, want %q", got, want)
+	}
+
+	edits := env.tg.Calls("editMessageText", testAdminID)
+	if len(edits) != 1 || !strings.HasPrefix(edits[0].Text, "Заказ №1 на сумму 300 руб. отменен пользователем") {
+		t.Errorf("admin edits = %v, want canceled order", edits)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testUserID, stepCancelOrder, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.Contains(got, "отменить его нельзя") {
+		t.Errorf("second cancel = %q, want refusal", got)
+	}
+}
+
+func TestRefundOrder(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.approvedOrder(t, 2)
+
+	if err := env.bot.handleRefund(env.command(testAdminID, "/refund 1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testAdminID); !strings.HasPrefix(got, "Возврат по заказу №1\n\nКлючей 2 шт.") || !strings.Contains(got, "К возврату на баланс 299 руб.") {
+		t.Errorf("preview = %q, want prorated amount", got)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepRefundOrder, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, oid); got != domain.OrderStatusRefunded {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusRefunded)
+	}
+
+	if n := len(env.outline.Keys()); n != 0 {
+		t.Errorf("outline keys = %d, want 0", n)
+	}
+
+	if got := env.balance(t, testUserID); got != 299 {
+		t.Errorf("balance = %d, want 299", got)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasPrefix(got, "Заказ №1 отменен админом") || !strings.Contains(got, "возвращено 299 руб.") {
+		t.Errorf("user msg = %q, want refund", got)
+	}
+
+	// refunded twice from the same preview
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepRefundOrder, oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.balance(t, testUserID); got != 299 {
+		t.Errorf("balance after second refund = %d, want 299", got)
+	}
+
+	if err := env.bot.handleRefund(env.command(testAdminID, "/refund 1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := env.lastText(t, testAdminID), "Заказ №1 нельзя вернуть (refunded)"; got != want {
+		t.Errorf("refund of closed order = %q, want %q", got, want)
+	}
+
+	if err := env.bot.handleRefund(env.command(testAdminID, "/refund abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testAdminID); got != refundUsage {
+		t.Errorf("invalid args = %q, want usage", got)
+	}
+}
@@ -0,0 +1,177 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+)
+
+const (
+	// reportDedupWindow is period in which the same error is reported to admin only once.
+	reportDedupWindow = 10 * time.Minute
+
+	// reportForgetAfter is period after which repeats of reported error are forgotten.
+	reportForgetAfter = time.Hour
+
+	// reportLimit is max amount of reports sent to admin in reportLimitWindow.
+	reportLimit       = 5
+	reportLimitWindow = time.Minute
+)
+
+// errorReporter decides which errors are sent to admin, it deduplicates
+// equal errors and limits amount of reports to not flood admin chat.
+type errorReporter struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	seen    map[string]*seenError
+	sent    []time.Time // report times in limit window
+	dropped int         // reports dropped by limit since last sent report
+}
+
+type seenError struct {
+	reportedAt time.Time
+	repeated   int // times error happened since it was reported
+}
+
+func newErrorReporter() *errorReporter {
+	return &errorReporter{
+		now:  time.Now,
+		seen: make(map[string]*seenError),
+	}
+}
+
+// reportDecision is result of errorReporter.allow.
+type reportDecision struct {
+	ok       bool
+	repeated int // times error was suppressed by deduplication before this report
+	dropped  int // other reports dropped by rate limit before this report
+}
+
+// allow reports whether error with key must be sent to admin.
+func (r *errorReporter) allow(key string) reportDecision {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+
+	for k, e := range r.seen {
+		if now.Sub(e.reportedAt) >= reportForgetAfter {
+			delete(r.seen, k)
+		}
+	}
+
+	e, seen := r.seen[key]
+	if seen && now.Sub(e.reportedAt) < reportDedupWindow {
+		e.repeated++
+		return reportDecision{}
+	}
+
+	sent := r.sent[:0]
+	for _, t := range r.sent {
+		if now.Sub(t) < reportLimitWindow {
+			sent = append(sent, t)
+		}
+	}
+	r.sent = sent
+
+	if len(r.sent) >= reportLimit {
+		r.dropped++
+		return reportDecision{}
+	}
+
+	d := reportDecision{ok: true, dropped: r.dropped}
+	if seen {
+		d.repeated = e.repeated
+	}
+
+	r.sent = append(r.sent, now)
+	r.dropped = 0
+	r.seen[key] = &seenError{reportedAt: now}
+
+	return d
+}
+
+// newCorrelationID returns short random id which user may quote to admin to find error in logs.
+func newCorrelationID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// reportError sends error summary to admin if it is allowed by reporter and returns correlation id of the error.
+// where is handler or worker in which error happened.
+func (b *Bot) reportError(ctx context.Context, where string, err error) string {
+	id := newCorrelationID()
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("correlation_id", id))
+
+	d := b.reporter.allow(where + ": " + err.Error())
+	if !d.ok {
+		return id
+	}
+
+	sb := &strings.Builder{}
+
+	fmt.Fprintf(sb, "Ошибка %s\n\nГде: %s", id, where)
+
+	if lc, ok := ctx.Value(logCtxKey{}).(logCtx); ok {
+		if lc.UID != 0 {
+			fmt.Fprintf(sb, "\nПользователь: %d", lc.UID)
+			if lc.Username != "" {
+				fmt.Fprintf(sb, " @%s", lc.Username)
+			}
+		}
+		if lc.OrderID != 0 {
+			fmt.Fprintf(sb, "\nЗаказ: №%d", lc.OrderID)
+		}
+		if lc.CallbackUnique != "" {
+			fmt.Fprintf(sb, "\nКнопка: %s %s", lc.CallbackUnique, lc.CallbackData)
+		}
+	}
+
+	fmt.Fprintf(sb, "\n\n%s", err.Error())
+
+	if d.repeated > 0 {
+		fmt.Fprintf(sb, "\n\nПовторялась после прошлого отчета: %d раз", d.repeated)
+	}
+
+	if d.dropped > 0 {
+		fmt.Fprintf(sb, "\n\nПропущено других ошибок: %d", d.dropped)
+	}
+
+	if _, err := b.notifyAdmins(ctx, eventError, 0, sb.String()); err != nil {
+		slog.ErrorContext(ctx, "error report not sent to admin", "cause", err.Error(), "correlation_id", id)
+	}
+
+	return id
+}
+
+// apologize tells user that error happened, admin receives reports instead.
+func (b *Bot) apologize(ctx context.Context, c tele.Context, correlationID string) {
+	if c == nil || c.Chat() == nil || b.isForum(c) {
+		return
+	}
+
+	// admins receive reports
+	if role, err := b.adminRole(ctx, c.Chat().ID); err != nil || role.Can(domain.AdminRoleOperator) {
+		return
+	}
+
+	msg := fmt.Sprintf("Что-то пошло не так, админ уже в курсе. Если будешь писать ему, назови код ошибки: %s", correlationID)
+
+	if err := c.Send(msg); err != nil {
+		slog.ErrorContext(ctx, "apology not sent to user", "cause", err.Error(), "correlation_id", correlationID)
+	}
+}
@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/outlinetest"
+	"github.com/ysomad/outline-bot/internal/telegramtest"
+)
+
+func TestErrorReporterAllow(t *testing.T) {
+	now := time.Now()
+	r := newErrorReporter()
+	r.now = func() time.Time { return now }
+
+	if d := r.allow("a"); !d.ok {
+		t.Fatal("first error not allowed")
+	}
+
+	for range 3 {
+		if d := r.allow("a"); d.ok {
+			t.Fatal("duplicate error allowed")
+		}
+	}
+
+	now = now.Add(reportDedupWindow)
+
+	if d := r.allow("a"); !d.ok || d.repeated != 3 {
+		t.Errorf("error after dedup window: %+v, want allowed with 3 repeats", d)
+	}
+
+	// "a" and 4 other errors are sent in the limit window
+	for _, key := range []string{"b", "c", "d", "e"} {
+		if d := r.allow(key); !d.ok {
+			t.Fatalf("error %s not allowed", key)
+		}
+	}
+
+	if d := r.allow("f"); d.ok {
+		t.Fatal("error allowed above limit")
+	}
+
+	now = now.Add(reportLimitWindow)
+
+	if d := r.allow("g"); !d.ok || d.dropped != 1 {
+		t.Errorf("error after limit window: %+v, want allowed with 1 dropped", d)
+	}
+}
+
+// reportID returns correlation id from apology or report message.
+func reportID(t *testing.T, c telegramtest.Call) string {
+	t.Helper()
+
+	i := strings.Index(c.Text, "код ошибки: ")
+	if i != -1 {
+		return c.Text[i+len("код ошибки: "):]
+	}
+
+	id, _, ok := strings.Cut(strings.TrimPrefix(c.Text, "Ошибка "), "\n")
+	if !ok {
+		t.Fatalf("no correlation id in %q", c.Text)
+	}
+
+	return id
+}
+
+func TestScenarioErrorReported(t *testing.T) {
+	env := newTestEnv(t)
+	env.start(t)
+
+	env.tg.PushText(testUser, "/order")
+	env.tg.PushCallback(testUser, env.wait(t, "sendMessage", testUserID, 1), "\funknown|1")
+
+	apology := env.wait(t, "sendMessage", testUserID, 2)
+	report := env.wait(t, "sendMessage", testAdminID, 1)
+
+	if id := reportID(t, apology); id != reportID(t, report) {
+		t.Errorf("correlation id of apology %q differs from report %q", id, report.Text)
+	}
+
+	for _, s := range []string{"callback unknown", "Пользователь: 100 @test_user", "Кнопка: unknown 1", "unsupported callback"} {
+		if !strings.Contains(report.Text, s) {
+			t.Errorf("report has no %q: %q", s, report.Text)
+		}
+	}
+
+	// the same error is not reported again
+	env.tg.PushCallback(testUser, apology, "\funknown|1")
+	env.wait(t, "sendMessage", testUserID, 3)
+
+	if n := len(env.tg.Calls("sendMessage", testAdminID)); n != 1 {
+		t.Errorf("admin received %d reports, want 1", n)
+	}
+}
+
+func TestScenarioApproveFailureReported(t *testing.T) {
+	env := newTestEnv(t)
+	env.start(t)
+
+	env.outline.Inject(outlinetest.Fault{Route: "POST /access-keys", Status: http.StatusInternalServerError})
+
+	env.tg.PushText(testUser, "/order")
+	env.press(t, testUser, env.wait(t, "sendMessage", testUserID, 1), "1")
+	env.press(t, testAdmin, env.wait(t, "sendMessage", testAdminID, 1), "Одобрить")
+
+	report := env.wait(t, "sendMessage", testAdminID, 2)
+
+	for _, s := range []string{"callback approve_order", "Заказ: №1", "key not created"} {
+		if !strings.Contains(report.Text, s) {
+			t.Errorf("report has no %q: %q", s, report.Text)
+		}
+	}
+}
+
+func TestScenarioAccessDeniedNotReported(t *testing.T) {
+	env := newTestEnv(t)
+	env.start(t)
+
+	env.tg.PushText(testUser, "/stats")
+	env.tg.PushText(testUser, "/broadcast")
+	env.tg.PushText(testUser, "/order")
+
+	if got := env.wait(t, "sendMessage", testUserID, 1); !strings.HasPrefix(got.Text, "Сколько") {
+		t.Errorf("first msg to user = %q, want /order reply without apologies", got.Text)
+	}
+
+	// handlers of updates run concurrently, give denied ones time to finish
+	time.Sleep(100 * time.Millisecond)
+
+	if n := env.sent("sendMessage", testUserID); n != 1 {
+		t.Errorf("user received %d msgs, want only /order reply", n)
+	}
+
+	if n := env.sent("sendMessage", testAdminID); n != 0 {
+		t.Errorf("admin received %d msgs, want no reports", n)
+	}
+}
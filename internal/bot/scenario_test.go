@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/telegramtest"
+)
+
+const waitTimeout = 3 * time.Second
+
+var (
+	testUser  = &tele.User{ID: testUserID, FirstName: "Test", Username: "test_user"}
+	testAdmin = &tele.User{ID: testAdminID, FirstName: "Admin", Username: "admin"}
+)
+
+// start starts polling updates from fake telegram server.
+func (e *testEnv) start(t *testing.T) {
+	t.Helper()
+	go e.bot.Start()
+	t.Cleanup(e.bot.Stop)
+}
+
+// wait waits for n-th call of method to chat and returns it.
+func (e *testEnv) wait(t *testing.T, method string, chatID int64, n int) telegramtest.Call {
+	t.Helper()
+
+	calls, err := e.tg.Wait(method, chatID, n, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return calls[n-1]
+}
+
+// press presses button with text on message of call by user.
+func (e *testEnv) press(t *testing.T, from *tele.User, c telegramtest.Call, text string) {
+	t.Helper()
+
+	btn, ok := c.Button(text)
+	if !ok {
+		t.Fatalf("button %q not found in %s message %q", text, c.Method, c.Text)
+	}
+
+	e.tg.PushCallback(from, c, btn.Data)
+}
+
+func TestScenarioCommandsSet(t *testing.T) {
+	env := newTestEnv(t)
+
+	cmds := env.tg.Commands()
+	if len(cmds) != 3 || cmds[0].Text != "order" || cmds[1].Text != "profile" || cmds[2].Text != "support" {
+		t.Errorf("unexpected commands: %+v", cmds)
+	}
+}
+
+func TestScenarioOrderApproved(t *testing.T) {
+	env := newTestEnv(t)
+	env.start(t)
+
+	env.tg.PushText(testUser, "/start")
+	env.wait(t, "sendMessage", testUserID, 1)
+
+	env.tg.PushText(testUser, "/order")
+	env.press(t, testUser, env.wait(t, "sendMessage", testUserID, 2), "2")
+
+	payment := env.wait(t, "sendPhoto", testUserID, 1)
+	if !strings.Contains(payment.Text, "к оплате 300₽") {
+		t.Errorf("unexpected payment details: %q", payment.Text)
+	}
+
+	newOrder := env.wait(t, "sendMessage", testAdminID, 1)
+	if !strings.Contains(newOrder.Text, "ID: 100") {
+		t.Errorf("user not mentioned in new order msg: %q", newOrder.Text)
+	}
+
+	env.press(t, testAdmin, newOrder, "Одобрить")
+
+	edited := env.wait(t, "editMessageText", testAdminID, 1)
+	if edited.MessageID != newOrder.MessageID {
+		t.Errorf("edited message %d, want %d", edited.MessageID, newOrder.MessageID)
+	}
+
+	keysMsg := env.wait(t, "sendMessage", testUserID, 3)
+	for _, k := range env.outline.Keys() {
+		if !strings.Contains(keysMsg.Text, k.AccessUrl.Value) {
+			t.Errorf("key %s not sent to user", k.ID)
+		}
+	}
+
+	env.tg.PushText(testUser, "/profile")
+
+	profile := env.wait(t, "sendMessage", testUserID, 4)
+	if n := len(env.outline.Keys()); n != 2 || strings.Count(profile.Text, "ss://") != n {
+		t.Errorf("profile has not all keys: %q", profile.Text)
+	}
+
+	if got := env.orderStatus(t, 1); got != domain.OrderStatusApproved {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusApproved)
+	}
+}
+
+func TestScenarioOrderRejected(t *testing.T) {
+	env := newTestEnv(t)
+	env.start(t)
+
+	env.tg.PushText(testUser, "/order")
+	env.press(t, testUser, env.wait(t, "sendMessage", testUserID, 1), "1")
+	env.press(t, testAdmin, env.wait(t, "sendMessage", testAdminID, 1), "Отклонить")
+
+	env.wait(t, "editMessageText", testAdminID, 1)
+	env.wait(t, "sendMessage", testUserID, 2)
+
+	if got := env.orderStatus(t, 1); got != domain.OrderStatusRejected {
+		t.Errorf("status = %q, want %q", got, domain.OrderStatusRejected)
+	}
+}
+
+func TestScenarioOrderCanceled(t *testing.T) {
+	env := newTestEnv(t)
+	env.start(t)
+
+	env.tg.PushText(testUser, "/order")
+	env.press(t, testUser, env.wait(t, "sendMessage", testUserID, 1), "Отменить")
+
+	if c := env.wait(t, "sendMessage", testUserID, 2); c.Text != "Операция отменена" {
+		t.Errorf("unexpected cancel msg: %q", c.Text)
+	}
+
+	if n := len(env.tg.Calls("sendMessage", testAdminID)); n != 0 {
+		t.Errorf("admin received %d messages", n)
+	}
+}
@@ -5,6 +5,7 @@ type step string
 const (
 	stepCancel          step = "cancel"
 	stepSelectKeyAmount step = "select_key_amount"
+	stepEnterPromo      step = "enter_promo"
 
 	stepApproveOrder step = "approve_order"
 	stepRejectOrder  step = "reject_order"
@@ -12,7 +13,34 @@ const (
 	stepOrderRenewApproved step = "renew_order_approved"
 	stepRejectOrderRenewal step = "reject_order_renewal"
 
-	stepMigrateKeys step = "migrate_keys"
+	stepMigrateKeys    step = "migrate_keys"
+	stepChangeHostname step = "change_hostname"
+
+	stepUserExtend  step = "user_extend"
+	stepUserRevoke  step = "user_revoke"
+	stepUserGrant   step = "user_grant"
+	stepUserMessage step = "user_message"
+	stepUserBan     step = "user_ban"
+	stepUserUnban   step = "user_unban"
+
+	stepOrdersPage step = "orders_page"
+
+	stepBroadcastMessage step = "broadcast_message"
+	stepBroadcastSegment step = "broadcast_segment"
+	stepBroadcastSend    step = "broadcast_send"
+
+	stepCloseTicket step = "close_ticket"
+
+	stepApproveJoin step = "approve_join"
+	stepDenyJoin    step = "deny_join"
+
+	stepTopUp        step = "top_up"
+	stepApproveTopUp step = "approve_top_up"
+	stepRejectTopUp  step = "reject_top_up"
+	stepAutoRenew    step = "auto_renew"
+
+	stepCancelOrder step = "cancel_order"
+	stepRefundOrder step = "refund_order"
 )
 
 func (s step) String() string { return string(s) }
@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const (
+	statsExpiringPeriod = 7 * 24 * time.Hour
+	statsChurnPeriod    = domain.OrderTTL
+	statsRevenueMonths  = 12
+	// statsExpiringLimit is max amount of expiring orders listed, so stats fit into one message.
+	statsExpiringLimit = 20
+)
+
+// handleStats sends admin dashboard with subscribers and revenue statistics.
+func (b *Bot) handleStats(c tele.Context) error {
+	ctx := stdContext(c)
+	now := time.Now()
+
+	st, err := b.storage.OrderStats(ctx, storage.OrderStatsParams{
+		Now:           now,
+		ChurnedSince:  now.Add(-statsChurnPeriod),
+		ExpiresBefore: now.Add(statsExpiringPeriod),
+	})
+	if err != nil {
+		return fmt.Errorf("order stats not received: %w", err)
+	}
+
+	firstMonth := time.Date(now.Year(), now.Month()-statsRevenueMonths+1, 1, 0, 0, 0, 0, time.UTC)
+
+	months, err := b.storage.RevenueByMonth(ctx, firstMonth)
+	if err != nil {
+		return fmt.Errorf("revenue not received: %w", err)
+	}
+
+	keys, err := b.storage.ListExpiringKeys(ctx, statsExpiringPeriod)
+	if err != nil {
+		return fmt.Errorf("expiring keys not listed: %w", err)
+	}
+
+	sb := &strings.Builder{}
+
+	fmt.Fprintf(sb, "Статистика на %s\n\n", now.Format("02.01.2006 15:04"))
+	fmt.Fprintf(sb, "Активных заказов: %d\nАктивных ключей: %d\nMRR: %d руб.\n\n", st.ActiveOrders, st.ActiveKeys, st.MRR)
+	fmt.Fprintf(sb, "Ждут оплаты: %d на сумму %d руб.\n", st.AwaitingPayment, st.AwaitingPaymentPrice)
+	fmt.Fprintf(sb, "Отток за %d дней: %d на сумму %d руб. (%.1f%%)\n",
+		int(statsChurnPeriod.Hours()/24), st.Churned, st.ChurnedPrice, churnRate(st))
+	fmt.Fprintf(sb, "Истекают за %d дней: %d на сумму %d руб.\n", int(statsExpiringPeriod.Hours()/24), st.Expiring, st.ExpiringPrice)
+
+	writeExpiringOrders(sb, keys, now)
+
+	sb.WriteString("\nВыручка по месяцам (первые оплаты заказов):\n")
+
+	if len(months) == 0 {
+		sb.WriteString("нет оплаченных заказов\n")
+	}
+
+	for _, m := range months {
+		fmt.Fprintf(sb, "%s: %d руб., заказов %d\n", m.Month, m.Revenue, m.Orders)
+	}
+
+	return c.Send(sb.String())
+}
+
+// churnRate returns percent of orders which expired without renewal in churn period
+// from orders active in the period.
+func churnRate(st storage.OrderStats) float64 {
+	total := st.ActiveOrders + st.Churned
+	if total == 0 {
+		return 0
+	}
+	return float64(st.Churned) / float64(total) * 100
+}
+
+// writeExpiringOrders writes orders of keys which are not expired yet sorted by expiration,
+// only first statsExpiringLimit orders are listed.
+func writeExpiringOrders(sb *strings.Builder, keys []storage.ExpiringKey, now time.Time) {
+	var orders []order
+
+	for o := range groupExpiringKeys(keys) {
+		if o.expiresAt.After(now) {
+			orders = append(orders, o)
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].expiresAt.Equal(orders[j].expiresAt) {
+			return orders[i].id < orders[j].id
+		}
+		return orders[i].expiresAt.Before(orders[j].expiresAt)
+	})
+
+	for i, o := range orders {
+		if i == statsExpiringLimit {
+			fmt.Fprintf(sb, "и еще %d\n", len(orders)-statsExpiringLimit)
+			break
+		}
+
+		fmt.Fprintf(sb, "- №%d до %s, %d руб., ID: %d", o.id, o.expiresAt.Format("02.01.2006"), o.price, o.user.id)
+
+		if o.user.username != "" {
+			fmt.Fprintf(sb, " @%s", o.user.username)
+		}
+
+		sb.WriteString("\n")
+	}
+}
@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+func TestStats(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	env.approvedOrder(t, 2)
+
+	now := time.Now()
+
+	// expiring order
+	oid, err := env.store.CreateOrder(ctx, storage.CreateOrderParams{
+		UID:       200,
+		Username:  "expiring",
+		KeyAmount: 1,
+		Price:     domain.PricePerKey,
+		CreatedAt: now,
+		Status:    domain.OrderStatusAwaitingPayment,
+		Backend:   domain.BackendOutline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = env.store.ApproveOrder(ctx, oid, []storage.Key{{ID: "expiring", Name: "key"}}, now.Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// churned order
+	oid, err = env.store.CreateOrder(ctx, storage.CreateOrderParams{
+		UID:       300,
+		KeyAmount: 3,
+		Price:     3 * domain.PricePerKey,
+		CreatedAt: now.Add(-domain.OrderTTL),
+		Status:    domain.OrderStatusAwaitingPayment,
+		Backend:   domain.BackendOutline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = env.store.ApproveOrder(ctx, oid, nil, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = env.store.CloseOrder(ctx, oid, domain.OrderStatusExpired, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// awaiting payment
+	if _, err = env.store.CreateOrder(ctx, storage.CreateOrderParams{
+		UID:       400,
+		KeyAmount: 1,
+		Price:     domain.PricePerKey,
+		CreatedAt: now,
+		Status:    domain.OrderStatusAwaitingPayment,
+		Backend:   domain.BackendOutline,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := env.sent("sendMessage", testAdminID)
+
+	if err = env.bot.handleStats(env.text(testAdminID, "/stats")); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := env.tg.Calls("sendMessage", testAdminID)
+	if len(calls) != before+1 {
+		t.Fatal("stats not sent to admin")
+	}
+
+	msg := calls[len(calls)-1].Text
+
+	for _, want := range []string{
+		"Активных заказов: 2",
+		"Активных ключей: 3",
+		"MRR: 450 руб.",
+		"Ждут оплаты: 1 на сумму 150 руб.",
+		"Отток за 30 дней: 1 на сумму 450 руб. (33.3%)",
+		"Истекают за 7 дней: 1 на сумму 150 руб.",
+		"- №2 до " + now.Add(48*time.Hour).Format("02.01.2006") + ", 150 руб., ID: 200 @expiring",
+		now.UTC().Format("2006-01") + ": ",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("stats has no %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestWriteExpiringOrdersLimit(t *testing.T) {
+	now := time.Now()
+	keys := make([]storage.ExpiringKey, statsExpiringLimit+5)
+
+	for i := range keys {
+		keys[i] = storage.ExpiringKey{
+			ID:        fmt.Sprint(i),
+			OrderID:   domain.OrderID(i + 1),
+			UID:       int64(i + 1),
+			ExpiresAt: now.Add(time.Duration(i+1) * time.Hour),
+			Backend:   domain.BackendOutline,
+		}
+	}
+
+	sb := &strings.Builder{}
+	writeExpiringOrders(sb, keys, now)
+
+	if n := strings.Count(sb.String(), "- №"); n != statsExpiringLimit {
+		t.Errorf("listed orders = %d, want %d", n, statsExpiringLimit)
+	}
+
+	if !strings.HasSuffix(sb.String(), "и еще 5\n") {
+		t.Errorf("expiring orders = %q, want rest counted", sb.String())
+	}
+}
@@ -0,0 +1,395 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const (
+	// ticketsLimit is max amount of tickets listed by /tickets.
+	ticketsLimit = 20
+	// ticketOrdersLimit is amount of latest user orders relayed with ticket message.
+	ticketOrdersLimit = 3
+	// ticketHistoryLimit is amount of latest ticket messages sent by /tickets <id>.
+	ticketHistoryLimit = 20
+)
+
+var ticketStatusNames = map[domain.TicketStatus]string{
+	domain.TicketStatusOpen:     "ждет ответа",
+	domain.TicketStatusAnswered: "отвечено",
+	domain.TicketStatusClosed:   "закрыто",
+}
+
+func ticketKeyboard(id int64) *tele.ReplyMarkup {
+	kb := &tele.ReplyMarkup{}
+	kb.Inline(kb.Row(kb.Data("Закрыть обращение", stepCloseTicket.String(), strconv.FormatInt(id, 10))))
+	return kb
+}
+
+// handleSupport opens support ticket, text after command is relayed to admins.
+func (b *Bot) handleSupport(c tele.Context) error {
+	ctx := stdContext(c)
+	usr := newUser(c.Chat())
+
+	t, err := b.storage.GetUserTicket(ctx, usr.id)
+	if err == nil {
+		if c.Message().Payload != "" {
+			return b.relayToAdmins(c, ctx, t, c.Message().Payload)
+		}
+
+		return c.Send(fmt.Sprintf("Обращение №%d уже открыто, просто напиши сообщение", t.ID), ticketKeyboard(t.ID))
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("user ticket not received: %w", err)
+	}
+
+	now := time.Now()
+
+	id, err := b.storage.CreateTicket(ctx, storage.CreateTicketParams{
+		UID:       usr.id,
+		Username:  usr.username,
+		FirstName: usr.firstName,
+		LastName:  usr.lastName,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("ticket not created: %w", err)
+	}
+
+	slog.InfoContext(ctx, "ticket opened", "ticket_id", id)
+
+	if c.Message().Payload != "" {
+		t, err := b.storage.GetTicket(ctx, id)
+		if err != nil {
+			return fmt.Errorf("ticket not received: %w", err)
+		}
+
+		return b.relayToAdmins(c, ctx, t, c.Message().Payload)
+	}
+
+	msg := fmt.Sprintf("Обращение №%d открыто. Опиши проблему одним или несколькими сообщениями, админ ответит здесь же", id)
+
+	return c.Send(msg, ticketKeyboard(id))
+}
+
+// relayTicketMessage relays text of user to admins if user has open ticket, returns false if there is no ticket.
+func (b *Bot) relayTicketMessage(c tele.Context) (bool, error) {
+	ctx := stdContext(c)
+
+	t, err := b.storage.GetUserTicket(ctx, c.Chat().ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("user ticket not received: %w", err)
+	}
+
+	text := c.Text()
+	if media := c.Message().Media(); media != nil {
+		text = mediaText(media.MediaType(), c.Message().Caption)
+	}
+
+	return true, b.relayToAdmins(c, ctx, t, text)
+}
+
+var mediaNames = map[string]string{
+	"photo":    "фото",
+	"video":    "видео",
+	"document": "файл",
+	"voice":    "голосовое сообщение",
+}
+
+// mediaText returns text of media message saved in ticket.
+func mediaText(mediaType, caption string) string {
+	name, ok := mediaNames[mediaType]
+	if !ok {
+		name = "вложение"
+	}
+
+	if caption == "" {
+		return "[" + name + "]"
+	}
+
+	return "[" + name + "] " + caption
+}
+
+// relayToAdmins saves message of user in ticket and sends it to admins with user and his orders.
+func (b *Bot) relayToAdmins(c tele.Context, ctx context.Context, t storage.Ticket, text string) error {
+	now := time.Now()
+
+	err := b.storage.SaveTicketMessage(ctx, storage.TicketMessage{
+		TicketID:  t.ID,
+		Text:      text,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("ticket message not saved: %w", err)
+	}
+
+	if err := b.storage.SetTicketStatus(ctx, t.ID, domain.TicketStatusOpen, now); err != nil {
+		return fmt.Errorf("ticket status not changed: %w", err)
+	}
+
+	orders, err := b.storage.ListUserOrders(ctx, t.UID)
+	if err != nil {
+		return fmt.Errorf("user orders not listed: %w", err)
+	}
+
+	sb := &strings.Builder{}
+
+	fmt.Fprintf(sb, "Обращение №%d\n\n", t.ID)
+	newUser(c.Chat()).write(sb)
+
+	for i, o := range orders {
+		if i == ticketOrdersLimit {
+			break
+		}
+
+		sb.WriteString("\n")
+		writeOrderLine(sb, o)
+	}
+
+	fmt.Fprintf(sb, "\n\n%s\n\nОтветь на это сообщение, чтобы написать пользователю", text)
+
+	msgs, err := b.notifyAdmins(ctx, eventSupport, 0, sb.String(), ticketKeyboard(t.ID))
+	if err != nil {
+		return fmt.Errorf("ticket message not sent to admin: %w", err)
+	}
+
+	// media itself is copied as reply to relay, admin may reply to either of them
+	if c.Message().Media() != nil {
+		for _, m := range msgs {
+			copied, err := b.tele.Copy(&tele.Chat{ID: m.ChatID}, c.Message(), &tele.SendOptions{ReplyTo: &tele.Message{ID: m.MessageID}})
+			if err != nil {
+				slog.WarnContext(ctx, "ticket media not copied to admin", "chat_id", m.ChatID, "cause", err.Error())
+				continue
+			}
+
+			msgs = append(msgs, storage.AdminMessage{ChatID: copied.Chat.ID, MessageID: copied.ID})
+		}
+	}
+
+	if err := b.storage.SaveTicketRelays(ctx, t.ID, msgs); err != nil {
+		return fmt.Errorf("ticket relays not saved: %w", err)
+	}
+
+	return c.Send(fmt.Sprintf("Передал админу, ответ придет сюда (обращение №%d)", t.ID))
+}
+
+// replyTicket sends reply of admin to relayed message to user, returns false if message is not reply to relay.
+func (b *Bot) replyTicket(c tele.Context) (bool, error) {
+	ctx := stdContext(c)
+	replyTo := c.Message().ReplyTo
+
+	id, err := b.storage.FindTicketByRelay(ctx, storage.AdminMessage{ChatID: c.Chat().ID, MessageID: replyTo.ID})
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("ticket not found by relay: %w", err)
+	}
+
+	err = b.checkRole(c, domain.AdminRoleOperator)
+	if errors.Is(err, errRoleHandled) {
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	t, err := b.storage.GetTicket(ctx, id)
+	if err != nil {
+		return true, fmt.Errorf("ticket not received: %w", err)
+	}
+
+	if t.Status == domain.TicketStatusClosed {
+		return true, c.Send(fmt.Sprintf("Обращение №%d закрыто, ответ не отправлен", t.ID))
+	}
+
+	msg := fmt.Sprintf("Ответ по обращению №%d:\n\n%s", t.ID, c.Text())
+
+	if _, err := b.tele.Send(recipient(t.UID), msg, ticketKeyboard(t.ID)); err != nil {
+		return true, fmt.Errorf("ticket reply not sent to user: %w", err)
+	}
+
+	now := time.Now()
+
+	err = b.storage.SaveTicketMessage(ctx, storage.TicketMessage{
+		TicketID:  t.ID,
+		FromAdmin: true,
+		Text:      c.Text(),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return true, fmt.Errorf("ticket message not saved: %w", err)
+	}
+
+	if err := b.storage.SetTicketStatus(ctx, t.ID, domain.TicketStatusAnswered, now); err != nil {
+		return true, fmt.Errorf("ticket status not changed: %w", err)
+	}
+
+	slog.InfoContext(ctx, "ticket answered", "ticket_id", t.ID)
+
+	return true, c.Send(fmt.Sprintf("Ответ по обращению №%d отправлен", t.ID))
+}
+
+// closeTicket triggers when user or admin closes ticket, the other side is notified.
+func (b *Bot) closeTicket(c tele.Context, ctx context.Context, cb btnCallback) error {
+	id, err := strconv.ParseInt(cb.data, 10, 64)
+	if err != nil {
+		return fmt.Errorf("ticket id not found in callback data: %w", err)
+	}
+
+	t, err := b.storage.GetTicket(ctx, id)
+	if err != nil {
+		return fmt.Errorf("ticket not received: %w", err)
+	}
+
+	byUser := c.Sender() != nil && c.Sender().ID == t.UID
+
+	if !byUser {
+		err := b.checkRole(c, domain.AdminRoleOperator)
+		if errors.Is(err, errRoleHandled) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := b.tele.EditReplyMarkup(c.Message(), nil); err != nil {
+		slog.WarnContext(ctx, "ticket keyboard not removed", "cause", err.Error())
+	}
+
+	if t.Status == domain.TicketStatusClosed {
+		return c.Send(fmt.Sprintf("Обращение №%d уже закрыто", t.ID))
+	}
+
+	if err := b.storage.SetTicketStatus(ctx, t.ID, domain.TicketStatusClosed, time.Now()); err != nil {
+		return fmt.Errorf("ticket not closed: %w", err)
+	}
+
+	slog.InfoContext(ctx, "ticket closed", "ticket_id", t.ID, "by_user", byUser)
+
+	if byUser {
+		if _, err := b.notifyAdmins(ctx, eventSupport, 0, fmt.Sprintf("Пользователь %d закрыл обращение №%d", t.UID, t.ID)); err != nil {
+			slog.WarnContext(ctx, "ticket close msg not sent to admin", "cause", err.Error())
+		}
+
+		return c.Send(fmt.Sprintf("Обращение №%d закрыто. Если что-то снова сломается, открой новое через /support", t.ID))
+	}
+
+	msg := fmt.Sprintf("Админ закрыл обращение №%d. Если проблема осталась, открой новое через /support", t.ID)
+
+	if _, err := b.tele.Send(recipient(t.UID), msg); err != nil {
+		slog.WarnContext(ctx, "ticket close msg not sent to user", "cause", err.Error())
+	}
+
+	return c.Send(fmt.Sprintf("Обращение №%d закрыто", t.ID))
+}
+
+// handleTickets lists open tickets, closed tickets with "closed" arg or history of ticket by id.
+func (b *Bot) handleTickets(c tele.Context) error {
+	ctx := stdContext(c)
+	args := c.Args()
+
+	statuses := []domain.TicketStatus{domain.TicketStatusOpen, domain.TicketStatusAnswered}
+
+	if len(args) == 1 {
+		if args[0] == string(domain.TicketStatusClosed) {
+			statuses = []domain.TicketStatus{domain.TicketStatusClosed}
+		} else if id, err := strconv.ParseInt(strings.TrimPrefix(args[0], "№"), 10, 64); err == nil {
+			return b.sendTicket(c, ctx, id)
+		} else {
+			return c.Send("Использование: /tickets [closed или номер обращения]")
+		}
+	}
+
+	tickets, err := b.storage.ListTickets(ctx, statuses, ticketsLimit)
+	if err != nil {
+		return fmt.Errorf("tickets not listed: %w", err)
+	}
+
+	if len(tickets) == 0 {
+		return c.Send("Обращений нет")
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString("Обращения:\n")
+
+	for _, t := range tickets {
+		fmt.Fprintf(sb, "\n№%d %s, ID: %d", t.ID, ticketStatusNames[t.Status], t.UID)
+
+		if t.Username != "" {
+			fmt.Fprintf(sb, " @%s", t.Username)
+		}
+
+		fmt.Fprintf(sb, ", обновлено %s", t.UpdatedAt.Format("02.01.2006 15:04"))
+	}
+
+	sb.WriteString("\n\n/tickets <номер> - история обращения")
+
+	return c.Send(sb.String())
+}
+
+// sendTicket sends history of ticket messages.
+func (b *Bot) sendTicket(c tele.Context, ctx context.Context, id int64) error {
+	t, err := b.storage.GetTicket(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Send(fmt.Sprintf("Обращение №%d не найдено", id))
+	}
+	if err != nil {
+		return fmt.Errorf("ticket not received: %w", err)
+	}
+
+	msgs, err := b.storage.ListTicketMessages(ctx, id)
+	if err != nil {
+		return fmt.Errorf("ticket messages not listed: %w", err)
+	}
+
+	sb := &strings.Builder{}
+
+	fmt.Fprintf(sb, "Обращение №%d, %s, открыто %s\n\n", t.ID, ticketStatusNames[t.Status], t.CreatedAt.Format("02.01.2006 15:04"))
+	(&user{id: t.UID, username: t.Username, firstName: t.FirstName, lastName: t.LastName}).write(sb)
+
+	if len(msgs) > ticketHistoryLimit {
+		fmt.Fprintf(sb, "\n\nпоказаны последние %d сообщений из %d", ticketHistoryLimit, len(msgs))
+		msgs = msgs[len(msgs)-ticketHistoryLimit:]
+	}
+
+	for _, m := range msgs {
+		from := "Пользователь"
+		if m.FromAdmin {
+			from = "Админ"
+		}
+
+		fmt.Fprintf(sb, "\n\n%s %s:\n%s", m.CreatedAt.Format("02.01 15:04"), from, m.Text)
+	}
+
+	if t.Status == domain.TicketStatusClosed {
+		return c.Send(sb.String())
+	}
+
+	sb.WriteString("\n\nОтветь на это сообщение, чтобы написать пользователю")
+
+	m, err := b.tele.Send(c.Chat(), sb.String(), ticketKeyboard(t.ID))
+	if err != nil {
+		return fmt.Errorf("ticket not sent: %w", err)
+	}
+
+	if err := b.storage.SaveTicketRelays(ctx, t.ID, []storage.AdminMessage{{ChatID: m.Chat.ID, MessageID: m.ID}}); err != nil {
+		return fmt.Errorf("ticket relays not saved: %w", err)
+	}
+
+	return nil
+}
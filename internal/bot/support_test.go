@@ -0,0 +1,230 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+)
+
+// openTicket opens ticket of user with message and returns id of the ticket and message relayed to admin.
+func (e *testEnv) openTicket(t *testing.T, text string) (int64, int) {
+	t.Helper()
+
+	if err := e.bot.handleSupport(e.command(testUserID, "/support "+text)); err != nil {
+		t.Fatal(err)
+	}
+
+	tk, err := e.store.GetUserTicket(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := e.tg.Calls("sendMessage", testAdminID)
+	if len(calls) == 0 {
+		t.Fatal("ticket not relayed to admin")
+	}
+
+	return tk.ID, calls[len(calls)-1].MessageID
+}
+
+// reply returns context of message replying to message in chat.
+func (e *testEnv) reply(chatID int64, replyTo int, text string) tele.Context {
+	c := e.text(chatID, text)
+	c.Message().ReplyTo = &tele.Message{ID: replyTo, Chat: &tele.Chat{ID: chatID}}
+	return c
+}
+
+func TestSupportRelay(t *testing.T) {
+	env := newTestEnv(t)
+	env.approvedOrder(t, 1)
+
+	if err := env.bot.handleSupport(env.command(testUserID, "/support")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.handleText(env.text(testUserID, "Ключ не работает")); err != nil {
+		t.Fatal(err)
+	}
+
+	relayed := env.lastText(t, testAdminID)
+	if !strings.Contains(relayed, "Ключ не работает") || !strings.Contains(relayed, "ID: 100") {
+		t.Errorf("relayed message = %q, want text and user", relayed)
+	}
+
+	if !strings.HasPrefix(env.lastText(t, testUserID), "Передал админу") {
+		t.Errorf("user message = %q, want confirmation", env.lastText(t, testUserID))
+	}
+
+	tk, err := env.store.GetUserTicket(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tk.Status != domain.TicketStatusOpen {
+		t.Errorf("ticket status = %s, want %s", tk.Status, domain.TicketStatusOpen)
+	}
+}
+
+func TestSupportRelayMedia(t *testing.T) {
+	env := newTestEnv(t)
+	env.openTicket(t, "Не подключается")
+
+	photo := env.text(testUserID, "")
+	photo.Message().Photo = &tele.Photo{File: tele.File{FileID: "photo"}}
+	photo.Message().Caption = "скриншот ошибки"
+
+	if err := env.bot.handleMedia(photo); err != nil {
+		t.Fatal(err)
+	}
+
+	relayed := env.lastText(t, testAdminID)
+	if !strings.Contains(relayed, "[фото] скриншот ошибки") {
+		t.Errorf("relayed message = %q, want media description", relayed)
+	}
+
+	copies := env.tg.Calls("copyMessage", testAdminID)
+	if len(copies) != 1 {
+		t.Fatalf("copies = %d, want photo copied to admin", len(copies))
+	}
+
+	relays := env.tg.Calls("sendMessage", testAdminID)
+	relayID := relays[len(relays)-1].MessageID
+
+	if got := copies[0].Params["reply_to_message_id"]; got != strconv.Itoa(relayID) {
+		t.Errorf("photo replies to %s, want relay %d", got, relayID)
+	}
+
+	if !strings.HasPrefix(env.lastText(t, testUserID), "Передал админу") {
+		t.Errorf("user message = %q, want confirmation", env.lastText(t, testUserID))
+	}
+
+	// admin replies to copied photo
+	if err := env.bot.handleText(env.reply(testAdminID, copies[0].MessageID, "Обнови приложение")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.Contains(got, "Обнови приложение") {
+		t.Errorf("reply = %q, want admin answer", got)
+	}
+}
+
+func TestSupportMediaWithoutTicket(t *testing.T) {
+	env := newTestEnv(t)
+
+	photo := env.text(testUserID, "")
+	photo.Message().Photo = &tele.Photo{File: tele.File{FileID: "photo"}}
+
+	if err := env.bot.handleMedia(photo); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := env.sent("sendMessage", testAdminID) + env.sent("copyMessage", testAdminID); n != 0 {
+		t.Errorf("media without ticket sent to admin %d times", n)
+	}
+}
+
+func TestSupportReply(t *testing.T) {
+	env := newTestEnv(t)
+	id, relayID := env.openTicket(t, "Ключ не работает")
+
+	if err := env.bot.handleText(env.reply(testAdminID, relayID, "Переустанови приложение")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasSuffix(got, "Переустанови приложение") {
+		t.Errorf("reply = %q, want admin text", got)
+	}
+
+	tk, err := env.store.GetTicket(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tk.Status != domain.TicketStatusAnswered {
+		t.Errorf("ticket status = %s, want %s", tk.Status, domain.TicketStatusAnswered)
+	}
+
+	msgs, err := env.store.ListTicketMessages(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msgs) != 2 || msgs[0].FromAdmin || !msgs[1].FromAdmin {
+		t.Errorf("unexpected ticket messages: %+v", msgs)
+	}
+}
+
+func TestSupportReplyByViewer(t *testing.T) {
+	env := newTestEnv(t)
+	env.withAdmins(t)
+	_, relayID := env.openTicket(t, "Ключ не работает")
+
+	before := env.sent("sendMessage", testUserID)
+
+	if err := env.bot.handleText(env.reply(testViewerID, relayID, "Переустанови приложение")); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.sent("sendMessage", testUserID) != before {
+		t.Error("reply of viewer sent to user")
+	}
+}
+
+func TestSupportCloseByUser(t *testing.T) {
+	env := newTestEnv(t)
+	id, _ := env.openTicket(t, "Ключ не работает")
+
+	if err := env.bot.handleCallback(env.callback(testUserID, stepCloseTicket, "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	tk, err := env.store.GetTicket(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tk.Status != domain.TicketStatusClosed {
+		t.Errorf("ticket status = %s, want %s", tk.Status, domain.TicketStatusClosed)
+	}
+
+	if got, want := env.lastText(t, testAdminID), "Пользователь 100 закрыл обращение №1"; got != want {
+		t.Errorf("admin message = %q, want %q", got, want)
+	}
+
+	// text after closed ticket is not relayed
+	before := env.sent("sendMessage", testAdminID)
+
+	if err := env.bot.handleText(env.text(testUserID, "Спасибо")); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.sent("sendMessage", testAdminID) != before {
+		t.Error("text relayed after ticket closed")
+	}
+}
+
+func TestTickets(t *testing.T) {
+	env := newTestEnv(t)
+	env.openTicket(t, "Ключ не работает")
+
+	if err := env.bot.handleTickets(env.command(testAdminID, "/tickets")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testAdminID); !strings.Contains(got, "№1 ждет ответа, ID: 100") {
+		t.Errorf("tickets = %q, want open ticket", got)
+	}
+
+	if err := env.bot.handleTickets(env.command(testAdminID, "/tickets 1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testAdminID); !strings.Contains(got, "Ключ не работает") {
+		t.Errorf("ticket history = %q, want user message", got)
+	}
+}
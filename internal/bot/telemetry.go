@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const instrumentationName = "github.com/ysomad/outline-bot/internal/bot"
+
+// endSpan records err in span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceMiddleware handles every update in its own span named after command or callback.
+func traceMiddleware(tracer trace.Tracer) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) (err error) {
+			ctx, span := tracer.Start(stdContext(c), updateSpanName(c), trace.WithSpanKind(trace.SpanKindServer))
+			defer func() { endSpan(span, err) }()
+
+			c.Set(ctxKey, ctx)
+
+			return next(c)
+		}
+	}
+}
+
+func updateSpanName(c tele.Context) string {
+	if cb := c.Callback(); cb != nil {
+		if parsed, err := parseCallback(cb.Data); err == nil {
+			return "callback " + parsed.unique
+		}
+		return "callback"
+	}
+
+	if text := c.Text(); strings.HasPrefix(text, "/") {
+		cmd, _, _ := strings.Cut(text, " ")
+		return "command " + cmd
+	}
+
+	return "text"
+}
+
+// metrics are business metrics of the bot.
+type metrics struct {
+	ordersCreated  metric.Int64Counter
+	ordersApproved metric.Int64Counter
+	ordersRejected metric.Int64Counter
+	ordersCanceled metric.Int64Counter
+	trialsStarted  metric.Int64Counter
+	revenue        metric.Int64Counter
+	workerFailures metric.Int64Counter
+	handlerErrors  metric.Int64Counter
+
+	mu            sync.Mutex
+	workerSuccess map[string]time.Time // last successful tick of worker
+}
+
+func newMetrics(meter metric.Meter, s storage.Storage) (*metrics, error) {
+	m := &metrics{workerSuccess: make(map[string]time.Time)}
+	errs := make([]error, 10)
+
+	m.ordersCreated, errs[0] = meter.Int64Counter("bot.orders.created",
+		metric.WithDescription("Orders created by users"))
+	m.ordersApproved, errs[1] = meter.Int64Counter("bot.orders.approved",
+		metric.WithDescription("Orders approved by admin"))
+	m.ordersRejected, errs[2] = meter.Int64Counter("bot.orders.rejected",
+		metric.WithDescription("Orders and renewals rejected by admin"))
+	m.revenue, errs[3] = meter.Int64Counter("bot.revenue",
+		metric.WithDescription("Paid amount of approved and renewed orders"),
+		metric.WithUnit("RUB"))
+	m.workerFailures, errs[4] = meter.Int64Counter("bot.worker.failures",
+		metric.WithDescription("Failed worker ticks"))
+	m.handlerErrors, errs[5] = meter.Int64Counter("bot.handler.errors",
+		metric.WithDescription("Errors returned by telegram update handlers"))
+
+	_, errs[6] = meter.Int64ObservableGauge("bot.worker.last_success",
+		metric.WithDescription("Unix time of last successful worker tick"),
+		metric.WithUnit("s"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			for name, t := range m.workerSuccess {
+				o.Observe(t.Unix(), metric.WithAttributes(attrWorker(name)))
+			}
+
+			return nil
+		}))
+
+	_, errs[7] = meter.Int64ObservableGauge("bot.keys.active",
+		metric.WithDescription("Active access keys"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			keys, err := s.AllActiveKeys(ctx)
+			if err != nil {
+				return err
+			}
+
+			perBackend := make(map[domain.Backend]int64)
+			for _, k := range keys {
+				perBackend[k.Backend]++
+			}
+
+			for backend, n := range perBackend {
+				o.Observe(n, metric.WithAttributes(attrBackend(backend)))
+			}
+
+			return nil
+		}))
+
+	m.ordersCanceled, errs[8] = meter.Int64Counter("bot.orders.canceled",
+		metric.WithDescription("Orders canceled by users or because they were not paid in time"))
+	m.trialsStarted, errs[9] = meter.Int64Counter("bot.trials.started",
+		metric.WithDescription("Free trials started by new users"))
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *metrics) workerSucceeded(name string, t time.Time) {
+	m.mu.Lock()
+	m.workerSuccess[name] = t
+	m.mu.Unlock()
+}
+
+func attrWorker(name string) attribute.KeyValue {
+	return attribute.String("worker", name)
+}
+
+func attrBackend(b domain.Backend) attribute.KeyValue {
+	return attribute.String("backend", string(b))
+}
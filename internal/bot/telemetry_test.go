@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+)
+
+func TestTelemetry(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	env := newTestEnv(t)
+	env.start(t)
+
+	env.tg.PushText(testUser, "/order")
+	env.press(t, testUser, env.wait(t, "sendMessage", testUserID, 1), "1")
+	env.wait(t, "sendPhoto", testUserID, 1)
+
+	// span ends after reply is sent
+	deadline := time.Now().Add(waitTimeout)
+	found := false
+
+	for !found && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+
+		for _, s := range spans.Ended() {
+			if s.Name() != "callback select_key_amount" {
+				continue
+			}
+
+			found = true
+			attrs := attribute.NewSet(s.Attributes()...)
+
+			if v, ok := attrs.Value("user_id"); !ok || v.AsInt64() != testUserID {
+				t.Errorf("user_id = %v, want %d", v.AsInt64(), testUserID)
+			}
+
+			if v, ok := attrs.Value("order_id"); !ok || v.AsInt64() != 1 {
+				t.Errorf("order_id = %v, want 1", v.AsInt64())
+			}
+		}
+	}
+
+	if !found {
+		t.Error("callback span not recorded")
+	}
+
+	rm := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	if created := sumCounter(rm, "bot.orders.created"); created != 1 {
+		t.Errorf("orders created = %d, want 1", created)
+	}
+}
+
+// sumCounter returns sum of all data points of int64 counter.
+func sumCounter(rm metricdata.ResourceMetrics, name string) int64 {
+	sum := int64(0)
+
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				sum += dp.Value
+			}
+		}
+	}
+
+	return sum
+}
+
+func TestRenewRevenue(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	env := newTestEnv(t)
+	oid := env.approvedOrder(t, 1)
+
+	if err := env.bot.handleRenew(env.command(testAdminID, "/renew "+oid.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	// approval and renewal
+	if revenue := sumCounter(rm, "bot.revenue"); revenue != 2*domain.PricePerKey {
+		t.Errorf("revenue = %d, want %d", revenue, 2*domain.PricePerKey)
+	}
+}
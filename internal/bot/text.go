@@ -12,19 +12,40 @@ import (
 	"time"
 
 	"github.com/goombaio/namegenerator"
+	"github.com/ysomad/outline-bot/internal/domain"
 	"github.com/ysomad/outline-bot/internal/outline"
+	"github.com/ysomad/outline-bot/internal/provisioner"
 	"github.com/ysomad/outline-bot/internal/storage"
+	"go.opentelemetry.io/otel"
 	tele "gopkg.in/telebot.v3"
 )
 
 func (b *Bot) handleText(c tele.Context) error {
 	usr := newUser(c.Chat())
 
+	if c.Message().ReplyTo != nil {
+		if replied, err := b.replyTicket(c); replied {
+			return err
+		}
+	}
+
 	state, ok := b.state.Get(usr.ID())
 	if !ok {
-		return errors.New("no state found in text handler")
+		// admins talk to each other in admin group
+		if b.isForum(c) {
+			return nil
+		}
+
+		if relayed, err := b.relayTicketMessage(c); relayed {
+			return err
+		}
+
+		// not an error, user just writes to the bot
+		return c.Send("Не понимаю тебя, воспользуйся командами из меню")
 	}
 
+	ctx := stdContext(c)
+
 	switch step(state.step) {
 	case stepMigrateKeys:
 		outlineURL, err := url.Parse(c.Text())
@@ -38,18 +59,31 @@ func (b *Bot) handleText(c tele.Context) error {
 		}
 
 		// outline client with new url
-		outlineClient, err := outline.NewClient(outlineURL.String(), outline.WithClient(outlineHttpCli))
+		outlineClient, err := outline.NewClient(outlineURL.String(),
+			outline.WithClient(outlineHttpCli),
+			outline.WithTracerProvider(otel.GetTracerProvider()),
+			outline.WithMeterProvider(otel.GetMeterProvider()))
 		if err != nil {
 			return c.Send("outline new client: " + err.Error())
 		}
 
-		orders, err := b.storage.ListActiveOrders()
+		ol, err := b.outlineBackend()
+		if err != nil {
+			return err
+		}
+
+		newOutline, err := provisioner.NewOutline(outlineClient, ol.Prefix())
+		if err != nil {
+			return c.Send("outline new backend: " + err.Error())
+		}
+
+		orders, err := b.storage.ListActiveOrders(ctx, domain.BackendOutline)
 		if err != nil {
 			return c.Send("list active orders: " + err.Error())
 		}
 
-		if err := b.storage.DeteleAllKeys(); err != nil {
-			return c.Send("delete all keys: %w", err)
+		if err := b.storage.DeleteBackendKeys(ctx, domain.BackendOutline); err != nil {
+			return c.Send("delete all keys: " + err.Error())
 		}
 
 		now := time.Now()
@@ -62,30 +96,26 @@ func (b *Bot) handleText(c tele.Context) error {
 			fmt.Fprintf(sb, "Заказ №%d пересоздан, срок окончания ключей не изменился (до %s)\n", order.ID, order.ExpiresAt.Time.Format("02.01.2006"))
 
 			for i := range order.KeyAmount {
-				keyName := gen.Generate()
-
-				newKey, err := outlineClient.AccessKeysPost(context.TODO(), outline.NewOptAccessKeysPostReq(outline.AccessKeysPostReq{
-					Name: outline.NewOptString(keyName),
-				}))
+				newKey, err := newOutline.CreateKey(ctx, gen.Generate())
 				if err != nil {
 					return fmt.Errorf("outline key not created: %w", err)
 				}
 
-				slog.Info("created key in outline", "key_id", newKey.ID, "key_name", newKey.Name.Value)
+				slog.InfoContext(ctx, "created key in outline", "key_id", newKey.ID, "key_name", newKey.Name)
 
-				fmt.Fprintf(sb, "\n%s %s\n```\n%s\n```", newKey.ID, newKey.Name.Value, newKey.AccessUrl.Value)
+				fmt.Fprintf(sb, "\n%s %s\n```\n%s\n```", newKey.ID, newKey.Name, newKey.URL)
 
 				keys[i] = storage.Key{
 					ID:   newKey.ID,
-					Name: keyName,
-					URL:  newKey.AccessUrl.Value,
+					Name: newKey.Name,
+					URL:  newKey.URL,
 				}
 			}
 
 			fmt.Fprintf(sb, "Старые ключи работать перестанут, не забудь поменять ключи в Outline!")
 
-			if err := b.storage.ApproveOrder(order.ID, keys, order.ExpiresAt.Time); err != nil {
-				return fmt.Errorf("order not approved: %w", err)
+			if err := b.storage.AddOrderKeys(ctx, order.ID, keys); err != nil {
+				return fmt.Errorf("order keys not added: %w", err)
 			}
 
 			if _, err := b.tele.Send(recipient(order.UID), sb.String(), tele.ModeMarkdown); err != nil {
@@ -98,7 +128,130 @@ func (b *Bot) handleText(c tele.Context) error {
 		b.state.Remove(usr.ID())
 
 		return c.Send("Все ключи мигрированы, не забудь обновить OUTLINE_URL, OUTLINE_HOST в .envv!!!")
+	case stepChangeHostname:
+		return b.changeHostname(c, usr)
+	case stepEnterPromo:
+		return b.applyPromo(c, ctx, usr)
+	case stepUserMessage:
+		return b.messageUser(c, state)
+	case stepBroadcastMessage, stepBroadcastSegment:
+		return b.composeBroadcast(c)
 	default:
 		return errors.New("unsupported text step")
 	}
 }
+
+// changeHostname sets new hostname for access keys in outline and rewrites urls of active keys.
+// Only users which keys urls are changed will be notified.
+func (b *Bot) changeHostname(c tele.Context, usr *user) error {
+	ctx := stdContext(c)
+	hostname := strings.TrimSpace(c.Text())
+
+	ol, err := b.outlineBackend()
+	if err != nil {
+		return err
+	}
+
+	if err := ol.SetHostname(ctx, hostname); err != nil {
+		if errors.Is(err, provisioner.ErrInvalidHostname) {
+			return c.Send(fmt.Sprintf("Некорректный хостнейм (%s), попробуй еще раз", hostname))
+		}
+		return c.Send("hostname not changed: " + err.Error())
+	}
+
+	b.state.Remove(usr.ID())
+
+	slog.InfoContext(ctx, "outline hostname changed", "hostname", hostname)
+
+	keys, err := b.storage.AllActiveKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("active keys not listed: %w", err)
+	}
+
+	updated := newUpdatedKeys()
+	failed := 0
+
+	// one broken key must not leave users of already rewritten keys unnotified
+	for _, k := range keys {
+		if k.Backend != domain.BackendOutline {
+			continue
+		}
+
+		key, err := ol.Key(ctx, k.ID)
+		if errors.Is(err, provisioner.ErrKeyNotFound) {
+			slog.WarnContext(ctx, "key not found in outline", "key_id", k.ID)
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "key not received from outline", "key_id", k.ID, "cause", err.Error())
+			failed++
+			continue
+		}
+
+		if key.URL == k.URL {
+			continue
+		}
+
+		if err := b.storage.UpdateKeyURL(ctx, k.ID, key.URL); err != nil {
+			slog.ErrorContext(ctx, "key url not updated", "key_id", k.ID, "cause", err.Error())
+			failed++
+			continue
+		}
+
+		slog.InfoContext(ctx, "key url updated", "key_id", k.ID)
+
+		k.URL = key.URL
+		updated.add(k)
+	}
+
+	b.sendUpdatedKeys(ctx, updated, "Адрес сервера изменился, ключи по заказу №%d обновлены (до %s)\n")
+
+	msg := fmt.Sprintf("Хостнейм изменен на %s, обновлено заказов %d шт., не забудь обновить OUTLINE_HOST в .env!!!", hostname, len(updated.oids))
+	if failed > 0 {
+		msg += fmt.Sprintf("\n\nНе удалось обновить ключей: %d шт., подробности в логах", failed)
+	}
+
+	return c.Send(msg)
+}
+
+// updatedKeys is active keys with changed urls grouped by order.
+type updatedKeys struct {
+	keys map[domain.OrderID][]storage.ActiveKey
+	oids []domain.OrderID
+}
+
+func newUpdatedKeys() *updatedKeys {
+	return &updatedKeys{keys: make(map[domain.OrderID][]storage.ActiveKey)}
+}
+
+func (u *updatedKeys) add(k storage.ActiveKey) {
+	if _, ok := u.keys[k.OrderID]; !ok {
+		u.oids = append(u.oids, k.OrderID)
+	}
+
+	u.keys[k.OrderID] = append(u.keys[k.OrderID], k)
+}
+
+// sendUpdatedKeys sends new keys to users of updated orders,
+// title must contain order id and expiration date verbs.
+func (b *Bot) sendUpdatedKeys(ctx context.Context, updated *updatedKeys, title string) {
+	sb := &strings.Builder{}
+
+	for _, oid := range updated.oids {
+		keys := updated.keys[oid]
+
+		fmt.Fprintf(sb, title, oid, keys[0].ExpiresAt.Format("02.01.2006"))
+
+		for _, k := range keys {
+			fmt.Fprintf(sb, "\n%s %s\n```\n%s\n```", k.ID, k.Name, k.URL)
+		}
+
+		sb.WriteString("\nСтарые ключи работать перестанут, не забудь поменять ключи в Outline!")
+
+		if _, err := b.tele.Send(recipient(keys[0].UID), sb.String(), tele.ModeMarkdown); err != nil {
+			slog.ErrorContext(ctx, "updated keys msg not sent to user", "order_id", oid, "cause", err.Error())
+		}
+
+		sb.Reset()
+	}
+}
@@ -0,0 +1,179 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const gigabyte int64 = 1 << 30
+
+// handleTrial provisions free key with short ttl for new user, every user gets trial only once.
+func (b *Bot) handleTrial(c tele.Context) error {
+	usr := newUser(c.Chat())
+	ctx := stdContext(c)
+
+	if b.trialTTL == 0 {
+		return c.Send("Пробный период недоступен, воспользуйся командами из меню")
+	}
+
+	_, err := b.storage.GetTrial(ctx, usr.id)
+	if err == nil {
+		return c.Send("Пробный период уже использован, чтобы продолжить пользоваться ВПНом размести заказ /order")
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("trial not received: %w", err)
+	}
+
+	orders, err := b.storage.ListUserOrders(ctx, usr.id)
+	if err != nil {
+		return fmt.Errorf("user orders not listed: %w", err)
+	}
+
+	for _, o := range orders {
+		// free order canceled because its keys were not provisioned, e.g. failed trial
+		if o.Price == 0 && o.Status.String == string(domain.OrderStatusCanceled) {
+			continue
+		}
+
+		return c.Send("Пробный период доступен только новым пользователям, размести заказ /order")
+	}
+
+	now := time.Now()
+
+	oid, err := b.storage.CreateOrder(ctx, storage.CreateOrderParams{
+		Status:    domain.OrderStatusAwaitingPayment,
+		UID:       usr.id,
+		Username:  usr.username,
+		FirstName: usr.firstName,
+		LastName:  usr.lastName,
+		KeyAmount: 1,
+		CreatedAt: now,
+		Backend:   b.backend,
+	})
+	if err != nil {
+		return fmt.Errorf("trial order not created: %w", err)
+	}
+
+	ctx = withOrderID(ctx, oid)
+	c.Set(ctxKey, ctx)
+
+	// trial is claimed before key is provisioned, so concurrent /trial of same user gets no second key
+	err = b.storage.CreateTrial(ctx, storage.Trial{UID: usr.id, OrderID: oid, CreatedAt: now})
+	if errors.Is(err, storage.ErrNotFound) {
+		b.cancelUnprovisionedOrder(ctx, oid)
+		return c.Send("Пробный период уже использован, чтобы продолжить пользоваться ВПНом размести заказ /order")
+	}
+	if err != nil {
+		b.cancelUnprovisionedOrder(ctx, oid)
+		return fmt.Errorf("trial not created: %w", err)
+	}
+
+	order, err := b.storage.GetOrder(ctx, oid)
+	if err != nil {
+		b.cancelTrial(ctx, usr.id, oid)
+		return fmt.Errorf("trial order not found: %w", err)
+	}
+
+	if err := c.Send(b.trialMsg()); err != nil {
+		b.cancelTrial(ctx, usr.id, oid)
+		return fmt.Errorf("trial msg not sent: %w", err)
+	}
+
+	if _, err := b.provisionOrder(ctx, order, b.trialTTL, b.trialDataLimit); err != nil {
+		b.cancelTrial(ctx, usr.id, oid)
+		return err
+	}
+
+	slog.InfoContext(ctx, "trial started")
+
+	b.metrics.trialsStarted.Add(ctx, 1, metric.WithAttributes(attrBackend(b.backend)))
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Пробный период по заказу №%d до %s\n\n", oid, now.Add(b.trialTTL).Format("02.01.2006 15:04"))
+	usr.write(sb)
+
+	if _, err := b.notifyAdmins(ctx, eventOrder, oid, sb.String()); err != nil {
+		return fmt.Errorf("trial not sent to admin: %w", err)
+	}
+
+	return nil
+}
+
+// cancelTrial cancels trial order which was not provisioned and forgets trial, so failed trial may be started again.
+func (b *Bot) cancelTrial(ctx context.Context, uid int64, oid domain.OrderID) {
+	b.cancelUnprovisionedOrder(ctx, oid)
+
+	if err := b.storage.DeleteTrial(ctx, uid, oid); err != nil {
+		slog.ErrorContext(ctx, "unprovisioned trial not deleted", "cause", err.Error())
+	}
+}
+
+const trialNotRenewedMsg = "Заказ №%d - пробный период, его нельзя продлить"
+
+// isTrialOrder reports whether order is free trial of its user.
+func (b *Bot) isTrialOrder(ctx context.Context, order storage.Order) (bool, error) {
+	t, err := b.storage.GetTrial(ctx, order.UID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("trial not received: %w", err)
+	}
+
+	return t.OrderID == order.ID, nil
+}
+
+func (b *Bot) trialMsg() string {
+	msg := fmt.Sprintf("Пробный период на %s", formatDuration(b.trialTTL))
+
+	if b.trialDataLimit > 0 {
+		msg += fmt.Sprintf(", трафик до %d ГБ", b.trialDataLimit/gigabyte)
+	}
+
+	return msg + ". Перед окончанием я напомню разместить заказ, чтобы ВПН продолжил работать"
+}
+
+// trialRemindBefore returns how long before expiration user is asked to order, it is never longer than half of trial.
+func (b *Bot) trialRemindBefore() time.Duration {
+	return min(domain.BeforeTrialExpiration, b.trialTTL/2)
+}
+
+// remindTrial asks user to order keys before trial expires, user is asked only once.
+func (b *Bot) remindTrial(ctx context.Context, o order, expiresIn time.Duration) error {
+	if expiresIn > b.trialRemindBefore() {
+		return nil
+	}
+
+	t, err := b.storage.GetTrial(ctx, o.user.id)
+	if err != nil {
+		return fmt.Errorf("trial not received: %w", err)
+	}
+
+	if t.RemindedAt.Valid {
+		return nil
+	}
+
+	msg := fmt.Sprintf("Пробный период закончится %s, после этого ключ перестанет работать.\n\nСколько ключей доступа к ВПНу хочешь заказать, чтобы продолжить пользоваться ВПНом?", o.expiresAt.Format("02.01.2006 15:04"))
+
+	if _, err := b.tele.Send(recipient(o.user.id), msg, keyAmountKeyboard(true)); err != nil {
+		return fmt.Errorf("trial reminder not sent to user: %w", err)
+	}
+
+	if err := b.storage.MarkTrialReminded(ctx, o.user.id, time.Now()); err != nil {
+		return fmt.Errorf("trial not marked reminded: %w", err)
+	}
+
+	slog.InfoContext(ctx, "trial reminder sent to user", "user_id", o.user.id, "order_id", o.id)
+
+	return nil
+}
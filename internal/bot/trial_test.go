@@ -0,0 +1,261 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/config"
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/outlinetest"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const testTrialTTL = 72 * time.Hour
+
+func withTrial(c *config.TG) {
+	c.TrialTTL = testTrialTTL
+	c.TrialDataLimit = 5
+}
+
+func TestTrial(t *testing.T) {
+	env := newTestEnv(t, withTrial)
+	ctx := context.Background()
+
+	if err := env.bot.handleTrial(env.command(testUserID, "/trial")); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := env.store.ListActiveUserKeys(ctx, testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 {
+		t.Fatalf("trial keys = %d, want 1", len(keys))
+	}
+
+	if got, _ := env.outline.Limit(keys[0].ID); int64(got) != 5*gigabyte {
+		t.Errorf("trial key limit = %d, want %d", got, 5*gigabyte)
+	}
+
+	if err := env.bot.handleTrial(env.command(testUserID, "/trial")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasPrefix(got, "Пробный период уже использован") {
+		t.Errorf("second trial = %q, want trial used", got)
+	}
+
+	// user with orders is not new
+	env.approvedOrderOf(t, testInvitedUserID)
+
+	if err := env.bot.handleTrial(env.command(testInvitedUserID, "/trial")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testInvitedUserID); !strings.HasPrefix(got, "Пробный период доступен только новым пользователям") {
+		t.Errorf("trial of customer = %q, want only new users", got)
+	}
+
+	env.store.Now = func() time.Time { return time.Now().Add(testTrialTTL - 2*time.Hour) }
+
+	for range 2 {
+		if err := env.bot.notifyExpiringOrders(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := len(env.tg.Calls("sendMessage", testUserID)); n != 4 {
+		t.Errorf("messages to user = %d, want trial msg, keys, used trial and one reminder", n)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasPrefix(got, "Пробный период закончится") {
+		t.Errorf("reminder = %q, want trial reminder", got)
+	}
+
+	if n := env.sent("sendPhoto", testUserID); n != 0 {
+		t.Errorf("renewal payments sent = %d, want 0", n)
+	}
+
+	env.store.Now = func() time.Time { return time.Now().Add(testTrialTTL + time.Hour) }
+
+	if err := env.bot.deactivateExpiredKeys(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, keys[0].OrderID); got != domain.OrderStatusExpired {
+		t.Errorf("trial status = %s, want %s", got, domain.OrderStatusExpired)
+	}
+
+	if _, ok := env.outline.Key(keys[0].ID); ok {
+		t.Error("trial key not deleted from outline")
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasPrefix(got, "Пробный период по заказу №1 закончился") || !strings.HasSuffix(got, "/order") {
+		t.Errorf("expired = %q, want trial expired with order prompt", got)
+	}
+}
+
+func TestTrialNotProvisioned(t *testing.T) {
+	env := newTestEnv(t, withTrial)
+	ctx := context.Background()
+
+	env.outline.Inject(outlinetest.Fault{Route: "POST /access-keys", Status: http.StatusInternalServerError, Times: 1})
+
+	if err := env.bot.handleTrial(env.command(testUserID, "/trial")); err == nil {
+		t.Fatal("trial error = nil, want key not created")
+	}
+
+	if got := env.orderStatus(t, 1); got != domain.OrderStatusCanceled {
+		t.Errorf("failed trial status = %s, want %s", got, domain.OrderStatusCanceled)
+	}
+
+	if _, err := env.store.GetTrial(ctx, testUserID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("failed trial = %v, want not found", err)
+	}
+
+	// user may try again
+	if err := env.bot.handleTrial(env.command(testUserID, "/trial")); err != nil {
+		t.Fatal(err)
+	}
+
+	trial, err := env.store.GetTrial(ctx, testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trial.OrderID != 2 {
+		t.Errorf("trial order = %d, want 2", trial.OrderID)
+	}
+}
+
+// concurrentOrdersStorage holds ListUserOrders until all concurrent callers passed it.
+type concurrentOrdersStorage struct {
+	storage.Storage
+	wg *sync.WaitGroup
+}
+
+func (s concurrentOrdersStorage) ListUserOrders(ctx context.Context, uid int64) ([]storage.Order, error) {
+	orders, err := s.Storage.ListUserOrders(ctx, uid)
+	s.wg.Done()
+	s.wg.Wait()
+	return orders, err
+}
+
+func TestTrialConcurrent(t *testing.T) {
+	env := newTestEnv(t, withTrial)
+
+	// double tap or retried update, both pass checks before any order is created
+	const n = 2
+
+	checked := &sync.WaitGroup{}
+	checked.Add(n)
+	env.bot.storage = concurrentOrdersStorage{Storage: env.bot.storage, wg: checked}
+
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := env.bot.handleTrial(env.command(testUserID, "/trial")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(env.outline.Keys()); n != 1 {
+		t.Errorf("trial keys = %d, want 1", n)
+	}
+
+	trial, err := env.store.GetTrial(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.orderStatus(t, trial.OrderID); got != domain.OrderStatusApproved {
+		t.Errorf("trial status = %s, want %s", got, domain.OrderStatusApproved)
+	}
+
+	if got := env.orderStatus(t, 3-trial.OrderID); got != domain.OrderStatusCanceled {
+		t.Errorf("second trial status = %s, want %s", got, domain.OrderStatusCanceled)
+	}
+
+	used := 0
+	for _, c := range env.tg.Calls("sendMessage", testUserID) {
+		if strings.HasPrefix(c.Text, "Пробный период уже использован") {
+			used++
+		}
+	}
+
+	if used != 1 {
+		t.Errorf("trial used messages = %d, want 1", used)
+	}
+}
+
+func TestTrialNotRenewed(t *testing.T) {
+	env := newTestEnv(t, withTrial)
+
+	if err := env.bot.handleTrial(env.command(testUserID, "/trial")); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := env.store.GetOrder(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.handleRenew(env.command(testAdminID, "/renew 1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := env.lastText(t, testAdminID), "Заказ №1 - пробный период, его нельзя продлить"; got != want {
+		t.Errorf("/renew = %q, want %q", got, want)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepOrderRenewApproved, "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	edits := env.tg.Calls("editMessageText", testAdminID)
+	if len(edits) == 0 || edits[len(edits)-1].Text != "Заказ №1 - пробный период, его нельзя продлить" {
+		t.Errorf("renewal approve edits = %+v, want trial not renewed", edits)
+	}
+
+	after, err := env.store.GetOrder(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !after.ExpiresAt.Time.Equal(before.ExpiresAt.Time) {
+		t.Errorf("trial expires at = %s, want %s", after.ExpiresAt.Time, before.ExpiresAt.Time)
+	}
+
+	// expiring trial is not listed as renewal
+	env.store.Now = func() time.Time { return time.Now().Add(testTrialTTL - 2*time.Hour) }
+
+	if err := env.bot.handlePending(env.command(testAdminID, "/pending")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := env.lastText(t, testAdminID), "Нет заказов и продлений, ожидающих одобрения"; got != want {
+		t.Errorf("/pending = %q, want %q", got, want)
+	}
+}
+
+func TestTrialDisabled(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := env.bot.handleTrial(env.command(testUserID, "/trial")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasPrefix(got, "Пробный период недоступен") {
+		t.Errorf("trial = %q, want disabled", got)
+	}
+}
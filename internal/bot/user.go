@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/storage"
 )
 
 type user struct {
@@ -29,6 +31,16 @@ func newUser(c *telebot.Chat) *user {
 	}
 }
 
+// orderUser returns user who placed order.
+func orderUser(o storage.Order) *user {
+	return &user{
+		id:        o.UID,
+		username:  o.Username.String,
+		firstName: o.FirstName.String,
+		lastName:  o.LastName.String,
+	}
+}
+
 func (u *user) ID() string {
 	return strconv.FormatInt(u.id, 10)
 }
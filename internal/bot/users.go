@@ -0,0 +1,495 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/provisioner"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+// profileOrdersLimit is max amount of orders shown in user profile.
+const profileOrdersLimit = 20
+
+// handleUser sends profile of user found by id or username with admin actions.
+func (b *Bot) handleUser(c tele.Context) error {
+	args := c.Args()
+
+	if len(args) != 1 {
+		return c.Send("Использование: /user <id или @username>")
+	}
+
+	ctx := stdContext(c)
+
+	uid, _, err := b.findUser(ctx, args[0])
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Send(fmt.Sprintf("Пользователь %s не найден", args[0]))
+	}
+	if err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+
+	msg, kb, err := b.userProfile(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	return c.Send(msg, kb)
+}
+
+// userProfile returns profile of user with orders, keys and payments and keyboard with admin actions.
+func (b *Bot) userProfile(ctx context.Context, uid int64) (string, *tele.ReplyMarkup, error) {
+	orders, err := b.storage.ListUserOrders(ctx, uid)
+	if err != nil {
+		return "", nil, fmt.Errorf("user orders not listed: %w", err)
+	}
+
+	keys, err := b.storage.ListActiveUserKeys(ctx, uid)
+	if err != nil {
+		return "", nil, fmt.Errorf("user keys not listed: %w", err)
+	}
+
+	banned, err := b.storage.IsBanned(ctx, uid)
+	if err != nil {
+		return "", nil, fmt.Errorf("user ban not checked: %w", err)
+	}
+
+	usr := recipient(uid)
+	if len(orders) > 0 {
+		usr = orderUser(orders[0])
+	}
+
+	sb := &strings.Builder{}
+
+	sb.WriteString("Пользователь\n\n")
+	usr.write(sb)
+
+	if banned {
+		sb.WriteString("\nЗаблокирован")
+	}
+
+	trial, err := b.storage.GetTrial(ctx, uid)
+	if err == nil {
+		fmt.Fprintf(sb, "\nПробный период %s (№%d)", trial.CreatedAt.Format("02.01.2006"), trial.OrderID)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return "", nil, fmt.Errorf("user trial not received: %w", err)
+	}
+
+	balance, err := b.storage.GetBalance(ctx, uid)
+	if err != nil {
+		return "", nil, fmt.Errorf("user balance not received: %w", err)
+	}
+
+	autoRenew, err := b.storage.IsAutoRenew(ctx, uid)
+	if err != nil {
+		return "", nil, fmt.Errorf("user auto renew not received: %w", err)
+	}
+
+	fmt.Fprintf(sb, "\nБаланс %d руб.", balance)
+
+	if autoRenew {
+		sb.WriteString(", автопродление")
+	}
+
+	if len(orders) == 0 {
+		sb.WriteString("\n\nЗаказов нет")
+	} else {
+		sb.WriteString("\n\nЗаказы:")
+	}
+
+	var (
+		paid      []storage.Order
+		paidTotal int
+	)
+
+	for i, o := range orders {
+		if i < profileOrdersLimit {
+			sb.WriteString("\n")
+			writeOrderLine(sb, o)
+		}
+
+		// order is paid if it was approved
+		if o.ExpiresAt.Valid && o.Price-o.Discount > 0 {
+			paid = append(paid, o)
+			paidTotal += o.Price - o.Discount
+		}
+	}
+
+	if len(orders) > profileOrdersLimit {
+		fmt.Fprintf(sb, "\nи еще %d", len(orders)-profileOrdersLimit)
+	}
+
+	if len(keys) > 0 {
+		sb.WriteString("\n\nАктивные ключи:")
+	}
+
+	for _, k := range keys {
+		fmt.Fprintf(sb, "\n%s %s (№%d)", k.ID, k.Name, k.OrderID)
+	}
+
+	if len(paid) > 0 {
+		sb.WriteString("\n\nОплаты:")
+	}
+
+	for i, o := range paid {
+		if i == profileOrdersLimit {
+			break
+		}
+		fmt.Fprintf(sb, "\n%s №%d %d руб.", o.CreatedAt.Time.Format("02.01.2006"), o.ID, o.Price-o.Discount)
+	}
+
+	if len(paid) > 0 {
+		fmt.Fprintf(sb, "\nВсего %d руб.", paidTotal)
+	}
+
+	return sb.String(), b.userKeyboard(uid, keys, banned), nil
+}
+
+// writeOrderLine writes short description of order.
+func writeOrderLine(sb *strings.Builder, o storage.Order) {
+	fmt.Fprintf(sb, "№%d от %s, %s, ключей %d, %d руб.", o.ID, o.CreatedAt.Time.Format("02.01.2006"), o.Status.String, o.KeyAmount, o.Price)
+
+	if o.PromoCode.Valid {
+		fmt.Fprintf(sb, ", промокод %s", o.PromoCode.String)
+	}
+
+	if o.ExpiresAt.Valid {
+		fmt.Fprintf(sb, ", до %s", o.ExpiresAt.Time.Format("02.01.2006"))
+	}
+}
+
+func (b *Bot) userKeyboard(uid int64, keys []storage.ActiveKey, banned bool) *tele.ReplyMarkup {
+	kb := &tele.ReplyMarkup{}
+	id := strconv.FormatInt(uid, 10)
+
+	var rows []tele.Row
+
+	for i, k := range keys {
+		// keys are sorted by order id
+		if i > 0 && keys[i-1].OrderID == k.OrderID {
+			continue
+		}
+
+		oid := k.OrderID.String()
+
+		rows = append(rows, kb.Row(
+			kb.Data("Продлить №"+oid, stepUserExtend.String(), oid),
+			kb.Data("Отозвать №"+oid, stepUserRevoke.String(), oid),
+		))
+	}
+
+	rows = append(rows,
+		kb.Row(
+			kb.Data("Подарить 1 ключ", stepUserGrant.String(), id+":1"),
+			kb.Data("2", stepUserGrant.String(), id+":2"),
+			kb.Data("3", stepUserGrant.String(), id+":3"),
+		),
+		kb.Row(kb.Data("Написать", stepUserMessage.String(), id)),
+	)
+
+	if banned {
+		rows = append(rows, kb.Row(kb.Data("Разблокировать", stepUserUnban.String(), id)))
+	} else {
+		rows = append(rows, kb.Row(kb.Data("Заблокировать", stepUserBan.String(), id)))
+	}
+
+	kb.Inline(rows...)
+
+	return kb
+}
+
+// handleUserAction handles admin actions from user profile and refreshes the profile.
+func (b *Bot) handleUserAction(c tele.Context, ctx context.Context, cb btnCallback) error {
+	var (
+		uid int64
+		err error
+	)
+
+	switch step(cb.unique) {
+	case stepUserExtend:
+		uid, err = b.extendOrder(ctx, cb.data)
+	case stepUserRevoke:
+		uid, err = b.revokeOrder(ctx, cb.data)
+	case stepUserGrant:
+		uid, err = b.grantKeys(ctx, cb.data)
+	case stepUserMessage:
+		uid, err = strconv.ParseInt(cb.data, 10, 64)
+		if err != nil {
+			return fmt.Errorf("user id not found in callback data: %w", err)
+		}
+
+		b.state.Add(newUser(c.Chat()).ID(), State{step: stepUserMessage.String(), data: uid})
+
+		kb := &tele.ReplyMarkup{}
+		kb.Inline(kb.Row(btnCancel(kb)))
+
+		return c.Send(fmt.Sprintf("Отправь сообщение для пользователя %d", uid), kb)
+	case stepUserBan, stepUserUnban:
+		uid, err = b.banUser(ctx, cb)
+	default:
+		return fmt.Errorf("unsupported user action: %s", cb.unique)
+	}
+	if err != nil {
+		return err
+	}
+
+	msg, kb, err := b.userProfile(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	return c.Edit(msg, kb)
+}
+
+// extendOrder prolongs order for free and notifies user, returns id of order user.
+func (b *Bot) extendOrder(ctx context.Context, data string) (int64, error) {
+	oid, err := domain.OrderIDFromString(data)
+	if err != nil {
+		return 0, fmt.Errorf("order id not found in callback data: %w", err)
+	}
+
+	ctx = withOrderID(ctx, oid)
+
+	order, err := b.storage.GetOrder(ctx, oid)
+	if err != nil {
+		return 0, fmt.Errorf("order not found: %w", err)
+	}
+
+	err = b.storage.RenewOrder(ctx, oid, order.ExpiresAt.Time, domain.OrderTTL)
+	if errors.Is(err, storage.ErrNotFound) {
+		// button of closed order is pressed, profile is refreshed only
+		slog.WarnContext(ctx, "closed order not extended", "status", order.Status.String)
+		return order.UID, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("order not renewed: %w", err)
+	}
+
+	order, err = b.storage.GetOrder(ctx, oid)
+	if err != nil {
+		return 0, fmt.Errorf("order not found: %w", err)
+	}
+
+	slog.InfoContext(ctx, "order extended by admin", "ttl", domain.OrderTTL)
+
+	msg := fmt.Sprintf("Админ продлил заказ №%d до %s", oid, order.ExpiresAt.Time.Format("02.01.2006"))
+
+	if _, err = b.tele.Send(recipient(order.UID), msg); err != nil {
+		slog.WarnContext(ctx, "extend msg not sent to user", "cause", err.Error())
+	}
+
+	return order.UID, nil
+}
+
+// revokeOrder deletes keys of order from backend and closes it, returns id of order user.
+func (b *Bot) revokeOrder(ctx context.Context, data string) (int64, error) {
+	oid, err := domain.OrderIDFromString(data)
+	if err != nil {
+		return 0, fmt.Errorf("order id not found in callback data: %w", err)
+	}
+
+	ctx = withOrderID(ctx, oid)
+
+	order, err := b.storage.GetOrder(ctx, oid)
+	if err != nil {
+		return 0, fmt.Errorf("order not found: %w", err)
+	}
+
+	// button of already closed order is pressed, profile is refreshed only
+	if !refundable(order) {
+		slog.WarnContext(ctx, "closed order not revoked", "status", order.Status.String)
+		return order.UID, nil
+	}
+
+	if err := b.deleteOrderKeys(ctx, order); err != nil {
+		return 0, err
+	}
+
+	err = b.storage.RevokeOrder(ctx, oid, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		slog.WarnContext(ctx, "order closed while revoked")
+		return order.UID, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("order not closed on revoke: %w", err)
+	}
+
+	slog.InfoContext(ctx, "order revoked by admin")
+
+	if _, err = b.tele.Send(recipient(order.UID), fmt.Sprintf("Ключи по заказу №%d отозваны админом", oid)); err != nil {
+		slog.WarnContext(ctx, "revoke msg not sent to user", "cause", err.Error())
+	}
+
+	return order.UID, nil
+}
+
+// deleteOrderKeys deletes active keys of order from its backend, keys missing in backend are skipped.
+func (b *Bot) deleteOrderKeys(ctx context.Context, order storage.Order) error {
+	backend, err := b.backends.Get(order.Backend)
+	if err != nil {
+		return err
+	}
+
+	keys, err := b.storage.ListActiveUserKeys(ctx, order.UID)
+	if err != nil {
+		return fmt.Errorf("user keys not listed: %w", err)
+	}
+
+	for _, k := range keys {
+		if k.OrderID != order.ID {
+			continue
+		}
+
+		err := backend.DeleteKey(ctx, k.ID)
+		if errors.Is(err, provisioner.ErrKeyNotFound) {
+			slog.WarnContext(ctx, "deleted key not found", "key_id", k.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("key with id %s not deleted from %s: %w", k.ID, order.Backend, err)
+		}
+	}
+
+	return nil
+}
+
+// grantKeys creates free order for user and provisions it, data is user id and key amount separated by colon.
+func (b *Bot) grantKeys(ctx context.Context, data string) (int64, error) {
+	rawUID, rawAmount, _ := strings.Cut(data, ":")
+
+	uid, err := strconv.ParseInt(rawUID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("user id not found in callback data: %w", err)
+	}
+
+	keyAmount, err := strconv.Atoi(rawAmount)
+	if err != nil {
+		return 0, fmt.Errorf("key amount not found in callback data: %w", err)
+	}
+
+	orders, err := b.storage.ListUserOrders(ctx, uid)
+	if err != nil {
+		return 0, fmt.Errorf("user orders not listed: %w", err)
+	}
+
+	usr := recipient(uid)
+	if len(orders) > 0 {
+		usr = orderUser(orders[0])
+	}
+
+	oid, err := b.storage.CreateOrder(ctx, storage.CreateOrderParams{
+		Status:    domain.OrderStatusAwaitingPayment,
+		UID:       usr.id,
+		Username:  usr.username,
+		FirstName: usr.firstName,
+		LastName:  usr.lastName,
+		KeyAmount: keyAmount,
+		CreatedAt: time.Now(),
+		Backend:   b.backend,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("order not created: %w", err)
+	}
+
+	ctx = withOrderID(ctx, oid)
+
+	order, err := b.storage.GetOrder(ctx, oid)
+	if err != nil {
+		return 0, fmt.Errorf("order not found: %w", err)
+	}
+
+	if _, err = b.provisionOrder(ctx, order, domain.OrderTTL, 0); err != nil {
+		b.cancelUnprovisionedOrder(ctx, oid)
+		return 0, err
+	}
+
+	slog.InfoContext(ctx, "keys granted by admin", "key_amount", keyAmount)
+
+	return uid, nil
+}
+
+func (b *Bot) banUser(ctx context.Context, cb btnCallback) (int64, error) {
+	uid, err := strconv.ParseInt(cb.data, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("user id not found in callback data: %w", err)
+	}
+
+	if step(cb.unique) == stepUserUnban {
+		if err = b.storage.UnbanUser(ctx, uid); err != nil {
+			return 0, fmt.Errorf("user not unbanned: %w", err)
+		}
+
+		slog.InfoContext(ctx, "user unbanned", "banned_uid", uid)
+
+		return uid, nil
+	}
+
+	role, err := b.adminRole(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+
+	if role != "" {
+		return 0, errors.New("admin can't be banned")
+	}
+
+	if err = b.storage.BanUser(ctx, uid, time.Now()); err != nil {
+		return 0, fmt.Errorf("user not banned: %w", err)
+	}
+
+	slog.InfoContext(ctx, "user banned", "banned_uid", uid)
+
+	return uid, nil
+}
+
+// messageUser sends text from admin to user chosen in profile.
+func (b *Bot) messageUser(c tele.Context, state State) error {
+	uid, ok := state.data.(int64)
+	if !ok {
+		return errors.New("user id not found in state")
+	}
+
+	if _, err := b.tele.Send(recipient(uid), "Сообщение от админа:\n\n"+c.Text()); err != nil {
+		return fmt.Errorf("admin msg not sent to user: %w", err)
+	}
+
+	b.state.Remove(newUser(c.Chat()).ID())
+
+	return c.Send("Сообщение отправлено")
+}
+
+// banMiddleware ignores updates from banned users.
+func banMiddleware(users storage.Users, admin int64) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if c.Chat() == nil || c.Chat().ID == admin {
+				return next(c)
+			}
+
+			banned, err := users.IsBanned(stdContext(c), c.Chat().ID)
+			if err != nil {
+				return fmt.Errorf("ban not checked: %w", err)
+			}
+
+			if !banned {
+				return next(c)
+			}
+
+			slog.InfoContext(stdContext(c), "update from banned user ignored")
+
+			if c.Callback() != nil {
+				return c.Respond()
+			}
+
+			return c.Send("Доступ к боту заблокирован")
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/outlinetest"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+func TestUserProfile(t *testing.T) {
+	env := newTestEnv(t)
+
+	oid := env.approvedOrder(t, 2)
+
+	_, err := env.store.CreateOrder(context.Background(), storage.CreateOrderParams{
+		UID:       testUserID,
+		Username:  "Test_User",
+		KeyAmount: 1,
+		Price:     domain.PricePerKey,
+		CreatedAt: time.Now(),
+		Status:    domain.OrderStatusAwaitingPayment,
+		Backend:   domain.BackendOutline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.handleUser(env.command(testAdminID, "/user @test_user")); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := env.lastText(t, testAdminID)
+
+	for _, want := range []string{
+		"ID: 100\nЛогин: @Test_User",
+		"№2 от ",
+		"awaiting payment, ключей 1, 150 руб.",
+		"№1 от ",
+		"approved, ключей 2, 300 руб., до ",
+		"Активные ключи:",
+		"Оплаты:",
+		"Всего 300 руб.",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("profile has no %q:\n%s", want, msg)
+		}
+	}
+
+	calls := env.tg.Calls("sendMessage", testAdminID)
+	profile := calls[len(calls)-1]
+
+	for _, btn := range []string{"Продлить №" + oid.String(), "Отозвать №" + oid.String(), "Подарить 1 ключ", "Написать", "Заблокировать"} {
+		if _, ok := profile.Button(btn); !ok {
+			t.Errorf("profile has no %q button", btn)
+		}
+	}
+
+	if err := env.bot.handleUser(env.command(testAdminID, "/user @unknown")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testAdminID); got != "Пользователь @unknown не найден" {
+		t.Errorf("unknown user reply = %q", got)
+	}
+}
+
+func TestUserActions(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	oid := env.approvedOrder(t, 1)
+	before, err := env.store.GetOrder(ctx, oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("extend", func(t *testing.T) {
+		if err := env.bot.handleCallback(env.callback(testAdminID, stepUserExtend, oid.String())); err != nil {
+			t.Fatal(err)
+		}
+
+		after, err := env.store.GetOrder(ctx, oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := after.ExpiresAt.Time.Sub(before.ExpiresAt.Time); got != domain.OrderTTL {
+			t.Errorf("order extended by %s, want %s", got, domain.OrderTTL)
+		}
+
+		if !strings.HasPrefix(env.lastText(t, testUserID), "Админ продлил заказ №1") {
+			t.Error("extension not sent to user")
+		}
+	})
+
+	t.Run("grant", func(t *testing.T) {
+		if err := env.bot.handleCallback(env.callback(testAdminID, stepUserGrant, "100:2")); err != nil {
+			t.Fatal(err)
+		}
+
+		granted, err := env.store.GetOrder(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if granted.UID != testUserID || granted.Price != 0 || granted.KeyAmount != 2 ||
+			domain.OrderStatus(granted.Status.String) != domain.OrderStatusApproved {
+			t.Errorf("unexpected granted order: %+v", granted)
+		}
+
+		if len(env.outline.Keys()) != 3 {
+			t.Errorf("outline keys = %d, want 3", len(env.outline.Keys()))
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		if err := env.bot.handleCallback(env.callback(testAdminID, stepUserRevoke, oid.String())); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := env.orderStatus(t, oid); got != domain.OrderStatusRevoked {
+			t.Errorf("status = %q, want %q", got, domain.OrderStatusRevoked)
+		}
+
+		if len(env.outline.Keys()) != 2 {
+			t.Errorf("outline keys = %d, want 2", len(env.outline.Keys()))
+		}
+
+		if got := env.lastText(t, testUserID); got != "Ключи по заказу №1 отозваны админом" {
+			t.Errorf("revoke msg = %q", got)
+		}
+	})
+
+	t.Run("revoke closed", func(t *testing.T) {
+		sent := env.sent("sendMessage", testUserID)
+
+		if err := env.bot.handleCallback(env.callback(testAdminID, stepUserRevoke, oid.String())); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := env.orderStatus(t, oid); got != domain.OrderStatusRevoked {
+			t.Errorf("status = %q, want %q", got, domain.OrderStatusRevoked)
+		}
+
+		if env.sent("sendMessage", testUserID) != sent {
+			t.Error("revoke of closed order sent to user")
+		}
+	})
+
+	t.Run("message", func(t *testing.T) {
+		if err := env.bot.handleCallback(env.callback(testAdminID, stepUserMessage, "100")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := env.bot.handleText(env.text(testAdminID, "привет")); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := env.lastText(t, testUserID); got != "Сообщение от админа:\n\nпривет" {
+			t.Errorf("admin msg = %q", got)
+		}
+	})
+
+	t.Run("ban", func(t *testing.T) {
+		if err := env.bot.handleCallback(env.callback(testAdminID, stepUserBan, "100")); err != nil {
+			t.Fatal(err)
+		}
+
+		handler := banMiddleware(env.store, testAdminID)(func(c tele.Context) error {
+			t.Error("update of banned user handled")
+			return nil
+		})
+
+		if err := handler(env.text(testUserID, "/order")); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := env.lastText(t, testUserID); got != "Доступ к боту заблокирован" {
+			t.Errorf("banned reply = %q", got)
+		}
+
+		if err := env.bot.handleCallback(env.callback(testAdminID, stepUserUnban, "100")); err != nil {
+			t.Fatal(err)
+		}
+
+		if banned, _ := env.store.IsBanned(ctx, testUserID); banned {
+			t.Error("user not unbanned")
+		}
+	})
+
+	t.Run("not admin", func(t *testing.T) {
+		if err := env.bot.handleCallback(env.callback(testUserID, stepUserBan, "100")); err == nil {
+			t.Error("user action allowed for not admin")
+		}
+	})
+}
+
+func TestGrantKeysNotProvisioned(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.outline.Inject(outlinetest.Fault{Route: "POST /access-keys", Status: http.StatusInternalServerError, Times: 1})
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepUserGrant, "100:1")); err == nil {
+		t.Fatal("grant error = nil, want key not created")
+	}
+
+	if got := env.orderStatus(t, 1); got != domain.OrderStatusCanceled {
+		t.Errorf("failed grant status = %s, want %s", got, domain.OrderStatusCanceled)
+	}
+}
+
+func TestOrdersPages(t *testing.T) {
+	env := newTestEnv(t)
+
+	for range ordersPageSize + 2 {
+		env.order(t, 1)
+	}
+
+	if err := env.bot.handleOrders(env.command(testAdminID, "/orders awaiting payment")); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := env.tg.Calls("sendMessage", testAdminID)
+	first := calls[len(calls)-1]
+
+	if !strings.HasPrefix(first.Text, "Заказы (awaiting payment), всего 12, страница 1 из 2\n\n№12 от ") {
+		t.Errorf("unexpected first page:\n%s", first.Text)
+	}
+
+	if _, ok := first.Button("« Назад"); ok {
+		t.Error("first page has back button")
+	}
+
+	if _, ok := first.Button("Вперед »"); !ok {
+		t.Fatal("first page has no next button")
+	}
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepOrdersPage, "awaiting payment:1")); err != nil {
+		t.Fatal(err)
+	}
+
+	edits := env.tg.Calls("editMessageText", testAdminID)
+	second := edits[len(edits)-1].Text
+
+	if !strings.HasPrefix(second, "Заказы (awaiting payment), всего 12, страница 2 из 2\n\n№2 от ") || strings.Count(second, "\n№") != 2 {
+		t.Errorf("unexpected second page:\n%s", second)
+	}
+
+	if err := env.bot.handleOrders(env.command(testAdminID, "/orders unknown")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testAdminID); !strings.HasPrefix(got, "Неизвестный статус") {
+		t.Errorf("unknown status reply = %q", got)
+	}
+}
@@ -0,0 +1,274 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+// topUpAmounts are amounts user may top up balance with, prices of one, three and six months of a key.
+var topUpAmounts = []int{domain.PricePerKey, 3 * domain.PricePerKey, 6 * domain.PricePerKey}
+
+const walletHistoryLimit = 5
+
+var ledgerKindNames = map[domain.LedgerKind]string{
+	domain.LedgerReferral: "бонус за друга",
+	domain.LedgerRenewal:  "продление",
+	domain.LedgerTopUp:    "пополнение",
+	domain.LedgerRefund:   "возврат",
+}
+
+// walletMsg returns balance of user with latest operations and referrals,
+// keyboard tops up balance and toggles auto renewal.
+func (b *Bot) walletMsg(ctx context.Context, uid int64) (string, *tele.ReplyMarkup, error) {
+	balance, err := b.storage.GetBalance(ctx, uid)
+	if err != nil {
+		return "", nil, fmt.Errorf("balance not received: %w", err)
+	}
+
+	autoRenew, err := b.storage.IsAutoRenew(ctx, uid)
+	if err != nil {
+		return "", nil, fmt.Errorf("auto renew not received: %w", err)
+	}
+
+	entries, err := b.storage.ListLedgerEntries(ctx, uid, walletHistoryLimit)
+	if err != nil {
+		return "", nil, fmt.Errorf("ledger entries not listed: %w", err)
+	}
+
+	referrals, err := b.referralsMsg(ctx, uid)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Баланс %d руб.\n", balance)
+
+	if autoRenew {
+		sb.WriteString("Автопродление включено: заказ продлевается с баланса перед окончанием, если на нем хватает денег")
+	} else {
+		sb.WriteString("Автопродление выключено")
+	}
+
+	if len(entries) > 0 {
+		sb.WriteString("\n\nПоследние операции:")
+	}
+
+	for _, e := range entries {
+		fmt.Fprintf(sb, "\n%s %+d руб., %s", e.CreatedAt.Format("02.01.2006"), e.Amount, ledgerKindNames[e.Kind])
+
+		// order of referral is order of friend
+		if e.OrderID != 0 && e.Kind != domain.LedgerReferral {
+			fmt.Fprintf(sb, " №%d", e.OrderID)
+		}
+	}
+
+	if referrals != "" {
+		sb.WriteString("\n\n" + referrals)
+	}
+
+	kb := &tele.ReplyMarkup{}
+	topUps := make([]tele.Btn, len(topUpAmounts))
+
+	for i, amount := range topUpAmounts {
+		topUps[i] = kb.Data(fmt.Sprintf("+%d₽", amount), stepTopUp.String(), strconv.Itoa(amount))
+	}
+
+	toggle := kb.Data("Включить автопродление", stepAutoRenew.String(), "on")
+	if autoRenew {
+		toggle = kb.Data("Выключить автопродление", stepAutoRenew.String(), "off")
+	}
+
+	kb.Inline(kb.Row(topUps...), kb.Row(toggle))
+
+	return sb.String(), kb, nil
+}
+
+// toggleAutoRenew triggers after user pressed auto renewal button in /profile.
+func (b *Bot) toggleAutoRenew(c tele.Context, ctx context.Context, cb btnCallback, usr *user) error {
+	enabled := cb.data == "on"
+
+	if err := b.storage.SetAutoRenew(ctx, usr.id, enabled, time.Now()); err != nil {
+		return fmt.Errorf("auto renew not set: %w", err)
+	}
+
+	slog.InfoContext(ctx, "auto renew set by user", "enabled", enabled)
+
+	msg, kb, err := b.walletMsg(ctx, usr.id)
+	if err != nil {
+		return err
+	}
+
+	return c.Edit(msg, kb)
+}
+
+// topUp triggers after user selected amount to top up balance with.
+// Sends payment details to user and request to admins which have to approve or reject it.
+func (b *Bot) topUp(c tele.Context, ctx context.Context, cb btnCallback, usr *user) error {
+	amount, err := strconv.Atoi(cb.data)
+	if err != nil {
+		return fmt.Errorf("amount not found in callback data: %w", err)
+	}
+
+	if !slices.Contains(topUpAmounts, amount) {
+		return fmt.Errorf("unsupported top up amount: %d", amount)
+	}
+
+	id, err := b.storage.CreateTopUp(ctx, storage.TopUp{
+		UID:       usr.id,
+		Username:  usr.username,
+		FirstName: usr.firstName,
+		LastName:  usr.lastName,
+		Amount:    amount,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("top up not created: %w", err)
+	}
+
+	slog.InfoContext(ctx, "top up created by user", "topup_id", id, "amount", amount)
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Пополнение баланса №%d\n\nК оплате: %d₽\n\n", id, amount)
+	usr.write(sb)
+
+	rawID := strconv.FormatInt(id, 10)
+
+	kb := &tele.ReplyMarkup{}
+	kb.Inline(kb.Row(
+		kb.Data("Одобрить", stepApproveTopUp.String(), rawID),
+		kb.Data("Отклонить", stepRejectTopUp.String(), rawID),
+	))
+
+	if _, err := b.notifyAdmins(ctx, eventTopUp, 0, sb.String(), kb); err != nil {
+		return fmt.Errorf("top up not sent to admin: %w", err)
+	}
+
+	qr := &tele.Photo{
+		Caption: fmt.Sprintf("Пополнение баланса №%d, к оплате %d₽, оплата по QR коду или кнопке ниже. После оплаты админ подтвердит пополнение и деньги поступят на баланс", id, amount),
+		File:    tele.FromDisk(paymentQR),
+	}
+
+	return c.Send(qr, paymentKeyboard())
+}
+
+// processTopUp triggers after admin approved or rejected top up, approved amount is credited to balance of user.
+func (b *Bot) processTopUp(c tele.Context, ctx context.Context, cb btnCallback) error {
+	id, err := strconv.ParseInt(cb.data, 10, 64)
+	if err != nil {
+		return fmt.Errorf("top up id not found in callback data: %w", err)
+	}
+
+	approve := step(cb.unique) == stepApproveTopUp
+
+	var t storage.TopUp
+	if approve {
+		t, err = b.storage.ApproveTopUp(ctx, id, time.Now())
+	} else {
+		t, err = b.storage.RejectTopUp(ctx, id, time.Now())
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Edit(c.Message().Text + "\n\nПополнение уже обработано")
+	}
+	if err != nil {
+		return fmt.Errorf("top up not processed: %w", err)
+	}
+
+	ctx = withUser(ctx, t.UID, t.Username)
+	slog.InfoContext(ctx, "top up processed by admin", "topup_id", id, "status", t.Status)
+
+	if !approve {
+		if _, err := b.tele.Send(recipient(t.UID), fmt.Sprintf("Пополнение баланса №%d на сумму %d руб. отклонено", id, t.Amount)); err != nil {
+			slog.WarnContext(ctx, "top up rejected msg not sent to user", "cause", err.Error())
+		}
+
+		return c.Edit(c.Message().Text + "\n\nОтклонено")
+	}
+
+	b.metrics.revenue.Add(ctx, int64(t.Amount), metric.WithAttributes(attrBackend(b.backend)))
+
+	balance, err := b.storage.GetBalance(ctx, t.UID)
+	if err != nil {
+		return fmt.Errorf("balance not received: %w", err)
+	}
+
+	msg := fmt.Sprintf("Баланс пополнен на %d руб., на балансе %d руб.", t.Amount, balance)
+
+	if _, err := b.tele.Send(recipient(t.UID), msg); err != nil {
+		return fmt.Errorf("top up approved msg not sent to user: %w", err)
+	}
+
+	return c.Edit(c.Message().Text + "\n\nОдобрено")
+}
+
+// autoRenewOrder renews expiring order of user who enabled auto renewal and pays it with balance,
+// returns false if order can't be renewed automatically and user has to be reminded to pay.
+func (b *Bot) autoRenewOrder(ctx context.Context, o order, balance int) (bool, error) {
+	if balance < o.price {
+		return false, nil
+	}
+
+	autoRenew, err := b.storage.IsAutoRenew(ctx, o.user.id)
+	if err != nil {
+		return false, fmt.Errorf("auto renew not received: %w", err)
+	}
+
+	if !autoRenew {
+		return false, nil
+	}
+
+	ctx = withOrderID(ctx, o.id)
+
+	// balance is checked again when charged, it may be spent since expiring keys were listed
+	credit, err := b.storage.RenewOrderWithCredit(ctx, o.id, o.expiresAt, domain.OrderTTL, true, time.Now())
+	if errors.Is(err, storage.ErrNotFound) {
+		// renewed or closed since expiring keys were listed
+		return true, nil
+	}
+	if errors.Is(err, storage.ErrInsufficientBalance) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("order not renewed: %w", err)
+	}
+
+	order, err := b.storage.GetOrder(ctx, o.id)
+	if err != nil {
+		return false, fmt.Errorf("order not found: %w", err)
+	}
+
+	balance, err = b.storage.GetBalance(ctx, order.UID)
+	if err != nil {
+		return false, fmt.Errorf("balance not received: %w", err)
+	}
+
+	slog.InfoContext(ctx, "order renewed automatically", "credit", credit)
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Заказ №%d автоматически продлен до %s\n\nКлючей %d шт.\nСписано с баланса %d руб., осталось %d руб.",
+		order.ID, order.ExpiresAt.Time.Format("02.01.2006"), order.KeyAmount, credit, balance)
+
+	if _, err := b.tele.Send(recipient(order.UID), sb.String()); err != nil {
+		slog.WarnContext(ctx, "auto renew msg not sent to user", "cause", err.Error())
+	}
+
+	sb.WriteString("\n\n")
+	o.user.write(sb)
+
+	if _, err := b.notifyAdmins(ctx, eventRenewal, order.ID, sb.String()); err != nil {
+		return false, fmt.Errorf("auto renew not sent to admin: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+func (e *testEnv) credit(t *testing.T, uid int64, amount int) {
+	t.Helper()
+
+	err := e.store.AddLedgerEntry(context.Background(), storage.LedgerEntry{
+		UID:       uid,
+		Amount:    amount,
+		Kind:      domain.LedgerTopUp,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTopUp(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := env.bot.handleCallback(env.callback(testUserID, stepTopUp, "450")); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := env.tg.Calls("sendPhoto", testUserID)
+	if len(calls) != 1 || !strings.Contains(calls[0].Text, "к оплате 450₽") {
+		t.Fatalf("payment = %v, want top up payment", calls)
+	}
+
+	if got := env.lastText(t, testAdminID); !strings.HasPrefix(got, "Пополнение баланса №1\n\nК оплате: 450₽") {
+		t.Errorf("admin msg = %q, want top up request", got)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepApproveTopUp, "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := env.lastText(t, testUserID), "Баланс пополнен на 450 руб., на балансе 450 руб."; got != want {
+		t.Errorf("approved = %q, want %q", got, want)
+	}
+
+	// approved twice by different admins
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepApproveTopUp, "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.balance(t, testUserID); got != 450 {
+		t.Errorf("balance = %d, want 450", got)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testUserID, stepTopUp, "150")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testAdminID, stepRejectTopUp, "2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := env.lastText(t, testUserID), "Пополнение баланса №2 на сумму 150 руб. отклонено"; got != want {
+		t.Errorf("rejected = %q, want %q", got, want)
+	}
+
+	if got := env.balance(t, testUserID); got != 450 {
+		t.Errorf("balance after reject = %d, want 450", got)
+	}
+
+	if err := env.bot.handleCallback(env.callback(testUserID, stepTopUp, "1")); err == nil {
+		t.Error("top up with unsupported amount created")
+	}
+
+	if err := env.bot.handleProfile(env.command(testUserID, "/profile")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasPrefix(got, "Баланс 450 руб.\nАвтопродление выключено\n\nПоследние операции:\n") || !strings.Contains(got, "+450 руб., пополнение") {
+		t.Errorf("profile = %q, want balance with operations", got)
+	}
+}
+
+func TestAutoRenew(t *testing.T) {
+	env := newTestEnv(t)
+	oid := env.approvedOrder(t, 1)
+	env.credit(t, testUserID, 400)
+
+	if err := env.bot.handleCallback(env.callback(testUserID, stepAutoRenew, "on")); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := env.store.GetOrder(context.Background(), oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payments := env.sent("sendPhoto", testUserID)
+	env.store.Now = func() time.Time { return time.Now().Add(domain.OrderTTL - 24*time.Hour) }
+
+	if err := env.bot.notifyExpiringOrders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := env.sent("sendPhoto", testUserID) - payments; n != 0 {
+		t.Errorf("renewal payments sent = %d, want 0", n)
+	}
+
+	if got := env.lastText(t, testUserID); !strings.HasPrefix(got, "Заказ №1 автоматически продлен") || !strings.HasSuffix(got, "Списано с баланса 150 руб., осталось 250 руб.") {
+		t.Errorf("renewed = %q, want auto renewal", got)
+	}
+
+	after, err := env.store.GetOrder(context.Background(), oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := after.ExpiresAt.Time.Sub(before.ExpiresAt.Time); got != domain.OrderTTL {
+		t.Errorf("order prolonged for %s, want %s", got, domain.OrderTTL)
+	}
+
+	// not enough for the next renewal
+	env.credit(t, testUserID, -200)
+	env.store.Now = func() time.Time { return time.Now().Add(2*domain.OrderTTL - 24*time.Hour) }
+
+	if err := env.bot.notifyExpiringOrders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := env.tg.Calls("sendPhoto", testUserID)
+	if got := calls[len(calls)-1].Text; len(calls) != payments+1 || !strings.Contains(got, "К оплате 100 руб. (еще 50 руб. будет списано с баланса)") {
+		t.Errorf("reminder = %q, want payment reminder with credit", got)
+	}
+}
+
+func TestAutoRenewSpentBalance(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	oid := env.approvedOrder(t, 1)
+	env.credit(t, testUserID, 150)
+
+	if err := env.bot.handleCallback(env.callback(testUserID, stepAutoRenew, "on")); err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := env.store.GetOrder(ctx, oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// balance is spent after expiring orders were listed
+	env.credit(t, testUserID, -100)
+
+	renewed, err := env.bot.autoRenewOrder(ctx, order{id: oid, user: user{id: testUserID}, price: o.Price, expiresAt: o.ExpiresAt.Time}, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renewed {
+		t.Error("order renewed with spent balance")
+	}
+
+	after, err := env.store.GetOrder(ctx, oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !after.ExpiresAt.Time.Equal(o.ExpiresAt.Time) {
+		t.Errorf("expires at = %s, want %s", after.ExpiresAt.Time, o.ExpiresAt.Time)
+	}
+
+	if got := env.balance(t, testUserID); got != 50 {
+		t.Errorf("balance = %d, want 50", got)
+	}
+}
@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/config"
+)
+
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+var secretTokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhook is telebot poller which receives updates from http server shared with other endpoints.
+// Updates are accepted only while bot is running, otherwise telegram gets 503 and redelivers them later,
+// so new instance may be started behind reverse proxy before old one is stopped.
+type webhook struct {
+	hook *tele.Webhook
+	path string
+
+	mu   sync.RWMutex
+	dest chan<- tele.Update // nil if bot is not running
+	stop <-chan struct{}    // closed when bot is stopping
+}
+
+func newWebhook(conf config.Webhook) (*webhook, error) {
+	u, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("webhook url not parsed: %w", err)
+	}
+
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("webhook url must be absolute https url, got %s", conf.URL)
+	}
+
+	if !secretTokenRe.MatchString(conf.Secret) {
+		return nil, errors.New("webhook secret must be 1-256 characters long and contain only A-Z, a-z, 0-9, _ and -")
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	return &webhook{
+		hook: &tele.Webhook{
+			SecretToken: conf.Secret,
+			Endpoint: &tele.WebhookEndpoint{
+				PublicURL: conf.URL,
+				Cert:      conf.Cert,
+			},
+		},
+		path: path,
+	}, nil
+}
+
+// Poll accepts updates until stop is closed, webhook itself is set when bot is created.
+func (w *webhook) Poll(_ *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	w.mu.Lock()
+	w.dest, w.stop = dest, stop
+	w.mu.Unlock()
+
+	<-stop
+
+	w.mu.Lock()
+	w.dest, w.stop = nil, nil
+	w.mu.Unlock()
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	secret := r.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(w.hook.SecretToken)) != 1 {
+		http.Error(rw, "invalid secret token", http.StatusForbidden)
+		return
+	}
+
+	var upd tele.Update
+
+	if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+		http.Error(rw, "update not decoded", http.StatusBadRequest)
+		return
+	}
+
+	// lock is not held while update is sent, so stopping poller isn't blocked by busy bot
+	w.mu.RLock()
+	dest, stop := w.dest, w.stop
+	w.mu.RUnlock()
+
+	if dest == nil {
+		http.Error(rw, "bot is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	select {
+	case dest <- upd:
+	case <-stop:
+		http.Error(rw, "bot is not running", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
+
+// RegisterWebhook registers webhook handler on mux, it does nothing if bot uses long polling.
+func (b *Bot) RegisterWebhook(mux *http.ServeMux) {
+	if b.webhook == nil {
+		return
+	}
+
+	mux.Handle("POST "+b.webhook.path, b.webhook)
+}
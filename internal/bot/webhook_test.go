@@ -0,0 +1,174 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/ysomad/outline-bot/internal/config"
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/provisioner"
+	"github.com/ysomad/outline-bot/internal/storage/memstore"
+	"github.com/ysomad/outline-bot/internal/telegramtest"
+)
+
+const testWebhookSecret = "test_secret"
+
+func postUpdate(t *testing.T, url, secret string, upd tele.Update) int {
+	t.Helper()
+
+	body, err := json.Marshal(upd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set(secretTokenHeader, secret)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func newWebhookBot(t *testing.T, tg *telegramtest.Server) (*Bot, error) {
+	t.Helper()
+
+	return New(config.TG{
+		APIURL:      tg.URL,
+		Token:       "test",
+		HTTPTimeout: 5 * time.Second,
+		Admin:       testAdminID,
+		Webhook: config.Webhook{
+			URL:    "https://bot.example.org/tg/updates",
+			Secret: testWebhookSecret,
+		},
+	},
+		expirable.NewLRU[string, State](100, nil, time.Hour),
+		provisioner.NewBackends(newOutlineBackend(t, "http://127.0.0.1:0")),
+		domain.BackendOutline,
+		memstore.New(),
+	)
+}
+
+func TestWebhook(t *testing.T) {
+	tg := telegramtest.NewServer()
+	t.Cleanup(tg.Close)
+
+	b, err := newWebhookBot(t, tg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := tg.Calls("setWebhook", 0)
+	if len(calls) != 1 {
+		t.Fatalf("setWebhook calls = %d, want webhook set on creation", len(calls))
+	}
+
+	if got := calls[0].Params["url"]; got != "https://bot.example.org/tg/updates" {
+		t.Errorf("webhook url = %q", got)
+	}
+
+	if got := calls[0].Params["secret_token"]; got != testWebhookSecret {
+		t.Errorf("webhook secret = %q", got)
+	}
+
+	mux := http.NewServeMux()
+	b.RegisterWebhook(mux)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	url := srv.URL + "/tg/updates"
+	start := tele.Update{ID: 1, Message: &tele.Message{
+		ID:     1,
+		Text:   "/start",
+		Sender: testUser,
+		Chat:   &tele.Chat{ID: testUserID, Type: tele.ChatPrivate},
+	}}
+
+	if code := postUpdate(t, url, testWebhookSecret, start); code != http.StatusServiceUnavailable {
+		t.Errorf("update before start: status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	go b.Start()
+
+	if code := postUpdate(t, url, "wrong", start); code != http.StatusForbidden {
+		t.Errorf("update with wrong secret: status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	// poller may be not ready right after start
+	deadline := time.Now().Add(waitTimeout)
+	for postUpdate(t, url, testWebhookSecret, start) != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("webhook not ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := tg.Wait("sendMessage", testUserID, 1, waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Stop()
+
+	if code := postUpdate(t, url, testWebhookSecret, start); code != http.StatusServiceUnavailable {
+		t.Errorf("update after stop: status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestNewWebhookInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		conf config.Webhook
+	}{
+		{name: "http url", conf: config.Webhook{URL: "http://bot.example.org/tg", Secret: testWebhookSecret}},
+		{name: "relative url", conf: config.Webhook{URL: "/tg", Secret: testWebhookSecret}},
+		{name: "no secret", conf: config.Webhook{URL: "https://bot.example.org/tg"}},
+		{name: "invalid secret", conf: config.Webhook{URL: "https://bot.example.org/tg", Secret: "no spaces"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newWebhook(tt.conf); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestWebhookNotSet(t *testing.T) {
+	tg := telegramtest.NewServer()
+	t.Cleanup(tg.Close)
+
+	tg.Fail("setWebhook")
+
+	if _, err := newWebhookBot(t, tg); err == nil {
+		t.Error("bot created without webhook")
+	}
+}
+
+func TestLongPollingDeletesWebhook(t *testing.T) {
+	env := newTestEnv(t)
+
+	if n := env.sent("deleteWebhook", 0); n != 1 {
+		t.Errorf("deleteWebhook calls = %d, want 1", n)
+	}
+
+	if n := env.sent("setWebhook", 0); n != 0 {
+		t.Errorf("setWebhook calls = %d, want 0", n)
+	}
+}
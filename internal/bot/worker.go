@@ -4,16 +4,22 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v3"
+
 	"github.com/ysomad/outline-bot/internal/domain"
-	"github.com/ysomad/outline-bot/internal/outline"
 	"github.com/ysomad/outline-bot/internal/storage"
-	tele "gopkg.in/telebot.v3"
 )
 
-func startWorker(ctx context.Context, interval time.Duration, f func() error, name string) {
+// maxWorkerTimeout limits worker tick with long interval.
+const maxWorkerTimeout = 10 * time.Minute
+
+func (b *Bot) startWorker(ctx context.Context, interval time.Duration, f func(context.Context) error, name string) {
 	slog.Info("starting worker", "worker", name)
 
 	ticker := time.NewTicker(interval)
@@ -25,19 +31,50 @@ func startWorker(ctx context.Context, interval time.Duration, f func() error, na
 			slog.Info("worker stopped", "worker", name)
 			return
 		case <-ticker.C:
-			if err := f(); err != nil {
-				slog.Error("worker failed", "cause", err.Error(), "worker", name)
-			}
+			b.runWorker(ctx, min(interval, maxWorkerTimeout), f, name)
 		}
 	}
 }
 
+// runWorker runs single worker tick in its own span, tick must finish in timeout.
+func (b *Bot) runWorker(ctx context.Context, timeout time.Duration, f func(context.Context) error, name string) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ctx, span := b.tracer.Start(ctx, "worker "+name, trace.WithAttributes(attrWorker(name)))
+
+	err := f(ctx)
+	if err != nil {
+		id := b.reportError(ctx, "worker "+name, err)
+		slog.ErrorContext(ctx, "worker failed", "cause", err.Error(), "worker", name, "correlation_id", id)
+		b.metrics.workerFailures.Add(ctx, 1, metric.WithAttributes(attrWorker(name)))
+	} else {
+		b.metrics.workerSucceeded(name, time.Now())
+	}
+
+	endSpan(span, err)
+}
+
 func (b *Bot) NotifyExpiringOrders(ctx context.Context, interval time.Duration) {
-	startWorker(ctx, interval, b.notifyExpiringOrders, "expiring_orders_notifier")
+	b.startWorker(ctx, interval, b.notifyExpiringOrders, "expiring_orders_notifier")
 }
 
 func (b *Bot) DeactivateExpiredKeys(ctx context.Context, interval time.Duration) {
-	startWorker(ctx, interval, b.deactivateExpiredKeys, "expired_keys_deactivator")
+	b.startWorker(ctx, interval, b.deactivateExpiredKeys, "expired_keys_deactivator")
+}
+
+// RemindPendingOrders reminds admin about orders awaiting payment longer than after.
+func (b *Bot) RemindPendingOrders(ctx context.Context, interval, after time.Duration) {
+	b.startWorker(ctx, interval, func(ctx context.Context) error {
+		return b.remindPendingOrders(ctx, after)
+	}, "pending_orders_reminder")
+}
+
+// CancelUnpaidOrders cancels orders awaiting payment longer than ttl.
+func (b *Bot) CancelUnpaidOrders(ctx context.Context, interval, ttl time.Duration) {
+	b.startWorker(ctx, interval, func(ctx context.Context) error {
+		return b.cancelUnpaidOrders(ctx, ttl)
+	}, "unpaid_orders_canceler")
 }
 
 func groupExpiringKeys(keys []storage.ExpiringKey) map[order][]storage.ExpiringKey {
@@ -55,6 +92,8 @@ func groupExpiringKeys(keys []storage.ExpiringKey) map[order][]storage.ExpiringK
 			keyAmount: k.KeyAmount,
 			price:     k.Price,
 			expiresAt: k.ExpiresAt,
+			backend:   k.Backend,
+			trial:     k.Trial,
 		}
 
 		res[o] = append(res[o], k)
@@ -69,10 +108,15 @@ type order struct {
 	price     int
 	keyAmount int
 	expiresAt time.Time
+	backend   domain.Backend
+	// credit is part of price which will be paid with balance of user.
+	credit int
+	// trial is true for free trial which can't be renewed.
+	trial bool
 }
 
-func (b *Bot) notifyExpiringOrders() error {
-	keys, err := b.storage.ListExpiringKeys(domain.BeforeOrderExpiration)
+func (b *Bot) notifyExpiringOrders(ctx context.Context) error {
+	keys, err := b.storage.ListExpiringKeys(ctx, domain.BeforeOrderExpiration)
 	if err != nil {
 		return fmt.Errorf("expiring keys not listed: %w", err)
 	}
@@ -88,9 +132,32 @@ func (b *Bot) notifyExpiringOrders() error {
 	sb := &strings.Builder{}
 
 	for order, keys := range groupedKeys {
-		_, err := fmt.Fprintf(sb,
-			"Ключи по заказу №%d будут деактивированы %s\n\nК оплате %d руб.\nКлючей %d шт.\n\nКлючи к деактивации: ",
-			order.id, order.expiresAt.Format("02.01.2006"), order.price, order.keyAmount)
+		if order.trial {
+			if err := b.remindTrial(ctx, order, keys[0].ExpiresIn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		balance, err := b.storage.GetBalance(ctx, order.user.id)
+		if err != nil {
+			return fmt.Errorf("balance not received: %w", err)
+		}
+
+		renewed, err := b.autoRenewOrder(ctx, order, balance)
+		if err != nil {
+			return err
+		}
+
+		if renewed {
+			continue
+		}
+
+		order.credit = max(min(balance, order.price), 0)
+
+		_, err = fmt.Fprintf(sb,
+			"Ключи по заказу №%d будут деактивированы %s\n\nК оплате %d руб.%s\nКлючей %d шт.\n\nКлючи к деактивации: ",
+			order.id, order.expiresAt.Format("02.01.2006"), order.price-order.credit, creditNote(order.credit), order.keyAmount)
 		if err != nil {
 			return fmt.Errorf("msg title not written: %w", err)
 		}
@@ -119,17 +186,8 @@ func (b *Bot) notifyExpiringOrders() error {
 
 		sb.Reset()
 
-		fmt.Fprintf(sb, "Заказ №%d истекает %s\n\nК оплате %d руб.\nКлючей %d шт.\n\n", order.id, order.expiresAt.Format("02.01.2006"), order.price, order.keyAmount)
-		order.user.write(sb)
-
-		kb := &tele.ReplyMarkup{}
-		kb.Inline(
-			kb.Row(kb.Data("Продлить на 1 месяц", stepOrderRenewApproved.String(), order.id.String())),
-			kb.Row(kb.Data("Отклонить продление", stepRejectOrderRenewal.String(), order.id.String())),
-		)
-
 		// send to admin
-		if _, err = b.tele.Send(recipient(b.adminID), sb.String(), kb); err != nil {
+		if err = b.notifyAdminsAboutOrder(ctx, eventRenewal, order.id, renewalMsg(order), renewalAdminKeyboard(order.id, order.expiresAt)); err != nil {
 			return fmt.Errorf("renewal msg not sent to admin: %w", err)
 		}
 
@@ -139,8 +197,59 @@ func (b *Bot) notifyExpiringOrders() error {
 	return nil
 }
 
-func (b *Bot) deactivateExpiredKeys() error {
-	keys, err := b.storage.ListExpiringKeys(0)
+// creditNote returns note about part of price paid with balance.
+func creditNote(credit int) string {
+	if credit == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (еще %d руб. будет списано с баланса)", credit)
+}
+
+func renewalMsg(o order) string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Заказ №%d истекает %s\n\nК оплате %d руб.%s\nКлючей %d шт.\n\n", o.id, o.expiresAt.Format("02.01.2006"), o.price-o.credit, creditNote(o.credit), o.keyAmount)
+	o.user.write(sb)
+	return sb.String()
+}
+
+// renewalAdminKeyboard returns keyboard for admin to renew or reject renewal of expiring order.
+// orderNotRenewedMsg is sent when renewal is approved for order which is closed or renewed meanwhile.
+const orderNotRenewedMsg = "Заказ №%d уже продлен или закрыт"
+
+// renewalAdminKeyboard returns buttons of renewal of order expiring at expiresAt.
+// Renewal is bound to the expiration, so the order is renewed once however many times it's pressed.
+func renewalAdminKeyboard(oid domain.OrderID, expiresAt time.Time) *tele.ReplyMarkup {
+	kb := &tele.ReplyMarkup{}
+	kb.Inline(
+		kb.Row(kb.Data("Продлить на 1 месяц", stepOrderRenewApproved.String(), fmt.Sprintf("%s:%d", oid, expiresAt.Unix()))),
+		kb.Row(kb.Data("Отклонить продление", stepRejectOrderRenewal.String(), oid.String())),
+	)
+	return kb
+}
+
+// parseRenewalData returns order id and expiration from data of renewal button, expiration is zero in old buttons.
+func parseRenewalData(data string) (domain.OrderID, time.Time, error) {
+	rawID, rawExp, found := strings.Cut(data, ":")
+
+	oid, err := domain.OrderIDFromString(rawID)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("order id not found in callback data: %w", err)
+	}
+
+	if !found {
+		return oid, time.Time{}, nil
+	}
+
+	unix, err := strconv.ParseInt(rawExp, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("expiration not found in callback data: %w", err)
+	}
+
+	return oid, time.Unix(unix, 0), nil
+}
+
+func (b *Bot) deactivateExpiredKeys(ctx context.Context) error {
+	keys, err := b.storage.ListExpiringKeys(ctx, 0)
 	if err != nil {
 		return fmt.Errorf("expired keys not listed: %w", err)
 	}
@@ -157,20 +266,28 @@ func (b *Bot) deactivateExpiredKeys() error {
 	for order, keys := range groupedKeys {
 		oid := order.id
 
+		backend, err := b.backends.Get(order.backend)
+		if err != nil {
+			return fmt.Errorf("order %d: %w", oid, err)
+		}
+
 		// query db in a for loop ;-)
-		err := b.storage.CloseOrder(order.id, domain.OrderStatusExpired, time.Now())
+		err = b.storage.CloseOrder(ctx, order.id, domain.OrderStatusExpired, time.Now())
 		if err != nil {
 			return fmt.Errorf("order %d not closed on expiration: %w", oid, err)
 		}
 
 		slog.Info("order expired", "oid", oid)
 
-		fmt.Fprintf(sb, "Заказ №%d истек, деактивированы ключи %d шт. на сумму %d руб.\n\n", oid, order.keyAmount, order.price)
+		if order.trial {
+			fmt.Fprintf(sb, "Пробный период по заказу №%d закончился, деактивированы ключи %d шт.\n\n", oid, order.keyAmount)
+		} else {
+			fmt.Fprintf(sb, "Заказ №%d истек, деактивированы ключи %d шт. на сумму %d руб.\n\n", oid, order.keyAmount, order.price)
+		}
 
 		for i, k := range keys {
-			_, err := b.outline.AccessKeysIDDelete(context.Background(), outline.AccessKeysIDDeleteParams{ID: k.ID})
-			if err != nil {
-				return fmt.Errorf("key with id %s not deleted from outline: %w", k.ID, err)
+			if err := backend.DeleteKey(ctx, k.ID); err != nil {
+				return fmt.Errorf("key with id %s not deleted from %s: %w", k.ID, order.backend, err)
 			}
 
 			fmt.Fprintf(sb, "%s %s", k.ID, k.Name)
@@ -180,14 +297,19 @@ func (b *Bot) deactivateExpiredKeys() error {
 			}
 		}
 
-		if _, err := b.tele.Send(&order.user, sb.String()); err != nil {
+		msg := sb.String()
+		if order.trial {
+			msg += "\n\nЧтобы продолжить пользоваться ВПНом, размести заказ /order"
+		}
+
+		if _, err := b.tele.Send(&order.user, msg); err != nil {
 			return fmt.Errorf("expired order msg not sent to user: %w", err)
 		}
 
 		sb.WriteString("\n\n")
 		order.user.write(sb)
 
-		if _, err := b.tele.Send(recipient(b.adminID), sb.String()); err != nil {
+		if _, err := b.notifyAdmins(ctx, eventExpiration, order.id, sb.String()); err != nil {
 			return fmt.Errorf("expired order not sent to admin: %w", err)
 		}
 
@@ -3,27 +3,102 @@ package config
 import "time"
 
 type Config struct {
-	LogLevel string `env:"LOG_LEVEL" env-required:"true"`
-	Worker   Worker
-	Outline  Outline
-	TG       TG
+	LogLevel  string `env:"LOG_LEVEL" env-required:"true"`
+	Backend   string `env:"VPN_BACKEND" env-default:"outline"`
+	DB        DB
+	Worker    Worker
+	Outline   Outline
+	WireGuard WireGuard
+	HTTP      HTTP
+	Telemetry Telemetry
+	TG        TG
+}
+
+type DB struct {
+	// Driver is sqlite3 or postgres.
+	Driver         string `env:"DB_DRIVER" env-default:"sqlite3"`
+	DSN            string `env:"DB_DSN" env-default:"db.db?_foreign_keys=on"`
+	MigrateOnStart bool   `env:"DB_MIGRATE_ON_START" env-default:"true"`
 }
 
 type Worker struct {
 	NotifyExpiringInterval    time.Duration `env:"WORKER_NOTIFY_EXPIRING_INTERVAL" env-required:"true"`
-	DeactivateExpiredInterval time.Duration `env:"WORKER_DEACTIVATE_EXPIRED_INTERVAL" env-required: "true"`
+	DeactivateExpiredInterval time.Duration `env:"WORKER_DEACTIVATE_EXPIRED_INTERVAL" env-required:"true"`
+
+	// Admin is reminded about orders awaiting payment longer than RemindPendingAfter.
+	RemindPendingInterval time.Duration `env:"WORKER_REMIND_PENDING_INTERVAL" env-default:"6h"`
+	RemindPendingAfter    time.Duration `env:"WORKER_REMIND_PENDING_AFTER" env-default:"24h"`
+
+	// Orders awaiting payment longer than UnpaidOrderTTL are canceled.
+	CancelUnpaidInterval time.Duration `env:"WORKER_CANCEL_UNPAID_INTERVAL" env-default:"1h"`
+	UnpaidOrderTTL       time.Duration `env:"WORKER_UNPAID_ORDER_TTL" env-default:"72h"`
 }
 
 type Outline struct {
 	URL         string        `env:"OUTLINE_URL" env-required:"true"`
 	HTTPTimeout time.Duration `env:"OUTLINE_HTTP_TIMEOUT" env-required:"true"`
+	KeyPrefix   string        `env:"OUTLINE_KEY_PREFIX"`
+}
+
+// WireGuard backend is enabled if endpoint is set.
+type WireGuard struct {
+	Endpoint  string `env:"WG_ENDPOINT"`
+	PublicKey string `env:"WG_PUBLIC_KEY"`
+	Interface string `env:"WG_INTERFACE" env-default:"wg0"`
+	Pool      string `env:"WG_POOL" env-default:"10.8.0.0/24"`
+	DNS       string `env:"WG_DNS" env-default:"1.1.1.1"`
+}
+
+// HTTP server serves metrics, health checks and webhook, it is disabled if addr is empty.
+type HTTP struct {
+	Addr    string `env:"HTTP_ADDR" env-default:"127.0.0.1:8080"`
+	TLSCert string `env:"HTTP_TLS_CERT"`
+	TLSKey  string `env:"HTTP_TLS_KEY"`
+}
+
+type Telemetry struct {
+	// Exporter is none, stdout or otlp.
+	Exporter    string `env:"OTEL_EXPORTER" env-default:"none"`
+	ServiceName string `env:"OTEL_SERVICE_NAME" env-default:"outline-bot"`
+}
+
+// Webhook mode is enabled if url is set, otherwise updates are received with long polling.
+type Webhook struct {
+	// URL is public https url telegram sends updates to, its path is served by the bot.
+	URL    string `env:"TG_WEBHOOK_URL"`
+	Secret string `env:"TG_WEBHOOK_SECRET"`
+	// Cert is uploaded to telegram, set it only for self-signed certificates.
+	Cert string `env:"TG_WEBHOOK_CERT"`
 }
 
 type TG struct {
 	Verbose       bool          `env:"TG_VERBOSE"`
 	PollerTimeout time.Duration `env:"TG_POLLER_TIMEOUT" env-required:"true"`
 	HTTPTimeout   time.Duration `env:"TG_HTTP_TIMEOUT" env-required:"true"`
+	// UpdateTimeout is deadline of handling single update.
+	UpdateTimeout time.Duration `env:"TG_UPDATE_TIMEOUT" env-default:"30s"`
+	Webhook       Webhook
+
+	// APIURL is telegram bot api url, set it to use local bot api server.
+	APIURL string `env:"TG_API_URL" env-default:"https://api.telegram.org"`
+	Token  string `env:"TG_TOKEN" env-required:"true"`
+	Admin  int64  `env:"TG_ADMIN" env-required:"true"`
+
+	// AdminGroup is id of supergroup with topics, admin notifications are sent into its topics instead of private chats.
+	AdminGroup int64 `env:"TG_ADMIN_GROUP"`
+	// AdminTopics is events for topic per type of notification or orders for topic per order.
+	AdminTopics string `env:"TG_ADMIN_TOPICS" env-default:"events"`
+
+	// InviteOnly allows orders only to users invited by members or approved by admin.
+	InviteOnly bool `env:"TG_INVITE_ONLY"`
+	// InvitesPerUser is amount of invites member may create, admins are not limited.
+	InvitesPerUser int `env:"TG_INVITES_PER_USER" env-default:"3"`
+
+	// ReferralCredit is amount in rubles referrer earns when first order of referred user is approved, 0 disables referrals.
+	ReferralCredit int `env:"TG_REFERRAL_CREDIT" env-default:"100"`
 
-	Token string `env:"TG_TOKEN" env-required:"true"`
-	Admin int64  `env:"TG_ADMIN" env-required:"true"`
+	// TrialTTL is lifetime of free trial key of new user, 0 disables trials.
+	TrialTTL time.Duration `env:"TG_TRIAL_TTL" env-default:"72h"`
+	// TrialDataLimit is data transfer limit of trial key in gigabytes, 0 is unlimited.
+	TrialDataLimit int64 `env:"TG_TRIAL_DATA_LIMIT"`
 }
@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AdminRole is role of bot admin, each role has all rights of lower roles.
+type AdminRole string
+
+const (
+	// AdminRoleOwner manages other admins.
+	AdminRoleOwner AdminRole = "owner"
+	// AdminRoleOperator processes orders and receives notifications.
+	AdminRoleOperator AdminRole = "operator"
+	// AdminRoleViewer only views statistics, users and orders.
+	AdminRoleViewer AdminRole = "viewer"
+)
+
+var adminRoleRanks = map[AdminRole]int{
+	AdminRoleViewer:   1,
+	AdminRoleOperator: 2,
+	AdminRoleOwner:    3,
+}
+
+var ErrInvalidAdminRole = errors.New("invalid admin role")
+
+func ParseAdminRole(s string) (AdminRole, error) {
+	r := AdminRole(s)
+	if _, ok := adminRoleRanks[r]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAdminRole, s)
+	}
+	return r, nil
+}
+
+// Can reports whether role has rights of role r.
+func (role AdminRole) Can(r AdminRole) bool {
+	rank, ok := adminRoleRanks[role]
+	return ok && rank >= adminRoleRanks[r]
+}
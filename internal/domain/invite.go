@@ -0,0 +1,9 @@
+package domain
+
+// JoinRequestStatus is status of request of not invited user to access the bot.
+type JoinRequestStatus string
+
+const (
+	JoinRequestPending JoinRequestStatus = "pending"
+	JoinRequestDenied  JoinRequestStatus = "denied"
+)
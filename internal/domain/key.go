@@ -1,6 +1,70 @@
 package domain
 
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
 const (
 	PricePerKey    = 150
 	MaxKeysPerUser = 10
 )
+
+const keyPrefixParam = "prefix"
+
+// ValidateKeyPrefix checks that prefix is url encoded and can be used in access url.
+// Reserved characters such as & and # must be percent encoded, otherwise they break the url.
+func ValidateKeyPrefix(prefix string) error {
+	if _, err := url.QueryUnescape(prefix); err != nil {
+		return fmt.Errorf("prefix must be url encoded: %w", err)
+	}
+
+	for _, r := range prefix {
+		if !isUnreserved(r) && r != '%' {
+			return fmt.Errorf("prefix must be url encoded: character %q must be percent encoded", r)
+		}
+	}
+
+	return nil
+}
+
+// isUnreserved reports whether r may be used in url without encoding, see RFC 3986.
+func isUnreserved(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' ||
+		r == '-' || r == '.' || r == '_' || r == '~'
+}
+
+// KeyURLWithPrefix returns outline access url with prefix parameter which disguises first bytes of connection.
+// Prefix must be url encoded, empty prefix removes it from access url.
+func KeyURLWithPrefix(accessURL, prefix string) (string, error) {
+	u, err := url.Parse(accessURL)
+	if err != nil {
+		return "", fmt.Errorf("access url not parsed: %w", err)
+	}
+
+	var params []string
+
+	for _, p := range strings.Split(u.RawQuery, "&") {
+		if p == "" || strings.HasPrefix(p, keyPrefixParam+"=") {
+			continue
+		}
+		params = append(params, p)
+	}
+
+	if prefix != "" {
+		params = append(params, keyPrefixParam+"="+prefix)
+	}
+
+	u.RawQuery = strings.Join(params, "&")
+
+	return u.String(), nil
+}
+
+// Backend is a vpn backend on which order keys live.
+type Backend string
+
+const (
+	BackendOutline   Backend = "outline"
+	BackendWireGuard Backend = "wireguard"
+)
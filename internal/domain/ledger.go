@@ -0,0 +1,20 @@
+package domain
+
+// LedgerKind is reason of balance change of user.
+type LedgerKind string
+
+const (
+	LedgerReferral LedgerKind = "referral" // credit for referred friend who paid his first order
+	LedgerRenewal  LedgerKind = "renewal"  // balance spent on renewal of order
+	LedgerTopUp    LedgerKind = "topup"    // payment of user approved by admin
+	LedgerRefund   LedgerKind = "refund"   // money returned for order
+)
+
+// TopUpStatus is status of request to top up balance.
+type TopUpStatus string
+
+const (
+	TopUpStatusPending  TopUpStatus = "pending"
+	TopUpStatusApproved TopUpStatus = "approved"
+	TopUpStatusRejected TopUpStatus = "rejected"
+)
@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 	"time"
 )
@@ -8,6 +10,7 @@ import (
 const (
 	OrderTTL              = 24 * time.Hour * 30 // 30 days
 	BeforeOrderExpiration = time.Hour * 24 * 3  // 3 days
+	BeforeTrialExpiration = time.Hour * 24      // 1 day
 )
 
 type OrderID int32
@@ -33,4 +36,31 @@ const (
 	OrderStatusAwaitingRenewal OrderStatus = "awaiting renewal"
 	OrderStatusRenewed         OrderStatus = "renewed"
 	OrderStatusExpired         OrderStatus = "expired"
+	OrderStatusRevoked         OrderStatus = "revoked"  // keys revoked by admin
+	OrderStatusCanceled        OrderStatus = "canceled" // not paid in time or canceled by user
+	OrderStatusRefunded        OrderStatus = "refunded" // keys revoked and unused part refunded by admin
 )
+
+// OrderStatuses are all statuses of orders.
+var OrderStatuses = []OrderStatus{
+	OrderStatusAwaitingPayment,
+	OrderStatusRejected,
+	OrderStatusApproved,
+	OrderStatusAwaitingRenewal,
+	OrderStatusRenewed,
+	OrderStatusExpired,
+	OrderStatusRevoked,
+	OrderStatusCanceled,
+	OrderStatusRefunded,
+}
+
+var ErrInvalidOrderStatus = errors.New("invalid order status")
+
+func ParseOrderStatus(s string) (OrderStatus, error) {
+	for _, status := range OrderStatuses {
+		if string(status) == s {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidOrderStatus, s)
+}
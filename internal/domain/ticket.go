@@ -0,0 +1,10 @@
+package domain
+
+// TicketStatus is status of support ticket.
+type TicketStatus string
+
+const (
+	TicketStatusOpen     TicketStatus = "open"     // waiting for admin reply
+	TicketStatusAnswered TicketStatus = "answered" // admin replied, waiting for user
+	TicketStatusClosed   TicketStatus = "closed"
+)
@@ -0,0 +1,86 @@
+// Package health provides liveness and readiness http handlers.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check returns error if dependency is not ready.
+type Check func(ctx context.Context) error
+
+type response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// Live responds ok while process is up.
+func Live() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, response{Status: statusOK})
+	})
+}
+
+// Ready runs checks concurrently and responds 503 if any of them failed or not finished in timeout.
+func Ready(timeout time.Duration, checks map[string]Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		res := response{
+			Status: statusOK,
+			Checks: make(map[string]string, len(checks)),
+		}
+
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+
+		for name, check := range checks {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				status := statusOK
+
+				if err := check(ctx); err != nil {
+					slog.WarnContext(ctx, "readiness check failed", "check", name, "cause", err.Error())
+					status = err.Error()
+				}
+
+				mu.Lock()
+				res.Checks[name] = status
+				if status != statusOK {
+					res.Status = statusFail
+				}
+				mu.Unlock()
+			}()
+		}
+
+		wg.Wait()
+
+		code := http.StatusOK
+		if res.Status != statusOK {
+			code = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, code, res)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, res response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(res)
+}
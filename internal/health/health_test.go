@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("connection refused") }
+	slow := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		name       string
+		checks     map[string]Check
+		wantCode   int
+		wantChecks map[string]string
+	}{
+		{
+			name:       "all ok",
+			checks:     map[string]Check{"db": ok, "outline": ok},
+			wantCode:   http.StatusOK,
+			wantChecks: map[string]string{"db": "ok", "outline": "ok"},
+		},
+		{
+			name:       "one failed",
+			checks:     map[string]Check{"db": ok, "outline": fail},
+			wantCode:   http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"db": "ok", "outline": "connection refused"},
+		},
+		{
+			name:       "timeout",
+			checks:     map[string]Check{"telegram": slow},
+			wantCode:   http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"telegram": context.DeadlineExceeded.Error()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Ready(50*time.Millisecond, tt.checks).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			res := response{}
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.wantChecks {
+				if got := res.Checks[name]; got != want {
+					t.Errorf("check %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
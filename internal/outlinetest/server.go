@@ -0,0 +1,380 @@
+// Package outlinetest provides fake outline management api server for tests.
+// It implements endpoints from api/outline.yaml used by the bot and keeps its state in memory.
+package outlinetest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/outline"
+)
+
+const (
+	secret = "/fake-secret"
+	method = "chacha20-ietf-poly1305"
+)
+
+// Fault is injected into requests matching route instead of or before handling them.
+type Fault struct {
+	// Route is method and path pattern of the endpoint, e.g. "POST /access-keys", empty route matches all requests.
+	Route string
+	// Status is sent instead of handling request if not zero.
+	Status int
+	// Delay is applied before handling request, use it to simulate slow responses and client timeouts.
+	Delay time.Duration
+	// Times is amount of requests affected by the fault, zero means all requests.
+	Times int
+}
+
+type Server struct {
+	// URL is management api url of the server, pass it to outline.NewClient.
+	URL string
+
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	lastID    int
+	hostname  string
+	port      int
+	keys      map[string]outline.AccessKey
+	limits    map[string]int
+	transfer  map[string]int
+	faults    []*Fault
+	requests  map[string]int
+	createdAt time.Time
+}
+
+// NewServer starts new fake outline server, it must be closed after use.
+func NewServer() *Server {
+	s := &Server{
+		hostname:  "127.0.0.1",
+		port:      12345,
+		keys:      make(map[string]outline.AccessKey),
+		limits:    make(map[string]int),
+		transfer:  make(map[string]int),
+		requests:  make(map[string]int),
+		createdAt: time.Now(),
+	}
+
+	mux := http.NewServeMux()
+	s.handle(mux, "GET /server", s.getServer)
+	s.handle(mux, "PUT /server/hostname-for-access-keys", s.putHostname)
+	s.handle(mux, "POST /access-keys", s.createKey)
+	s.handle(mux, "GET /access-keys", s.listKeys)
+	s.handle(mux, "GET /access-keys/{id}", s.getKey)
+	s.handle(mux, "DELETE /access-keys/{id}", s.deleteKey)
+	s.handle(mux, "PUT /access-keys/{id}/data-limit", s.putLimit)
+	s.handle(mux, "DELETE /access-keys/{id}/data-limit", s.deleteLimit)
+	s.handle(mux, "GET /metrics/transfer", s.getTransfer)
+
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL + secret
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// handle registers handler for route with fault injection and request counting.
+func (s *Server) handle(mux *http.ServeMux, route string, h http.HandlerFunc) {
+	method, path, _ := strings.Cut(route, " ")
+
+	mux.HandleFunc(method+" "+secret+path, func(w http.ResponseWriter, r *http.Request) {
+		f := s.fault(route)
+
+		if f.Delay > 0 {
+			select {
+			case <-time.After(f.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if f.Status != 0 {
+			w.WriteHeader(f.Status)
+			return
+		}
+
+		h(w, r)
+	})
+}
+
+// fault counts request and returns fault which must be applied to it.
+func (s *Server) fault(route string) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[route]++
+
+	for i, f := range s.faults {
+		if f.Route != "" && f.Route != route {
+			continue
+		}
+
+		res := *f
+
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+
+		return res
+	}
+
+	return Fault{}
+}
+
+// Inject adds fault, faults are matched in order they were injected.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	s.faults = append(s.faults, &f)
+	s.mu.Unlock()
+}
+
+// ClearFaults removes all injected faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	s.faults = nil
+	s.mu.Unlock()
+}
+
+// Requests returns amount of requests received by route, e.g. "POST /access-keys".
+func (s *Server) Requests(route string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[route]
+}
+
+// Keys returns access keys sorted by id.
+func (s *Server) Keys() []outline.AccessKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]outline.AccessKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys
+}
+
+// Key returns access key by id.
+func (s *Server) Key(id string) (outline.AccessKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	return k, ok
+}
+
+// Limit returns data limit of access key in bytes.
+func (s *Server) Limit(id string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.limits[id]
+	return l, ok
+}
+
+func (s *Server) Hostname() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hostname
+}
+
+// SetTransfer sets transferred bytes of access key returned by metrics.
+func (s *Server) SetTransfer(id string, bytes int) {
+	s.mu.Lock()
+	s.transfer[id] = bytes
+	s.mu.Unlock()
+}
+
+// accessURL must be called under lock.
+func (s *Server) accessURL(password string) string {
+	userinfo := base64.URLEncoding.EncodeToString([]byte(method + ":" + password))
+	return fmt.Sprintf("ss://%s@%s:%d/?outline=1", userinfo, s.hostname, s.port)
+}
+
+func (s *Server) getServer(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, &outline.Server{
+		Name:                 outline.NewOptString("outlinetest"),
+		ServerId:             outline.NewOptString("outlinetest"),
+		MetricsEnabled:       outline.NewOptBool(true),
+		CreatedTimestampMs:   outline.NewOptFloat64(float64(s.createdAt.UnixMilli())),
+		PortForNewAccessKeys: outline.NewOptInt(s.port),
+	})
+}
+
+func (s *Server) putHostname(w http.ResponseWriter, r *http.Request) {
+	req := outline.ServerHostnameForAccessKeysPutReq{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Hostname.Value == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hostname = req.Hostname.Value
+
+	for id, k := range s.keys {
+		k.AccessUrl = outline.NewOptString(s.accessURL(k.Password.Value))
+		s.keys[id] = k
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) createKey(w http.ResponseWriter, r *http.Request) {
+	req := outline.AccessKeysPostReq{}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := strconv.Itoa(s.lastID)
+	s.lastID++
+
+	password := "password" + id
+
+	k := outline.AccessKey{
+		ID:        id,
+		Name:      req.Name,
+		Password:  outline.NewOptString(password),
+		Port:      outline.NewOptInt(s.port),
+		Method:    outline.NewOptString(method),
+		AccessUrl: outline.NewOptString(s.accessURL(password)),
+	}
+
+	s.keys[id] = k
+
+	if req.Limit.Set {
+		s.limits[id] = req.Limit.Value.Bytes.Value
+	}
+
+	writeJSON(w, http.StatusCreated, &k)
+}
+
+func (s *Server) listKeys(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, &outline.AccessKeysGetOK{AccessKeys: s.Keys()})
+}
+
+func (s *Server) getKey(w http.ResponseWriter, r *http.Request) {
+	k, ok := s.Key(r.PathValue("id"))
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &k)
+}
+
+func (s *Server) deleteKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		writeNotFound(w)
+		return
+	}
+
+	delete(s.keys, id)
+	delete(s.limits, id)
+	delete(s.transfer, id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) putLimit(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	limit := outline.DataLimit{}
+
+	if err := json.NewDecoder(r.Body).Decode(&limit); err != nil || limit.Bytes.Value < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.limits[id] = limit.Bytes.Value
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteLimit(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	delete(s.limits, id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getTransfer(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transfer := make(outline.MetricsTransferGetOKBytesTransferredByUserId, len(s.transfer))
+	for id, b := range s.transfer {
+		transfer[id] = b
+	}
+
+	writeJSON(w, http.StatusOK, &outline.MetricsTransferGetOK{
+		BytesTransferredByUserId: outline.NewOptMetricsTransferGetOKBytesTransferredByUserId(transfer),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v json.Marshaler) {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`{"code":"NotFoundError","message":"No access key found"}`))
+}
@@ -0,0 +1,149 @@
+package provisioner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Peer struct {
+	PublicKey string
+	IP        netip.Addr
+	// Transfer is received and sent bytes by the peer.
+	Transfer int64
+}
+
+// Device manages peers of wireguard interface.
+type Device interface {
+	AddPeer(ctx context.Context, p Peer) error
+	// RemovePeer removes peer, removing non existing peer is not an error.
+	RemovePeer(ctx context.Context, publicKey string) error
+	Peers(ctx context.Context) ([]Peer, error)
+}
+
+var _ Device = &CmdDevice{}
+
+// CmdDevice manages peers of wireguard interface on the host via wg(8).
+// Changes are not persisted in interface config, use SaveConfig = true in wg-quick config.
+type CmdDevice struct {
+	iface string
+}
+
+func NewCmdDevice(iface string) *CmdDevice {
+	return &CmdDevice{iface: iface}
+}
+
+func (d *CmdDevice) wg(ctx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "wg", args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("wg %s: %w: %s", strings.Join(args, " "), err, stderr.String())
+	}
+
+	return out, nil
+}
+
+func (d *CmdDevice) AddPeer(ctx context.Context, p Peer) error {
+	_, err := d.wg(ctx, "set", d.iface, "peer", p.PublicKey, "allowed-ips", netip.PrefixFrom(p.IP, p.IP.BitLen()).String())
+	return err
+}
+
+func (d *CmdDevice) RemovePeer(ctx context.Context, publicKey string) error {
+	_, err := d.wg(ctx, "set", d.iface, "peer", publicKey, "remove")
+	return err
+}
+
+// Peers parses output of wg show dump, first line is the interface itself,
+// other lines are peers: public-key preshared-key endpoint allowed-ips latest-handshake transfer-rx transfer-tx persistent-keepalive.
+func (d *CmdDevice) Peers(ctx context.Context) ([]Peer, error) {
+	out, err := d.wg(ctx, "show", d.iface, "dump")
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []Peer
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Scan() // skip interface
+
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) != 8 {
+			return nil, fmt.Errorf("unexpected wg dump line: %s", s.Text())
+		}
+
+		p := Peer{PublicKey: fields[0]}
+
+		if ips := strings.Split(fields[3], ","); ips[0] != "(none)" {
+			prefix, err := netip.ParsePrefix(ips[0])
+			if err != nil {
+				return nil, fmt.Errorf("allowed ips not parsed: %w", err)
+			}
+			p.IP = prefix.Addr()
+		}
+
+		rx, _ := strconv.ParseInt(fields[5], 10, 64)
+		tx, _ := strconv.ParseInt(fields[6], 10, 64)
+		p.Transfer = rx + tx
+
+		peers = append(peers, p)
+	}
+
+	return peers, s.Err()
+}
+
+var _ Device = &MemDevice{}
+
+// MemDevice is in-memory wireguard device, local stand-in for tests and development.
+type MemDevice struct {
+	mu    sync.Mutex
+	peers map[string]Peer
+}
+
+func NewMemDevice() *MemDevice {
+	return &MemDevice{peers: make(map[string]Peer)}
+}
+
+func (d *MemDevice) AddPeer(_ context.Context, p Peer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, peer := range d.peers {
+		if peer.IP == p.IP && peer.PublicKey != p.PublicKey {
+			return fmt.Errorf("ip %s already used by peer %s", p.IP, peer.PublicKey)
+		}
+	}
+
+	d.peers[p.PublicKey] = p
+
+	return nil
+}
+
+func (d *MemDevice) RemovePeer(_ context.Context, publicKey string) error {
+	d.mu.Lock()
+	delete(d.peers, publicKey)
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *MemDevice) Peers(context.Context) ([]Peer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	peers := make([]Peer, 0, len(d.peers))
+	for _, p := range d.peers {
+		peers = append(peers, p)
+	}
+
+	return peers, nil
+}
@@ -0,0 +1,169 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/outline"
+)
+
+var ErrInvalidHostname = errors.New("invalid hostname")
+
+var _ Backend = &Outline{}
+
+// Outline is a backend which provisions shadowsocks keys on outline server.
+type Outline struct {
+	client *outline.Client
+
+	mu     sync.RWMutex
+	prefix string
+}
+
+// NewOutline returns outline backend, prefix is added to access urls of created keys.
+func NewOutline(c *outline.Client, prefix string) (*Outline, error) {
+	if err := domain.ValidateKeyPrefix(prefix); err != nil {
+		return nil, err
+	}
+	return &Outline{
+		client: c,
+		prefix: prefix,
+	}, nil
+}
+
+func (o *Outline) Name() domain.Backend { return domain.BackendOutline }
+
+func (o *Outline) CreateKey(ctx context.Context, name string) (Key, error) {
+	key, err := o.client.AccessKeysPost(ctx, outline.NewOptAccessKeysPostReq(outline.AccessKeysPostReq{
+		Name: outline.NewOptString(name),
+	}))
+	if err != nil {
+		return Key{}, err
+	}
+
+	url, err := o.KeyURL(key.AccessUrl.Value)
+	if err != nil {
+		return Key{}, err
+	}
+
+	return Key{
+		ID:   key.ID,
+		Name: key.Name.Value,
+		URL:  url,
+	}, nil
+}
+
+func (o *Outline) DeleteKey(ctx context.Context, id string) error {
+	_, err := o.client.AccessKeysIDDelete(ctx, outline.AccessKeysIDDeleteParams{ID: id})
+	return err
+}
+
+func (o *Outline) SetLimit(ctx context.Context, id string, bytes int64) error {
+	res, err := o.client.AccessKeysIDDataLimitPut(ctx,
+		&outline.DataLimit{Bytes: outline.NewOptInt(int(bytes))},
+		outline.AccessKeysIDDataLimitPutParams{ID: id})
+	if err != nil {
+		return err
+	}
+
+	switch res.(type) {
+	case *outline.AccessKeysIDDataLimitPutNoContent:
+		return nil
+	case *outline.AccessKeysIDDataLimitPutNotFound:
+		return ErrKeyNotFound
+	default:
+		return fmt.Errorf("invalid data limit %d", bytes)
+	}
+}
+
+func (o *Outline) Usage(ctx context.Context) (map[string]int64, error) {
+	res, err := o.client.MetricsTransferGet(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]int64, len(res.BytesTransferredByUserId.Value))
+	for id, b := range res.BytesTransferredByUserId.Value {
+		usage[id] = int64(b)
+	}
+
+	return usage, nil
+}
+
+func (o *Outline) RenderConfig(_ context.Context, key Key) (ClientConfig, error) {
+	return ClientConfig{Text: key.URL}, nil
+}
+
+// Key returns key from outline with prefixed access url.
+func (o *Outline) Key(ctx context.Context, id string) (Key, error) {
+	res, err := o.client.AccessKeysIDGet(ctx, outline.AccessKeysIDGetParams{ID: id})
+	if err != nil {
+		return Key{}, err
+	}
+
+	key, ok := res.(*outline.AccessKey)
+	if !ok {
+		return Key{}, ErrKeyNotFound
+	}
+
+	url, err := o.KeyURL(key.AccessUrl.Value)
+	if err != nil {
+		return Key{}, err
+	}
+
+	return Key{
+		ID:   key.ID,
+		Name: key.Name.Value,
+		URL:  url,
+	}, nil
+}
+
+// Ping checks that outline management api is reachable.
+func (o *Outline) Ping(ctx context.Context) error {
+	_, err := o.client.ServerGet(ctx)
+	return err
+}
+
+// SetHostname changes hostname in access urls of all keys.
+func (o *Outline) SetHostname(ctx context.Context, hostname string) error {
+	res, err := o.client.ServerHostnameForAccessKeysPut(ctx, &outline.ServerHostnameForAccessKeysPutReq{
+		Hostname: outline.NewOptString(hostname),
+	})
+	if err != nil {
+		return err
+	}
+
+	switch res.(type) {
+	case *outline.ServerHostnameForAccessKeysPutNoContent:
+		return nil
+	case *outline.ServerHostnameForAccessKeysPutBadRequest:
+		return ErrInvalidHostname
+	default:
+		return errors.New("outline internal error")
+	}
+}
+
+func (o *Outline) Prefix() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.prefix
+}
+
+func (o *Outline) SetPrefix(prefix string) error {
+	if err := domain.ValidateKeyPrefix(prefix); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.prefix = prefix
+	o.mu.Unlock()
+
+	return nil
+}
+
+// KeyURL returns access url with current key prefix.
+func (o *Outline) KeyURL(accessURL string) (string, error) {
+	return domain.KeyURLWithPrefix(accessURL, o.Prefix())
+}
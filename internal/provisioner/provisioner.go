@@ -0,0 +1,76 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+)
+
+var (
+	ErrNotSupported = errors.New("operation not supported by backend")
+	ErrKeyNotFound  = errors.New("key not found")
+)
+
+// Backend provisions vpn access keys on a vpn server.
+type Backend interface {
+	// Name returns name of the backend which is stored in orders.
+	Name() domain.Backend
+
+	// CreateKey creates new access key with name.
+	CreateKey(ctx context.Context, name string) (Key, error)
+
+	// DeleteKey deletes access key, deleting non existing key is not an error.
+	DeleteKey(ctx context.Context, id string) error
+
+	// SetLimit sets data transfer limit in bytes for access key.
+	SetLimit(ctx context.Context, id string, bytes int64) error
+
+	// Usage returns transferred bytes by access key ids.
+	Usage(ctx context.Context) (map[string]int64, error)
+
+	// RenderConfig returns client config of the key to send it to user.
+	RenderConfig(ctx context.Context, key Key) (ClientConfig, error)
+}
+
+type Key struct {
+	ID   string
+	Name string
+	// URL is access url of the key or its public part if the key has secret, stored as is.
+	URL string
+	// Secret is a part of client config which must not be stored, it is set only on key creation.
+	Secret string
+}
+
+// ClientConfig is what user needs to connect to vpn.
+type ClientConfig struct {
+	// Text is sent to user inside a message.
+	Text string
+
+	// File is sent to user as a document with FileName if set.
+	File     []byte
+	FileName string
+
+	// QR is png image of the config which is sent to user if set.
+	QR []byte
+}
+
+// Backends is a set of configured backends by name.
+type Backends map[domain.Backend]Backend
+
+func NewBackends(bb ...Backend) Backends {
+	res := make(Backends, len(bb))
+	for _, b := range bb {
+		res[b.Name()] = b
+	}
+	return res
+}
+
+func (bb Backends) Get(name domain.Backend) (Backend, error) {
+	b, ok := bb[name]
+	if !ok {
+		return nil, fmt.Errorf("backend %q not configured", name)
+	}
+	return b, nil
+}
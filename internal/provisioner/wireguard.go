@@ -0,0 +1,175 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/skip2/go-qrcode"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+)
+
+var (
+	ErrPoolExhausted = errors.New("no free ip addresses left in pool")
+	ErrNoSecret      = errors.New("key secret is not stored, config can be rendered only on key creation")
+)
+
+var _ Backend = &WireGuard{}
+
+type WireGuardConfig struct {
+	// Endpoint is host:port of wireguard server.
+	Endpoint string
+	// PublicKey is base64 encoded public key of wireguard server.
+	PublicKey string
+	// Pool is a subnet from which peers get addresses, first address in the pool belongs to the server.
+	Pool netip.Prefix
+	DNS  string
+}
+
+// WireGuard is a backend which provisions wireguard peers on a device.
+type WireGuard struct {
+	conf   WireGuardConfig
+	device Device
+
+	// mu guards ip allocation.
+	mu sync.Mutex
+}
+
+func NewWireGuard(conf WireGuardConfig, d Device) (*WireGuard, error) {
+	if !conf.Pool.IsValid() {
+		return nil, errors.New("invalid wireguard pool")
+	}
+	if conf.Endpoint == "" || conf.PublicKey == "" {
+		return nil, errors.New("wireguard endpoint and public key must be set")
+	}
+	return &WireGuard{
+		conf:   conf,
+		device: d,
+	}, nil
+}
+
+func (wg *WireGuard) Name() domain.Backend { return domain.BackendWireGuard }
+
+func (wg *WireGuard) CreateKey(ctx context.Context, name string) (Key, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Key{}, fmt.Errorf("keypair not generated: %w", err)
+	}
+
+	pub := base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes())
+
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	ip, err := wg.allocateIP(ctx)
+	if err != nil {
+		return Key{}, err
+	}
+
+	if err := wg.device.AddPeer(ctx, Peer{PublicKey: pub, IP: ip}); err != nil {
+		return Key{}, fmt.Errorf("peer not added: %w", err)
+	}
+
+	// private key is sent to user once and never stored
+	return Key{
+		ID:     pub,
+		Name:   name,
+		URL:    netip.PrefixFrom(ip, ip.BitLen()).String(),
+		Secret: base64.StdEncoding.EncodeToString(priv.Bytes()),
+	}, nil
+}
+
+// allocateIP returns first address from the pool which is not used by server or existing peers.
+func (wg *WireGuard) allocateIP(ctx context.Context) (netip.Addr, error) {
+	peers, err := wg.device.Peers(ctx)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("peers not listed: %w", err)
+	}
+
+	used := make(map[netip.Addr]struct{}, len(peers))
+	for _, p := range peers {
+		used[p.IP] = struct{}{}
+	}
+
+	pool := wg.conf.Pool.Masked()
+	server := pool.Addr().Next()
+
+	for ip := server.Next(); pool.Contains(ip); ip = ip.Next() {
+		// skip broadcast address
+		if !pool.Contains(ip.Next()) {
+			break
+		}
+		if _, ok := used[ip]; !ok {
+			return ip, nil
+		}
+	}
+
+	return netip.Addr{}, ErrPoolExhausted
+}
+
+// renderConfig returns client config of peer, address is peer ip prefix.
+func (wg *WireGuard) renderConfig(privateKey, address string) string {
+	sb := &strings.Builder{}
+
+	fmt.Fprintf(sb, "[Interface]\nPrivateKey = %s\nAddress = %s\n", privateKey, address)
+
+	if wg.conf.DNS != "" {
+		fmt.Fprintf(sb, "DNS = %s\n", wg.conf.DNS)
+	}
+
+	fmt.Fprintf(sb, "\n[Peer]\nPublicKey = %s\nEndpoint = %s\nAllowedIPs = 0.0.0.0/0, ::/0\nPersistentKeepalive = 25\n",
+		wg.conf.PublicKey, wg.conf.Endpoint)
+
+	return sb.String()
+}
+
+func (wg *WireGuard) DeleteKey(ctx context.Context, id string) error {
+	return wg.device.RemovePeer(ctx, id)
+}
+
+func (wg *WireGuard) SetLimit(context.Context, string, int64) error {
+	return ErrNotSupported
+}
+
+func (wg *WireGuard) Usage(ctx context.Context) (map[string]int64, error) {
+	peers, err := wg.device.Peers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("peers not listed: %w", err)
+	}
+
+	usage := make(map[string]int64, len(peers))
+	for _, p := range peers {
+		usage[p.PublicKey] = p.Transfer
+	}
+
+	return usage, nil
+}
+
+// RenderConfig returns config with private key as file and qr code, text is address of the peer.
+// Config can be rendered only for just created key.
+func (wg *WireGuard) RenderConfig(_ context.Context, key Key) (ClientConfig, error) {
+	if key.Secret == "" {
+		return ClientConfig{}, ErrNoSecret
+	}
+
+	conf := wg.renderConfig(key.Secret, key.URL)
+
+	qr, err := qrcode.Encode(conf, qrcode.Medium, 512)
+	if err != nil {
+		return ClientConfig{}, fmt.Errorf("qr not encoded: %w", err)
+	}
+
+	return ClientConfig{
+		Text:     key.URL,
+		File:     []byte(conf),
+		FileName: key.Name + ".conf",
+		QR:       qr,
+	}, nil
+}
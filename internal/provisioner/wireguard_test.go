@@ -0,0 +1,175 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func newTestWireGuard(t *testing.T, pool string) (*WireGuard, *MemDevice) {
+	t.Helper()
+
+	d := NewMemDevice()
+
+	wg, err := NewWireGuard(WireGuardConfig{
+		Endpoint:  "vpn.example.org:51820",
+		PublicKey: "c2VydmVyLXB1YmxpYy1rZXktYmFzZTY0LWVuY29kZWQ=",
+		Pool:      netip.MustParsePrefix(pool),
+		DNS:       "1.1.1.1",
+	}, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wg, d
+}
+
+func TestWireGuardCreateKey(t *testing.T) {
+	wg, d := newTestWireGuard(t, "10.8.0.0/24")
+	ctx := context.Background()
+
+	first, err := wg.CreateKey(ctx, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := wg.CreateKey(ctx, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first address after network one belongs to the server
+	if first.URL != "10.8.0.2/32" || second.URL != "10.8.0.3/32" {
+		t.Errorf("addresses = %s, %s, want 10.8.0.2/32, 10.8.0.3/32", first.URL, second.URL)
+	}
+
+	if first.Secret == "" || strings.Contains(first.URL, first.Secret) {
+		t.Errorf("private key must be secret, not part of url: %+v", first)
+	}
+
+	peers, err := d.Peers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peers) != 2 {
+		t.Fatalf("peers = %d, want 2", len(peers))
+	}
+
+	for _, p := range peers {
+		if p.PublicKey != first.ID && p.PublicKey != second.ID {
+			t.Errorf("unexpected peer %+v", p)
+		}
+	}
+
+	cfg, err := wg.RenderConfig(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := string(cfg.File)
+
+	for _, want := range []string{"PrivateKey = " + first.Secret, "Address = 10.8.0.2/32", "DNS = 1.1.1.1", "Endpoint = vpn.example.org:51820"} {
+		if !strings.Contains(conf, want) {
+			t.Errorf("config doesn't contain %q:\n%s", want, conf)
+		}
+	}
+
+	if strings.Contains(cfg.Text, first.Secret) {
+		t.Error("private key is in config text")
+	}
+
+	if cfg.FileName != "first.conf" || len(cfg.QR) == 0 {
+		t.Errorf("unexpected config file %q or empty qr", cfg.FileName)
+	}
+
+	// stored key has no secret
+	stored := Key{ID: first.ID, Name: first.Name, URL: first.URL}
+
+	if _, err := wg.RenderConfig(ctx, stored); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("render of stored key err = %v, want %v", err, ErrNoSecret)
+	}
+}
+
+func TestWireGuardPool(t *testing.T) {
+	// network, server, two peers and broadcast
+	wg, _ := newTestWireGuard(t, "10.8.0.0/29")
+	ctx := context.Background()
+
+	var keys []Key
+
+	for range 5 {
+		k, err := wg.CreateKey(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+
+	if _, err := wg.CreateKey(ctx, "key"); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("err = %v, want %v", err, ErrPoolExhausted)
+	}
+
+	if err := wg.DeleteKey(ctx, keys[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// deleting twice is not an error
+	if err := wg.DeleteKey(ctx, keys[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := wg.CreateKey(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.URL != keys[1].URL {
+		t.Errorf("address = %s, want freed %s", k.URL, keys[1].URL)
+	}
+}
+
+func TestWireGuardUsage(t *testing.T) {
+	wg, d := newTestWireGuard(t, "10.8.0.0/24")
+	ctx := context.Background()
+
+	k, err := wg.CreateKey(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.mu.Lock()
+	p := d.peers[k.ID]
+	p.Transfer = 1024
+	d.peers[k.ID] = p
+	d.mu.Unlock()
+
+	usage, err := wg.Usage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage[k.ID] != 1024 {
+		t.Errorf("usage = %v, want 1024 bytes of %s", usage, k.ID)
+	}
+
+	if err := wg.SetLimit(ctx, k.ID, 1); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("set limit err = %v, want %v", err, ErrNotSupported)
+	}
+}
+
+func TestMemDeviceAddressConflict(t *testing.T) {
+	d := NewMemDevice()
+	ctx := context.Background()
+	ip := netip.MustParseAddr("10.8.0.2")
+
+	if err := d.AddPeer(ctx, Peer{PublicKey: "a", IP: ip}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.AddPeer(ctx, Peer{PublicKey: "b", IP: ip}); err == nil {
+		t.Error("peer with used address added")
+	}
+}
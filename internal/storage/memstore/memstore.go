@@ -0,0 +1,1747 @@
+// Package memstore is in-memory implementation of storage.Storage for tests.
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+var _ storage.Storage = &Storage{}
+
+type order struct {
+	storage.Order
+	closedAt sql.NullTime
+}
+
+type key struct {
+	storage.Key
+	orderID domain.OrderID
+}
+
+type invite struct {
+	storage.Invite
+	usedBy int64
+}
+
+type Storage struct {
+	mu     sync.RWMutex
+	lastID domain.OrderID
+	orders map[domain.OrderID]*order
+	keys   map[string]key
+	banned map[int64]time.Time
+	users  map[int64]time.Time
+	admins map[int64]storage.Admin
+	msgs   map[domain.OrderID][]storage.AdminMessage
+	topics map[string]int
+
+	prefixes map[domain.Backend]string
+
+	lastTicketID int64
+	tickets      map[int64]storage.Ticket
+	ticketMsgs   map[int64][]storage.TicketMessage
+	relays       map[storage.AdminMessage]int64
+
+	invites      map[string]invite
+	members      map[int64]storage.Member
+	joinRequests map[int64]storage.JoinRequest
+
+	referrals map[int64]storage.Referral
+	ledger    []storage.LedgerEntry
+
+	promos map[string]storage.Promo
+
+	trials map[int64]storage.Trial
+
+	topups      map[int64]storage.TopUp
+	lastTopUpID int64
+	autoRenew   map[int64]time.Time
+
+	// Now returns current time, may be replaced in tests.
+	Now func() time.Time
+}
+
+func New() *Storage {
+	return &Storage{
+		orders: make(map[domain.OrderID]*order),
+		keys:   make(map[string]key),
+		banned: make(map[int64]time.Time),
+		users:  make(map[int64]time.Time),
+		admins: make(map[int64]storage.Admin),
+		msgs:   make(map[domain.OrderID][]storage.AdminMessage),
+		topics: make(map[string]int),
+
+		prefixes: make(map[domain.Backend]string),
+
+		tickets:    make(map[int64]storage.Ticket),
+		ticketMsgs: make(map[int64][]storage.TicketMessage),
+		relays:     make(map[storage.AdminMessage]int64),
+
+		invites:      make(map[string]invite),
+		members:      make(map[int64]storage.Member),
+		joinRequests: make(map[int64]storage.JoinRequest),
+
+		referrals: make(map[int64]storage.Referral),
+
+		promos: make(map[string]storage.Promo),
+		trials: make(map[int64]storage.Trial),
+
+		topups:    make(map[int64]storage.TopUp),
+		autoRenew: make(map[int64]time.Time),
+
+		Now: time.Now,
+	}
+}
+
+func (s *Storage) GetOrder(ctx context.Context, oid domain.OrderID) (storage.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.Order{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.orders[oid]
+	if !ok {
+		return storage.Order{}, storage.ErrNotFound
+	}
+
+	return o.Order, nil
+}
+
+func (s *Storage) CreateOrder(ctx context.Context, p storage.CreateOrderParams) (domain.OrderID, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	s.saveUser(p.UID, p.CreatedAt)
+
+	s.orders[s.lastID] = &order{Order: storage.Order{
+		ID:        s.lastID,
+		UID:       p.UID,
+		Username:  nullString(p.Username),
+		FirstName: nullString(p.FirstName),
+		LastName:  nullString(p.LastName),
+		KeyAmount: p.KeyAmount,
+		Price:     p.Price,
+		Status:    nullString(string(p.Status)),
+		CreatedAt: sql.NullTime{Time: p.CreatedAt, Valid: true},
+		Backend:   p.Backend,
+		PromoCode: nullString(p.PromoCode),
+		Discount:  p.Discount,
+		BonusDays: p.BonusDays,
+	}}
+
+	return s.lastID, nil
+}
+
+func (s *Storage) CloseOrder(ctx context.Context, oid domain.OrderID, status domain.OrderStatus, closedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if o, ok := s.orders[oid]; ok {
+		o.Status = nullString(string(status))
+		o.closedAt = sql.NullTime{Time: closedAt.UTC(), Valid: true}
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeOrder(ctx context.Context, oid domain.OrderID, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[oid]
+	if !ok || o.closedAt.Valid {
+		return storage.ErrNotFound
+	}
+
+	o.Status = nullString(string(domain.OrderStatusRevoked))
+	o.closedAt = sql.NullTime{Time: at.UTC(), Valid: true}
+
+	return nil
+}
+
+func (s *Storage) CancelOrder(ctx context.Context, oid domain.OrderID, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[oid]
+	if !ok || o.closedAt.Valid || o.Status.String != string(domain.OrderStatusAwaitingPayment) {
+		return storage.ErrNotFound
+	}
+
+	o.Status = nullString(string(domain.OrderStatusCanceled))
+	o.closedAt = sql.NullTime{Time: at.UTC(), Valid: true}
+
+	return nil
+}
+
+func (s *Storage) ApproveOrder(ctx context.Context, oid domain.OrderID, keys []storage.Key, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[oid]
+	if !ok || o.Status.String != string(domain.OrderStatusAwaitingPayment) || o.closedAt.Valid {
+		return storage.ErrNotFound
+	}
+
+	for _, k := range keys {
+		if _, ok := s.keys[k.ID]; ok {
+			return fmt.Errorf("access keys not created: key %s already exists", k.ID)
+		}
+	}
+
+	o.ExpiresAt = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	o.Status = nullString(string(domain.OrderStatusApproved))
+
+	for _, k := range keys {
+		s.keys[k.ID] = key{Key: k, orderID: oid}
+	}
+
+	return nil
+}
+
+func (s *Storage) RenewOrder(ctx context.Context, oid domain.OrderID, expiresAt time.Time, exp time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[oid]
+	if !ok || o.closedAt.Valid || !o.ExpiresAt.Valid ||
+		!o.ExpiresAt.Time.Truncate(time.Second).Equal(expiresAt.Truncate(time.Second)) {
+		return storage.ErrNotFound
+	}
+
+	o.ExpiresAt.Time = o.ExpiresAt.Time.Add(exp)
+
+	return nil
+}
+
+func (s *Storage) RenewOrderWithCredit(ctx context.Context, oid domain.OrderID, expiresAt time.Time, exp time.Duration, full bool, at time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[oid]
+	if !ok || o.closedAt.Valid || !o.ExpiresAt.Valid ||
+		!o.ExpiresAt.Time.Truncate(time.Second).Equal(expiresAt.Truncate(time.Second)) {
+		return 0, storage.ErrNotFound
+	}
+
+	balance := 0
+
+	for _, e := range s.ledger {
+		if e.UID == o.UID {
+			balance += e.Amount
+		}
+	}
+
+	if full && balance < o.Price {
+		return 0, storage.ErrInsufficientBalance
+	}
+
+	o.ExpiresAt.Time = o.ExpiresAt.Time.Add(exp)
+
+	credit := min(balance, o.Price)
+	if credit <= 0 {
+		return 0, nil
+	}
+
+	s.ledger = append(s.ledger, storage.LedgerEntry{
+		UID:       o.UID,
+		Amount:    -credit,
+		Kind:      domain.LedgerRenewal,
+		OrderID:   oid,
+		CreatedAt: at,
+	})
+
+	return credit, nil
+}
+
+func (s *Storage) ListActiveOrders(ctx context.Context, backend domain.Backend) ([]storage.ActiveOrder, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orders []storage.ActiveOrder
+
+	for _, o := range s.sortedOrders() {
+		if o.Status.String != string(domain.OrderStatusApproved) || o.Backend != backend {
+			continue
+		}
+
+		orders = append(orders, storage.ActiveOrder{
+			ID:        o.ID,
+			UID:       o.UID,
+			KeyAmount: o.KeyAmount,
+			ExpiresAt: o.ExpiresAt,
+		})
+	}
+
+	return orders, nil
+}
+
+func (s *Storage) ListActiveUserKeys(ctx context.Context, uid int64) ([]storage.ActiveKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.Now()
+
+	return s.activeKeys(func(o *order) bool {
+		return o.UID == uid && !o.closedAt.Valid && o.ExpiresAt.Valid && o.ExpiresAt.Time.After(now)
+	}), nil
+}
+
+func (s *Storage) CountActiveKeys(ctx context.Context, uid int64) (uint8, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.Now()
+	keys := s.activeKeys(func(o *order) bool {
+		return o.UID == uid && o.ExpiresAt.Valid && o.ExpiresAt.Time.After(now)
+	})
+
+	return uint8(len(keys)), nil
+}
+
+func (s *Storage) ListExpiringKeys(ctx context.Context, exp time.Duration) ([]storage.ExpiringKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.Now()
+
+	var keys []storage.ExpiringKey
+
+	for _, k := range s.keys {
+		o := s.orders[k.orderID]
+
+		if o.closedAt.Valid || !o.ExpiresAt.Valid {
+			continue
+		}
+
+		expiresIn := o.ExpiresAt.Time.Sub(now).Truncate(time.Second)
+		if expiresIn > exp {
+			continue
+		}
+
+		keys = append(keys, storage.ExpiringKey{
+			ID:        k.ID,
+			Name:      k.Name,
+			URL:       k.URL,
+			ExpiresAt: o.ExpiresAt.Time,
+			ExpiresIn: expiresIn,
+			OrderID:   o.ID,
+			KeyAmount: o.KeyAmount,
+			Price:     o.Price,
+			UID:       o.UID,
+			Username:  o.Username,
+			FirstName: o.FirstName,
+			LastName:  o.LastName,
+			Backend:   o.Backend,
+			Trial:     s.isTrial(o.ID),
+		})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ExpiresIn == keys[j].ExpiresIn {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].ExpiresIn < keys[j].ExpiresIn
+	})
+
+	return keys, nil
+}
+
+func (s *Storage) AllActiveKeys(ctx context.Context) ([]storage.ActiveKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.Now()
+	keys := s.activeKeys(func(o *order) bool {
+		return !o.closedAt.Valid && o.ExpiresAt.Valid && o.ExpiresAt.Time.After(now)
+	})
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys, nil
+}
+
+func (s *Storage) UpdateKeyURL(ctx context.Context, id, url string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.keys[id]; ok {
+		k.URL = url
+		s.keys[id] = k
+	}
+
+	return nil
+}
+
+func (s *Storage) GetKeyPrefix(ctx context.Context, backend domain.Backend) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix, ok := s.prefixes[backend]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+
+	return prefix, nil
+}
+
+func (s *Storage) SaveKeyPrefix(ctx context.Context, backend domain.Backend, prefix string, _ time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prefixes[backend] = prefix
+
+	return nil
+}
+
+func (s *Storage) AddOrderKeys(ctx context.Context, oid domain.OrderID, keys []storage.Key) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[oid]; !ok {
+		return fmt.Errorf("access keys not created: order %d not found", oid)
+	}
+
+	for _, k := range keys {
+		if _, ok := s.keys[k.ID]; ok {
+			return fmt.Errorf("access keys not created: key %s already exists", k.ID)
+		}
+	}
+
+	for _, k := range keys {
+		s.keys[k.ID] = key{Key: k, orderID: oid}
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteBackendKeys(ctx context.Context, backend domain.Backend) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, k := range s.keys {
+		if s.orders[k.orderID].Backend == backend {
+			delete(s.keys, id)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) OrderStats(ctx context.Context, p storage.OrderStatsParams) (storage.OrderStats, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.OrderStats{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st := storage.OrderStats{}
+
+	for _, o := range s.orders {
+		active := !o.closedAt.Valid && o.ExpiresAt.Valid && o.ExpiresAt.Time.After(p.Now)
+
+		if active {
+			st.ActiveOrders++
+			st.MRR += o.Price
+			st.ActiveKeys += len(s.sortedKeys(o.ID))
+		}
+
+		if active && !o.ExpiresAt.Time.After(p.ExpiresBefore) {
+			st.Expiring++
+			st.ExpiringPrice += o.Price
+		}
+
+		if !o.closedAt.Valid && o.Status.String == string(domain.OrderStatusAwaitingPayment) {
+			st.AwaitingPayment++
+			st.AwaitingPaymentPrice += o.Price - o.Discount
+		}
+
+		if o.Status.String == string(domain.OrderStatusExpired) && !o.closedAt.Time.Before(p.ChurnedSince) {
+			st.Churned++
+			st.ChurnedPrice += o.Price
+		}
+	}
+
+	return st, nil
+}
+
+func (s *Storage) RevenueByMonth(ctx context.Context, since time.Time) ([]storage.MonthRevenue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var months []storage.MonthRevenue
+
+	for _, o := range s.sortedOrders() {
+		if !o.ExpiresAt.Valid || o.CreatedAt.Time.Before(since) {
+			continue
+		}
+
+		month := o.CreatedAt.Time.UTC().Format("2006-01")
+		i := sort.Search(len(months), func(i int) bool { return months[i].Month <= month })
+
+		if i == len(months) || months[i].Month != month {
+			months = append(months, storage.MonthRevenue{})
+			copy(months[i+1:], months[i:])
+			months[i] = storage.MonthRevenue{Month: month}
+		}
+
+		months[i].Orders++
+		months[i].Revenue += o.Price - o.Discount
+	}
+
+	return months, nil
+}
+
+func (s *Storage) ListOrders(ctx context.Context, p storage.ListOrdersParams) ([]storage.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orders := s.latestOrders(func(o *order) bool {
+		return p.Status == "" || o.Status.String == string(p.Status)
+	})
+
+	if p.Offset >= len(orders) {
+		return nil, nil
+	}
+
+	orders = orders[p.Offset:]
+
+	if len(orders) > p.Limit {
+		orders = orders[:p.Limit]
+	}
+
+	return orders, nil
+}
+
+func (s *Storage) CountOrders(ctx context.Context, status domain.OrderStatus) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orders := s.latestOrders(func(o *order) bool {
+		return status == "" || o.Status.String == string(status)
+	})
+
+	return len(orders), nil
+}
+
+func (s *Storage) ListPendingOrders(ctx context.Context, createdBefore time.Time) ([]storage.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orders []storage.Order
+
+	for _, o := range s.sortedOrders() {
+		if o.Status.String == string(domain.OrderStatusAwaitingPayment) && !o.closedAt.Valid && !o.CreatedAt.Time.After(createdBefore) {
+			orders = append(orders, o.Order)
+		}
+	}
+
+	return orders, nil
+}
+
+func (s *Storage) FindUID(ctx context.Context, username string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orders := s.latestOrders(func(o *order) bool {
+		return strings.EqualFold(o.Username.String, username)
+	})
+
+	if len(orders) == 0 {
+		return 0, storage.ErrNotFound
+	}
+
+	return orders[0].UID, nil
+}
+
+func (s *Storage) ListUserOrders(ctx context.Context, uid int64) ([]storage.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.latestOrders(func(o *order) bool { return o.UID == uid }), nil
+}
+
+func (s *Storage) BanUser(ctx context.Context, uid int64, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.banned[uid]; !ok {
+		s.banned[uid] = at
+	}
+
+	return nil
+}
+
+func (s *Storage) UnbanUser(ctx context.Context, uid int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.banned, uid)
+
+	return nil
+}
+
+func (s *Storage) IsBanned(ctx context.Context, uid int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.banned[uid]
+
+	return ok, nil
+}
+
+// saveUser must be called under write lock.
+func (s *Storage) saveUser(uid int64, at time.Time) {
+	if _, ok := s.users[uid]; !ok {
+		s.users[uid] = at
+	}
+}
+
+func (s *Storage) SaveUser(ctx context.Context, uid int64, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saveUser(uid, at)
+
+	return nil
+}
+
+func (s *Storage) ListUserIDs(ctx context.Context, seg storage.UserSegment) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !seg.Active && seg.Backend == "" && seg.ExpiredSince.IsZero() {
+		uids := make([]int64, 0, len(s.users))
+		for uid := range s.users {
+			if _, ok := s.banned[uid]; !ok {
+				uids = append(uids, uid)
+			}
+		}
+
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+		return uids, nil
+	}
+
+	active := make(map[int64]bool)
+
+	for _, o := range s.orders {
+		if !o.closedAt.Valid && o.ExpiresAt.Valid && o.ExpiresAt.Time.After(seg.Now) {
+			active[o.UID] = true
+		}
+	}
+
+	selected := make(map[int64]bool)
+
+	for _, o := range s.orders {
+		if _, ok := s.banned[o.UID]; ok {
+			continue
+		}
+
+		isActive := !o.closedAt.Valid && o.ExpiresAt.Valid && o.ExpiresAt.Time.After(seg.Now)
+
+		if (seg.Active || seg.Backend != "") && !isActive {
+			continue
+		}
+
+		if seg.Backend != "" && o.Backend != seg.Backend {
+			continue
+		}
+
+		if !seg.ExpiredSince.IsZero() && (o.Status.String != string(domain.OrderStatusExpired) ||
+			o.closedAt.Time.Before(seg.ExpiredSince) || active[o.UID]) {
+			continue
+		}
+
+		selected[o.UID] = true
+	}
+
+	uids := make([]int64, 0, len(selected))
+	for uid := range selected {
+		uids = append(uids, uid)
+	}
+
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+	return uids, nil
+}
+
+func (s *Storage) GetAdmin(ctx context.Context, uid int64) (storage.Admin, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.Admin{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.admins[uid]
+	if !ok {
+		return storage.Admin{}, storage.ErrNotFound
+	}
+
+	return a, nil
+}
+
+func (s *Storage) ListAdmins(ctx context.Context) ([]storage.Admin, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	admins := make([]storage.Admin, 0, len(s.admins))
+	for _, a := range s.admins {
+		admins = append(admins, a)
+	}
+
+	sort.Slice(admins, func(i, j int) bool {
+		if admins[i].CreatedAt.Equal(admins[j].CreatedAt) {
+			return admins[i].UID < admins[j].UID
+		}
+		return admins[i].CreatedAt.Before(admins[j].CreatedAt)
+	})
+
+	return admins, nil
+}
+
+func (s *Storage) SaveAdmin(ctx context.Context, a storage.Admin) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.admins[a.UID]; ok {
+		a.CreatedAt = old.CreatedAt
+		if a.Username == "" {
+			a.Username = old.Username
+		}
+	}
+
+	s.admins[a.UID] = a
+
+	return nil
+}
+
+func (s *Storage) DeleteAdmin(ctx context.Context, uid int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.admins, uid)
+
+	return nil
+}
+
+func (s *Storage) SaveAdminMessages(ctx context.Context, oid domain.OrderID, msgs []storage.AdminMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.msgs[oid] = append(s.msgs[oid], msgs...)
+
+	return nil
+}
+
+func (s *Storage) PopAdminMessages(ctx context.Context, oid domain.OrderID) ([]storage.AdminMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs := s.msgs[oid]
+	delete(s.msgs, oid)
+
+	return msgs, nil
+}
+
+func (s *Storage) GetAdminTopic(ctx context.Context, key string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	threadID, ok := s.topics[key]
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+
+	return threadID, nil
+}
+
+func (s *Storage) SaveAdminTopic(ctx context.Context, key string, threadID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.topics[key] = threadID
+
+	return nil
+}
+
+// latestOrders returns orders matching filter, latest first, must be called under lock.
+func (s *Storage) latestOrders(filter func(o *order) bool) []storage.Order {
+	sorted := s.sortedOrders()
+
+	var orders []storage.Order
+
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if filter(sorted[i]) {
+			orders = append(orders, sorted[i].Order)
+		}
+	}
+
+	return orders
+}
+
+// sortedOrders returns orders sorted by id, must be called under lock.
+func (s *Storage) sortedOrders() []*order {
+	orders := make([]*order, 0, len(s.orders))
+	for _, o := range s.orders {
+		orders = append(orders, o)
+	}
+
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+
+	return orders
+}
+
+// activeKeys returns keys of orders matching filter sorted by order id, must be called under lock.
+func (s *Storage) activeKeys(filter func(o *order) bool) []storage.ActiveKey {
+	var keys []storage.ActiveKey
+
+	for _, o := range s.sortedOrders() {
+		if !filter(o) {
+			continue
+		}
+
+		for _, k := range s.sortedKeys(o.ID) {
+			keys = append(keys, storage.ActiveKey{
+				ID:        k.ID,
+				Name:      k.Name,
+				URL:       k.URL,
+				ExpiresAt: o.ExpiresAt.Time,
+				OrderID:   o.ID,
+				Price:     o.Price,
+				UID:       o.UID,
+				Backend:   o.Backend,
+			})
+		}
+	}
+
+	return keys
+}
+
+// sortedKeys returns keys of order sorted by id, must be called under lock.
+func (s *Storage) sortedKeys(oid domain.OrderID) []key {
+	var keys []key
+
+	for _, k := range s.keys {
+		if k.orderID == oid {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (s *Storage) CreateTicket(ctx context.Context, p storage.CreateTicketParams) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastTicketID++
+
+	s.tickets[s.lastTicketID] = storage.Ticket{
+		ID:        s.lastTicketID,
+		UID:       p.UID,
+		Username:  p.Username,
+		FirstName: p.FirstName,
+		LastName:  p.LastName,
+		Status:    domain.TicketStatusOpen,
+		CreatedAt: p.CreatedAt,
+		UpdatedAt: p.CreatedAt,
+	}
+
+	return s.lastTicketID, nil
+}
+
+func (s *Storage) GetTicket(ctx context.Context, id int64) (storage.Ticket, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.Ticket{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tickets[id]
+	if !ok {
+		return storage.Ticket{}, storage.ErrNotFound
+	}
+
+	return t, nil
+}
+
+func (s *Storage) GetUserTicket(ctx context.Context, uid int64) (storage.Ticket, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.Ticket{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found storage.Ticket
+
+	for _, t := range s.tickets {
+		if t.UID == uid && t.Status != domain.TicketStatusClosed && t.ID > found.ID {
+			found = t
+		}
+	}
+
+	if found.ID == 0 {
+		return storage.Ticket{}, storage.ErrNotFound
+	}
+
+	return found, nil
+}
+
+func (s *Storage) ListTickets(ctx context.Context, statuses []domain.TicketStatus, limit int) ([]storage.Ticket, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tickets []storage.Ticket
+
+	for _, t := range s.tickets {
+		if slices.Contains(statuses, t.Status) {
+			tickets = append(tickets, t)
+		}
+	}
+
+	sort.Slice(tickets, func(i, j int) bool {
+		if tickets[i].UpdatedAt.Equal(tickets[j].UpdatedAt) {
+			return tickets[i].ID > tickets[j].ID
+		}
+		return tickets[i].UpdatedAt.After(tickets[j].UpdatedAt)
+	})
+
+	if len(tickets) > limit {
+		tickets = tickets[:limit]
+	}
+
+	return tickets, nil
+}
+
+func (s *Storage) SetTicketStatus(ctx context.Context, id int64, status domain.TicketStatus, updatedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tickets[id]
+	if !ok {
+		return nil
+	}
+
+	t.Status = status
+	t.UpdatedAt = updatedAt
+	s.tickets[id] = t
+
+	return nil
+}
+
+func (s *Storage) SaveTicketMessage(ctx context.Context, m storage.TicketMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ticketMsgs[m.TicketID] = append(s.ticketMsgs[m.TicketID], m)
+
+	return nil
+}
+
+func (s *Storage) ListTicketMessages(ctx context.Context, id int64) ([]storage.TicketMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.ticketMsgs[id]), nil
+}
+
+func (s *Storage) SaveTicketRelays(ctx context.Context, id int64, msgs []storage.AdminMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range msgs {
+		s.relays[m] = id
+	}
+
+	return nil
+}
+
+func (s *Storage) FindTicketByRelay(ctx context.Context, m storage.AdminMessage) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.relays[m]
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+
+	return id, nil
+}
+
+func (s *Storage) CreateInvite(ctx context.Context, inv storage.Invite, limit int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.invites[inv.Code]; ok {
+		return fmt.Errorf("invite %s already exists", inv.Code)
+	}
+
+	created := 0
+
+	for _, i := range s.invites {
+		if i.CreatedBy == inv.CreatedBy {
+			created++
+		}
+	}
+
+	if limit > 0 && created >= limit {
+		return storage.ErrNotFound
+	}
+
+	s.invites[inv.Code] = invite{Invite: inv}
+
+	return nil
+}
+
+func (s *Storage) CountInvites(ctx context.Context, uid int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+
+	for _, inv := range s.invites {
+		if inv.CreatedBy == uid {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (s *Storage) UseInvite(ctx context.Context, code string, m storage.Member) (storage.Member, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.Member{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv, ok := s.invites[code]
+	if !ok || inv.usedBy != 0 {
+		return storage.Member{}, storage.ErrNotFound
+	}
+
+	inv.usedBy = m.UID
+	s.invites[code] = inv
+
+	m.InvitedBy = inv.CreatedBy
+
+	if _, ok := s.members[m.UID]; !ok {
+		s.members[m.UID] = m
+	}
+
+	s.saveUser(m.UID, m.JoinedAt)
+
+	return m, nil
+}
+
+func (s *Storage) GetMember(ctx context.Context, uid int64) (storage.Member, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.Member{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.members[uid]
+	if !ok {
+		return storage.Member{}, storage.ErrNotFound
+	}
+
+	return m, nil
+}
+
+func (s *Storage) SaveMember(ctx context.Context, m storage.Member) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.members[m.UID]; !ok {
+		s.members[m.UID] = m
+	}
+
+	s.saveUser(m.UID, m.JoinedAt)
+
+	return nil
+}
+
+func (s *Storage) ListMembers(ctx context.Context) ([]storage.Member, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members := make([]storage.Member, 0, len(s.members))
+	for _, m := range s.members {
+		members = append(members, m)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].JoinedAt.Equal(members[j].JoinedAt) {
+			return members[i].UID < members[j].UID
+		}
+		return members[i].JoinedAt.Before(members[j].JoinedAt)
+	})
+
+	return members, nil
+}
+
+func (s *Storage) GetJoinRequest(ctx context.Context, uid int64) (storage.JoinRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.JoinRequest{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.joinRequests[uid]
+	if !ok {
+		return storage.JoinRequest{}, storage.ErrNotFound
+	}
+
+	return r, nil
+}
+
+func (s *Storage) SaveJoinRequest(ctx context.Context, r storage.JoinRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.joinRequests[r.UID]; ok {
+		existing.Status = r.Status
+		r = existing
+	}
+
+	s.joinRequests[r.UID] = r
+
+	return nil
+}
+
+func (s *Storage) DeleteJoinRequest(ctx context.Context, uid int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.joinRequests, uid)
+
+	return nil
+}
+
+func (s *Storage) SaveReferral(ctx context.Context, r storage.Referral) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.referrals[r.UID]; !ok {
+		s.referrals[r.UID] = r
+	}
+
+	return nil
+}
+
+func (s *Storage) RewardReferral(ctx context.Context, uid int64, credit int, oid domain.OrderID, at time.Time) (storage.Referral, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.Referral{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.referrals[uid]
+	if !ok || r.RewardedAt.Valid {
+		return storage.Referral{}, storage.ErrNotFound
+	}
+
+	r.RewardedAt = sql.NullTime{Time: at, Valid: true}
+	s.referrals[uid] = r
+
+	s.ledger = append(s.ledger, storage.LedgerEntry{
+		UID:       r.ReferrerID,
+		Amount:    credit,
+		Kind:      domain.LedgerReferral,
+		OrderID:   oid,
+		CreatedAt: at,
+	})
+
+	return r, nil
+}
+
+func (s *Storage) ListReferrals(ctx context.Context, referrerID int64) ([]storage.Referral, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var referrals []storage.Referral
+
+	for _, r := range s.referrals {
+		if r.ReferrerID == referrerID {
+			referrals = append(referrals, r)
+		}
+	}
+
+	sort.Slice(referrals, func(i, j int) bool {
+		if referrals[i].CreatedAt.Equal(referrals[j].CreatedAt) {
+			return referrals[i].UID < referrals[j].UID
+		}
+		return referrals[i].CreatedAt.Before(referrals[j].CreatedAt)
+	})
+
+	return referrals, nil
+}
+
+func (s *Storage) AddLedgerEntry(ctx context.Context, e storage.LedgerEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ledger = append(s.ledger, e)
+
+	return nil
+}
+
+func (s *Storage) GetBalance(ctx context.Context, uid int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	balance := 0
+
+	for _, e := range s.ledger {
+		if e.UID == uid {
+			balance += e.Amount
+		}
+	}
+
+	return balance, nil
+}
+
+func (s *Storage) ListLedgerEntries(ctx context.Context, uid int64, limit uint64) ([]storage.LedgerEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []storage.LedgerEntry
+
+	for i := len(s.ledger) - 1; i >= 0 && uint64(len(entries)) < limit; i-- {
+		if s.ledger[i].UID == uid {
+			entries = append(entries, s.ledger[i])
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *Storage) RefundOrder(ctx context.Context, oid domain.OrderID, amount int, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[oid]
+	if !ok || o.closedAt.Valid {
+		return storage.ErrNotFound
+	}
+
+	o.Status = nullString(string(domain.OrderStatusRefunded))
+	o.closedAt = sql.NullTime{Time: at.UTC(), Valid: true}
+
+	if amount > 0 {
+		s.ledger = append(s.ledger, storage.LedgerEntry{
+			UID:       o.UID,
+			Amount:    amount,
+			Kind:      domain.LedgerRefund,
+			OrderID:   oid,
+			CreatedAt: at,
+		})
+	}
+
+	return nil
+}
+
+func (s *Storage) CreatePromo(ctx context.Context, p storage.Promo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.promos[p.Code]; ok {
+		return fmt.Errorf("promo %s already exists", p.Code)
+	}
+
+	s.promos[p.Code] = p
+
+	return nil
+}
+
+func (s *Storage) GetPromo(ctx context.Context, code string) (storage.Promo, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.Promo{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.promos[code]
+	if !ok {
+		return storage.Promo{}, storage.ErrNotFound
+	}
+
+	return p, nil
+}
+
+func (s *Storage) UsePromo(ctx context.Context, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.promos[code]
+	if !ok || p.Disabled || (p.MaxUses != 0 && p.Uses >= p.MaxUses) {
+		return storage.ErrNotFound
+	}
+
+	p.Uses++
+	s.promos[code] = p
+
+	return nil
+}
+
+func (s *Storage) DisablePromo(ctx context.Context, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.promos[code]; ok {
+		p.Disabled = true
+		s.promos[code] = p
+	}
+
+	return nil
+}
+
+func (s *Storage) ListPromos(ctx context.Context) ([]storage.PromoStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	promos := make([]storage.PromoStats, 0, len(s.promos))
+
+	for _, p := range s.promos {
+		st := storage.PromoStats{Promo: p}
+
+		for _, o := range s.orders {
+			if o.PromoCode.String == p.Code && o.ExpiresAt.Valid {
+				st.PaidOrders++
+				st.Discount += o.Discount
+			}
+		}
+
+		promos = append(promos, st)
+	}
+
+	sort.Slice(promos, func(i, j int) bool {
+		if promos[i].CreatedAt.Equal(promos[j].CreatedAt) {
+			return promos[i].Code < promos[j].Code
+		}
+		return promos[i].CreatedAt.After(promos[j].CreatedAt)
+	})
+
+	return promos, nil
+}
+
+func (s *Storage) CreateTrial(ctx context.Context, t storage.Trial) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.trials[t.UID]; ok {
+		return storage.ErrNotFound
+	}
+
+	s.trials[t.UID] = t
+
+	return nil
+}
+
+func (s *Storage) DeleteTrial(ctx context.Context, uid int64, oid domain.OrderID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.trials[uid]; ok && t.OrderID == oid {
+		delete(s.trials, uid)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetTrial(ctx context.Context, uid int64) (storage.Trial, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.Trial{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.trials[uid]
+	if !ok {
+		return storage.Trial{}, storage.ErrNotFound
+	}
+
+	return t, nil
+}
+
+func (s *Storage) MarkTrialReminded(ctx context.Context, uid int64, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.trials[uid]; ok {
+		t.RemindedAt = sql.NullTime{Time: at, Valid: true}
+		s.trials[uid] = t
+	}
+
+	return nil
+}
+
+// isTrial returns true if order is trial of its user, must be called under lock.
+func (s *Storage) isTrial(oid domain.OrderID) bool {
+	for _, t := range s.trials {
+		if t.OrderID == oid {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Storage) CreateTopUp(ctx context.Context, t storage.TopUp) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastTopUpID++
+
+	t.ID = s.lastTopUpID
+	t.Status = domain.TopUpStatusPending
+	s.topups[t.ID] = t
+
+	return t.ID, nil
+}
+
+func (s *Storage) ApproveTopUp(ctx context.Context, id int64, at time.Time) (storage.TopUp, error) {
+	return s.closeTopUp(ctx, id, domain.TopUpStatusApproved, at)
+}
+
+func (s *Storage) RejectTopUp(ctx context.Context, id int64, at time.Time) (storage.TopUp, error) {
+	return s.closeTopUp(ctx, id, domain.TopUpStatusRejected, at)
+}
+
+func (s *Storage) closeTopUp(ctx context.Context, id int64, status domain.TopUpStatus, at time.Time) (storage.TopUp, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.TopUp{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.topups[id]
+	if !ok || t.Status != domain.TopUpStatusPending {
+		return storage.TopUp{}, storage.ErrNotFound
+	}
+
+	t.Status = status
+	t.ClosedAt = sql.NullTime{Time: at, Valid: true}
+	s.topups[id] = t
+
+	if status == domain.TopUpStatusApproved {
+		s.ledger = append(s.ledger, storage.LedgerEntry{
+			UID:       t.UID,
+			Amount:    t.Amount,
+			Kind:      domain.LedgerTopUp,
+			CreatedAt: at,
+		})
+	}
+
+	return t, nil
+}
+
+func (s *Storage) SetAutoRenew(ctx context.Context, uid int64, enabled bool, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !enabled {
+		delete(s.autoRenew, uid)
+		return nil
+	}
+
+	if _, ok := s.autoRenew[uid]; !ok {
+		s.autoRenew[uid] = at
+	}
+
+	return nil
+}
+
+func (s *Storage) IsAutoRenew(ctx context.Context, uid int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.autoRenew[uid]
+
+	return ok, nil
+}
@@ -0,0 +1,15 @@
+package memstore_test
+
+import (
+	"testing"
+
+	"github.com/ysomad/outline-bot/internal/storage"
+	"github.com/ysomad/outline-bot/internal/storage/memstore"
+	"github.com/ysomad/outline-bot/internal/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return memstore.New()
+	})
+}
@@ -0,0 +1,536 @@
+// Package otelstore wraps storage.Storage with opentelemetry tracing, every call is traced in its own span.
+package otelstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const tracerName = "github.com/ysomad/outline-bot/internal/storage/otelstore"
+
+var _ storage.Storage = &Storage{}
+
+// Storage traces calls of wrapped storage, methods which are not overridden are called without tracing.
+type Storage struct {
+	storage.Storage
+	tracer trace.Tracer
+}
+
+func New(s storage.Storage, tp trace.TracerProvider) *Storage {
+	return &Storage{
+		Storage: s,
+		tracer:  tp.Tracer(tracerName),
+	}
+}
+
+func (s *Storage) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "storage."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+// end records err in span and ends it, not found is not an error of storage.
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func orderID(oid domain.OrderID) attribute.KeyValue {
+	return attribute.Int("order_id", int(oid))
+}
+
+func userID(uid int64) attribute.KeyValue {
+	return attribute.Int64("user_id", uid)
+}
+
+func ticketID(id int64) attribute.KeyValue {
+	return attribute.Int64("ticket_id", id)
+}
+
+func promoCode(code string) attribute.KeyValue {
+	return attribute.String("promo_code", code)
+}
+
+func topUpID(id int64) attribute.KeyValue {
+	return attribute.Int64("topup_id", id)
+}
+
+func (s *Storage) GetOrder(ctx context.Context, oid domain.OrderID) (o storage.Order, err error) {
+	ctx, span := s.start(ctx, "GetOrder", orderID(oid))
+	defer func() { end(span, err) }()
+	return s.Storage.GetOrder(ctx, oid)
+}
+
+func (s *Storage) CreateOrder(ctx context.Context, p storage.CreateOrderParams) (oid domain.OrderID, err error) {
+	ctx, span := s.start(ctx, "CreateOrder", userID(p.UID))
+	defer func() { end(span, err) }()
+
+	oid, err = s.Storage.CreateOrder(ctx, p)
+	span.SetAttributes(orderID(oid))
+
+	return oid, err
+}
+
+func (s *Storage) CloseOrder(ctx context.Context, oid domain.OrderID, status domain.OrderStatus, closedAt time.Time) (err error) {
+	ctx, span := s.start(ctx, "CloseOrder", orderID(oid), attribute.String("status", string(status)))
+	defer func() { end(span, err) }()
+	return s.Storage.CloseOrder(ctx, oid, status, closedAt)
+}
+
+func (s *Storage) RevokeOrder(ctx context.Context, oid domain.OrderID, at time.Time) (err error) {
+	ctx, span := s.start(ctx, "RevokeOrder", orderID(oid))
+	defer func() { end(span, err) }()
+	return s.Storage.RevokeOrder(ctx, oid, at)
+}
+
+func (s *Storage) CancelOrder(ctx context.Context, oid domain.OrderID, at time.Time) (err error) {
+	ctx, span := s.start(ctx, "CancelOrder", orderID(oid))
+	defer func() { end(span, err) }()
+	return s.Storage.CancelOrder(ctx, oid, at)
+}
+
+func (s *Storage) ApproveOrder(ctx context.Context, oid domain.OrderID, keys []storage.Key, expiresAt time.Time) (err error) {
+	ctx, span := s.start(ctx, "ApproveOrder", orderID(oid), attribute.Int("keys", len(keys)))
+	defer func() { end(span, err) }()
+	return s.Storage.ApproveOrder(ctx, oid, keys, expiresAt)
+}
+
+func (s *Storage) RenewOrder(ctx context.Context, oid domain.OrderID, expiresAt time.Time, exp time.Duration) (err error) {
+	ctx, span := s.start(ctx, "RenewOrder", orderID(oid))
+	defer func() { end(span, err) }()
+	return s.Storage.RenewOrder(ctx, oid, expiresAt, exp)
+}
+
+func (s *Storage) RenewOrderWithCredit(ctx context.Context, oid domain.OrderID, expiresAt time.Time, exp time.Duration, full bool, at time.Time) (_ int, err error) {
+	ctx, span := s.start(ctx, "RenewOrderWithCredit", orderID(oid), attribute.Bool("full", full))
+	defer func() { end(span, err) }()
+	return s.Storage.RenewOrderWithCredit(ctx, oid, expiresAt, exp, full, at)
+}
+
+func (s *Storage) ListActiveOrders(ctx context.Context, backend domain.Backend) (orders []storage.ActiveOrder, err error) {
+	ctx, span := s.start(ctx, "ListActiveOrders", attribute.String("backend", string(backend)))
+	defer func() { end(span, err) }()
+	return s.Storage.ListActiveOrders(ctx, backend)
+}
+
+func (s *Storage) ListActiveUserKeys(ctx context.Context, uid int64) (keys []storage.ActiveKey, err error) {
+	ctx, span := s.start(ctx, "ListActiveUserKeys", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.ListActiveUserKeys(ctx, uid)
+}
+
+func (s *Storage) CountActiveKeys(ctx context.Context, uid int64) (n uint8, err error) {
+	ctx, span := s.start(ctx, "CountActiveKeys", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.CountActiveKeys(ctx, uid)
+}
+
+func (s *Storage) ListExpiringKeys(ctx context.Context, exp time.Duration) (keys []storage.ExpiringKey, err error) {
+	ctx, span := s.start(ctx, "ListExpiringKeys")
+	defer func() { end(span, err) }()
+	return s.Storage.ListExpiringKeys(ctx, exp)
+}
+
+func (s *Storage) AllActiveKeys(ctx context.Context) (keys []storage.ActiveKey, err error) {
+	ctx, span := s.start(ctx, "AllActiveKeys")
+	defer func() { end(span, err) }()
+	return s.Storage.AllActiveKeys(ctx)
+}
+
+func (s *Storage) GetKeyPrefix(ctx context.Context, backend domain.Backend) (prefix string, err error) {
+	ctx, span := s.start(ctx, "GetKeyPrefix", attribute.String("backend", string(backend)))
+	defer func() { end(span, err) }()
+	return s.Storage.GetKeyPrefix(ctx, backend)
+}
+
+func (s *Storage) SaveKeyPrefix(ctx context.Context, backend domain.Backend, prefix string, at time.Time) (err error) {
+	ctx, span := s.start(ctx, "SaveKeyPrefix", attribute.String("backend", string(backend)))
+	defer func() { end(span, err) }()
+	return s.Storage.SaveKeyPrefix(ctx, backend, prefix, at)
+}
+
+func (s *Storage) UpdateKeyURL(ctx context.Context, id, url string) (err error) {
+	ctx, span := s.start(ctx, "UpdateKeyURL", attribute.String("key_id", id))
+	defer func() { end(span, err) }()
+	return s.Storage.UpdateKeyURL(ctx, id, url)
+}
+
+func (s *Storage) AddOrderKeys(ctx context.Context, oid domain.OrderID, keys []storage.Key) (err error) {
+	ctx, span := s.start(ctx, "AddOrderKeys", orderID(oid), attribute.Int("keys", len(keys)))
+	defer func() { end(span, err) }()
+	return s.Storage.AddOrderKeys(ctx, oid, keys)
+}
+
+func (s *Storage) DeleteBackendKeys(ctx context.Context, backend domain.Backend) (err error) {
+	ctx, span := s.start(ctx, "DeleteBackendKeys", attribute.String("backend", string(backend)))
+	defer func() { end(span, err) }()
+	return s.Storage.DeleteBackendKeys(ctx, backend)
+}
+
+func (s *Storage) OrderStats(ctx context.Context, p storage.OrderStatsParams) (st storage.OrderStats, err error) {
+	ctx, span := s.start(ctx, "OrderStats")
+	defer func() { end(span, err) }()
+	return s.Storage.OrderStats(ctx, p)
+}
+
+func (s *Storage) RevenueByMonth(ctx context.Context, since time.Time) (months []storage.MonthRevenue, err error) {
+	ctx, span := s.start(ctx, "RevenueByMonth")
+	defer func() { end(span, err) }()
+	return s.Storage.RevenueByMonth(ctx, since)
+}
+
+func (s *Storage) ListOrders(ctx context.Context, p storage.ListOrdersParams) (orders []storage.Order, err error) {
+	ctx, span := s.start(ctx, "ListOrders", attribute.String("status", string(p.Status)), attribute.Int("offset", p.Offset))
+	defer func() { end(span, err) }()
+	return s.Storage.ListOrders(ctx, p)
+}
+
+func (s *Storage) CountOrders(ctx context.Context, status domain.OrderStatus) (n int, err error) {
+	ctx, span := s.start(ctx, "CountOrders", attribute.String("status", string(status)))
+	defer func() { end(span, err) }()
+	return s.Storage.CountOrders(ctx, status)
+}
+
+func (s *Storage) ListPendingOrders(ctx context.Context, createdBefore time.Time) (orders []storage.Order, err error) {
+	ctx, span := s.start(ctx, "ListPendingOrders")
+	defer func() { end(span, err) }()
+	return s.Storage.ListPendingOrders(ctx, createdBefore)
+}
+
+func (s *Storage) FindUID(ctx context.Context, username string) (uid int64, err error) {
+	ctx, span := s.start(ctx, "FindUID", attribute.String("username", username))
+	defer func() { end(span, err) }()
+	return s.Storage.FindUID(ctx, username)
+}
+
+func (s *Storage) ListUserOrders(ctx context.Context, uid int64) (orders []storage.Order, err error) {
+	ctx, span := s.start(ctx, "ListUserOrders", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.ListUserOrders(ctx, uid)
+}
+
+func (s *Storage) BanUser(ctx context.Context, uid int64, at time.Time) (err error) {
+	ctx, span := s.start(ctx, "BanUser", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.BanUser(ctx, uid, at)
+}
+
+func (s *Storage) UnbanUser(ctx context.Context, uid int64) (err error) {
+	ctx, span := s.start(ctx, "UnbanUser", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.UnbanUser(ctx, uid)
+}
+
+func (s *Storage) IsBanned(ctx context.Context, uid int64) (banned bool, err error) {
+	ctx, span := s.start(ctx, "IsBanned", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.IsBanned(ctx, uid)
+}
+
+func (s *Storage) SaveUser(ctx context.Context, uid int64, at time.Time) (err error) {
+	ctx, span := s.start(ctx, "SaveUser", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.SaveUser(ctx, uid, at)
+}
+
+func (s *Storage) ListUserIDs(ctx context.Context, seg storage.UserSegment) (uids []int64, err error) {
+	ctx, span := s.start(ctx, "ListUserIDs",
+		attribute.Bool("active", seg.Active),
+		attribute.String("backend", string(seg.Backend)),
+		attribute.Bool("expired", !seg.ExpiredSince.IsZero()))
+	defer func() { end(span, err) }()
+	return s.Storage.ListUserIDs(ctx, seg)
+}
+
+func (s *Storage) GetAdmin(ctx context.Context, uid int64) (a storage.Admin, err error) {
+	ctx, span := s.start(ctx, "GetAdmin", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.GetAdmin(ctx, uid)
+}
+
+func (s *Storage) ListAdmins(ctx context.Context) (admins []storage.Admin, err error) {
+	ctx, span := s.start(ctx, "ListAdmins")
+	defer func() { end(span, err) }()
+	return s.Storage.ListAdmins(ctx)
+}
+
+func (s *Storage) SaveAdmin(ctx context.Context, a storage.Admin) (err error) {
+	ctx, span := s.start(ctx, "SaveAdmin", userID(a.UID), attribute.String("role", string(a.Role)))
+	defer func() { end(span, err) }()
+	return s.Storage.SaveAdmin(ctx, a)
+}
+
+func (s *Storage) DeleteAdmin(ctx context.Context, uid int64) (err error) {
+	ctx, span := s.start(ctx, "DeleteAdmin", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.DeleteAdmin(ctx, uid)
+}
+
+func (s *Storage) SaveAdminMessages(ctx context.Context, oid domain.OrderID, msgs []storage.AdminMessage) (err error) {
+	ctx, span := s.start(ctx, "SaveAdminMessages", orderID(oid), attribute.Int("messages", len(msgs)))
+	defer func() { end(span, err) }()
+	return s.Storage.SaveAdminMessages(ctx, oid, msgs)
+}
+
+func (s *Storage) PopAdminMessages(ctx context.Context, oid domain.OrderID) (msgs []storage.AdminMessage, err error) {
+	ctx, span := s.start(ctx, "PopAdminMessages", orderID(oid))
+	defer func() { end(span, err) }()
+	return s.Storage.PopAdminMessages(ctx, oid)
+}
+
+func (s *Storage) GetAdminTopic(ctx context.Context, key string) (threadID int, err error) {
+	ctx, span := s.start(ctx, "GetAdminTopic", attribute.String("topic", key))
+	defer func() { end(span, err) }()
+	return s.Storage.GetAdminTopic(ctx, key)
+}
+
+func (s *Storage) SaveAdminTopic(ctx context.Context, key string, threadID int) (err error) {
+	ctx, span := s.start(ctx, "SaveAdminTopic", attribute.String("topic", key))
+	defer func() { end(span, err) }()
+	return s.Storage.SaveAdminTopic(ctx, key, threadID)
+}
+
+func (s *Storage) CreateTicket(ctx context.Context, p storage.CreateTicketParams) (id int64, err error) {
+	ctx, span := s.start(ctx, "CreateTicket", userID(p.UID))
+	defer func() { end(span, err) }()
+	return s.Storage.CreateTicket(ctx, p)
+}
+
+func (s *Storage) GetTicket(ctx context.Context, id int64) (t storage.Ticket, err error) {
+	ctx, span := s.start(ctx, "GetTicket", ticketID(id))
+	defer func() { end(span, err) }()
+	return s.Storage.GetTicket(ctx, id)
+}
+
+func (s *Storage) GetUserTicket(ctx context.Context, uid int64) (t storage.Ticket, err error) {
+	ctx, span := s.start(ctx, "GetUserTicket", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.GetUserTicket(ctx, uid)
+}
+
+func (s *Storage) ListTickets(ctx context.Context, statuses []domain.TicketStatus, limit int) (tickets []storage.Ticket, err error) {
+	ctx, span := s.start(ctx, "ListTickets", attribute.Int("limit", limit))
+	defer func() { end(span, err) }()
+	return s.Storage.ListTickets(ctx, statuses, limit)
+}
+
+func (s *Storage) SetTicketStatus(ctx context.Context, id int64, status domain.TicketStatus, updatedAt time.Time) (err error) {
+	ctx, span := s.start(ctx, "SetTicketStatus", ticketID(id), attribute.String("status", string(status)))
+	defer func() { end(span, err) }()
+	return s.Storage.SetTicketStatus(ctx, id, status, updatedAt)
+}
+
+func (s *Storage) SaveTicketMessage(ctx context.Context, m storage.TicketMessage) (err error) {
+	ctx, span := s.start(ctx, "SaveTicketMessage", ticketID(m.TicketID), attribute.Bool("from_admin", m.FromAdmin))
+	defer func() { end(span, err) }()
+	return s.Storage.SaveTicketMessage(ctx, m)
+}
+
+func (s *Storage) ListTicketMessages(ctx context.Context, id int64) (msgs []storage.TicketMessage, err error) {
+	ctx, span := s.start(ctx, "ListTicketMessages", ticketID(id))
+	defer func() { end(span, err) }()
+	return s.Storage.ListTicketMessages(ctx, id)
+}
+
+func (s *Storage) SaveTicketRelays(ctx context.Context, id int64, msgs []storage.AdminMessage) (err error) {
+	ctx, span := s.start(ctx, "SaveTicketRelays", ticketID(id), attribute.Int("messages", len(msgs)))
+	defer func() { end(span, err) }()
+	return s.Storage.SaveTicketRelays(ctx, id, msgs)
+}
+
+func (s *Storage) FindTicketByRelay(ctx context.Context, m storage.AdminMessage) (id int64, err error) {
+	ctx, span := s.start(ctx, "FindTicketByRelay")
+	defer func() { end(span, err) }()
+	return s.Storage.FindTicketByRelay(ctx, m)
+}
+
+func (s *Storage) CreateInvite(ctx context.Context, inv storage.Invite, limit int) (err error) {
+	ctx, span := s.start(ctx, "CreateInvite", userID(inv.CreatedBy), attribute.Int("limit", limit))
+	defer func() { end(span, err) }()
+	return s.Storage.CreateInvite(ctx, inv, limit)
+}
+
+func (s *Storage) CountInvites(ctx context.Context, uid int64) (n int, err error) {
+	ctx, span := s.start(ctx, "CountInvites", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.CountInvites(ctx, uid)
+}
+
+func (s *Storage) UseInvite(ctx context.Context, code string, m storage.Member) (_ storage.Member, err error) {
+	ctx, span := s.start(ctx, "UseInvite", userID(m.UID))
+	defer func() { end(span, err) }()
+	return s.Storage.UseInvite(ctx, code, m)
+}
+
+func (s *Storage) GetMember(ctx context.Context, uid int64) (m storage.Member, err error) {
+	ctx, span := s.start(ctx, "GetMember", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.GetMember(ctx, uid)
+}
+
+func (s *Storage) SaveMember(ctx context.Context, m storage.Member) (err error) {
+	ctx, span := s.start(ctx, "SaveMember", userID(m.UID))
+	defer func() { end(span, err) }()
+	return s.Storage.SaveMember(ctx, m)
+}
+
+func (s *Storage) ListMembers(ctx context.Context) (members []storage.Member, err error) {
+	ctx, span := s.start(ctx, "ListMembers")
+	defer func() { end(span, err) }()
+	return s.Storage.ListMembers(ctx)
+}
+
+func (s *Storage) GetJoinRequest(ctx context.Context, uid int64) (r storage.JoinRequest, err error) {
+	ctx, span := s.start(ctx, "GetJoinRequest", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.GetJoinRequest(ctx, uid)
+}
+
+func (s *Storage) SaveJoinRequest(ctx context.Context, r storage.JoinRequest) (err error) {
+	ctx, span := s.start(ctx, "SaveJoinRequest", userID(r.UID), attribute.String("status", string(r.Status)))
+	defer func() { end(span, err) }()
+	return s.Storage.SaveJoinRequest(ctx, r)
+}
+
+func (s *Storage) DeleteJoinRequest(ctx context.Context, uid int64) (err error) {
+	ctx, span := s.start(ctx, "DeleteJoinRequest", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.DeleteJoinRequest(ctx, uid)
+}
+
+func (s *Storage) SaveReferral(ctx context.Context, r storage.Referral) (err error) {
+	ctx, span := s.start(ctx, "SaveReferral", userID(r.UID), attribute.Int64("referrer_id", r.ReferrerID))
+	defer func() { end(span, err) }()
+	return s.Storage.SaveReferral(ctx, r)
+}
+
+func (s *Storage) RewardReferral(ctx context.Context, uid int64, credit int, oid domain.OrderID, at time.Time) (_ storage.Referral, err error) {
+	ctx, span := s.start(ctx, "RewardReferral", userID(uid), orderID(oid), attribute.Int("credit", credit))
+	defer func() { end(span, err) }()
+	return s.Storage.RewardReferral(ctx, uid, credit, oid, at)
+}
+
+func (s *Storage) ListReferrals(ctx context.Context, referrerID int64) (referrals []storage.Referral, err error) {
+	ctx, span := s.start(ctx, "ListReferrals", attribute.Int64("referrer_id", referrerID))
+	defer func() { end(span, err) }()
+	return s.Storage.ListReferrals(ctx, referrerID)
+}
+
+func (s *Storage) AddLedgerEntry(ctx context.Context, e storage.LedgerEntry) (err error) {
+	ctx, span := s.start(ctx, "AddLedgerEntry", userID(e.UID), attribute.Int("amount", e.Amount), attribute.String("kind", string(e.Kind)))
+	defer func() { end(span, err) }()
+	return s.Storage.AddLedgerEntry(ctx, e)
+}
+
+func (s *Storage) GetBalance(ctx context.Context, uid int64) (balance int, err error) {
+	ctx, span := s.start(ctx, "GetBalance", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.GetBalance(ctx, uid)
+}
+
+func (s *Storage) ListLedgerEntries(ctx context.Context, uid int64, limit uint64) (entries []storage.LedgerEntry, err error) {
+	ctx, span := s.start(ctx, "ListLedgerEntries", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.ListLedgerEntries(ctx, uid, limit)
+}
+
+func (s *Storage) RefundOrder(ctx context.Context, oid domain.OrderID, amount int, at time.Time) (err error) {
+	ctx, span := s.start(ctx, "RefundOrder", orderID(oid), attribute.Int("amount", amount))
+	defer func() { end(span, err) }()
+	return s.Storage.RefundOrder(ctx, oid, amount, at)
+}
+
+func (s *Storage) CreatePromo(ctx context.Context, p storage.Promo) (err error) {
+	ctx, span := s.start(ctx, "CreatePromo", promoCode(p.Code))
+	defer func() { end(span, err) }()
+	return s.Storage.CreatePromo(ctx, p)
+}
+
+func (s *Storage) GetPromo(ctx context.Context, code string) (p storage.Promo, err error) {
+	ctx, span := s.start(ctx, "GetPromo", promoCode(code))
+	defer func() { end(span, err) }()
+	return s.Storage.GetPromo(ctx, code)
+}
+
+func (s *Storage) UsePromo(ctx context.Context, code string) (err error) {
+	ctx, span := s.start(ctx, "UsePromo", promoCode(code))
+	defer func() { end(span, err) }()
+	return s.Storage.UsePromo(ctx, code)
+}
+
+func (s *Storage) DisablePromo(ctx context.Context, code string) (err error) {
+	ctx, span := s.start(ctx, "DisablePromo", promoCode(code))
+	defer func() { end(span, err) }()
+	return s.Storage.DisablePromo(ctx, code)
+}
+
+func (s *Storage) ListPromos(ctx context.Context) (promos []storage.PromoStats, err error) {
+	ctx, span := s.start(ctx, "ListPromos")
+	defer func() { end(span, err) }()
+	return s.Storage.ListPromos(ctx)
+}
+
+func (s *Storage) CreateTrial(ctx context.Context, t storage.Trial) (err error) {
+	ctx, span := s.start(ctx, "CreateTrial", userID(t.UID), orderID(t.OrderID))
+	defer func() { end(span, err) }()
+	return s.Storage.CreateTrial(ctx, t)
+}
+
+func (s *Storage) DeleteTrial(ctx context.Context, uid int64, oid domain.OrderID) (err error) {
+	ctx, span := s.start(ctx, "DeleteTrial", userID(uid), orderID(oid))
+	defer func() { end(span, err) }()
+	return s.Storage.DeleteTrial(ctx, uid, oid)
+}
+
+func (s *Storage) GetTrial(ctx context.Context, uid int64) (_ storage.Trial, err error) {
+	ctx, span := s.start(ctx, "GetTrial", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.GetTrial(ctx, uid)
+}
+
+func (s *Storage) MarkTrialReminded(ctx context.Context, uid int64, at time.Time) (err error) {
+	ctx, span := s.start(ctx, "MarkTrialReminded", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.MarkTrialReminded(ctx, uid, at)
+}
+
+func (s *Storage) CreateTopUp(ctx context.Context, t storage.TopUp) (_ int64, err error) {
+	ctx, span := s.start(ctx, "CreateTopUp", userID(t.UID), attribute.Int("amount", t.Amount))
+	defer func() { end(span, err) }()
+	return s.Storage.CreateTopUp(ctx, t)
+}
+
+func (s *Storage) ApproveTopUp(ctx context.Context, id int64, at time.Time) (_ storage.TopUp, err error) {
+	ctx, span := s.start(ctx, "ApproveTopUp", topUpID(id))
+	defer func() { end(span, err) }()
+	return s.Storage.ApproveTopUp(ctx, id, at)
+}
+
+func (s *Storage) RejectTopUp(ctx context.Context, id int64, at time.Time) (_ storage.TopUp, err error) {
+	ctx, span := s.start(ctx, "RejectTopUp", topUpID(id))
+	defer func() { end(span, err) }()
+	return s.Storage.RejectTopUp(ctx, id, at)
+}
+
+func (s *Storage) SetAutoRenew(ctx context.Context, uid int64, enabled bool, at time.Time) (err error) {
+	ctx, span := s.start(ctx, "SetAutoRenew", userID(uid), attribute.Bool("enabled", enabled))
+	defer func() { end(span, err) }()
+	return s.Storage.SetAutoRenew(ctx, uid, enabled, at)
+}
+
+func (s *Storage) IsAutoRenew(ctx context.Context, uid int64) (_ bool, err error) {
+	ctx, span := s.start(ctx, "IsAutoRenew", userID(uid))
+	defer func() { end(span, err) }()
+	return s.Storage.IsAutoRenew(ctx, uid)
+}
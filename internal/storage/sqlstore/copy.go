@@ -0,0 +1,238 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// copiedTables are tables copied from sqlite into postgres with their columns, in order of dependencies.
+// Sequence of serial id is moved after the last copied row.
+var copiedTables = []struct {
+	name     string
+	columns  string
+	orderBy  string
+	sequence string
+}{
+	{
+		name:     "orders",
+		columns:  "id, uid, username, first_name, last_name, key_amount, price, status, backend, closed_at, created_at, expires_at, promo_code, discount, bonus_days",
+		orderBy:  "id",
+		sequence: "orders_id_seq",
+	},
+	{
+		name:    "access_keys",
+		columns: "id, name, order_id, url",
+		orderBy: "order_id",
+	},
+	{
+		name:    "banned_users",
+		columns: "uid, banned_at",
+		orderBy: "uid",
+	},
+	{
+		name:    "admins",
+		columns: "uid, username, role, created_at",
+		orderBy: "uid",
+	},
+	{
+		name:    "admin_messages",
+		columns: "order_id, chat_id, message_id",
+		orderBy: "order_id",
+	},
+	{
+		name:    "admin_topics",
+		columns: "key, thread_id",
+		orderBy: "key",
+	},
+	{
+		name:     "tickets",
+		columns:  "id, uid, username, first_name, last_name, status, created_at, updated_at",
+		orderBy:  "id",
+		sequence: "tickets_id_seq",
+	},
+	{
+		name:     "ticket_messages",
+		columns:  "id, ticket_id, from_admin, text, created_at",
+		orderBy:  "id",
+		sequence: "ticket_messages_id_seq",
+	},
+	{
+		name:    "ticket_relays",
+		columns: "ticket_id, chat_id, message_id",
+		orderBy: "ticket_id",
+	},
+	{
+		name:    "invites",
+		columns: "code, created_by, created_at, used_by, used_at, slot",
+		orderBy: "created_at",
+	},
+	{
+		name:    "members",
+		columns: "uid, username, invited_by, joined_at",
+		orderBy: "uid",
+	},
+	{
+		name:    "join_requests",
+		columns: "uid, username, first_name, last_name, status, created_at",
+		orderBy: "uid",
+	},
+	{
+		name:    "referrals",
+		columns: "uid, referrer_id, created_at, rewarded_at",
+		orderBy: "uid",
+	},
+	{
+		name:     "ledger",
+		columns:  "id, uid, amount, kind, order_id, created_at",
+		orderBy:  "id",
+		sequence: "ledger_id_seq",
+	},
+	{
+		name:    "promos",
+		columns: "code, percent, amount, days, max_uses, uses, expires_at, first_order_only, disabled, created_at",
+		orderBy: "code",
+	},
+	{
+		name:    "trials",
+		columns: "uid, order_id, created_at, reminded_at",
+		orderBy: "uid",
+	},
+	{
+		name:     "topups",
+		columns:  "id, uid, username, first_name, last_name, amount, status, created_at, closed_at",
+		orderBy:  "id",
+		sequence: "topups_id_seq",
+	},
+	{
+		name:    "auto_renewals",
+		columns: "uid, enabled_at",
+		orderBy: "uid",
+	},
+	{
+		name:    "users",
+		columns: "uid, created_at",
+		orderBy: "uid",
+	},
+	{
+		name:    "key_prefixes",
+		columns: "backend, prefix, updated_at",
+		orderBy: "backend",
+	},
+}
+
+// CopySQLiteToPostgres copies all tables from sqlite database into migrated postgres database
+// in one transaction, id sequences are moved after the last copied rows.
+func CopySQLiteToPostgres(ctx context.Context, src, dst *sql.DB) error {
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("tx not started: %w", err)
+	}
+	defer tx.Rollback()
+
+	copied := make([]any, 0, len(copiedTables)*2)
+
+	for _, t := range copiedTables {
+		n, err := copyTable(ctx, src, tx, t.name, t.columns, t.orderBy)
+		if err != nil {
+			return err
+		}
+
+		copied = append(copied, t.name, n)
+
+		if t.sequence == "" {
+			continue
+		}
+
+		setval := fmt.Sprintf("SELECT setval('%s', (SELECT COALESCE(MAX(id), 0) + 1 FROM %s), false)", t.sequence, t.name)
+
+		if _, err := tx.ExecContext(ctx, setval); err != nil {
+			return fmt.Errorf("%s sequence not moved: %w", t.name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("tx commit: %w", err)
+	}
+
+	slog.Info("sqlite copied to postgres", copied...)
+
+	return nil
+}
+
+// maxParams is limit of bind parameters in one postgres statement.
+const maxParams = 65535
+
+// copyTable copies all rows of table from src into tx and returns amount of copied rows.
+// Rows are inserted in batches, so every statement fits into maxParams.
+func copyTable(ctx context.Context, src *sql.DB, tx *sql.Tx, table, columns, orderBy string) (int, error) {
+	rows, err := src.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", columns, table, orderBy))
+	if err != nil {
+		return 0, fmt.Errorf("%s not queried: %w", table, err)
+	}
+	defer rows.Close()
+
+	colNum := len(strings.Split(columns, ","))
+	batchSize := maxParams / colNum
+	batch := make([][]any, 0, batchSize)
+	count := 0
+
+	for rows.Next() {
+		values := make([]any, colNum)
+		dest := make([]any, colNum)
+
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return 0, fmt.Errorf("%s row not scanned: %w", table, err)
+		}
+
+		batch = append(batch, values)
+
+		if len(batch) == batchSize {
+			if err := insertBatch(ctx, tx, table, columns, batch); err != nil {
+				return 0, err
+			}
+
+			count += len(batch)
+			batch = batch[:0]
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("%s rows: %w", table, err)
+	}
+
+	if len(batch) > 0 {
+		if err := insertBatch(ctx, tx, table, columns, batch); err != nil {
+			return 0, err
+		}
+
+		count += len(batch)
+	}
+
+	return count, nil
+}
+
+func insertBatch(ctx context.Context, tx *sql.Tx, table, columns string, batch [][]any) error {
+	insert := Postgres.builder().Insert(table).Columns(columns)
+
+	for _, values := range batch {
+		insert = insert.Values(values...)
+	}
+
+	sql, args, err := insert.ToSql()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s not copied: %w", table, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package sqlstore
+
+import (
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Dialect describes sql database specific parts of queries.
+type Dialect struct {
+	// Name is value of DB_DRIVER, also used as goose dialect name.
+	Name string
+	// Driver is database/sql driver name.
+	Driver string
+
+	placeholder sq.PlaceholderFormat
+
+	// secondsUntil returns expression of seconds left until timestamp column.
+	secondsUntil func(col string) string
+	// addSeconds returns expression which adds seconds to timestamp column.
+	addSeconds func(col string, secs float64) string
+	// month returns expression of timestamp column month in format YYYY-MM.
+	month func(col string) string
+	// equalSeconds returns condition that timestamp column equals timestamp placeholder up to seconds.
+	equalSeconds func(col string) string
+	// forUpdate is suffix of select which locks selected rows until end of transaction,
+	// empty if first write of transaction locks whole database.
+	forUpdate string
+}
+
+var SQLite = Dialect{
+	Name:        "sqlite3",
+	Driver:      "sqlite3",
+	placeholder: sq.Question,
+	secondsUntil: func(col string) string {
+		return fmt.Sprintf("(JULIANDAY(%s) - JULIANDAY(current_timestamp)) * 24 * 60 * 60", col)
+	},
+	addSeconds: func(col string, secs float64) string {
+		return fmt.Sprintf("datetime(%s, '+%.f seconds')", col, secs)
+	},
+	month: func(col string) string {
+		return fmt.Sprintf("strftime('%%Y-%%m', %s)", col)
+	},
+	equalSeconds: func(col string) string {
+		return fmt.Sprintf("datetime(%s) = datetime(?)", col)
+	},
+}
+
+var Postgres = Dialect{
+	Name:        "postgres",
+	Driver:      "pgx",
+	placeholder: sq.Dollar,
+	secondsUntil: func(col string) string {
+		return fmt.Sprintf("EXTRACT(EPOCH FROM (%s - current_timestamp))", col)
+	},
+	addSeconds: func(col string, secs float64) string {
+		return fmt.Sprintf("%s + make_interval(secs => %.f)", col, secs)
+	},
+	month: func(col string) string {
+		return fmt.Sprintf("to_char(%s, 'YYYY-MM')", col)
+	},
+	equalSeconds: func(col string) string {
+		return fmt.Sprintf("date_trunc('second', %s) = date_trunc('second', ?::timestamptz)", col)
+	},
+	forUpdate: "FOR UPDATE",
+}
+
+func DialectByName(name string) (Dialect, error) {
+	switch name {
+	case SQLite.Name:
+		return SQLite, nil
+	case Postgres.Name:
+		return Postgres, nil
+	default:
+		return Dialect{}, fmt.Errorf("unsupported db driver %q", name)
+	}
+}
+
+func (d Dialect) builder() sq.StatementBuilderType {
+	return sq.StatementBuilder.PlaceholderFormat(d.placeholder)
+}
@@ -0,0 +1,2348 @@
+// Package sqlstore is storage.Storage implementation on top of sql database, see Dialect for supported ones.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+var _ storage.Storage = &Storage{}
+
+type Storage struct {
+	db      *sql.DB
+	sq      sq.StatementBuilderType
+	dialect Dialect
+}
+
+func New(db *sql.DB, d Dialect) *Storage {
+	return &Storage{
+		db:      db,
+		sq:      d.builder(),
+		dialect: d,
+	}
+}
+
+const orderColumns = "id, uid, username, first_name, last_name, key_amount, price, status, created_at, expires_at, backend, promo_code, discount, bonus_days"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanOrder(row scanner) (storage.Order, error) {
+	o := storage.Order{}
+	err := row.Scan(
+		&o.ID,
+		&o.UID,
+		&o.Username,
+		&o.FirstName,
+		&o.LastName,
+		&o.KeyAmount,
+		&o.Price,
+		&o.Status,
+		&o.CreatedAt,
+		&o.ExpiresAt,
+		&o.Backend,
+		&o.PromoCode,
+		&o.Discount,
+		&o.BonusDays,
+	)
+	return o, err
+}
+
+func (s *Storage) GetOrder(ctx context.Context, oid domain.OrderID) (storage.Order, error) {
+	query, args, err := s.sq.
+		Select(orderColumns).
+		From("orders").
+		Where(sq.Eq{"id": oid}).
+		ToSql()
+	if err != nil {
+		return storage.Order{}, err
+	}
+
+	o, err := scanOrder(s.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Order{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Order{}, err
+	}
+
+	return o, nil
+}
+
+func (s *Storage) CreateOrder(ctx context.Context, p storage.CreateOrderParams) (domain.OrderID, error) {
+	sql, args, err := s.sq.
+		Insert("orders").
+		Columns("uid, username, first_name, last_name, key_amount, price, created_at, status, backend, promo_code, discount, bonus_days").
+		Values(p.UID, p.Username, p.FirstName, p.LastName, p.KeyAmount, p.Price, p.CreatedAt.UTC(), p.Status, p.Backend,
+			sql.NullString{String: p.PromoCode, Valid: p.PromoCode != ""}, p.Discount, p.BonusDays).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	userSQL, userArgs, err := s.insertUser(p.UID, p.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("tx not started: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, userSQL, userArgs...); err != nil {
+		return 0, fmt.Errorf("user not saved: %w", err)
+	}
+
+	var id domain.OrderID
+
+	if err := tx.QueryRowContext(ctx, sql, args...).Scan(&id); err != nil {
+		return 0, fmt.Errorf("insert: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("tx commit: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) CloseOrder(ctx context.Context, oid domain.OrderID, status domain.OrderStatus, closedAt time.Time) error {
+	sql, args, err := s.sq.
+		Update("orders").
+		Set("closed_at", closedAt.UTC()).
+		Set("status", status).
+		Where(sq.Eq{"id": oid}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeOrder(ctx context.Context, oid domain.OrderID, at time.Time) error {
+	return s.closeOpenOrder(ctx, sq.Eq{"id": oid}, domain.OrderStatusRevoked, at)
+}
+
+func (s *Storage) CancelOrder(ctx context.Context, oid domain.OrderID, at time.Time) error {
+	return s.closeOpenOrder(ctx, sq.Eq{"id": oid, "status": domain.OrderStatusAwaitingPayment}, domain.OrderStatusCanceled, at)
+}
+
+// closeOpenOrder closes not closed order matching where, ErrNotFound if there is no such order.
+func (s *Storage) closeOpenOrder(ctx context.Context, where sq.Eq, status domain.OrderStatus, at time.Time) error {
+	sql, args, err := s.sq.
+		Update("orders").
+		Set("closed_at", at.UTC()).
+		Set("status", status).
+		Where(where).
+		Where(sq.Eq{"closed_at": nil}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	res, err := s.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// ApprovedOrder approves order awaiting payment and creates key for the order,
+// ErrNotFound if order is already approved or closed.
+func (s *Storage) ApproveOrder(ctx context.Context, oid domain.OrderID, keys []storage.Key, expiresAt time.Time) error {
+	sql1, args1, err := s.sq.
+		Update("orders").
+		Set("expires_at", expiresAt.UTC()).
+		Set("status", domain.OrderStatusApproved).
+		Where(sq.Eq{"id": oid, "status": domain.OrderStatusAwaitingPayment, "closed_at": nil}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("tx not started: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, sql1, args1...)
+	if err != nil {
+		return fmt.Errorf("order not approved: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+
+	// insert without values is invalid, order without keys is only approved
+	if len(keys) > 0 {
+		sql2, args2, err := s.insertKeys(oid, keys)
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.ExecContext(ctx, sql2, args2...); err != nil {
+			return fmt.Errorf("access keys not created: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("tx commit: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ListActiveUserKeys(ctx context.Context, uid int64) ([]storage.ActiveKey, error) {
+	sql, args, err := s.sq.
+		Select("ak.id, ak.url, o.expires_at, ak.name, o.id, o.price, o.uid, o.backend").
+		From("access_keys ak").
+		InnerJoin("orders o ON ak.order_id = o.id").
+		Where(sq.Eq{"o.uid": uid}).
+		Where("o.expires_at > current_timestamp").
+		Where(sq.Eq{"o.closed_at": nil}).
+		OrderBy("o.id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []storage.ActiveKey
+
+	for rows.Next() {
+		k := storage.ActiveKey{}
+
+		if err := rows.Scan(&k.ID, &k.URL, &k.ExpiresAt, &k.Name, &k.OrderID, &k.Price, &k.UID, &k.Backend); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) CountActiveKeys(ctx context.Context, uid int64) (uint8, error) {
+	sql, args, err := s.sq.
+		Select("count(*)").
+		From("access_keys ak").
+		InnerJoin("orders o on o.id = ak.order_id").
+		Where(sq.Eq{"o.uid": uid}).
+		Where("o.expires_at > current_timestamp").
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	row := s.db.QueryRowContext(ctx, sql, args...)
+
+	var count uint8
+
+	if err := row.Scan(&count); err != nil {
+		return 0, fmt.Errorf("scan: %w", err)
+	}
+
+	return count, nil
+}
+
+// ListExpiringKeys returns keys that expire in or less than exp.
+func (s *Storage) ListExpiringKeys(ctx context.Context, exp time.Duration) ([]storage.ExpiringKey, error) {
+	expiresIn := s.dialect.secondsUntil("o.expires_at")
+
+	sql, args, err := s.sq.
+		Select("ak.id, ak.name, ak.url, o.expires_at, o.id, o.key_amount, o.price",
+			"o.uid, o.username, o.first_name, o.last_name, o.backend",
+			expiresIn+" expires_in", "t.uid IS NOT NULL").
+		From("access_keys ak").
+		InnerJoin("orders o ON o.id = ak.order_id").
+		LeftJoin("trials t ON t.order_id = o.id").
+		Where(expiresIn+" <= ?", exp.Seconds()).
+		Where(sq.Eq{"closed_at": nil}).
+		OrderBy("expires_in").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug(sql, "args", args)
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		keys []storage.ExpiringKey
+		diff float64
+	)
+
+	for rows.Next() {
+		k := storage.ExpiringKey{}
+
+		err := rows.Scan(
+			&k.ID, &k.Name, &k.URL, &k.ExpiresAt, &k.OrderID,
+			&k.KeyAmount, &k.Price, &k.UID, &k.Username, &k.FirstName, &k.LastName,
+			&k.Backend, &diff, &k.Trial)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		k.ExpiresIn = time.Duration(diff) * time.Second
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RenewOrder prolongs expiration of open order by exp if the order still expires at expiresAt,
+// ErrNotFound if order is closed or already renewed.
+func (s *Storage) RenewOrder(ctx context.Context, oid domain.OrderID, expiresAt time.Time, exp time.Duration) error {
+	sql, args, err := s.sq.
+		Update("orders").
+		Set("expires_at", sq.Expr(s.dialect.addSeconds("expires_at", exp.Seconds()))).
+		Where(sq.Eq{"id": oid, "closed_at": nil}).
+		Where(s.dialect.equalSeconds("expires_at"), expiresAt.UTC()).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	res, err := s.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// RenewOrderWithCredit renews order like RenewOrder and pays its price with balance of its user in one transaction,
+// returns charged credit. If full, order is renewed only when balance covers the price, ErrInsufficientBalance otherwise.
+func (s *Storage) RenewOrderWithCredit(ctx context.Context, oid domain.OrderID, expiresAt time.Time, exp time.Duration, full bool, at time.Time) (int, error) {
+	query, args, err := s.sq.
+		Update("orders").
+		Set("expires_at", sq.Expr(s.dialect.addSeconds("expires_at", exp.Seconds()))).
+		Where(sq.Eq{"id": oid, "closed_at": nil}).
+		Where(s.dialect.equalSeconds("expires_at"), expiresAt.UTC()).
+		Suffix("RETURNING uid, price").
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("tx not started: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		uid   int64
+		price int
+	)
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&uid, &price)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("order not renewed: %w", err)
+	}
+
+	// concurrent renewals of other orders of the user wait until balance is charged
+	query, args, err = s.sq.
+		Select("uid").
+		From("users").
+		Where(sq.Eq{"uid": uid}).
+		Suffix(s.dialect.forUpdate).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return 0, fmt.Errorf("user not locked: %w", err)
+	}
+
+	query, args, err = s.sq.
+		Select("COALESCE(SUM(amount), 0)").
+		From("ledger").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	var balance int
+
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&balance); err != nil {
+		return 0, fmt.Errorf("balance not received: %w", err)
+	}
+
+	if full && balance < price {
+		return 0, storage.ErrInsufficientBalance
+	}
+
+	credit := max(min(balance, price), 0)
+
+	if credit > 0 {
+		query, args, err = s.insertLedgerEntry(storage.LedgerEntry{
+			UID:       uid,
+			Amount:    -credit,
+			Kind:      domain.LedgerRenewal,
+			OrderID:   oid,
+			CreatedAt: at,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("builder: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return 0, fmt.Errorf("credit not charged: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("tx not committed: %w", err)
+	}
+
+	return credit, nil
+}
+
+func (s *Storage) AllActiveKeys(ctx context.Context) ([]storage.ActiveKey, error) {
+	sql, args, err := s.sq.
+		Select("ak.id, ak.url, o.expires_at, ak.name, o.id, o.price, o.uid, o.backend").
+		From("access_keys ak").
+		InnerJoin("orders o ON ak.order_id = o.id").
+		Where("o.expires_at > current_timestamp").
+		Where(sq.Eq{"o.closed_at": nil}).
+		OrderBy("ak.id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []storage.ActiveKey
+
+	for rows.Next() {
+		k := storage.ActiveKey{}
+
+		if err := rows.Scan(&k.ID, &k.URL, &k.ExpiresAt, &k.Name, &k.OrderID, &k.Price, &k.UID, &k.Backend); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) UpdateKeyURL(ctx context.Context, id, url string) error {
+	sql, args, err := s.sq.
+		Update("access_keys").
+		Set("url", url).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetKeyPrefix(ctx context.Context, backend domain.Backend) (string, error) {
+	query, args, err := s.sq.
+		Select("prefix").
+		From("key_prefixes").
+		Where(sq.Eq{"backend": backend}).
+		ToSql()
+	if err != nil {
+		return "", fmt.Errorf("builder: %w", err)
+	}
+
+	var prefix string
+
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(&prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", storage.ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("scan: %w", err)
+	}
+
+	return prefix, nil
+}
+
+func (s *Storage) SaveKeyPrefix(ctx context.Context, backend domain.Backend, prefix string, at time.Time) error {
+	sql, args, err := s.sq.
+		Insert("key_prefixes").
+		Columns("backend, prefix, updated_at").
+		Values(backend, prefix, at.UTC()).
+		Suffix("ON CONFLICT (backend) DO UPDATE SET prefix = excluded.prefix, updated_at = excluded.updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+// ListActiveOrders returns approved orders which keys live on backend.
+func (s *Storage) ListActiveOrders(ctx context.Context, backend domain.Backend) ([]storage.ActiveOrder, error) {
+	sql, args, err := s.sq.
+		Select("id,key_amount,uid,expires_at").
+		From("orders").
+		Where(sq.Eq{"status": domain.OrderStatusApproved}).
+		Where(sq.Eq{"backend": backend}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("quiery: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []storage.ActiveOrder
+
+	for rows.Next() {
+		o := storage.ActiveOrder{}
+
+		if err := rows.Scan(&o.ID, &o.KeyAmount, &o.UID, &o.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		orders = append(orders, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return orders, nil
+}
+
+// DeleteBackendKeys deletes all keys of orders which keys live on backend.
+func (s *Storage) insertKeys(oid domain.OrderID, keys []storage.Key) (string, []any, error) {
+	b := s.sq.
+		Insert("access_keys").
+		Columns("id, name, url, order_id")
+
+	for _, k := range keys {
+		b = b.Values(k.ID, k.Name, k.URL, oid)
+	}
+
+	return b.ToSql()
+}
+
+func (s *Storage) AddOrderKeys(ctx context.Context, oid domain.OrderID, keys []storage.Key) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sql, args, err := s.insertKeys(oid, keys)
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteBackendKeys(ctx context.Context, backend domain.Backend) error {
+	sql, args, err := s.sq.
+		Delete("access_keys").
+		Where("order_id IN (SELECT id FROM orders WHERE backend = ?)", backend).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) OrderStats(ctx context.Context, p storage.OrderStatsParams) (storage.OrderStats, error) {
+	var (
+		now     = p.Now.UTC()
+		active  = "closed_at IS NULL AND expires_at > ?"
+		waiting = "closed_at IS NULL AND status = ?"
+		churned = "status = ? AND closed_at >= ?"
+		expires = "closed_at IS NULL AND expires_at > ? AND expires_at <= ?"
+	)
+
+	sql1, args1, err := s.sq.
+		Select().
+		Column(sq.Expr("COALESCE(SUM(CASE WHEN "+active+" THEN 1 ELSE 0 END), 0)", now)).
+		Column(sq.Expr("COALESCE(SUM(CASE WHEN "+active+" THEN price ELSE 0 END), 0)", now)).
+		Column(sq.Expr("COALESCE(SUM(CASE WHEN "+waiting+" THEN 1 ELSE 0 END), 0)", domain.OrderStatusAwaitingPayment)).
+		Column(sq.Expr("COALESCE(SUM(CASE WHEN "+waiting+" THEN price - discount ELSE 0 END), 0)", domain.OrderStatusAwaitingPayment)).
+		Column(sq.Expr("COALESCE(SUM(CASE WHEN "+churned+" THEN 1 ELSE 0 END), 0)", domain.OrderStatusExpired, p.ChurnedSince.UTC())).
+		Column(sq.Expr("COALESCE(SUM(CASE WHEN "+churned+" THEN price ELSE 0 END), 0)", domain.OrderStatusExpired, p.ChurnedSince.UTC())).
+		Column(sq.Expr("COALESCE(SUM(CASE WHEN "+expires+" THEN 1 ELSE 0 END), 0)", now, p.ExpiresBefore.UTC())).
+		Column(sq.Expr("COALESCE(SUM(CASE WHEN "+expires+" THEN price ELSE 0 END), 0)", now, p.ExpiresBefore.UTC())).
+		From("orders").
+		ToSql()
+	if err != nil {
+		return storage.OrderStats{}, fmt.Errorf("builder: %w", err)
+	}
+
+	st := storage.OrderStats{}
+
+	err = s.db.QueryRowContext(ctx, sql1, args1...).Scan(
+		&st.ActiveOrders, &st.MRR,
+		&st.AwaitingPayment, &st.AwaitingPaymentPrice,
+		&st.Churned, &st.ChurnedPrice,
+		&st.Expiring, &st.ExpiringPrice)
+	if err != nil {
+		return storage.OrderStats{}, fmt.Errorf("orders scan: %w", err)
+	}
+
+	sql2, args2, err := s.sq.
+		Select("count(*)").
+		From("access_keys ak").
+		InnerJoin("orders o ON o.id = ak.order_id").
+		Where(sq.Eq{"o.closed_at": nil}).
+		Where(sq.Gt{"o.expires_at": now}).
+		ToSql()
+	if err != nil {
+		return storage.OrderStats{}, fmt.Errorf("builder: %w", err)
+	}
+
+	if err = s.db.QueryRowContext(ctx, sql2, args2...).Scan(&st.ActiveKeys); err != nil {
+		return storage.OrderStats{}, fmt.Errorf("keys scan: %w", err)
+	}
+
+	return st, nil
+}
+
+// RevenueByMonth returns paid orders created since t grouped by month of creation, latest month first.
+func (s *Storage) RevenueByMonth(ctx context.Context, since time.Time) ([]storage.MonthRevenue, error) {
+	month := s.dialect.month("created_at")
+
+	// order is paid if it was approved at least once
+	sql, args, err := s.sq.
+		Select(month+" AS month", "count(*)", "COALESCE(SUM(price - discount), 0)").
+		From("orders").
+		Where(sq.NotEq{"expires_at": nil}).
+		Where(sq.GtOrEq{"created_at": since.UTC()}).
+		GroupBy(month).
+		OrderBy("month DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var months []storage.MonthRevenue
+
+	for rows.Next() {
+		m := storage.MonthRevenue{}
+
+		if err := rows.Scan(&m.Month, &m.Orders, &m.Revenue); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		months = append(months, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return months, nil
+}
+
+// ListOrders returns page of orders with status, or of all orders if status is empty, latest first.
+func (s *Storage) ListOrders(ctx context.Context, p storage.ListOrdersParams) ([]storage.Order, error) {
+	b := s.sq.
+		Select(orderColumns).
+		From("orders").
+		OrderBy("id DESC").
+		Limit(uint64(p.Limit)).
+		Offset(uint64(p.Offset))
+
+	if p.Status != "" {
+		b = b.Where(sq.Eq{"status": p.Status})
+	}
+
+	sql, args, err := b.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	return s.queryOrders(ctx, sql, args)
+}
+
+// CountOrders returns amount of orders with status, or of all orders if status is empty.
+func (s *Storage) CountOrders(ctx context.Context, status domain.OrderStatus) (int, error) {
+	b := s.sq.
+		Select("count(*)").
+		From("orders")
+
+	if status != "" {
+		b = b.Where(sq.Eq{"status": status})
+	}
+
+	sql, args, err := b.ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	var n int
+
+	if err := s.db.QueryRowContext(ctx, sql, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("scan: %w", err)
+	}
+
+	return n, nil
+}
+
+// ListPendingOrders returns orders awaiting payment created before t, oldest first.
+func (s *Storage) ListPendingOrders(ctx context.Context, createdBefore time.Time) ([]storage.Order, error) {
+	sql, args, err := s.sq.
+		Select(orderColumns).
+		From("orders").
+		Where(sq.Eq{"status": domain.OrderStatusAwaitingPayment}).
+		Where(sq.Eq{"closed_at": nil}).
+		Where(sq.LtOrEq{"created_at": createdBefore.UTC()}).
+		OrderBy("id").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	return s.queryOrders(ctx, sql, args)
+}
+
+// FindUID returns id of user by username from his latest order, ErrNotFound if there are no such orders.
+func (s *Storage) FindUID(ctx context.Context, username string) (int64, error) {
+	query, args, err := s.sq.
+		Select("uid").
+		From("orders").
+		Where("lower(username) = lower(?)", username).
+		OrderBy("id DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	var uid int64
+
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(&uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("scan: %w", err)
+	}
+
+	return uid, nil
+}
+
+// ListUserOrders returns all orders of user, latest first.
+func (s *Storage) ListUserOrders(ctx context.Context, uid int64) ([]storage.Order, error) {
+	sql, args, err := s.sq.
+		Select(orderColumns).
+		From("orders").
+		Where(sq.Eq{"uid": uid}).
+		OrderBy("id DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	return s.queryOrders(ctx, sql, args)
+}
+
+func (s *Storage) queryOrders(ctx context.Context, sql string, args []any) ([]storage.Order, error) {
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []storage.Order
+
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		orders = append(orders, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return orders, nil
+}
+
+func (s *Storage) BanUser(ctx context.Context, uid int64, at time.Time) error {
+	sql, args, err := s.sq.
+		Insert("banned_users").
+		Columns("uid, banned_at").
+		Values(uid, at.UTC()).
+		Suffix("ON CONFLICT (uid) DO NOTHING").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UnbanUser(ctx context.Context, uid int64) error {
+	sql, args, err := s.sq.
+		Delete("banned_users").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsBanned(ctx context.Context, uid int64) (bool, error) {
+	sql, args, err := s.sq.
+		Select("count(*)").
+		From("banned_users").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return false, fmt.Errorf("builder: %w", err)
+	}
+
+	var n int
+
+	if err := s.db.QueryRowContext(ctx, sql, args...).Scan(&n); err != nil {
+		return false, fmt.Errorf("scan: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+func (s *Storage) insertUser(uid int64, at time.Time) (string, []any, error) {
+	return s.sq.
+		Insert("users").
+		Columns("uid, created_at").
+		Values(uid, at.UTC()).
+		Suffix("ON CONFLICT (uid) DO NOTHING").
+		ToSql()
+}
+
+func (s *Storage) SaveUser(ctx context.Context, uid int64, at time.Time) error {
+	sql, args, err := s.insertUser(uid, at)
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ListUserIDs(ctx context.Context, seg storage.UserSegment) ([]int64, error) {
+	now := seg.Now.UTC()
+
+	// segments are selected by orders, everyone else is in users
+	from := "users"
+	if seg.Active || seg.Backend != "" || !seg.ExpiredSince.IsZero() {
+		from = "orders"
+	}
+
+	b := s.sq.
+		Select("DISTINCT uid").
+		From(from).
+		Where("uid NOT IN (SELECT uid FROM banned_users)").
+		OrderBy("uid")
+
+	if seg.Active || seg.Backend != "" {
+		b = b.Where(sq.Eq{"closed_at": nil}).Where(sq.Gt{"expires_at": now})
+	}
+
+	if seg.Backend != "" {
+		b = b.Where(sq.Eq{"backend": seg.Backend})
+	}
+
+	if !seg.ExpiredSince.IsZero() {
+		b = b.
+			Where(sq.Eq{"status": domain.OrderStatusExpired}).
+			Where(sq.GtOrEq{"closed_at": seg.ExpiredSince.UTC()}).
+			Where("uid NOT IN (SELECT uid FROM orders WHERE closed_at IS NULL AND expires_at > ?)", now)
+	}
+
+	sql, args, err := b.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var uids []int64
+
+	for rows.Next() {
+		var uid int64
+
+		if err := rows.Scan(&uid); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		uids = append(uids, uid)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return uids, nil
+}
+
+func (s *Storage) GetAdmin(ctx context.Context, uid int64) (storage.Admin, error) {
+	query, args, err := s.sq.
+		Select("uid, username, role, created_at").
+		From("admins").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return storage.Admin{}, fmt.Errorf("builder: %w", err)
+	}
+
+	a, err := scanAdmin(s.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Admin{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Admin{}, fmt.Errorf("scan: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Storage) ListAdmins(ctx context.Context) ([]storage.Admin, error) {
+	sql, args, err := s.sq.
+		Select("uid, username, role, created_at").
+		From("admins").
+		OrderBy("created_at, uid").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var admins []storage.Admin
+
+	for rows.Next() {
+		a, err := scanAdmin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		admins = append(admins, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return admins, nil
+}
+
+func scanAdmin(row scanner) (storage.Admin, error) {
+	var (
+		a        storage.Admin
+		username sql.NullString
+	)
+
+	err := row.Scan(&a.UID, &username, &a.Role, &a.CreatedAt)
+	a.Username = username.String
+
+	return a, err
+}
+
+// SaveAdmin creates admin or changes role and username of existing one.
+func (s *Storage) SaveAdmin(ctx context.Context, a storage.Admin) error {
+	sql, args, err := s.sq.
+		Insert("admins").
+		Columns("uid, username, role, created_at").
+		Values(a.UID, a.Username, a.Role, a.CreatedAt.UTC()).
+		Suffix("ON CONFLICT (uid) DO UPDATE SET role = excluded.role, username = COALESCE(NULLIF(excluded.username, ''), admins.username)").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteAdmin(ctx context.Context, uid int64) error {
+	sql, args, err := s.sq.
+		Delete("admins").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+// SaveAdminMessages remembers messages about order sent to admins, to edit them all when order is processed.
+func (s *Storage) SaveAdminMessages(ctx context.Context, oid domain.OrderID, msgs []storage.AdminMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	b := s.sq.
+		Insert("admin_messages").
+		Columns("order_id, chat_id, message_id")
+
+	for _, m := range msgs {
+		b = b.Values(oid, m.ChatID, m.MessageID)
+	}
+
+	sql, args, err := b.ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+// PopAdminMessages returns messages about order and forgets them.
+func (s *Storage) PopAdminMessages(ctx context.Context, oid domain.OrderID) ([]storage.AdminMessage, error) {
+	sql, args, err := s.sq.
+		Delete("admin_messages").
+		Where(sq.Eq{"order_id": oid}).
+		Suffix("RETURNING chat_id, message_id").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var msgs []storage.AdminMessage
+
+	for rows.Next() {
+		m := storage.AdminMessage{}
+
+		if err := rows.Scan(&m.ChatID, &m.MessageID); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return msgs, nil
+}
+
+func (s *Storage) GetAdminTopic(ctx context.Context, key string) (int, error) {
+	query, args, err := s.sq.
+		Select("thread_id").
+		From("admin_topics").
+		Where(sq.Eq{"key": key}).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	var threadID int
+
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(&threadID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("scan: %w", err)
+	}
+
+	return threadID, nil
+}
+
+func (s *Storage) SaveAdminTopic(ctx context.Context, key string, threadID int) error {
+	sql, args, err := s.sq.
+		Insert("admin_topics").
+		Columns("key, thread_id").
+		Values(key, threadID).
+		Suffix("ON CONFLICT (key) DO UPDATE SET thread_id = excluded.thread_id").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+const ticketColumns = "id, uid, username, first_name, last_name, status, created_at, updated_at"
+
+func scanTicket(row scanner) (storage.Ticket, error) {
+	var (
+		t                             storage.Ticket
+		username, firstName, lastName sql.NullString
+	)
+
+	err := row.Scan(&t.ID, &t.UID, &username, &firstName, &lastName, &t.Status, &t.CreatedAt, &t.UpdatedAt)
+
+	t.Username = username.String
+	t.FirstName = firstName.String
+	t.LastName = lastName.String
+
+	return t, err
+}
+
+func (s *Storage) CreateTicket(ctx context.Context, p storage.CreateTicketParams) (int64, error) {
+	sql, args, err := s.sq.
+		Insert("tickets").
+		Columns("uid, username, first_name, last_name, status, created_at, updated_at").
+		Values(p.UID, p.Username, p.FirstName, p.LastName, domain.TicketStatusOpen, p.CreatedAt.UTC(), p.CreatedAt.UTC()).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	var id int64
+
+	if err := s.db.QueryRowContext(ctx, sql, args...).Scan(&id); err != nil {
+		return 0, fmt.Errorf("insert: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) GetTicket(ctx context.Context, id int64) (storage.Ticket, error) {
+	return s.getTicket(ctx, sq.Eq{"id": id})
+}
+
+// GetUserTicket returns not closed ticket of user, ErrNotFound if user has no such ticket.
+func (s *Storage) GetUserTicket(ctx context.Context, uid int64) (storage.Ticket, error) {
+	return s.getTicket(ctx, sq.And{
+		sq.Eq{"uid": uid},
+		sq.NotEq{"status": domain.TicketStatusClosed},
+	})
+}
+
+func (s *Storage) getTicket(ctx context.Context, where sq.Sqlizer) (storage.Ticket, error) {
+	query, args, err := s.sq.
+		Select(ticketColumns).
+		From("tickets").
+		Where(where).
+		OrderBy("id DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return storage.Ticket{}, fmt.Errorf("builder: %w", err)
+	}
+
+	t, err := scanTicket(s.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Ticket{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Ticket{}, fmt.Errorf("scan: %w", err)
+	}
+
+	return t, nil
+}
+
+// ListTickets returns tickets with statuses, recently updated first.
+func (s *Storage) ListTickets(ctx context.Context, statuses []domain.TicketStatus, limit int) ([]storage.Ticket, error) {
+	sql, args, err := s.sq.
+		Select(ticketColumns).
+		From("tickets").
+		Where(sq.Eq{"status": statuses}).
+		OrderBy("updated_at DESC, id DESC").
+		Limit(uint64(limit)).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var tickets []storage.Ticket
+
+	for rows.Next() {
+		t, err := scanTicket(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		tickets = append(tickets, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return tickets, nil
+}
+
+func (s *Storage) SetTicketStatus(ctx context.Context, id int64, status domain.TicketStatus, updatedAt time.Time) error {
+	sql, args, err := s.sq.
+		Update("tickets").
+		Set("status", status).
+		Set("updated_at", updatedAt.UTC()).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveTicketMessage(ctx context.Context, m storage.TicketMessage) error {
+	sql, args, err := s.sq.
+		Insert("ticket_messages").
+		Columns("ticket_id, from_admin, text, created_at").
+		Values(m.TicketID, m.FromAdmin, m.Text, m.CreatedAt.UTC()).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+// ListTicketMessages returns messages of ticket, oldest first.
+func (s *Storage) ListTicketMessages(ctx context.Context, id int64) ([]storage.TicketMessage, error) {
+	sql, args, err := s.sq.
+		Select("ticket_id, from_admin, text, created_at").
+		From("ticket_messages").
+		Where(sq.Eq{"ticket_id": id}).
+		OrderBy("id").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var msgs []storage.TicketMessage
+
+	for rows.Next() {
+		m := storage.TicketMessage{}
+
+		if err := rows.Scan(&m.TicketID, &m.FromAdmin, &m.Text, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return msgs, nil
+}
+
+// SaveTicketRelays remembers messages ticket is relayed to admins with, so admin reply to them reaches user.
+func (s *Storage) SaveTicketRelays(ctx context.Context, id int64, msgs []storage.AdminMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	b := s.sq.
+		Insert("ticket_relays").
+		Columns("ticket_id, chat_id, message_id")
+
+	for _, m := range msgs {
+		b = b.Values(id, m.ChatID, m.MessageID)
+	}
+
+	sql, args, err := b.ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+// FindTicketByRelay returns id of ticket relayed with message, ErrNotFound if message is not a relay.
+func (s *Storage) FindTicketByRelay(ctx context.Context, m storage.AdminMessage) (int64, error) {
+	query, args, err := s.sq.
+		Select("ticket_id").
+		From("ticket_relays").
+		Where(sq.Eq{"chat_id": m.ChatID, "message_id": m.MessageID}).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	var id int64
+
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("scan: %w", err)
+	}
+
+	return id, nil
+}
+
+// CreateInvite creates invite if its creator created less than limit invites, ErrNotFound if limit is reached.
+// Every invite takes next slot of its creator and slots are unique, so concurrent invites can't exceed the limit.
+func (s *Storage) CreateInvite(ctx context.Context, inv storage.Invite, limit int) error {
+	created, err := s.CountInvites(ctx, inv.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	if limit > 0 && created >= limit {
+		return storage.ErrNotFound
+	}
+
+	sql, args, err := s.sq.
+		Insert("invites").
+		Columns("code, created_by, created_at, slot").
+		Values(inv.Code, inv.CreatedBy, inv.CreatedAt.UTC(), created).
+		Suffix("ON CONFLICT (created_by, slot) DO NOTHING").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	res, err := s.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+
+	// slot is taken by concurrent invite
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// CountInvites returns amount of invites created by user.
+func (s *Storage) CountInvites(ctx context.Context, uid int64) (int, error) {
+	query, args, err := s.sq.
+		Select("count(*)").
+		From("invites").
+		Where(sq.Eq{"created_by": uid}).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	var n int
+
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("scan: %w", err)
+	}
+
+	return n, nil
+}
+
+// UseInvite makes user member invited by creator of invite, ErrNotFound if code doesn't exist or is already used.
+func (s *Storage) UseInvite(ctx context.Context, code string, m storage.Member) (storage.Member, error) {
+	query, args, err := s.sq.
+		Update("invites").
+		Set("used_by", m.UID).
+		Set("used_at", m.JoinedAt.UTC()).
+		Where(sq.Eq{"code": code, "used_by": nil}).
+		Suffix("RETURNING created_by").
+		ToSql()
+	if err != nil {
+		return storage.Member{}, fmt.Errorf("builder: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Member{}, fmt.Errorf("tx not started: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&m.InvitedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Member{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Member{}, fmt.Errorf("invite not used: %w", err)
+	}
+
+	query, args, err = s.insertMember(m)
+	if err != nil {
+		return storage.Member{}, fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return storage.Member{}, fmt.Errorf("member not saved: %w", err)
+	}
+
+	query, args, err = s.insertUser(m.UID, m.JoinedAt)
+	if err != nil {
+		return storage.Member{}, fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return storage.Member{}, fmt.Errorf("user not saved: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return storage.Member{}, fmt.Errorf("tx commit: %w", err)
+	}
+
+	return m, nil
+}
+
+func (s *Storage) insertMember(m storage.Member) (string, []any, error) {
+	return s.sq.
+		Insert("members").
+		Columns("uid, username, invited_by, joined_at").
+		Values(m.UID, m.Username, m.InvitedBy, m.JoinedAt.UTC()).
+		Suffix("ON CONFLICT (uid) DO NOTHING").
+		ToSql()
+}
+
+func (s *Storage) GetMember(ctx context.Context, uid int64) (storage.Member, error) {
+	query, args, err := s.sq.
+		Select("uid, username, invited_by, joined_at").
+		From("members").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return storage.Member{}, fmt.Errorf("builder: %w", err)
+	}
+
+	m, err := scanMember(s.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Member{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Member{}, fmt.Errorf("scan: %w", err)
+	}
+
+	return m, nil
+}
+
+// SaveMember makes user member, existing member is not changed.
+func (s *Storage) SaveMember(ctx context.Context, m storage.Member) error {
+	memberSQL, memberArgs, err := s.insertMember(m)
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	userSQL, userArgs, err := s.insertUser(m.UID, m.JoinedAt)
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("tx not started: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, memberSQL, memberArgs...); err != nil {
+		return fmt.Errorf("member not saved: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, userSQL, userArgs...); err != nil {
+		return fmt.Errorf("user not saved: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("tx commit: %w", err)
+	}
+
+	return nil
+}
+
+// ListMembers returns all members sorted by date of joining.
+func (s *Storage) ListMembers(ctx context.Context) ([]storage.Member, error) {
+	sql, args, err := s.sq.
+		Select("uid, username, invited_by, joined_at").
+		From("members").
+		OrderBy("joined_at, uid").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var members []storage.Member
+
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		members = append(members, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return members, nil
+}
+
+func scanMember(row scanner) (storage.Member, error) {
+	var (
+		m        storage.Member
+		username sql.NullString
+	)
+
+	err := row.Scan(&m.UID, &username, &m.InvitedBy, &m.JoinedAt)
+	m.Username = username.String
+
+	return m, err
+}
+
+func (s *Storage) GetJoinRequest(ctx context.Context, uid int64) (storage.JoinRequest, error) {
+	query, args, err := s.sq.
+		Select("uid, username, first_name, last_name, status, created_at").
+		From("join_requests").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return storage.JoinRequest{}, fmt.Errorf("builder: %w", err)
+	}
+
+	var (
+		r                             storage.JoinRequest
+		username, firstName, lastName sql.NullString
+	)
+
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(&r.UID, &username, &firstName, &lastName, &r.Status, &r.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.JoinRequest{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.JoinRequest{}, fmt.Errorf("scan: %w", err)
+	}
+
+	r.Username = username.String
+	r.FirstName = firstName.String
+	r.LastName = lastName.String
+
+	return r, nil
+}
+
+// SaveJoinRequest creates request or changes status of existing one.
+func (s *Storage) SaveJoinRequest(ctx context.Context, r storage.JoinRequest) error {
+	sql, args, err := s.sq.
+		Insert("join_requests").
+		Columns("uid, username, first_name, last_name, status, created_at").
+		Values(r.UID, r.Username, r.FirstName, r.LastName, r.Status, r.CreatedAt.UTC()).
+		Suffix("ON CONFLICT (uid) DO UPDATE SET status = excluded.status").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteJoinRequest(ctx context.Context, uid int64) error {
+	sql, args, err := s.sq.
+		Delete("join_requests").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+// SaveReferral remembers who referred user, existing referral is not changed.
+func (s *Storage) SaveReferral(ctx context.Context, r storage.Referral) error {
+	sql, args, err := s.sq.
+		Insert("referrals").
+		Columns("uid, referrer_id, created_at").
+		Values(r.UID, r.ReferrerID, r.CreatedAt.UTC()).
+		Suffix("ON CONFLICT (uid) DO NOTHING").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+// RewardReferral marks referral of user rewarded and adds credit for order to balance of referrer,
+// ErrNotFound if user is not referred or referral is already rewarded.
+func (s *Storage) RewardReferral(ctx context.Context, uid int64, credit int, oid domain.OrderID, at time.Time) (storage.Referral, error) {
+	query, args, err := s.sq.
+		Update("referrals").
+		Set("rewarded_at", at.UTC()).
+		Where(sq.Eq{"uid": uid, "rewarded_at": nil}).
+		Suffix("RETURNING referrer_id, created_at").
+		ToSql()
+	if err != nil {
+		return storage.Referral{}, fmt.Errorf("builder: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Referral{}, fmt.Errorf("tx not started: %w", err)
+	}
+	defer tx.Rollback()
+
+	r := storage.Referral{
+		UID:        uid,
+		RewardedAt: sql.NullTime{Time: at, Valid: true},
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&r.ReferrerID, &r.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Referral{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Referral{}, fmt.Errorf("referral not rewarded: %w", err)
+	}
+
+	query, args, err = s.insertLedgerEntry(storage.LedgerEntry{
+		UID:       r.ReferrerID,
+		Amount:    credit,
+		Kind:      domain.LedgerReferral,
+		OrderID:   oid,
+		CreatedAt: at,
+	})
+	if err != nil {
+		return storage.Referral{}, fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return storage.Referral{}, fmt.Errorf("ledger entry not added: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return storage.Referral{}, fmt.Errorf("tx commit: %w", err)
+	}
+
+	return r, nil
+}
+
+// ListReferrals returns users referred by user, oldest first.
+func (s *Storage) ListReferrals(ctx context.Context, referrerID int64) ([]storage.Referral, error) {
+	sql, args, err := s.sq.
+		Select("uid, referrer_id, created_at, rewarded_at").
+		From("referrals").
+		Where(sq.Eq{"referrer_id": referrerID}).
+		OrderBy("created_at, uid").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var referrals []storage.Referral
+
+	for rows.Next() {
+		r := storage.Referral{}
+
+		if err := rows.Scan(&r.UID, &r.ReferrerID, &r.CreatedAt, &r.RewardedAt); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		referrals = append(referrals, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return referrals, nil
+}
+
+func (s *Storage) insertLedgerEntry(e storage.LedgerEntry) (string, []any, error) {
+	oid := sql.NullInt32{Int32: int32(e.OrderID), Valid: e.OrderID != 0}
+
+	return s.sq.
+		Insert("ledger").
+		Columns("uid, amount, kind, order_id, created_at").
+		Values(e.UID, e.Amount, e.Kind, oid, e.CreatedAt.UTC()).
+		ToSql()
+}
+
+func (s *Storage) AddLedgerEntry(ctx context.Context, e storage.LedgerEntry) error {
+	sql, args, err := s.insertLedgerEntry(e)
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetBalance(ctx context.Context, uid int64) (int, error) {
+	query, args, err := s.sq.
+		Select("COALESCE(SUM(amount), 0)").
+		From("ledger").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	var balance int
+
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&balance); err != nil {
+		return 0, fmt.Errorf("scan: %w", err)
+	}
+
+	return balance, nil
+}
+
+// ListLedgerEntries returns latest entries of user, latest first.
+func (s *Storage) ListLedgerEntries(ctx context.Context, uid int64, limit uint64) ([]storage.LedgerEntry, error) {
+	query, args, err := s.sq.
+		Select("uid, amount, kind, order_id, created_at").
+		From("ledger").
+		Where(sq.Eq{"uid": uid}).
+		OrderBy("id DESC").
+		Limit(limit).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []storage.LedgerEntry
+
+	for rows.Next() {
+		var (
+			e   storage.LedgerEntry
+			oid sql.NullInt32
+		)
+
+		if err := rows.Scan(&e.UID, &e.Amount, &e.Kind, &oid, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		e.OrderID = domain.OrderID(oid.Int32)
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return entries, nil
+}
+
+// RefundOrder closes open order as refunded and credits amount to balance of its user,
+// ErrNotFound if order is already closed.
+func (s *Storage) RefundOrder(ctx context.Context, oid domain.OrderID, amount int, at time.Time) error {
+	query, args, err := s.sq.
+		Update("orders").
+		Set("closed_at", at.UTC()).
+		Set("status", domain.OrderStatusRefunded).
+		Where(sq.Eq{"id": oid, "closed_at": nil}).
+		Suffix("RETURNING uid").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("tx not started: %w", err)
+	}
+	defer tx.Rollback()
+
+	var uid int64
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("order not closed: %w", err)
+	}
+
+	if amount > 0 {
+		query, args, err = s.insertLedgerEntry(storage.LedgerEntry{
+			UID:       uid,
+			Amount:    amount,
+			Kind:      domain.LedgerRefund,
+			OrderID:   oid,
+			CreatedAt: at,
+		})
+		if err != nil {
+			return fmt.Errorf("builder: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("ledger entry not added: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("tx commit: %w", err)
+	}
+
+	return nil
+}
+
+const promoColumns = "code, percent, amount, days, max_uses, uses, expires_at, first_order_only, disabled, created_at"
+
+func scanPromo(row scanner, dest ...any) (storage.Promo, error) {
+	p := storage.Promo{}
+
+	err := row.Scan(append([]any{
+		&p.Code,
+		&p.Percent,
+		&p.Amount,
+		&p.Days,
+		&p.MaxUses,
+		&p.Uses,
+		&p.ExpiresAt,
+		&p.FirstOrderOnly,
+		&p.Disabled,
+		&p.CreatedAt,
+	}, dest...)...)
+
+	return p, err
+}
+
+func (s *Storage) CreatePromo(ctx context.Context, p storage.Promo) error {
+	expiresAt := sql.NullTime{Time: p.ExpiresAt.Time.UTC(), Valid: p.ExpiresAt.Valid}
+
+	sql, args, err := s.sq.
+		Insert("promos").
+		Columns("code, percent, amount, days, max_uses, expires_at, first_order_only, created_at").
+		Values(p.Code, p.Percent, p.Amount, p.Days, p.MaxUses, expiresAt, p.FirstOrderOnly, p.CreatedAt.UTC()).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetPromo(ctx context.Context, code string) (storage.Promo, error) {
+	query, args, err := s.sq.
+		Select(promoColumns).
+		From("promos").
+		Where(sq.Eq{"code": code}).
+		ToSql()
+	if err != nil {
+		return storage.Promo{}, fmt.Errorf("builder: %w", err)
+	}
+
+	p, err := scanPromo(s.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Promo{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Promo{}, fmt.Errorf("scan: %w", err)
+	}
+
+	return p, nil
+}
+
+// UsePromo counts use of promo, ErrNotFound if promo doesn't exist, is disabled or all its uses are spent.
+func (s *Storage) UsePromo(ctx context.Context, code string) error {
+	sql, args, err := s.sq.
+		Update("promos").
+		Set("uses", sq.Expr("uses + 1")).
+		Where(sq.Eq{"code": code, "disabled": false}).
+		Where("(max_uses = 0 OR uses < max_uses)").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	res, err := s.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Storage) DisablePromo(ctx context.Context, code string) error {
+	sql, args, err := s.sq.
+		Update("promos").
+		Set("disabled", true).
+		Where(sq.Eq{"code": code}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+// ListPromos returns promos with usage stats, latest first.
+func (s *Storage) ListPromos(ctx context.Context) ([]storage.PromoStats, error) {
+	// order is paid if it was approved at least once
+	sql, args, err := s.sq.
+		Select(promoColumns).
+		Column("(SELECT count(*) FROM orders o WHERE o.promo_code = promos.code AND o.expires_at IS NOT NULL)").
+		Column("(SELECT COALESCE(SUM(o.discount), 0) FROM orders o WHERE o.promo_code = promos.code AND o.expires_at IS NOT NULL)").
+		From("promos").
+		OrderBy("created_at DESC, code").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("builder: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var promos []storage.PromoStats
+
+	for rows.Next() {
+		st := storage.PromoStats{}
+
+		st.Promo, err = scanPromo(rows, &st.PaidOrders, &st.Discount)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		promos = append(promos, st)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return promos, nil
+}
+
+// CreateTrial returns ErrNotFound if user already has trial.
+func (s *Storage) CreateTrial(ctx context.Context, t storage.Trial) error {
+	sql, args, err := s.sq.
+		Insert("trials").
+		Columns("uid, order_id, created_at").
+		Values(t.UID, t.OrderID, t.CreatedAt.UTC()).
+		Suffix("ON CONFLICT (uid) DO NOTHING").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	res, err := s.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteTrial forgets trial of order which was not provisioned, so user may start it again.
+func (s *Storage) DeleteTrial(ctx context.Context, uid int64, oid domain.OrderID) error {
+	sql, args, err := s.sq.
+		Delete("trials").
+		Where(sq.Eq{"uid": uid, "order_id": oid}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+// GetTrial returns ErrNotFound if user had no trial.
+func (s *Storage) GetTrial(ctx context.Context, uid int64) (storage.Trial, error) {
+	query, args, err := s.sq.
+		Select("uid, order_id, created_at, reminded_at").
+		From("trials").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return storage.Trial{}, fmt.Errorf("builder: %w", err)
+	}
+
+	var t storage.Trial
+
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(&t.UID, &t.OrderID, &t.CreatedAt, &t.RemindedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Trial{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Trial{}, fmt.Errorf("scan: %w", err)
+	}
+
+	return t, nil
+}
+
+// MarkTrialReminded remembers that user was asked to order before trial expiration.
+func (s *Storage) MarkTrialReminded(ctx context.Context, uid int64, at time.Time) error {
+	sql, args, err := s.sq.
+		Update("trials").
+		Set("reminded_at", at.UTC()).
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) CreateTopUp(ctx context.Context, t storage.TopUp) (int64, error) {
+	sql, args, err := s.sq.
+		Insert("topups").
+		Columns("uid, username, first_name, last_name, amount, status, created_at").
+		Values(t.UID, t.Username, t.FirstName, t.LastName, t.Amount, domain.TopUpStatusPending, t.CreatedAt.UTC()).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("builder: %w", err)
+	}
+
+	var id int64
+
+	if err := s.db.QueryRowContext(ctx, sql, args...).Scan(&id); err != nil {
+		return 0, fmt.Errorf("insert: %w", err)
+	}
+
+	return id, nil
+}
+
+// ApproveTopUp approves pending top up and credits its amount to balance of user, ErrNotFound if top up is not pending.
+func (s *Storage) ApproveTopUp(ctx context.Context, id int64, at time.Time) (storage.TopUp, error) {
+	return s.closeTopUp(ctx, id, domain.TopUpStatusApproved, at)
+}
+
+// RejectTopUp rejects pending top up, ErrNotFound if top up is not pending.
+func (s *Storage) RejectTopUp(ctx context.Context, id int64, at time.Time) (storage.TopUp, error) {
+	return s.closeTopUp(ctx, id, domain.TopUpStatusRejected, at)
+}
+
+// closeTopUp sets status of pending top up, amount of approved top up is added to ledger in the same tx.
+func (s *Storage) closeTopUp(ctx context.Context, id int64, status domain.TopUpStatus, at time.Time) (storage.TopUp, error) {
+	query, args, err := s.sq.
+		Update("topups").
+		Set("status", status).
+		Set("closed_at", at.UTC()).
+		Where(sq.Eq{"id": id, "status": domain.TopUpStatusPending}).
+		Suffix("RETURNING uid, username, first_name, last_name, amount, created_at").
+		ToSql()
+	if err != nil {
+		return storage.TopUp{}, fmt.Errorf("builder: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.TopUp{}, fmt.Errorf("tx not started: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		t                             = storage.TopUp{ID: id, Status: status, ClosedAt: sql.NullTime{Time: at, Valid: true}}
+		username, firstName, lastName sql.NullString
+	)
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&t.UID, &username, &firstName, &lastName, &t.Amount, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.TopUp{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.TopUp{}, fmt.Errorf("top up not closed: %w", err)
+	}
+
+	t.Username, t.FirstName, t.LastName = username.String, firstName.String, lastName.String
+
+	if status == domain.TopUpStatusApproved {
+		query, args, err = s.insertLedgerEntry(storage.LedgerEntry{
+			UID:       t.UID,
+			Amount:    t.Amount,
+			Kind:      domain.LedgerTopUp,
+			CreatedAt: at,
+		})
+		if err != nil {
+			return storage.TopUp{}, fmt.Errorf("builder: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return storage.TopUp{}, fmt.Errorf("ledger entry not added: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return storage.TopUp{}, fmt.Errorf("tx commit: %w", err)
+	}
+
+	return t, nil
+}
+
+// SetAutoRenew enables or disables renewal of expiring orders of user with balance.
+func (s *Storage) SetAutoRenew(ctx context.Context, uid int64, enabled bool, at time.Time) error {
+	var (
+		sql  string
+		args []any
+		err  error
+	)
+
+	if enabled {
+		sql, args, err = s.sq.
+			Insert("auto_renewals").
+			Columns("uid, enabled_at").
+			Values(uid, at.UTC()).
+			Suffix("ON CONFLICT (uid) DO NOTHING").
+			ToSql()
+	} else {
+		sql, args, err = s.sq.
+			Delete("auto_renewals").
+			Where(sq.Eq{"uid": uid}).
+			ToSql()
+	}
+	if err != nil {
+		return fmt.Errorf("builder: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sql, args...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsAutoRenew(ctx context.Context, uid int64) (bool, error) {
+	sql, args, err := s.sq.
+		Select("count(*)").
+		From("auto_renewals").
+		Where(sq.Eq{"uid": uid}).
+		ToSql()
+	if err != nil {
+		return false, fmt.Errorf("builder: %w", err)
+	}
+
+	var n int
+
+	if err := s.db.QueryRowContext(ctx, sql, args...).Scan(&n); err != nil {
+		return false, fmt.Errorf("scan: %w", err)
+	}
+
+	return n > 0, nil
+}
@@ -0,0 +1,138 @@
+package sqlstore_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+	"github.com/ysomad/outline-bot/internal/storage/sqlstore"
+	"github.com/ysomad/outline-bot/internal/storage/storagetest"
+	"github.com/ysomad/outline-bot/migrations"
+)
+
+// postgresDSNEnv is env with dsn of disposable postgres database, its public schema is dropped by tests.
+const postgresDSNEnv = "TEST_POSTGRES_DSN"
+
+func newSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open(sqlstore.SQLite.Driver, ":memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// every connection opens its own in-memory database
+	db.SetMaxOpenConns(1)
+
+	if err := migrations.Up(context.Background(), db, sqlstore.SQLite.Driver); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// newPostgres returns migrated empty postgres database, test is skipped if postgresDSNEnv is not set.
+func newPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	db, err := sql.Open(sqlstore.Postgres.Driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+
+	for _, q := range []string{"DROP SCHEMA public CASCADE", "CREATE SCHEMA public"} {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrations.Up(ctx, db, sqlstore.Postgres.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestSQLite(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return sqlstore.New(newSQLite(t), sqlstore.SQLite)
+	})
+}
+
+func TestPostgres(t *testing.T) {
+	if os.Getenv(postgresDSNEnv) == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return sqlstore.New(newPostgres(t), sqlstore.Postgres)
+	})
+}
+
+func TestCopySQLiteToPostgres(t *testing.T) {
+	dst := newPostgres(t)
+	src := newSQLite(t)
+	ctx := context.Background()
+
+	sqlite := sqlstore.New(src, sqlstore.SQLite)
+
+	oid, err := sqlite.CreateOrder(ctx, storage.CreateOrderParams{
+		Status:    domain.OrderStatusAwaitingPayment,
+		UID:       100,
+		KeyAmount: 1,
+		Price:     domain.PricePerKey,
+		CreatedAt: time.Now(),
+		Backend:   domain.BackendOutline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sqlstore.CopySQLiteToPostgres(ctx, src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	pg := sqlstore.New(dst, sqlstore.Postgres)
+
+	o, err := pg.GetOrder(ctx, oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if o.UID != 100 || o.Price != domain.PricePerKey {
+		t.Errorf("copied order = %+v", o)
+	}
+
+	// sequence is moved after copied order
+	next, err := pg.CreateOrder(ctx, storage.CreateOrderParams{
+		Status:    domain.OrderStatusAwaitingPayment,
+		UID:       100,
+		KeyAmount: 1,
+		Price:     domain.PricePerKey,
+		CreatedAt: time.Now(),
+		Backend:   domain.BackendOutline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next != oid+1 {
+		t.Errorf("next order id = %d, want %d", next, oid+1)
+	}
+}
@@ -3,25 +3,238 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"fmt"
-	"log/slog"
+	"errors"
 	"time"
 
-	sq "github.com/Masterminds/squirrel"
-
 	"github.com/ysomad/outline-bot/internal/domain"
 )
 
-type Storage struct {
-	db *sql.DB
-	sq sq.StatementBuilderType
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+// Storage is a persistent storage of orders and their access keys.
+// All methods must respect context deadlines.
+type Storage interface {
+	Orders
+	Keys
+	Stats
+	Users
+	Admins
+	Tickets
+	Invites
+	Referrals
+	Ledger
+	Promos
+	Trials
+	Wallets
+}
+
+type Orders interface {
+	// GetOrder returns ErrNotFound if order doesn't exist.
+	GetOrder(ctx context.Context, oid domain.OrderID) (Order, error)
+	CreateOrder(ctx context.Context, p CreateOrderParams) (domain.OrderID, error)
+	CloseOrder(ctx context.Context, oid domain.OrderID, status domain.OrderStatus, closedAt time.Time) error
+	// RevokeOrder closes open order as revoked, ErrNotFound if order is already closed.
+	RevokeOrder(ctx context.Context, oid domain.OrderID, at time.Time) error
+	// CancelOrder closes order awaiting payment as canceled, ErrNotFound if order is paid or closed.
+	CancelOrder(ctx context.Context, oid domain.OrderID, at time.Time) error
+
+	// ApprovedOrder approves order awaiting payment and creates key for the order,
+	// ErrNotFound if order is already approved or closed.
+	ApproveOrder(ctx context.Context, oid domain.OrderID, keys []Key, expiresAt time.Time) error
+
+	// RenewOrder prolongs expiration of open order by exp if the order still expires at expiresAt (up to seconds),
+	// ErrNotFound if order is closed or already renewed.
+	RenewOrder(ctx context.Context, oid domain.OrderID, expiresAt time.Time, exp time.Duration) error
+
+	// RenewOrderWithCredit renews order like RenewOrder and pays its price with balance of its user in one transaction,
+	// returns charged credit. If full, order is renewed only when balance covers the price, ErrInsufficientBalance otherwise.
+	RenewOrderWithCredit(ctx context.Context, oid domain.OrderID, expiresAt time.Time, exp time.Duration, full bool, at time.Time) (int, error)
+
+	// ListActiveOrders returns approved orders which keys live on backend.
+	ListActiveOrders(ctx context.Context, backend domain.Backend) ([]ActiveOrder, error)
+
+	// ListOrders returns page of orders with status, or of all orders if status is empty, latest first.
+	ListOrders(ctx context.Context, p ListOrdersParams) ([]Order, error)
+	// CountOrders returns amount of orders with status, or of all orders if status is empty.
+	CountOrders(ctx context.Context, status domain.OrderStatus) (int, error)
+
+	// ListPendingOrders returns orders awaiting payment created before t, oldest first.
+	ListPendingOrders(ctx context.Context, createdBefore time.Time) ([]Order, error)
+}
+
+type Keys interface {
+	ListActiveUserKeys(ctx context.Context, uid int64) ([]ActiveKey, error)
+	CountActiveKeys(ctx context.Context, uid int64) (uint8, error)
+
+	// ListExpiringKeys returns keys that expire in or less than exp.
+	ListExpiringKeys(ctx context.Context, exp time.Duration) ([]ExpiringKey, error)
+
+	AllActiveKeys(ctx context.Context) ([]ActiveKey, error)
+	UpdateKeyURL(ctx context.Context, id, url string) error
+
+	// DeleteBackendKeys deletes all keys of orders which keys live on backend.
+	DeleteBackendKeys(ctx context.Context, backend domain.Backend) error
+	// AddOrderKeys adds keys to approved order, e.g. keys recreated on new server.
+	AddOrderKeys(ctx context.Context, oid domain.OrderID, keys []Key) error
+
+	// GetKeyPrefix returns prefix of access keys on backend, ErrNotFound if it was never saved.
+	GetKeyPrefix(ctx context.Context, backend domain.Backend) (string, error)
+	SaveKeyPrefix(ctx context.Context, backend domain.Backend, prefix string, at time.Time) error
+}
+
+// Users are bot users, user is known to the bot by his orders.
+type Users interface {
+	// FindUID returns id of user by username from his latest order, ErrNotFound if there are no such orders.
+	FindUID(ctx context.Context, username string) (int64, error)
+
+	// ListUserOrders returns all orders of user, latest first.
+	ListUserOrders(ctx context.Context, uid int64) ([]Order, error)
+
+	BanUser(ctx context.Context, uid int64, at time.Time) error
+	UnbanUser(ctx context.Context, uid int64) error
+	IsBanned(ctx context.Context, uid int64) (bool, error)
+
+	// SaveUser remembers user who started the bot, users who place orders or join by invite are saved with them.
+	SaveUser(ctx context.Context, uid int64, at time.Time) error
+
+	// ListUserIDs returns sorted ids of not banned users in segment.
+	ListUserIDs(ctx context.Context, seg UserSegment) ([]int64, error)
+}
+
+// Admins are users who manage the bot, see domain.AdminRole.
+type Admins interface {
+	// GetAdmin returns ErrNotFound if user is not admin.
+	GetAdmin(ctx context.Context, uid int64) (Admin, error)
+	// ListAdmins returns all admins sorted by date of creation.
+	ListAdmins(ctx context.Context) ([]Admin, error)
+	// SaveAdmin creates admin or changes role and username of existing one.
+	SaveAdmin(ctx context.Context, a Admin) error
+	DeleteAdmin(ctx context.Context, uid int64) error
+
+	// SaveAdminMessages remembers messages about order sent to admins, to edit them all when order is processed.
+	SaveAdminMessages(ctx context.Context, oid domain.OrderID, msgs []AdminMessage) error
+	// PopAdminMessages returns messages about order and forgets them.
+	PopAdminMessages(ctx context.Context, oid domain.OrderID) ([]AdminMessage, error)
+
+	// GetAdminTopic returns thread id of forum topic in admin group by key, ErrNotFound if topic is not created.
+	GetAdminTopic(ctx context.Context, key string) (int, error)
+	SaveAdminTopic(ctx context.Context, key string, threadID int) error
+}
+
+// Tickets are support conversations of users with admins.
+type Tickets interface {
+	CreateTicket(ctx context.Context, p CreateTicketParams) (int64, error)
+	// GetTicket returns ErrNotFound if ticket doesn't exist.
+	GetTicket(ctx context.Context, id int64) (Ticket, error)
+	// GetUserTicket returns not closed ticket of user, ErrNotFound if user has no such ticket.
+	GetUserTicket(ctx context.Context, uid int64) (Ticket, error)
+	// ListTickets returns tickets with statuses, recently updated first.
+	ListTickets(ctx context.Context, statuses []domain.TicketStatus, limit int) ([]Ticket, error)
+	SetTicketStatus(ctx context.Context, id int64, status domain.TicketStatus, updatedAt time.Time) error
+
+	SaveTicketMessage(ctx context.Context, m TicketMessage) error
+	// ListTicketMessages returns messages of ticket, oldest first.
+	ListTicketMessages(ctx context.Context, id int64) ([]TicketMessage, error)
+
+	// SaveTicketRelays remembers messages ticket is relayed to admins with, so admin reply to them reaches user.
+	SaveTicketRelays(ctx context.Context, id int64, msgs []AdminMessage) error
+	// FindTicketByRelay returns id of ticket relayed with message, ErrNotFound if message is not a relay.
+	FindTicketByRelay(ctx context.Context, m AdminMessage) (int64, error)
+}
+
+// Invites restrict access to the bot in invite only mode, only members may order keys.
+type Invites interface {
+	// CreateInvite creates invite if its creator created less than limit invites, ErrNotFound if limit is reached.
+	// Limit 0 means unlimited.
+	CreateInvite(ctx context.Context, inv Invite, limit int) error
+	// CountInvites returns amount of invites created by user.
+	CountInvites(ctx context.Context, uid int64) (int, error)
+	// UseInvite makes user member invited by creator of invite, ErrNotFound if code doesn't exist or is already used.
+	UseInvite(ctx context.Context, code string, m Member) (Member, error)
+
+	// GetMember returns ErrNotFound if user is not member.
+	GetMember(ctx context.Context, uid int64) (Member, error)
+	// SaveMember makes user member, existing member is not changed.
+	SaveMember(ctx context.Context, m Member) error
+	// ListMembers returns all members sorted by date of joining.
+	ListMembers(ctx context.Context) ([]Member, error)
+
+	// GetJoinRequest returns ErrNotFound if user didn't request access.
+	GetJoinRequest(ctx context.Context, uid int64) (JoinRequest, error)
+	// SaveJoinRequest creates request or changes status of existing one.
+	SaveJoinRequest(ctx context.Context, r JoinRequest) error
+	DeleteJoinRequest(ctx context.Context, uid int64) error
+}
+
+// Referrals are users brought by other users with referral link.
+type Referrals interface {
+	// SaveReferral remembers who referred user, existing referral is not changed.
+	SaveReferral(ctx context.Context, r Referral) error
+	// RewardReferral marks referral of user rewarded and adds credit for order to balance of referrer,
+	// ErrNotFound if user is not referred or referral is already rewarded.
+	RewardReferral(ctx context.Context, uid int64, credit int, oid domain.OrderID, at time.Time) (Referral, error)
+	// ListReferrals returns users referred by user, oldest first.
+	ListReferrals(ctx context.Context, referrerID int64) ([]Referral, error)
+}
+
+// Ledger is history of balance changes of users, balance is sum of entries.
+type Ledger interface {
+	AddLedgerEntry(ctx context.Context, e LedgerEntry) error
+	GetBalance(ctx context.Context, uid int64) (int, error)
+	// ListLedgerEntries returns latest entries of user, latest first.
+	ListLedgerEntries(ctx context.Context, uid int64, limit uint64) ([]LedgerEntry, error)
+	// RefundOrder closes open order as refunded and credits amount to balance of its user,
+	// ErrNotFound if order is already closed.
+	RefundOrder(ctx context.Context, oid domain.OrderID, amount int, at time.Time) error
+}
+
+// Wallets are prepaid balances of users topped up with payments approved by admin.
+type Wallets interface {
+	CreateTopUp(ctx context.Context, t TopUp) (int64, error)
+	// ApproveTopUp approves pending top up and credits its amount to balance of user, ErrNotFound if top up is not pending.
+	ApproveTopUp(ctx context.Context, id int64, at time.Time) (TopUp, error)
+	// RejectTopUp rejects pending top up, ErrNotFound if top up is not pending.
+	RejectTopUp(ctx context.Context, id int64, at time.Time) (TopUp, error)
+
+	// SetAutoRenew enables or disables renewal of expiring orders of user with balance.
+	SetAutoRenew(ctx context.Context, uid int64, enabled bool, at time.Time) error
+	IsAutoRenew(ctx context.Context, uid int64) (bool, error)
+}
+
+// Promos are promo codes giving discount or bonus days on new orders.
+type Promos interface {
+	CreatePromo(ctx context.Context, p Promo) error
+	// GetPromo returns ErrNotFound if promo doesn't exist.
+	GetPromo(ctx context.Context, code string) (Promo, error)
+	// UsePromo counts use of promo, ErrNotFound if promo doesn't exist, is disabled or all its uses are spent.
+	UsePromo(ctx context.Context, code string) error
+	DisablePromo(ctx context.Context, code string) error
+	// ListPromos returns promos with usage stats, latest first.
+	ListPromos(ctx context.Context) ([]PromoStats, error)
 }
 
-func New(db *sql.DB, b sq.StatementBuilderType) *Storage {
-	return &Storage{
-		db: db,
-		sq: b,
-	}
+// Trials are free trial orders, every user may have only one.
+type Trials interface {
+	// CreateTrial returns ErrNotFound if user already has trial.
+	CreateTrial(ctx context.Context, t Trial) error
+	// DeleteTrial forgets trial of order which was not provisioned, so user may start it again.
+	DeleteTrial(ctx context.Context, uid int64, oid domain.OrderID) error
+	// GetTrial returns ErrNotFound if user had no trial.
+	GetTrial(ctx context.Context, uid int64) (Trial, error)
+	// MarkTrialReminded remembers that user was asked to order before trial expiration.
+	MarkTrialReminded(ctx context.Context, uid int64, at time.Time) error
+}
+
+// Stats are aggregates of orders and keys for admin dashboard.
+type Stats interface {
+	OrderStats(ctx context.Context, p OrderStatsParams) (OrderStats, error)
+
+	// RevenueByMonth returns paid orders created since t grouped by month of creation, latest month first.
+	// Renewals are not stored separately, so order price is counted only once.
+	RevenueByMonth(ctx context.Context, since time.Time) ([]MonthRevenue, error)
 }
 
 type Order struct {
@@ -35,37 +248,29 @@ type Order struct {
 	Status    sql.NullString
 	CreatedAt sql.NullTime
 	ExpiresAt sql.NullTime
+	Backend   domain.Backend
+	PromoCode sql.NullString
+	// Discount is subtracted from price of the first payment only, renewals cost full price.
+	Discount int
+	// BonusDays are added to the first period of order.
+	BonusDays int
+}
+
+type ListOrdersParams struct {
+	Status domain.OrderStatus
+	Offset int
+	Limit  int
 }
 
-func (s *Storage) GetOrder(oid domain.OrderID) (Order, error) {
-	sql, args, err := s.sq.
-		Select("id, uid, username, first_name, last_name, key_amount, price, status, created_at, expires_at").
-		From("orders").
-		Where(sq.Eq{"id": oid}).
-		ToSql()
-	if err != nil {
-		return Order{}, err
-	}
-
-	row := s.db.QueryRow(sql, args...)
-	o := Order{}
-	err = row.Scan(
-		&o.ID,
-		&o.UID,
-		&o.Username,
-		&o.FirstName,
-		&o.LastName,
-		&o.KeyAmount,
-		&o.Price,
-		&o.Status,
-		&o.CreatedAt,
-		&o.ExpiresAt,
-	)
-	if err != nil {
-		return Order{}, err
-	}
-
-	return o, nil
+// UserSegment selects users by their orders, zero value selects every user of the bot.
+type UserSegment struct {
+	Now time.Time
+	// Active selects users with active orders.
+	Active bool
+	// Backend selects users with active orders on backend.
+	Backend domain.Backend
+	// ExpiredSince selects users whose orders expired after the time and who have no active orders.
+	ExpiredSince time.Time
 }
 
 type CreateOrderParams struct {
@@ -77,37 +282,10 @@ type CreateOrderParams struct {
 	Price     int
 	CreatedAt time.Time
 	Status    domain.OrderStatus
-}
-
-func (s *Storage) CreateOrder(p CreateOrderParams) (domain.OrderID, error) {
-	sql, args, err := s.sq.
-		Insert("orders").
-		Columns("uid, username, first_name, last_name, key_amount, price, created_at, status").
-		Values(p.UID, p.Username, p.FirstName, p.LastName, p.KeyAmount, p.Price, p.CreatedAt, p.Status).
-		ToSql()
-	if err != nil {
-		return 0, err
-	}
-
-	res, err := s.db.Exec(sql, args...)
-	if err != nil {
-		return 0, fmt.Errorf("exec: %w", err)
-	}
-
-	id, err := res.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("last insert id: %w", err)
-	}
-
-	return domain.OrderID(id), nil
-}
-
-func (s *Storage) CloseOrder(oid domain.OrderID, status domain.OrderStatus, closedAt time.Time) error {
-	if _, err := s.db.Exec("UPDATE orders SET closed_at = ?, status = ? WHERE id = ?",
-		closedAt.UTC(), status, oid); err != nil {
-		return err
-	}
-	return nil
+	Backend   domain.Backend
+	PromoCode string
+	Discount  int
+	BonusDays int
 }
 
 type Key struct {
@@ -116,52 +294,6 @@ type Key struct {
 	URL  string
 }
 
-// ApprovedOrder approves order and creates key for the order.
-func (s *Storage) ApproveOrder(oid domain.OrderID, keys []Key, expiresAt time.Time) error {
-	sql1, args1, err := s.sq.
-		Update("orders").
-		Set("expires_at", expiresAt.UTC()).
-		Set("status", domain.OrderStatusApproved).
-		Where(sq.Eq{"id": oid}).
-		ToSql()
-	if err != nil {
-		return err
-	}
-
-	b := s.sq.
-		Insert("access_keys").
-		Columns("id, name, url, order_id")
-
-	for _, k := range keys {
-		b = b.Values(k.ID, k.Name, k.URL, oid)
-	}
-
-	sql2, args2, err := b.ToSql()
-	if err != nil {
-		return err
-	}
-
-	tx, err := s.db.BeginTx(context.TODO(), nil)
-	if err != nil {
-		return fmt.Errorf("tx not started: %w", err)
-	}
-	defer tx.Rollback()
-
-	if _, err = tx.Exec(sql1, args1...); err != nil {
-		return fmt.Errorf("order not closed: %w", err)
-	}
-
-	if _, err = tx.Exec(sql2, args2...); err != nil {
-		return fmt.Errorf("access keys not created: %w", err)
-	}
-
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("tx commit: %w", err)
-	}
-
-	return nil
-}
-
 type ActiveKey struct {
 	ID        string
 	Name      string
@@ -170,68 +302,7 @@ type ActiveKey struct {
 	OrderID   domain.OrderID
 	Price     int
 	UID       int64
-}
-
-func (s *Storage) ListActiveUserKeys(uid int64) ([]ActiveKey, error) {
-	sql, args, err := s.sq.
-		Select("ak.id, ak.url, o.expires_at, ak.name, o.id, o.price, o.uid").
-		From("access_keys ak").
-		InnerJoin("orders o ON ak.order_id = o.id").
-		Where(sq.Eq{"o.uid": uid}).
-		Where(sq.Lt{"o.expires_at": "current_timestamp"}).
-		Where(sq.Eq{"o.closed_at": nil}).
-		OrderBy("o.id").
-		ToSql()
-	if err != nil {
-		return nil, err
-	}
-
-	rows, err := s.db.Query(sql, args...)
-	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
-	}
-	defer rows.Close()
-
-	var keys []ActiveKey
-
-	for rows.Next() {
-		k := ActiveKey{}
-
-		if err := rows.Scan(&k.ID, &k.URL, &k.ExpiresAt, &k.Name, &k.OrderID, &k.Price, &k.UID); err != nil {
-			return nil, err
-		}
-
-		keys = append(keys, k)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows: %w", err)
-	}
-
-	return keys, nil
-}
-
-func (s *Storage) CountActiveKeys(uid int64) (uint8, error) {
-	sql, args, err := s.sq.
-		Select("count(*)").
-		From("access_keys ak").
-		InnerJoin("orders o on o.id = ak.order_id").
-		Where(sq.Eq{"o.uid": uid}).
-		Where("o.expires_at > current_timestamp").
-		ToSql()
-	if err != nil {
-		return 0, err
-	}
-
-	row := s.db.QueryRow(sql, args...)
-
-	var count uint8
-
-	if err := row.Scan(&count); err != nil {
-		return 0, fmt.Errorf("scan: %w", err)
-	}
-
-	return count, nil
+	Backend   domain.Backend
 }
 
 type ExpiringKey struct {
@@ -247,114 +318,9 @@ type ExpiringKey struct {
 	Username  sql.NullString
 	FirstName sql.NullString
 	LastName  sql.NullString
-}
-
-// ListExpiringKeys returns keys that expire in or less than exp.
-func (s *Storage) ListExpiringKeys(exp time.Duration) ([]ExpiringKey, error) {
-	sql, args, err := s.sq.
-		Select("ak.id, ak.name, ak.url, o.expires_at, o.id, o.key_amount, o.price",
-			"o.uid, o.username, o.first_name, o.last_name",
-			"(JULIANDAY(o.expires_at) - JULIANDAY(current_timestamp)) * 24 * 60 * 60 expires_in").
-		From("access_keys ak").
-		InnerJoin("orders o ON o.id = ak.order_id").
-		Where(sq.LtOrEq{"expires_in": exp.Seconds()}).
-		Where(sq.Eq{"closed_at": nil}).
-		OrderBy("expires_in").
-		ToSql()
-	if err != nil {
-		return nil, err
-	}
-
-	slog.Debug(sql, "args", args)
-
-	rows, err := s.db.Query(sql, args...)
-	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
-	}
-	defer rows.Close()
-
-	var (
-		keys []ExpiringKey
-		diff float64
-	)
-
-	for rows.Next() {
-		k := ExpiringKey{}
-
-		err := rows.Scan(
-			&k.ID, &k.Name, &k.URL, &k.ExpiresAt, &k.OrderID,
-			&k.KeyAmount, &k.Price, &k.UID, &k.Username, &k.FirstName, &k.LastName,
-			&diff)
-		if err != nil {
-			return nil, fmt.Errorf("scan: %w", err)
-		}
-
-		k.ExpiresIn = time.Duration(diff) * time.Second
-		keys = append(keys, k)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows: %w", err)
-	}
-
-	return keys, nil
-}
-
-func (s *Storage) RenewOrder(oid domain.OrderID, exp time.Duration) error {
-	sql := fmt.Sprintf(`
-UPDATE orders
-SET expires_at = datetime(expires_at, '+%.f seconds')
-WHERE id = ?
-`, exp.Seconds())
-
-	res, err := s.db.Exec(sql, oid)
-	if err != nil {
-		return fmt.Errorf("exec: %w", err)
-	}
-
-	if _, err := res.RowsAffected(); err != nil {
-		return fmt.Errorf("rows affected: %w", err)
-	}
-
-	return nil
-}
-
-func (s *Storage) AllActiveKeys() ([]ActiveKey, error) {
-	sql, args, err := s.sq.
-		Select("ak.id, ak.url, o.expires_at, ak.name, o.id, o.price").
-		From("access_keys ak").
-		InnerJoin("orders o ON ak.order_id = o.id").
-		Where(sq.Lt{"o.expires_at": "current_timestamp"}).
-		Where(sq.Eq{"o.closed_at": nil}).
-		OrderBy("ak.id").
-		ToSql()
-	if err != nil {
-		return nil, err
-	}
-
-	rows, err := s.db.Query(sql, args...)
-	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
-	}
-	defer rows.Close()
-
-	var keys []ActiveKey
-
-	for rows.Next() {
-		k := ActiveKey{}
-
-		if err := rows.Scan(&k.ID, &k.URL, &k.ExpiresAt, &k.Name, &k.OrderID, &k.Price); err != nil {
-			return nil, err
-		}
-
-		keys = append(keys, k)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows: %w", err)
-	}
-
-	return keys, nil
+	Backend   domain.Backend
+	// Trial is true if order is free trial of user.
+	Trial bool
 }
 
 type ActiveOrder struct {
@@ -364,50 +330,156 @@ type ActiveOrder struct {
 	ExpiresAt sql.NullTime
 }
 
-func (s *Storage) ListActiveOrders() ([]ActiveOrder, error) {
-	sql, args, err := s.sq.
-		Select("id,key_amount,uid,expires_at").
-		From("orders").
-		Where(sq.Eq{"status": domain.OrderStatusApproved}).
-		ToSql()
-	if err != nil {
-		return nil, fmt.Errorf("builder: %w", err)
-	}
+type OrderStatsParams struct {
+	Now time.Time
+	// ChurnedSince is start of period in which expired orders are counted.
+	ChurnedSince time.Time
+	// ExpiresBefore is end of period in which expiring orders are counted.
+	ExpiresBefore time.Time
+}
+
+type OrderStats struct {
+	ActiveOrders int
+	ActiveKeys   int
+	// MRR is monthly recurring revenue, sum of prices of active orders.
+	MRR int
+
+	AwaitingPayment      int
+	AwaitingPaymentPrice int
+
+	// Churned is amount of orders expired without renewal since OrderStatsParams.ChurnedSince.
+	Churned      int
+	ChurnedPrice int
+
+	// Expiring is amount of active orders which expire before OrderStatsParams.ExpiresBefore.
+	Expiring      int
+	ExpiringPrice int
+}
+
+type MonthRevenue struct {
+	// Month is in format YYYY-MM.
+	Month   string
+	Orders  int
+	Revenue int
+}
+
+type Admin struct {
+	UID       int64
+	Username  string
+	Role      domain.AdminRole
+	CreatedAt time.Time
+}
+
+type AdminMessage struct {
+	ChatID    int64
+	MessageID int
+}
+
+type Ticket struct {
+	ID        int64
+	UID       int64
+	Username  string
+	FirstName string
+	LastName  string
+	Status    domain.TicketStatus
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type CreateTicketParams struct {
+	UID       int64
+	Username  string
+	FirstName string
+	LastName  string
+	CreatedAt time.Time
+}
 
-	rows, err := s.db.Query(sql, args...)
-	if err != nil {
-		return nil, fmt.Errorf("quiery: %w", err)
-	}
-	defer rows.Close()
+type TicketMessage struct {
+	TicketID  int64
+	FromAdmin bool
+	Text      string
+	CreatedAt time.Time
+}
 
-	var orders []ActiveOrder
+type Invite struct {
+	Code      string
+	CreatedBy int64
+	CreatedAt time.Time
+}
 
-	for rows.Next() {
-		o := ActiveOrder{}
+type Member struct {
+	UID      int64
+	Username string
+	// InvitedBy is id of user who created invite or admin who approved join request, 0 for users who had orders before invite only mode.
+	InvitedBy int64
+	JoinedAt  time.Time
+}
 
-		if err := rows.Scan(&o.ID, &o.KeyAmount, &o.UID, &o.ExpiresAt); err != nil {
-			return nil, fmt.Errorf("scan: %w", err)
-		}
+type JoinRequest struct {
+	UID       int64
+	Username  string
+	FirstName string
+	LastName  string
+	Status    domain.JoinRequestStatus
+	CreatedAt time.Time
+}
 
-		orders = append(orders, o)
-	}
+type Referral struct {
+	UID        int64
+	ReferrerID int64
+	CreatedAt  time.Time
+	RewardedAt sql.NullTime
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows: %w", err)
-	}
+type LedgerEntry struct {
+	UID int64
+	// Amount is positive for credits and negative for charges.
+	Amount int
+	Kind   domain.LedgerKind
+	// OrderID is order entry is related to, 0 if none.
+	OrderID   domain.OrderID
+	CreatedAt time.Time
+}
 
-	return orders, nil
+type Promo struct {
+	Code    string
+	Percent int
+	// Amount is fixed discount in rubles.
+	Amount int
+	// Days are added to the first period of order.
+	Days int
+	// MaxUses is 0 if uses are not limited.
+	MaxUses        int
+	Uses           int
+	ExpiresAt      sql.NullTime
+	FirstOrderOnly bool
+	Disabled       bool
+	CreatedAt      time.Time
 }
 
-func (s *Storage) DeteleAllKeys() error {
-	sql, args, err := s.sq.Delete("access_keys").ToSql()
-	if err != nil {
-		return fmt.Errorf("builder: %w", err)
-	}
+type PromoStats struct {
+	Promo
+	// PaidOrders is amount of approved orders placed with promo.
+	PaidOrders int
+	// Discount is sum of discounts of paid orders.
+	Discount int
+}
 
-	if _, err := s.db.Exec(sql, args...); err != nil {
-		return fmt.Errorf("exec: %w", err)
-	}
+type Trial struct {
+	UID        int64
+	OrderID    domain.OrderID
+	CreatedAt  time.Time
+	RemindedAt sql.NullTime
+}
 
-	return nil
+type TopUp struct {
+	ID        int64
+	UID       int64
+	Username  string
+	FirstName string
+	LastName  string
+	Amount    int
+	Status    domain.TopUpStatus
+	CreatedAt time.Time
+	ClosedAt  sql.NullTime
 }
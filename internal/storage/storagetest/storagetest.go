@@ -0,0 +1,1259 @@
+// Package storagetest is conformance test suite of storage.Storage implementations,
+// so in-memory storage used by bot tests behaves like sql one.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/storage"
+)
+
+const (
+	uid      = 100
+	otherUID = 101
+	day      = 24 * time.Hour
+)
+
+// Run runs the suite, every test gets empty storage from newStorage.
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{"Orders", testOrders},
+		{"ApproveOrderWithoutKeys", testApproveOrderWithoutKeys},
+		{"ListOrders", testListOrders},
+		{"Keys", testKeys},
+		{"ExpiringKeys", testExpiringKeys},
+		{"KeyPrefix", testKeyPrefix},
+		{"Users", testUsers},
+		{"UserSegments", testUserSegments},
+		{"Admins", testAdmins},
+		{"Tickets", testTickets},
+		{"Invites", testInvites},
+		{"Referrals", testReferrals},
+		{"Ledger", testLedger},
+		{"RevokeOrder", testRevokeOrder},
+		{"CancelOrder", testCancelOrder},
+		{"ApproveOrderConflict", testApproveOrderConflict},
+		{"RenewOrderConflict", testRenewOrderConflict},
+		{"RenewOrderWithCredit", testRenewOrderWithCredit},
+		{"RefundOrder", testRefundOrder},
+		{"Wallets", testWallets},
+		{"Promos", testPromos},
+		{"Trials", testTrials},
+		{"Stats", testStats},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+// now is truncated to seconds, so it survives round trip through any database.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func createOrder(t *testing.T, s storage.Storage, p storage.CreateOrderParams) domain.OrderID {
+	t.Helper()
+
+	if p.UID == 0 {
+		p.UID = uid
+	}
+	if p.KeyAmount == 0 {
+		p.KeyAmount = 1
+	}
+	if p.Price == 0 {
+		p.Price = domain.PricePerKey * p.KeyAmount
+	}
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = now()
+	}
+	if p.Status == "" {
+		p.Status = domain.OrderStatusAwaitingPayment
+	}
+	if p.Backend == "" {
+		p.Backend = domain.BackendOutline
+	}
+
+	oid, err := s.CreateOrder(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return oid
+}
+
+// approvedOrder creates order of user with key named by order id which expires at exp.
+func approvedOrder(t *testing.T, s storage.Storage, user int64, exp time.Time) domain.OrderID {
+	t.Helper()
+
+	oid := createOrder(t, s, storage.CreateOrderParams{UID: user, Username: "user"})
+
+	keys := []storage.Key{{ID: "key" + oid.String(), Name: "name", URL: "ss://key" + oid.String()}}
+
+	if err := s.ApproveOrder(context.Background(), oid, keys, exp); err != nil {
+		t.Fatal(err)
+	}
+
+	return oid
+}
+
+func getOrder(t *testing.T, s storage.Storage, oid domain.OrderID) storage.Order {
+	t.Helper()
+
+	o, err := s.GetOrder(context.Background(), oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return o
+}
+
+func wantNotFound(t *testing.T, err error) {
+	t.Helper()
+
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("err = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func testOrders(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	created := now()
+
+	oid := createOrder(t, s, storage.CreateOrderParams{
+		Username:  "user",
+		FirstName: "First",
+		KeyAmount: 2,
+		Price:     300,
+		CreatedAt: created,
+		PromoCode: "SPRING",
+		Discount:  30,
+		BonusDays: 7,
+	})
+
+	o := getOrder(t, s, oid)
+
+	if o.ID != oid || o.UID != uid || o.Username.String != "user" || o.FirstName.String != "First" || o.LastName.String != "" ||
+		o.KeyAmount != 2 || o.Price != 300 || o.Backend != domain.BackendOutline ||
+		o.PromoCode.String != "SPRING" || o.Discount != 30 || o.BonusDays != 7 {
+		t.Errorf("unexpected order: %+v", o)
+	}
+
+	if o.Status.String != string(domain.OrderStatusAwaitingPayment) || !o.CreatedAt.Time.Equal(created) || o.ExpiresAt.Valid {
+		t.Errorf("unexpected status or dates of new order: %+v", o)
+	}
+
+	_, err := s.GetOrder(ctx, oid+1)
+	wantNotFound(t, err)
+
+	exp := created.Add(domain.OrderTTL)
+	keys := []storage.Key{{ID: "1", Name: "first", URL: "ss://1"}, {ID: "2", Name: "second", URL: "ss://2"}}
+
+	if err := s.ApproveOrder(ctx, oid, keys, exp); err != nil {
+		t.Fatal(err)
+	}
+
+	o = getOrder(t, s, oid)
+
+	if o.Status.String != string(domain.OrderStatusApproved) || !o.ExpiresAt.Time.Equal(exp) {
+		t.Errorf("unexpected approved order: %+v", o)
+	}
+
+	if err := s.RenewOrder(ctx, oid, exp, domain.OrderTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	if o = getOrder(t, s, oid); !o.ExpiresAt.Time.Equal(exp.Add(domain.OrderTTL)) {
+		t.Errorf("renewed expires at = %s, want %s", o.ExpiresAt.Time, exp.Add(domain.OrderTTL))
+	}
+
+	active, err := s.ListActiveOrders(ctx, domain.BackendOutline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(active) != 1 || active[0].ID != oid || active[0].KeyAmount != 2 || active[0].UID != uid {
+		t.Errorf("active orders = %+v, want approved order", active)
+	}
+
+	if err := s.CloseOrder(ctx, oid, domain.OrderStatusExpired, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if o = getOrder(t, s, oid); o.Status.String != string(domain.OrderStatusExpired) {
+		t.Errorf("status = %s, want %s", o.Status.String, domain.OrderStatusExpired)
+	}
+
+}
+
+func testApproveOrderWithoutKeys(t *testing.T, s storage.Storage) {
+	oid := createOrder(t, s, storage.CreateOrderParams{})
+	exp := now().Add(domain.OrderTTL)
+
+	if err := s.ApproveOrder(context.Background(), oid, nil, exp); err != nil {
+		t.Fatal(err)
+	}
+
+	if o := getOrder(t, s, oid); o.Status.String != string(domain.OrderStatusApproved) || !o.ExpiresAt.Time.Equal(exp) {
+		t.Errorf("unexpected approved order: %+v", o)
+	}
+}
+
+func testListOrders(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	created := now().Add(-2 * day)
+
+	first := createOrder(t, s, storage.CreateOrderParams{CreatedAt: created})
+	second := createOrder(t, s, storage.CreateOrderParams{CreatedAt: created.Add(time.Hour)})
+	third := createOrder(t, s, storage.CreateOrderParams{CreatedAt: now()})
+
+	if err := s.CloseOrder(ctx, second, domain.OrderStatusRejected, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := s.ListOrders(ctx, storage.ListOrdersParams{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orders) != 2 || orders[0].ID != third || orders[1].ID != second {
+		t.Errorf("first page = %+v, want latest orders", orders)
+	}
+
+	orders, err = s.ListOrders(ctx, storage.ListOrdersParams{Offset: 2, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orders) != 1 || orders[0].ID != first {
+		t.Errorf("second page = %+v, want the oldest order", orders)
+	}
+
+	orders, err = s.ListOrders(ctx, storage.ListOrdersParams{Status: domain.OrderStatusRejected, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orders) != 1 || orders[0].ID != second {
+		t.Errorf("rejected orders = %+v, want second one", orders)
+	}
+
+	for status, want := range map[domain.OrderStatus]int{"": 3, domain.OrderStatusAwaitingPayment: 2, domain.OrderStatusApproved: 0} {
+		n, err := s.CountOrders(ctx, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != want {
+			t.Errorf("count of %q orders = %d, want %d", status, n, want)
+		}
+	}
+
+	pending, err := s.ListPendingOrders(ctx, now().Add(-day))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 1 || pending[0].ID != first {
+		t.Errorf("pending orders = %+v, want the first one", pending)
+	}
+}
+
+func testKeys(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	oid := approvedOrder(t, s, uid, now().Add(domain.OrderTTL))
+	approvedOrder(t, s, otherUID, now().Add(domain.OrderTTL))
+	expired := approvedOrder(t, s, uid, now().Add(-time.Minute))
+
+	keys, err := s.ListActiveUserKeys(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || keys[0].OrderID != oid || keys[0].URL != "ss://key"+oid.String() || keys[0].UID != uid ||
+		keys[0].Backend != domain.BackendOutline || keys[0].Price != domain.PricePerKey {
+		t.Errorf("active keys = %+v, want key of active order", keys)
+	}
+
+	n, err := s.CountActiveKeys(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Errorf("active keys = %d, want 1", n)
+	}
+
+	all, err := s.AllActiveKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) != 2 {
+		t.Errorf("all active keys = %+v, want keys of not expired orders", all)
+	}
+
+	if err := s.UpdateKeyURL(ctx, "key"+oid.String(), "ss://new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, _ = s.ListActiveUserKeys(ctx, uid); len(keys) != 1 || keys[0].URL != "ss://new" {
+		t.Errorf("key url not updated: %+v", keys)
+	}
+
+	if err := s.DeleteBackendKeys(ctx, domain.BackendWireGuard); err != nil {
+		t.Fatal(err)
+	}
+
+	if all, _ = s.AllActiveKeys(ctx); len(all) != 2 {
+		t.Errorf("keys of other backend deleted: %+v", all)
+	}
+
+	if err := s.DeleteBackendKeys(ctx, domain.BackendOutline); err != nil {
+		t.Fatal(err)
+	}
+
+	if all, _ = s.AllActiveKeys(ctx); len(all) != 0 {
+		t.Errorf("keys not deleted: %+v", all)
+	}
+
+	// keys recreated on new server
+	if err := s.AddOrderKeys(ctx, oid, []storage.Key{{ID: "new", Name: "new", URL: "ss://recreated"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, _ = s.ListActiveUserKeys(ctx, uid); len(keys) != 1 || keys[0].ID != "new" || keys[0].OrderID != oid {
+		t.Errorf("keys not added: %+v", keys)
+	}
+
+	// order of expired keys is still open until worker closes it
+	if o := getOrder(t, s, expired); o.Status.String != string(domain.OrderStatusApproved) {
+		t.Errorf("status = %s, want approved", o.Status.String)
+	}
+}
+
+func testExpiringKeys(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	soon := approvedOrder(t, s, uid, now().Add(day))
+	approvedOrder(t, s, uid, now().Add(domain.OrderTTL))
+	expired := approvedOrder(t, s, otherUID, now().Add(-time.Hour))
+
+	if err := s.CreateTrial(ctx, storage.Trial{UID: otherUID, OrderID: expired, CreatedAt: now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := s.ListExpiringKeys(ctx, domain.BeforeOrderExpiration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 || keys[0].OrderID != expired || keys[1].OrderID != soon {
+		t.Fatalf("expiring keys = %+v, want expired and expiring soon, soonest first", keys)
+	}
+
+	if !keys[0].Trial || keys[1].Trial {
+		t.Errorf("trial flags = %t, %t, want only trial order marked", keys[0].Trial, keys[1].Trial)
+	}
+
+	if keys[0].ExpiresIn > 0 || keys[1].ExpiresIn <= day-time.Minute || keys[1].ExpiresIn > day {
+		t.Errorf("expires in = %s, %s", keys[0].ExpiresIn, keys[1].ExpiresIn)
+	}
+
+	if k := keys[1]; k.UID != uid || k.Username.String != "user" || k.KeyAmount != 1 || k.Price != domain.PricePerKey {
+		t.Errorf("unexpected expiring key: %+v", k)
+	}
+}
+
+func testKeyPrefix(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	_, err := s.GetKeyPrefix(ctx, domain.BackendOutline)
+	wantNotFound(t, err)
+
+	for _, prefix := range []string{"%16%03", ""} {
+		if err := s.SaveKeyPrefix(ctx, domain.BackendOutline, prefix, now()); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.GetKeyPrefix(ctx, domain.BackendOutline)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != prefix {
+			t.Errorf("prefix = %q, want %q", got, prefix)
+		}
+	}
+}
+
+func testUsers(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	first := createOrder(t, s, storage.CreateOrderParams{Username: "old", CreatedAt: now().Add(-day)})
+	second := createOrder(t, s, storage.CreateOrderParams{Username: "new"})
+
+	got, err := s.FindUID(ctx, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != uid {
+		t.Errorf("uid = %d, want %d", got, uid)
+	}
+
+	_, err = s.FindUID(ctx, "unknown")
+	wantNotFound(t, err)
+
+	orders, err := s.ListUserOrders(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orders) != 2 || orders[0].ID != second || orders[1].ID != first {
+		t.Errorf("user orders = %+v, want latest first", orders)
+	}
+
+	if err := s.BanUser(ctx, uid, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// banned twice
+	if err := s.BanUser(ctx, uid, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if banned, _ := s.IsBanned(ctx, uid); !banned {
+		t.Error("user not banned")
+	}
+
+	if err := s.UnbanUser(ctx, uid); err != nil {
+		t.Fatal(err)
+	}
+
+	if banned, _ := s.IsBanned(ctx, uid); banned {
+		t.Error("user not unbanned")
+	}
+}
+
+func testUserSegments(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	const (
+		expiredUID = 102
+		bannedUID  = 103
+	)
+
+	approvedOrder(t, s, uid, now().Add(domain.OrderTTL))
+	createOrder(t, s, storage.CreateOrderParams{UID: otherUID})
+
+	expired := approvedOrder(t, s, expiredUID, now().Add(-2*day))
+	if err := s.CloseOrder(ctx, expired, domain.OrderStatusExpired, now().Add(-day)); err != nil {
+		t.Fatal(err)
+	}
+
+	approvedOrder(t, s, bannedUID, now().Add(domain.OrderTTL))
+	if err := s.BanUser(ctx, bannedUID, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		startedUID = 104
+		memberUID  = 105
+	)
+
+	// users without orders
+	if err := s.SaveUser(ctx, startedUID, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SaveMember(ctx, storage.Member{UID: memberUID, InvitedBy: uid, JoinedAt: now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// saved twice
+	if err := s.SaveUser(ctx, uid, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		seg  storage.UserSegment
+		want []int64
+	}{
+		{"all", storage.UserSegment{Now: now()}, []int64{uid, otherUID, expiredUID, startedUID, memberUID}},
+		{"active", storage.UserSegment{Now: now(), Active: true}, []int64{uid}},
+		{"backend", storage.UserSegment{Now: now(), Backend: domain.BackendOutline}, []int64{uid}},
+		{"other backend", storage.UserSegment{Now: now(), Backend: domain.BackendWireGuard}, nil},
+		{"expired", storage.UserSegment{Now: now(), ExpiredSince: now().Add(-7 * day)}, []int64{expiredUID}},
+		{"expired long ago", storage.UserSegment{Now: now(), ExpiredSince: now().Add(-time.Hour)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ListUserIDs(ctx, tt.seg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !equalIDs(got, tt.want) {
+				t.Errorf("uids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func equalIDs(got, want []int64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testAdmins(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	_, err := s.GetAdmin(ctx, uid)
+	wantNotFound(t, err)
+
+	if err := s.SaveAdmin(ctx, storage.Admin{UID: 1, Username: "owner", Role: domain.AdminRoleOwner, CreatedAt: now().Add(-day)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SaveAdmin(ctx, storage.Admin{UID: uid, Username: "admin", Role: domain.AdminRoleViewer, CreatedAt: now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// role changed, username is kept if unknown
+	if err := s.SaveAdmin(ctx, storage.Admin{UID: uid, Role: domain.AdminRoleOperator, CreatedAt: now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := s.GetAdmin(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Role != domain.AdminRoleOperator || a.Username != "admin" {
+		t.Errorf("admin = %+v, want operator with kept username", a)
+	}
+
+	admins, err := s.ListAdmins(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(admins) != 2 || admins[0].UID != 1 || admins[1].UID != uid {
+		t.Errorf("admins = %+v, want sorted by creation", admins)
+	}
+
+	if err := s.DeleteAdmin(ctx, uid); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.GetAdmin(ctx, uid)
+	wantNotFound(t, err)
+
+	oid := createOrder(t, s, storage.CreateOrderParams{})
+	msgs := []storage.AdminMessage{{ChatID: 1, MessageID: 10}, {ChatID: 2, MessageID: 20}}
+
+	if err := s.SaveAdminMessages(ctx, oid, msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.PopAdminMessages(ctx, oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0] != msgs[0] || got[1] != msgs[1] {
+		t.Errorf("admin messages = %+v, want %+v", got, msgs)
+	}
+
+	if got, _ = s.PopAdminMessages(ctx, oid); len(got) != 0 {
+		t.Errorf("admin messages popped twice: %+v", got)
+	}
+
+	_, err = s.GetAdminTopic(ctx, "orders")
+	wantNotFound(t, err)
+
+	for _, threadID := range []int{5, 6} {
+		if err := s.SaveAdminTopic(ctx, "orders", threadID); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, _ := s.GetAdminTopic(ctx, "orders"); got != threadID {
+			t.Errorf("thread id = %d, want %d", got, threadID)
+		}
+	}
+}
+
+func testTickets(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	_, err := s.GetUserTicket(ctx, uid)
+	wantNotFound(t, err)
+
+	id, err := s.CreateTicket(ctx, storage.CreateTicketParams{UID: uid, Username: "user", CreatedAt: now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk, err := s.GetTicket(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tk.UID != uid || tk.Username != "user" || tk.Status != domain.TicketStatusOpen {
+		t.Errorf("unexpected ticket: %+v", tk)
+	}
+
+	if tk, err = s.GetUserTicket(ctx, uid); err != nil || tk.ID != id {
+		t.Errorf("user ticket = %+v, %v, want %d", tk, err, id)
+	}
+
+	for i, fromAdmin := range []bool{false, true} {
+		err := s.SaveTicketMessage(ctx, storage.TicketMessage{TicketID: id, FromAdmin: fromAdmin, Text: "text", CreatedAt: now().Add(time.Duration(i) * time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs, err := s.ListTicketMessages(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msgs) != 2 || msgs[0].FromAdmin || !msgs[1].FromAdmin {
+		t.Errorf("ticket messages = %+v, want oldest first", msgs)
+	}
+
+	relay := storage.AdminMessage{ChatID: 1, MessageID: 10}
+
+	if err := s.SaveTicketRelays(ctx, id, []storage.AdminMessage{relay}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := s.FindTicketByRelay(ctx, relay); err != nil || got != id {
+		t.Errorf("ticket by relay = %d, %v, want %d", got, err, id)
+	}
+
+	_, err = s.FindTicketByRelay(ctx, storage.AdminMessage{ChatID: 1, MessageID: 11})
+	wantNotFound(t, err)
+
+	if err := s.SetTicketStatus(ctx, id, domain.TicketStatusClosed, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.GetUserTicket(ctx, uid)
+	wantNotFound(t, err)
+
+	closed, err := s.ListTickets(ctx, []domain.TicketStatus{domain.TicketStatusClosed}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(closed) != 1 || closed[0].ID != id {
+		t.Errorf("closed tickets = %+v, want %d", closed, id)
+	}
+}
+
+func testInvites(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	if err := s.CreateInvite(ctx, storage.Invite{Code: "code", CreatedBy: uid, CreatedAt: now()}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CreateInvite(ctx, storage.Invite{Code: "second", CreatedBy: uid, CreatedAt: now()}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// limit is reached
+	wantNotFound(t, s.CreateInvite(ctx, storage.Invite{Code: "third", CreatedBy: uid, CreatedAt: now()}, 2))
+
+	if n, _ := s.CountInvites(ctx, uid); n != 2 {
+		t.Errorf("invites = %d, want 2", n)
+	}
+
+	// no limit
+	for _, code := range []string{"admin1", "admin2", "admin3"} {
+		if err := s.CreateInvite(ctx, storage.Invite{Code: code, CreatedBy: otherUID, CreatedAt: now()}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, _ := s.CountInvites(ctx, otherUID); n != 3 {
+		t.Errorf("unlimited invites = %d, want 3", n)
+	}
+
+	m, err := s.UseInvite(ctx, "code", storage.Member{UID: otherUID, Username: "friend", JoinedAt: now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.InvitedBy != uid {
+		t.Errorf("member = %+v, want invited by %d", m, uid)
+	}
+
+	_, err = s.UseInvite(ctx, "code", storage.Member{UID: 102, JoinedAt: now()})
+	wantNotFound(t, err)
+
+	_, err = s.GetMember(ctx, 102)
+	wantNotFound(t, err)
+
+	// existing member is not changed
+	if err := s.SaveMember(ctx, storage.Member{UID: otherUID, InvitedBy: 1, JoinedAt: now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, _ = s.GetMember(ctx, otherUID); m.InvitedBy != uid {
+		t.Errorf("member = %+v, want unchanged", m)
+	}
+
+	if members, _ := s.ListMembers(ctx); len(members) != 1 {
+		t.Errorf("members = %+v, want 1", members)
+	}
+
+	_, err = s.GetJoinRequest(ctx, uid)
+	wantNotFound(t, err)
+
+	r := storage.JoinRequest{UID: uid, Username: "user", Status: domain.JoinRequestPending, CreatedAt: now()}
+
+	if err := s.SaveJoinRequest(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Status = domain.JoinRequestDenied
+
+	if err := s.SaveJoinRequest(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := s.GetJoinRequest(ctx, uid); got.Status != domain.JoinRequestDenied || got.Username != "user" {
+		t.Errorf("join request = %+v, want denied", got)
+	}
+
+	if err := s.DeleteJoinRequest(ctx, uid); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.GetJoinRequest(ctx, uid)
+	wantNotFound(t, err)
+}
+
+func testReferrals(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	if err := s.SaveReferral(ctx, storage.Referral{UID: otherUID, ReferrerID: uid, CreatedAt: now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// existing referral is not changed
+	if err := s.SaveReferral(ctx, storage.Referral{UID: otherUID, ReferrerID: 1, CreatedAt: now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	oid := createOrder(t, s, storage.CreateOrderParams{UID: otherUID})
+
+	r, err := s.RewardReferral(ctx, otherUID, 100, oid, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.ReferrerID != uid || !r.RewardedAt.Valid {
+		t.Errorf("referral = %+v, want rewarded referral of %d", r, uid)
+	}
+
+	_, err = s.RewardReferral(ctx, otherUID, 100, oid, now())
+	wantNotFound(t, err)
+
+	if balance, _ := s.GetBalance(ctx, uid); balance != 100 {
+		t.Errorf("balance of referrer = %d, want 100", balance)
+	}
+
+	refs, err := s.ListReferrals(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(refs) != 1 || refs[0].UID != otherUID {
+		t.Errorf("referrals = %+v, want %d", refs, otherUID)
+	}
+}
+
+func testLedger(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	entries := []storage.LedgerEntry{
+		{UID: uid, Amount: 450, Kind: domain.LedgerTopUp, CreatedAt: now().Add(-2 * time.Hour)},
+		{UID: uid, Amount: -150, Kind: domain.LedgerRenewal, OrderID: 1, CreatedAt: now().Add(-time.Hour)},
+		{UID: otherUID, Amount: 100, Kind: domain.LedgerTopUp, CreatedAt: now()},
+	}
+
+	for _, e := range entries {
+		if err := s.AddLedgerEntry(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if balance, _ := s.GetBalance(ctx, uid); balance != 300 {
+		t.Errorf("balance = %d, want 300", balance)
+	}
+
+	if balance, _ := s.GetBalance(ctx, 1); balance != 0 {
+		t.Errorf("balance of unknown user = %d, want 0", balance)
+	}
+
+	got, err := s.ListLedgerEntries(ctx, uid, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].Amount != -150 || got[0].OrderID != 1 || got[0].Kind != domain.LedgerRenewal {
+		t.Errorf("entries = %+v, want the latest charge", got)
+	}
+}
+
+func testRevokeOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	oid := approvedOrder(t, s, uid, now().Add(domain.OrderTTL))
+
+	if err := s.RevokeOrder(ctx, oid, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	wantNotFound(t, s.RevokeOrder(ctx, oid, now()))
+	wantNotFound(t, s.RevokeOrder(ctx, oid+1, now()))
+
+	if o := getOrder(t, s, oid); o.Status.String != string(domain.OrderStatusRevoked) {
+		t.Errorf("status = %s, want %s", o.Status.String, domain.OrderStatusRevoked)
+	}
+}
+
+func testCancelOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	oid := createOrder(t, s, storage.CreateOrderParams{})
+
+	if err := s.CancelOrder(ctx, oid, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	wantNotFound(t, s.CancelOrder(ctx, oid, now()))
+
+	if o := getOrder(t, s, oid); o.Status.String != string(domain.OrderStatusCanceled) {
+		t.Errorf("status = %s, want %s", o.Status.String, domain.OrderStatusCanceled)
+	}
+
+	paid := approvedOrder(t, s, uid, now().Add(domain.OrderTTL))
+
+	wantNotFound(t, s.CancelOrder(ctx, paid, now()))
+
+	if o := getOrder(t, s, paid); o.Status.String != string(domain.OrderStatusApproved) {
+		t.Errorf("status = %s, want %s", o.Status.String, domain.OrderStatusApproved)
+	}
+}
+
+func testApproveOrderConflict(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	exp := now().Add(domain.OrderTTL)
+
+	oid := createOrder(t, s, storage.CreateOrderParams{})
+
+	if err := s.ApproveOrder(ctx, oid, []storage.Key{{ID: "1", Name: "first", URL: "ss://1"}}, exp); err != nil {
+		t.Fatal(err)
+	}
+
+	// approved twice
+	wantNotFound(t, s.ApproveOrder(ctx, oid, []storage.Key{{ID: "2", Name: "second", URL: "ss://2"}}, exp.Add(day)))
+
+	if o := getOrder(t, s, oid); !o.ExpiresAt.Time.Equal(exp) {
+		t.Errorf("expires at = %s, want %s", o.ExpiresAt.Time, exp)
+	}
+
+	// approved after cancel
+	canceled := createOrder(t, s, storage.CreateOrderParams{})
+
+	if err := s.CancelOrder(ctx, canceled, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	wantNotFound(t, s.ApproveOrder(ctx, canceled, []storage.Key{{ID: "3", Name: "third", URL: "ss://3"}}, exp))
+
+	if o := getOrder(t, s, canceled); o.Status.String != string(domain.OrderStatusCanceled) {
+		t.Errorf("status = %s, want %s", o.Status.String, domain.OrderStatusCanceled)
+	}
+
+	keys, err := s.ListActiveUserKeys(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || keys[0].ID != "1" {
+		t.Errorf("keys = %+v, want only key of first approval", keys)
+	}
+}
+
+func testRenewOrderConflict(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	// expiration with fraction of second is matched up to seconds
+	exp := time.Now().UTC().Add(domain.OrderTTL)
+	oid := approvedOrder(t, s, uid, exp)
+
+	if err := s.RenewOrder(ctx, oid, exp.Truncate(time.Second), domain.OrderTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	// renewed twice from the same expiration
+	wantNotFound(t, s.RenewOrder(ctx, oid, exp, domain.OrderTTL))
+
+	want := exp.Add(domain.OrderTTL).Truncate(time.Second)
+
+	if o := getOrder(t, s, oid); !o.ExpiresAt.Time.Truncate(time.Second).Equal(want) {
+		t.Errorf("expires at = %s, want %s", o.ExpiresAt.Time, want)
+	}
+
+	if err := s.CloseOrder(ctx, oid, domain.OrderStatusRevoked, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	wantNotFound(t, s.RenewOrder(ctx, oid, getOrder(t, s, oid).ExpiresAt.Time, domain.OrderTTL))
+
+	wantNotFound(t, s.RenewOrder(ctx, oid+1, exp, domain.OrderTTL))
+}
+
+func testRenewOrderWithCredit(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	exp := now().Add(day)
+	oid := approvedOrder(t, s, uid, exp)
+	price := getOrder(t, s, oid).Price
+
+	if err := s.AddLedgerEntry(ctx, storage.LedgerEntry{UID: uid, Amount: price - 1, Kind: domain.LedgerTopUp, CreatedAt: now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.RenewOrderWithCredit(ctx, oid, exp, domain.OrderTTL, true, now())
+	if !errors.Is(err, storage.ErrInsufficientBalance) {
+		t.Fatalf("err = %v, want %v", err, storage.ErrInsufficientBalance)
+	}
+
+	if o := getOrder(t, s, oid); !o.ExpiresAt.Time.Truncate(time.Second).Equal(exp.Truncate(time.Second)) {
+		t.Errorf("expires at = %s, want not renewed %s", o.ExpiresAt.Time, exp)
+	}
+
+	// balance pays part of price
+	credit, err := s.RenewOrderWithCredit(ctx, oid, exp, domain.OrderTTL, false, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if credit != price-1 {
+		t.Errorf("credit = %d, want %d", credit, price-1)
+	}
+
+	_, err = s.RenewOrderWithCredit(ctx, oid, exp, domain.OrderTTL, false, now())
+	wantNotFound(t, err)
+
+	// renewal without balance charges nothing
+	exp = exp.Add(domain.OrderTTL)
+
+	credit, err = s.RenewOrderWithCredit(ctx, oid, exp, domain.OrderTTL, false, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if credit != 0 {
+		t.Errorf("credit = %d, want 0", credit)
+	}
+
+	// balance covers one of concurrent renewals of other orders
+	if err := s.AddLedgerEntry(ctx, storage.LedgerEntry{UID: otherUID, Amount: price, Kind: domain.LedgerTopUp, CreatedAt: now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	exp = now().Add(day)
+	oids := []domain.OrderID{approvedOrder(t, s, otherUID, exp), approvedOrder(t, s, otherUID, exp)}
+	errs := make([]error, len(oids))
+
+	var wg sync.WaitGroup
+	for i, oid := range oids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = s.RenewOrderWithCredit(ctx, oid, exp, domain.OrderTTL, true, now())
+		}()
+	}
+	wg.Wait()
+
+	renewed := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			renewed++
+		case !errors.Is(err, storage.ErrInsufficientBalance):
+			t.Error(err)
+		}
+	}
+
+	if renewed != 1 {
+		t.Errorf("renewed orders = %d, want 1", renewed)
+	}
+
+	for _, u := range []int64{uid, otherUID} {
+		if balance, err := s.GetBalance(ctx, u); err != nil || balance != 0 {
+			t.Errorf("balance of %d = %d, %v, want 0", u, balance, err)
+		}
+	}
+}
+
+func testRefundOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	oid := approvedOrder(t, s, uid, now().Add(domain.OrderTTL))
+
+	if err := s.RefundOrder(ctx, oid, 70, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	wantNotFound(t, s.RefundOrder(ctx, oid, 70, now()))
+
+	if o := getOrder(t, s, oid); o.Status.String != string(domain.OrderStatusRefunded) {
+		t.Errorf("status = %s, want %s", o.Status.String, domain.OrderStatusRefunded)
+	}
+
+	entries, err := s.ListLedgerEntries(ctx, uid, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Amount != 70 || entries[0].Kind != domain.LedgerRefund || entries[0].OrderID != oid {
+		t.Errorf("entries = %+v, want single refund", entries)
+	}
+
+	// nothing to refund
+	free := approvedOrder(t, s, uid, now().Add(domain.OrderTTL))
+
+	if err := s.RefundOrder(ctx, free, 0, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, _ = s.ListLedgerEntries(ctx, uid, 10); len(entries) != 1 {
+		t.Errorf("entries = %+v, want no entry for zero refund", entries)
+	}
+}
+
+func testWallets(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	approved, err := s.CreateTopUp(ctx, storage.TopUp{UID: uid, Username: "user", Amount: 450, Status: domain.TopUpStatusPending, CreatedAt: now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejected, err := s.CreateTopUp(ctx, storage.TopUp{UID: uid, Amount: 150, Status: domain.TopUpStatusPending, CreatedAt: now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp, err := s.ApproveTopUp(ctx, approved, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tp.UID != uid || tp.Amount != 450 || tp.Status != domain.TopUpStatusApproved || !tp.ClosedAt.Valid {
+		t.Errorf("unexpected approved top up: %+v", tp)
+	}
+
+	_, err = s.ApproveTopUp(ctx, approved, now())
+	wantNotFound(t, err)
+
+	if tp, err = s.RejectTopUp(ctx, rejected, now()); err != nil || tp.Status != domain.TopUpStatusRejected {
+		t.Errorf("rejected top up = %+v, %v", tp, err)
+	}
+
+	_, err = s.ApproveTopUp(ctx, rejected, now())
+	wantNotFound(t, err)
+
+	if balance, _ := s.GetBalance(ctx, uid); balance != 450 {
+		t.Errorf("balance = %d, want 450", balance)
+	}
+
+	for _, enabled := range []bool{true, true, false} {
+		if err := s.SetAutoRenew(ctx, uid, enabled, now()); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, _ := s.IsAutoRenew(ctx, uid); got != enabled {
+			t.Errorf("auto renew = %t, want %t", got, enabled)
+		}
+	}
+}
+
+func testPromos(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	p := storage.Promo{Code: "SPRING", Percent: 10, Days: 7, MaxUses: 1, FirstOrderOnly: true, CreatedAt: now()}
+
+	if err := s.CreatePromo(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetPromo(ctx, "SPRING")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Percent != 10 || got.Days != 7 || got.MaxUses != 1 || !got.FirstOrderOnly || got.Disabled || got.ExpiresAt.Valid {
+		t.Errorf("unexpected promo: %+v", got)
+	}
+
+	_, err = s.GetPromo(ctx, "UNKNOWN")
+	wantNotFound(t, err)
+
+	if err := s.UsePromo(ctx, "SPRING"); err != nil {
+		t.Fatal(err)
+	}
+
+	wantNotFound(t, s.UsePromo(ctx, "SPRING"))
+
+	oid := createOrder(t, s, storage.CreateOrderParams{Price: 300, PromoCode: "SPRING", Discount: 30})
+
+	if err := s.ApproveOrder(ctx, oid, nil, now().Add(domain.OrderTTL)); err != nil {
+		t.Fatal(err)
+	}
+
+	// not paid
+	createOrder(t, s, storage.CreateOrderParams{Price: 300, PromoCode: "SPRING", Discount: 30})
+
+	if err := s.CreatePromo(ctx, storage.Promo{Code: "SUMMER", Amount: 50, CreatedAt: now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DisablePromo(ctx, "SUMMER"); err != nil {
+		t.Fatal(err)
+	}
+
+	wantNotFound(t, s.UsePromo(ctx, "SUMMER"))
+
+	promos, err := s.ListPromos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(promos) != 2 || promos[0].Code != "SUMMER" || !promos[0].Disabled || promos[1].Code != "SPRING" {
+		t.Fatalf("promos = %+v, want latest first", promos)
+	}
+
+	if st := promos[1]; st.Uses != 1 || st.PaidOrders != 1 || st.Discount != 30 {
+		t.Errorf("promo stats = %+v, want one paid order", st)
+	}
+}
+
+func testTrials(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	_, err := s.GetTrial(ctx, uid)
+	wantNotFound(t, err)
+
+	oid := createOrder(t, s, storage.CreateOrderParams{Price: -1})
+
+	if err := s.CreateTrial(ctx, storage.Trial{UID: uid, OrderID: oid, CreatedAt: now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// user has only one trial
+	other := createOrder(t, s, storage.CreateOrderParams{Price: -1})
+	wantNotFound(t, s.CreateTrial(ctx, storage.Trial{UID: uid, OrderID: other, CreatedAt: now()}))
+
+	// trial of other order is not deleted
+	if err := s.DeleteTrial(ctx, uid, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.MarkTrialReminded(ctx, uid, now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := s.GetTrial(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tr.OrderID != oid || !tr.RemindedAt.Valid {
+		t.Errorf("trial = %+v, want reminded trial of order %d", tr, oid)
+	}
+
+	if err := s.DeleteTrial(ctx, uid, oid); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.GetTrial(ctx, uid)
+	wantNotFound(t, err)
+}
+
+func testStats(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	approvedOrder(t, s, uid, now().Add(day))
+	approvedOrder(t, s, otherUID, now().Add(domain.OrderTTL))
+	createOrder(t, s, storage.CreateOrderParams{Price: 300, Discount: 30})
+
+	expired := approvedOrder(t, s, 102, now().Add(-day))
+	if err := s.CloseOrder(ctx, expired, domain.OrderStatusExpired, now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := s.OrderStats(ctx, storage.OrderStatsParams{
+		Now:           now(),
+		ChurnedSince:  now().Add(-7 * day),
+		ExpiresBefore: now().Add(domain.BeforeOrderExpiration),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := storage.OrderStats{
+		ActiveOrders:         2,
+		ActiveKeys:           2,
+		MRR:                  2 * domain.PricePerKey,
+		AwaitingPayment:      1,
+		AwaitingPaymentPrice: 270,
+		Churned:              1,
+		ChurnedPrice:         domain.PricePerKey,
+		Expiring:             1,
+		ExpiringPrice:        domain.PricePerKey,
+	}
+
+	if st != want {
+		t.Errorf("stats = %+v, want %+v", st, want)
+	}
+
+	months, err := s.RevenueByMonth(ctx, now().Add(-day))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(months) != 1 || months[0].Month != now().Format("2006-01") || months[0].Orders != 3 || months[0].Revenue != 3*domain.PricePerKey {
+		t.Errorf("revenue = %+v, want 3 paid orders of this month", months)
+	}
+}
@@ -0,0 +1,506 @@
+// Package telegramtest provides fake telegram bot api server for tests.
+// It records requests of the bot and delivers updates pushed by tests via getUpdates.
+package telegramtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// BotID is telegram id of the bot returned by getMe.
+const BotID = 1000
+
+var ErrTimeout = errors.New("telegramtest: wait timeout")
+
+// Call is bot api request made by the bot.
+type Call struct {
+	Method    string
+	ChatID    int64
+	MessageID int
+	// ThreadID is forum topic of the message.
+	ThreadID int
+	// Text is text of the message or caption of the media.
+	Text   string
+	Markup *tele.ReplyMarkup
+	Params map[string]string
+}
+
+// Button returns inline button of the call by its text.
+func (c Call) Button(text string) (tele.InlineButton, bool) {
+	if c.Markup == nil {
+		return tele.InlineButton{}, false
+	}
+
+	for _, row := range c.Markup.InlineKeyboard {
+		for _, btn := range row {
+			if btn.Text == text {
+				return btn, true
+			}
+		}
+	}
+
+	return tele.InlineButton{}, false
+}
+
+type Server struct {
+	// URL is bot api url of the server, pass it to tele.Settings.
+	URL string
+
+	srv *httptest.Server
+
+	mu           sync.Mutex
+	calls        []Call
+	commands     []tele.Command
+	updates      []tele.Update
+	lastUpdateID int
+	lastMsgID    int
+	members      map[int64]map[int64]tele.MemberStatus // chat id -> user id -> status
+	blocked      map[int64]bool                        // chats of users who blocked the bot
+	failing      map[string]bool                       // methods which always fail
+	flooded      map[int64]int                         // chats and amount of messages to them failed with flood wait
+	changed      chan struct{}                         // closed and replaced on every new call or update
+}
+
+// NewServer starts new fake telegram bot api server, it must be closed after use.
+func NewServer() *Server {
+	s := &Server{
+		changed: make(chan struct{}),
+		members: make(map[int64]map[int64]tele.MemberStatus),
+		blocked: make(map[int64]bool),
+		failing: make(map[string]bool),
+		flooded: make(map[int64]int),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.srv.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Calls returns calls of method to chat, zero chat id matches all chats.
+func (s *Server) Calls(method string, chatID int64) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filter(method, chatID)
+}
+
+// filter must be called under lock.
+func (s *Server) filter(method string, chatID int64) []Call {
+	var calls []Call
+
+	for _, c := range s.calls {
+		if c.Method == method && (chatID == 0 || c.ChatID == chatID) {
+			calls = append(calls, c)
+		}
+	}
+
+	return calls
+}
+
+// Wait waits until bot makes at least n calls of method to chat and returns all of them.
+func (s *Server) Wait(method string, chatID int64, n int, timeout time.Duration) ([]Call, error) {
+	deadline := time.After(timeout)
+
+	for {
+		s.mu.Lock()
+		calls := s.filter(method, chatID)
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(calls) >= n {
+			return calls, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return calls, fmt.Errorf("%w: %s to %d, got %d calls, want %d", ErrTimeout, method, chatID, len(calls), n)
+		}
+	}
+}
+
+// Commands returns bot commands set by setMyCommands.
+func (s *Server) Commands() []tele.Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands
+}
+
+// SetMember sets status of user in group chat returned by getChatMember, users are not members by default.
+func (s *Server) SetMember(chatID, userID int64, status tele.MemberStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.members[chatID] == nil {
+		s.members[chatID] = make(map[int64]tele.MemberStatus)
+	}
+
+	s.members[chatID][userID] = status
+}
+
+// Block makes messages to chat fail as if user blocked the bot.
+func (s *Server) Block(chatID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked[chatID] = true
+}
+
+// Fail makes all calls of method fail with bad request.
+func (s *Server) Fail(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing[method] = true
+}
+
+// Flood makes next n messages to chat fail with too many requests and zero retry after.
+func (s *Server) Flood(chatID int64, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flooded[chatID] = n
+}
+
+// PushText sends text message from user to the bot in private chat.
+func (s *Server) PushText(from *tele.User, text string) {
+	s.push(tele.Update{Message: &tele.Message{
+		ID:       s.nextMsgID(),
+		Unixtime: time.Now().Unix(),
+		Sender:   from,
+		Chat:     privateChat(from),
+		Text:     text,
+	}})
+}
+
+// PushCallback presses inline button with data on message of call by user.
+func (s *Server) PushCallback(from *tele.User, c Call, data string) {
+	chat := &tele.Chat{ID: c.ChatID, Type: chatType(c.ChatID)}
+	if c.ChatID == from.ID {
+		chat = privateChat(from)
+	}
+
+	s.push(tele.Update{Callback: &tele.Callback{
+		Sender: from,
+		Data:   data,
+		Message: &tele.Message{
+			ID:       c.MessageID,
+			ThreadID: c.ThreadID,
+			Chat:     chat,
+			Text:     c.Text,
+		},
+	}})
+}
+
+func (s *Server) push(u tele.Update) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastUpdateID++
+	u.ID = s.lastUpdateID
+
+	if u.Callback != nil {
+		u.Callback.ID = strconv.Itoa(u.ID)
+	}
+
+	s.updates = append(s.updates, u)
+	s.notify()
+}
+
+func (s *Server) nextMsgID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastMsgID++
+	return s.lastMsgID
+}
+
+// notify must be called under lock.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// chatType returns type of chat by id, group ids are negative.
+func chatType(id int64) tele.ChatType {
+	if id < 0 {
+		return tele.ChatSuperGroup
+	}
+	return tele.ChatPrivate
+}
+
+func privateChat(u *tele.User) *tele.Chat {
+	return &tele.Chat{
+		ID:        u.ID,
+		Type:      tele.ChatPrivate,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Username:  u.Username,
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	// path is /bot<token>/<method>
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	params, err := parseParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	failing := s.failing[method]
+	s.mu.Unlock()
+
+	if failing {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+method+" failed by telegramtest")
+		return
+	}
+
+	switch method {
+	case "getMe":
+		writeResult(w, &tele.User{ID: BotID, IsBot: true, FirstName: "telegramtest", Username: "telegramtest_bot"})
+	case "getUpdates":
+		s.getUpdates(w, r, params)
+	case "setMyCommands":
+		s.setCommands(w, params)
+	case "sendMessage", "sendPhoto", "sendDocument", "copyMessage":
+		if s.isBlocked(params["chat_id"]) {
+			writeError(w, http.StatusForbidden, "Forbidden: bot was blocked by the user")
+			return
+		}
+		if s.isFlooded(params["chat_id"]) {
+			writeFloodError(w)
+			return
+		}
+		writeResult(w, s.record(method, params))
+	case "editMessageText", "editMessageCaption", "editMessageReplyMarkup":
+		writeResult(w, s.record(method, params))
+	case "createForumTopic":
+		c := s.record(method, params)
+		writeResult(w, &tele.Topic{Name: params["name"], ThreadID: c.ID})
+	case "getChatMember":
+		s.getChatMember(w, params)
+	case "answerCallbackQuery", "deleteMessage":
+		s.record(method, params)
+		writeResult(w, true)
+	case "setWebhook", "deleteWebhook":
+		// not recorded as message, so ids of messages don't depend on polling mode
+		s.mu.Lock()
+		s.calls = append(s.calls, Call{Method: method, Params: params})
+		s.notify()
+		s.mu.Unlock()
+		writeResult(w, true)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found: method "+method+" not supported by telegramtest")
+	}
+}
+
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	offset, _ := strconv.Atoi(params["offset"])
+	timeout, _ := strconv.Atoi(params["timeout"])
+	deadline := time.After(time.Duration(timeout) * time.Second)
+
+	for {
+		s.mu.Lock()
+
+		var updates []tele.Update
+		for _, u := range s.updates {
+			if u.ID >= offset {
+				updates = append(updates, u)
+			}
+		}
+
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(updates) > 0 || timeout == 0 {
+			writeResult(w, updates)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			timeout = 0
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) isBlocked(chatID string) bool {
+	id, _ := strconv.ParseInt(chatID, 10, 64)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.blocked[id]
+}
+
+// isFlooded returns true if message to chat must fail with flood wait and counts it.
+func (s *Server) isFlooded(chatID string) bool {
+	id, _ := strconv.ParseInt(chatID, 10, 64)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.flooded[id] == 0 {
+		return false
+	}
+
+	s.flooded[id]--
+
+	return true
+}
+
+func (s *Server) getChatMember(w http.ResponseWriter, params map[string]string) {
+	chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+	userID, _ := strconv.ParseInt(params["user_id"], 10, 64)
+
+	s.mu.Lock()
+	status, ok := s.members[chatID][userID]
+	s.mu.Unlock()
+
+	if !ok {
+		status = tele.Left
+	}
+
+	writeResult(w, &tele.ChatMember{User: &tele.User{ID: userID}, Role: status})
+}
+
+func (s *Server) setCommands(w http.ResponseWriter, params map[string]string) {
+	var cmds []tele.Command
+
+	if err := json.Unmarshal([]byte(params["commands"]), &cmds); err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.commands = cmds
+	s.mu.Unlock()
+
+	writeResult(w, true)
+}
+
+// record saves call and returns message which is sent or edited by it.
+func (s *Server) record(method string, params map[string]string) *tele.Message {
+	c := Call{
+		Method: method,
+		Params: params,
+		Text:   params["text"],
+	}
+
+	c.ChatID, _ = strconv.ParseInt(params["chat_id"], 10, 64)
+	c.MessageID, _ = strconv.Atoi(params["message_id"])
+	c.ThreadID, _ = strconv.Atoi(params["message_thread_id"])
+
+	if c.Text == "" {
+		c.Text = params["caption"]
+	}
+
+	if markup := params["reply_markup"]; markup != "" {
+		c.Markup = &tele.ReplyMarkup{}
+		if err := json.Unmarshal([]byte(markup), c.Markup); err != nil {
+			c.Markup = nil
+		}
+	}
+
+	// copy is new message, message_id param is id of copied one
+	if c.MessageID == 0 || method == "copyMessage" {
+		c.MessageID = s.nextMsgID()
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, c)
+	s.notify()
+	s.mu.Unlock()
+
+	msg := &tele.Message{
+		ID:       c.MessageID,
+		Unixtime: time.Now().Unix(),
+		ThreadID: c.ThreadID,
+		Chat:     &tele.Chat{ID: c.ChatID, Type: chatType(c.ChatID)},
+		Text:     c.Text,
+	}
+
+	switch method {
+	case "sendPhoto":
+		msg.Photo = &tele.Photo{File: tele.File{FileID: "photo" + strconv.Itoa(c.MessageID)}}
+		msg.Caption = c.Text
+	case "sendDocument":
+		msg.Document = &tele.Document{File: tele.File{FileID: "document" + strconv.Itoa(c.MessageID)}}
+		msg.Caption = c.Text
+	}
+
+	return msg
+}
+
+// parseParams returns params of json or multipart request.
+func parseParams(r *http.Request) (map[string]string, error) {
+	params := make(map[string]string)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, err
+		}
+
+		for k, v := range r.MultipartForm.Value {
+			params[k] = v[0]
+		}
+
+		return params, nil
+	}
+
+	if r.ContentLength == 0 {
+		return params, nil
+	}
+
+	raw := make(map[string]json.RawMessage)
+
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	// non string params are kept as raw json
+	for k, v := range raw {
+		var str string
+		if err := json.Unmarshal(v, &str); err != nil {
+			str = string(v)
+		}
+		params[k] = str
+	}
+
+	return params, nil
+}
+
+func writeResult(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": v})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": code, "description": description})
+}
+
+func writeFloodError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]any{
+		"ok":          false,
+		"error_code":  http.StatusTooManyRequests,
+		"description": "Too Many Requests: retry after 0",
+		"parameters":  map[string]any{"retry_after": 0},
+	})
+}
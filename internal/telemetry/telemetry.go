@@ -0,0 +1,145 @@
+// Package telemetry sets up global opentelemetry tracer and meter providers and prometheus metrics endpoint.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"github.com/ysomad/outline-bot/internal/config"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+
+	// ShutdownTimeout is time given to exporters to flush telemetry on shutdown.
+	ShutdownTimeout = 5 * time.Second
+)
+
+var ErrUnknownExporter = errors.New("unknown telemetry exporter")
+
+// Telemetry is set of global tracer and meter providers.
+type Telemetry struct {
+	registry *prometheus.Registry
+	shutdown []func(context.Context) error
+}
+
+// Setup sets global tracer and meter providers exporting to exporter from config.
+// Metrics are always collected into prometheus registry served by MetricsHandler,
+// tracer provider is left noop if exporter is none.
+// Exporters are configured with standard OTEL_EXPORTER_OTLP_* and OTEL_METRIC_EXPORT_INTERVAL environment variables.
+func Setup(ctx context.Context, conf config.Telemetry) (*Telemetry, error) {
+	var (
+		spanExporter   sdktrace.SpanExporter
+		metricExporter sdkmetric.Exporter
+		err            error
+	)
+
+	switch conf.Exporter {
+	case ExporterNone, "":
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+		if err != nil {
+			return nil, fmt.Errorf("stdout span exporter not created: %w", err)
+		}
+
+		metricExporter, err = stdoutmetric.New(stdoutmetric.WithWriter(os.Stderr))
+		if err != nil {
+			return nil, fmt.Errorf("stdout metric exporter not created: %w", err)
+		}
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("otlp span exporter not created: %w", err)
+		}
+
+		metricExporter, err = otlpmetrichttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("otlp metric exporter not created: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExporter, conf.Exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(conf.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("resource not created: %w", err)
+	}
+
+	t := &Telemetry{registry: prometheus.NewRegistry()}
+
+	t.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	promExporter, err := otelprom.New(otelprom.WithRegisterer(t.registry))
+	if err != nil {
+		return nil, fmt.Errorf("prometheus exporter not created: %w", err)
+	}
+
+	meterOpts := []sdkmetric.Option{
+		sdkmetric.WithReader(promExporter),
+		sdkmetric.WithResource(res),
+	}
+
+	if metricExporter != nil {
+		meterOpts = append(meterOpts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))
+	}
+
+	mp := sdkmetric.NewMeterProvider(meterOpts...)
+	otel.SetMeterProvider(mp)
+	t.shutdown = append(t.shutdown, mp.Shutdown)
+
+	if spanExporter != nil {
+		tp := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(spanExporter),
+			sdktrace.WithResource(res),
+		)
+
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		t.shutdown = append(t.shutdown, tp.Shutdown)
+	}
+
+	return t, nil
+}
+
+// MetricsHandler serves metrics in prometheus format.
+func (t *Telemetry) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(t.registry, promhttp.HandlerOpts{})
+}
+
+// Shutdown flushes and stops providers.
+func (t *Telemetry) Shutdown(ctx context.Context) error {
+	errs := make([]error, len(t.shutdown))
+
+	for i, f := range t.shutdown {
+		errs[i] = f(ctx)
+	}
+
+	return errors.Join(errs...)
+}
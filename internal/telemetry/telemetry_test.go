@@ -0,0 +1,46 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+
+	"github.com/ysomad/outline-bot/internal/config"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	tel, err := Setup(context.Background(), config.Telemetry{Exporter: ExporterNone, ServiceName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tel.Shutdown(context.Background()) })
+
+	counter, err := otel.Meter("test").Int64Counter("bot.orders.created")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter.Add(context.Background(), 2)
+
+	rec := httptest.NewRecorder()
+	tel.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), "bot_orders_created_total") {
+		t.Errorf("counter not exported:\n%s", body)
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), config.Telemetry{Exporter: "jaeger"}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -4,26 +4,37 @@ import (
 	"context"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	sq "github.com/Masterminds/squirrel"
 	"github.com/hashicorp/golang-lru/v2/expirable"
 	"github.com/ilyakaznacheev/cleanenv"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
 
 	"github.com/ysomad/outline-bot/internal/bot"
 	"github.com/ysomad/outline-bot/internal/config"
+	"github.com/ysomad/outline-bot/internal/domain"
+	"github.com/ysomad/outline-bot/internal/health"
 	"github.com/ysomad/outline-bot/internal/outline"
+	"github.com/ysomad/outline-bot/internal/provisioner"
 	"github.com/ysomad/outline-bot/internal/slogx"
-	"github.com/ysomad/outline-bot/internal/storage"
+	"github.com/ysomad/outline-bot/internal/storage/otelstore"
+	"github.com/ysomad/outline-bot/internal/storage/sqlstore"
+	"github.com/ysomad/outline-bot/internal/telemetry"
+	"github.com/ysomad/outline-bot/migrations"
 )
 
+const readinessTimeout = 5 * time.Second
+
 func main() {
 	var conf config.Config
 
@@ -41,7 +52,17 @@ func main() {
 
 	slog.Debug("loaded config", "config", conf)
 
-	db, err := sql.Open("sqlite3", "db.db?_foreign_keys=on")
+	tel, err := telemetry.Setup(context.Background(), conf.Telemetry)
+	if err != nil {
+		slogx.Fatal(fmt.Sprintf("telemetry not set up: %s", err.Error()))
+	}
+
+	dialect, err := sqlstore.DialectByName(conf.DB.Driver)
+	if err != nil {
+		slogx.Fatal(err.Error())
+	}
+
+	db, err := sql.Open(dialect.Driver, conf.DB.DSN)
 	if err != nil {
 		slogx.Fatal(fmt.Sprintf("db not opened: %s", err.Error()))
 	}
@@ -50,8 +71,33 @@ func main() {
 		slogx.Fatal(fmt.Sprintf("ping failed: %s", err.Error()))
 	}
 
+	// migrate subcommand applies migrations and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err = migrations.Up(context.Background(), db, dialect.Name); err != nil {
+			slogx.Fatal(fmt.Sprintf("migrations not applied: %s", err.Error()))
+		}
+		slog.Info("migrations applied")
+		return
+	}
+
+	// copy-sqlite subcommand copies sqlite database from path in args into postgres and exits
+	if len(os.Args) > 1 && os.Args[1] == "copy-sqlite" {
+		copySQLite(db, dialect)
+		return
+	}
+
+	if err = migrations.Check(context.Background(), db, dialect.Name); err != nil {
+		slogx.Fatal(fmt.Sprintf("db schema check failed: %s", err.Error()))
+	}
+
+	if conf.DB.MigrateOnStart {
+		if err = migrations.Up(context.Background(), db, dialect.Name); err != nil {
+			slogx.Fatal(fmt.Sprintf("migrations not applied: %s", err.Error()))
+		}
+	}
+
 	stateLRU := expirable.NewLRU[string, bot.State](100, nil, time.Hour)
-	storage := storage.New(db, sq.StatementBuilder.PlaceholderFormat(sq.Question))
+	storage := otelstore.New(sqlstore.New(db, dialect), otel.GetTracerProvider())
 
 	outlineHttpCli := &http.Client{
 		Timeout: conf.Outline.HTTPTimeout,
@@ -60,12 +106,45 @@ func main() {
 		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
 	}
 
-	outlineClient, err := outline.NewClient(conf.Outline.URL, outline.WithClient(outlineHttpCli))
+	outlineClient, err := outline.NewClient(conf.Outline.URL,
+		outline.WithClient(outlineHttpCli),
+		outline.WithTracerProvider(otel.GetTracerProvider()),
+		outline.WithMeterProvider(otel.GetMeterProvider()))
 	if err != nil {
 		slogx.Fatal(err.Error())
 	}
 
-	bot, err := bot.New(conf.TG, stateLRU, outlineClient, storage)
+	outlineBackend, err := provisioner.NewOutline(outlineClient, conf.Outline.KeyPrefix)
+	if err != nil {
+		slogx.Fatal(fmt.Sprintf("outline backend not initialized: %s", err.Error()))
+	}
+
+	backends := provisioner.NewBackends(outlineBackend)
+
+	if conf.WireGuard.Endpoint != "" {
+		pool, err := netip.ParsePrefix(conf.WireGuard.Pool)
+		if err != nil {
+			slogx.Fatal(fmt.Sprintf("wireguard pool not parsed: %s", err.Error()))
+		}
+
+		wg, err := provisioner.NewWireGuard(provisioner.WireGuardConfig{
+			Endpoint:  conf.WireGuard.Endpoint,
+			PublicKey: conf.WireGuard.PublicKey,
+			Pool:      pool,
+			DNS:       conf.WireGuard.DNS,
+		}, provisioner.NewCmdDevice(conf.WireGuard.Interface))
+		if err != nil {
+			slogx.Fatal(fmt.Sprintf("wireguard backend not initialized: %s", err.Error()))
+		}
+
+		backends[wg.Name()] = wg
+	}
+
+	if conf.TG.Webhook.URL != "" && conf.HTTP.Addr == "" {
+		slogx.Fatal("HTTP_ADDR must be set in webhook mode")
+	}
+
+	bot, err := bot.New(conf.TG, stateLRU, backends, domain.Backend(conf.Backend), storage)
 	if err != nil {
 		slogx.Fatal(fmt.Sprintf("bot not initialized: %s", err.Error()))
 	}
@@ -78,14 +157,105 @@ func main() {
 
 	go bot.NotifyExpiringOrders(ctx, conf.Worker.NotifyExpiringInterval)
 	go bot.DeactivateExpiredKeys(ctx, conf.Worker.DeactivateExpiredInterval)
+	go bot.RemindPendingOrders(ctx, conf.Worker.RemindPendingInterval, conf.Worker.RemindPendingAfter)
+	go bot.CancelUnpaidOrders(ctx, conf.Worker.CancelUnpaidInterval, conf.Worker.UnpaidOrderTTL)
 	go bot.Start()
 
+	mux := http.NewServeMux()
+	bot.RegisterWebhook(mux)
+	mux.Handle("GET /metrics", tel.MetricsHandler())
+	mux.Handle("GET /healthz", health.Live())
+	mux.Handle("GET /readyz", health.Ready(readinessTimeout, map[string]health.Check{
+		"db":       db.PingContext,
+		"outline":  outlineBackend.Ping,
+		"telegram": bot.PingTelegram,
+	}))
+
+	srv := &http.Server{
+		Addr:              conf.HTTP.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if conf.HTTP.Addr != "" {
+		go serveHTTP(srv, conf.HTTP)
+	}
+
 	slog.Info("bot started")
 	<-stop
 	slog.Info("shutting down")
 
 	cancel()
 	slog.Info("stopping bot")
+	// bot is stopped before http server so webhook updates are rejected and redelivered by telegram
 	bot.Stop()
 	slog.Info("bot stopped")
+
+	if conf.HTTP.Addr != "" {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer shutdownCancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("http server not stopped gracefully", "cause", err.Error())
+		}
+		slog.Info("http server stopped")
+	}
+
+	telemetryCtx, telemetryCancel := context.WithTimeout(context.Background(), telemetry.ShutdownTimeout)
+	defer telemetryCancel()
+
+	if err := tel.Shutdown(telemetryCtx); err != nil {
+		slog.Error("telemetry not flushed", "cause", err.Error())
+	}
+}
+
+func serveHTTP(srv *http.Server, conf config.HTTP) {
+	slog.Info("http server started", "addr", srv.Addr)
+
+	var err error
+
+	if conf.TLSCert != "" {
+		err = srv.ListenAndServeTLS(conf.TLSCert, conf.TLSKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slogx.Fatal(fmt.Sprintf("http server failed: %s", err.Error()))
+	}
+}
+
+func copySQLite(dst *sql.DB, dialect sqlstore.Dialect) {
+	if dialect.Name != sqlstore.Postgres.Name {
+		slogx.Fatal("copy-sqlite requires DB_DRIVER=postgres")
+	}
+
+	if len(os.Args) != 3 {
+		slogx.Fatal("usage: outline-bot copy-sqlite <path to sqlite db>")
+	}
+
+	src, err := sql.Open(sqlstore.SQLite.Driver, os.Args[2])
+	if err != nil {
+		slogx.Fatal(fmt.Sprintf("sqlite db not opened: %s", err.Error()))
+	}
+	defer src.Close()
+
+	ctx := context.Background()
+
+	// source is brought to the same schema version as postgres, so every copied column exists
+	if err = migrations.Check(ctx, src, sqlstore.SQLite.Driver); err != nil {
+		slogx.Fatal(fmt.Sprintf("sqlite schema check failed: %s", err.Error()))
+	}
+
+	if err = migrations.Up(ctx, src, sqlstore.SQLite.Driver); err != nil {
+		slogx.Fatal(fmt.Sprintf("sqlite migrations not applied: %s", err.Error()))
+	}
+
+	if err = migrations.Up(ctx, dst, dialect.Name); err != nil {
+		slogx.Fatal(fmt.Sprintf("migrations not applied: %s", err.Error()))
+	}
+
+	if err = sqlstore.CopySQLiteToPostgres(ctx, src, dst); err != nil {
+		slogx.Fatal(fmt.Sprintf("sqlite not copied: %s", err.Error()))
+	}
 }
@@ -0,0 +1,82 @@
+// Package migrations embeds sql migrations into the binary and applies them with goose.
+// Each supported database has its own migration set in a directory named after its driver.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+
+	"github.com/pressly/goose/v3"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var embedded embed.FS
+
+var ErrSchemaNewer = errors.New("database schema is newer than binary")
+
+// newProvider returns goose provider with migrations for driver, sqlite3 or postgres.
+func newProvider(db *sql.DB, driver string) (*goose.Provider, error) {
+	var (
+		dialect goose.Dialect
+		dir     string
+	)
+
+	switch driver {
+	case "sqlite3":
+		dialect, dir = goose.DialectSQLite3, "sqlite"
+	case "postgres":
+		dialect, dir = goose.DialectPostgres, "postgres"
+	default:
+		return nil, fmt.Errorf("unsupported db driver %q", driver)
+	}
+
+	fsys, err := fs.Sub(embedded, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return goose.NewProvider(dialect, db, fsys)
+}
+
+// Check returns ErrSchemaNewer if database schema version is newer than latest migration embedded in binary.
+func Check(ctx context.Context, db *sql.DB, driver string) error {
+	p, err := newProvider(db, driver)
+	if err != nil {
+		return fmt.Errorf("goose provider not created: %w", err)
+	}
+
+	current, target, err := p.GetVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("versions not received: %w", err)
+	}
+
+	if current > target {
+		return fmt.Errorf("%w: database version %d, binary version %d", ErrSchemaNewer, current, target)
+	}
+
+	return nil
+}
+
+// Up applies all new migrations.
+func Up(ctx context.Context, db *sql.DB, driver string) error {
+	p, err := newProvider(db, driver)
+	if err != nil {
+		return fmt.Errorf("goose provider not created: %w", err)
+	}
+
+	res, err := p.Up(ctx)
+	if err != nil {
+		return fmt.Errorf("up: %w", err)
+	}
+
+	for _, r := range res {
+		slog.Info("migration applied", "migration", r.Source.Path, "duration", r.Duration)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS orders (
+    id serial PRIMARY KEY NOT NULL,
+
+    uid bigint NOT NULL,
+    username varchar(32),
+    first_name varchar(64),
+    last_name varchar(64),
+
+    key_amount smallint NOT NULL,
+    price int NOT NULL,
+
+    status varchar(32) NOT NULL,
+    backend varchar(32) NOT NULL DEFAULT 'outline',
+
+    closed_at timestamptz,
+    created_at timestamptz NOT NULL,
+    expires_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS access_keys (
+    id varchar(64) PRIMARY KEY NOT NULL,
+    name varchar(32) NOT NULL,
+    order_id int NOT NULL REFERENCES orders (id) ON DELETE RESTRICT,
+    url text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS access_keys_order_id_idx ON access_keys (order_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS access_keys;
+DROP TABLE IF EXISTS orders;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS admins (
+    uid bigint PRIMARY KEY NOT NULL,
+    username varchar(32),
+    role varchar(16) NOT NULL,
+    created_at timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS admin_messages (
+    order_id int NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    chat_id bigint NOT NULL,
+    message_id int NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS admin_messages_order_id_idx ON admin_messages (order_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS admin_messages;
+DROP TABLE IF EXISTS admins;
+-- +goose StatementEnd
@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS promos (
+    code varchar(32) PRIMARY KEY NOT NULL,
+
+    percent int NOT NULL,
+    amount int NOT NULL,
+    days int NOT NULL,
+
+    max_uses int NOT NULL,
+    uses int NOT NULL DEFAULT 0,
+    expires_at timestamptz,
+    first_order_only boolean NOT NULL,
+    disabled boolean NOT NULL DEFAULT false,
+
+    created_at timestamptz NOT NULL
+);
+
+ALTER TABLE orders
+    ADD COLUMN promo_code varchar(32);
+ALTER TABLE orders
+    ADD COLUMN discount int NOT NULL DEFAULT 0;
+ALTER TABLE orders
+    ADD COLUMN bonus_days int NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE orders
+    DROP COLUMN bonus_days;
+ALTER TABLE orders
+    DROP COLUMN discount;
+ALTER TABLE orders
+    DROP COLUMN promo_code;
+
+DROP TABLE IF EXISTS promos;
+-- +goose StatementEnd
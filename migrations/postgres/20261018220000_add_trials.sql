@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS trials (
+    uid bigint PRIMARY KEY NOT NULL,
+    order_id int NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    created_at timestamptz NOT NULL,
+    reminded_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS trials_order_id_idx ON trials (order_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS trials;
+-- +goose StatementEnd
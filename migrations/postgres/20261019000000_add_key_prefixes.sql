@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS key_prefixes (
+    backend varchar(16) PRIMARY KEY NOT NULL,
+    prefix varchar(255) NOT NULL,
+    updated_at timestamptz NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS key_prefixes;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS users (
+    uid bigint PRIMARY KEY NOT NULL,
+    created_at timestamptz NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO users (uid, created_at)
+SELECT uid, MIN(created_at) FROM (
+    SELECT uid, created_at FROM orders
+    UNION ALL
+    SELECT uid, joined_at FROM members
+    UNION ALL
+    SELECT uid, created_at FROM trials
+) u
+GROUP BY uid;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS users;
+-- +goose StatementEnd
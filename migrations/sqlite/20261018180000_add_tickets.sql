@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS tickets (
+    id integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+
+    uid bigint NOT NULL,
+    username varchar(32),
+    first_name varchar(64),
+    last_name varchar(64),
+
+    status varchar(16) NOT NULL,
+
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS tickets_uid_idx ON tickets (uid);
+
+CREATE TABLE IF NOT EXISTS ticket_messages (
+    id integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+    ticket_id int NOT NULL REFERENCES tickets (id) ON DELETE CASCADE,
+    from_admin boolean NOT NULL,
+    text text NOT NULL,
+    created_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ticket_messages_ticket_id_idx ON ticket_messages (ticket_id);
+
+CREATE TABLE IF NOT EXISTS ticket_relays (
+    ticket_id int NOT NULL REFERENCES tickets (id) ON DELETE CASCADE,
+    chat_id bigint NOT NULL,
+    message_id int NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ticket_relays_message_idx ON ticket_relays (chat_id, message_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ticket_relays;
+DROP TABLE IF EXISTS ticket_messages;
+DROP TABLE IF EXISTS tickets;
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS invites (
+    code varchar(32) PRIMARY KEY NOT NULL,
+    created_by bigint NOT NULL,
+    created_at timestamp NOT NULL,
+    used_by bigint,
+    used_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS invites_created_by_idx ON invites (created_by);
+
+CREATE TABLE IF NOT EXISTS members (
+    uid bigint PRIMARY KEY NOT NULL,
+    username varchar(32),
+    invited_by bigint NOT NULL,
+    joined_at timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS join_requests (
+    uid bigint PRIMARY KEY NOT NULL,
+    username varchar(32),
+    first_name varchar(64),
+    last_name varchar(64),
+    status varchar(16) NOT NULL,
+    created_at timestamp NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS join_requests;
+DROP TABLE IF EXISTS members;
+DROP TABLE IF EXISTS invites;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS referrals (
+    uid bigint PRIMARY KEY NOT NULL,
+    referrer_id bigint NOT NULL,
+    created_at timestamp NOT NULL,
+    rewarded_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS referrals_referrer_id_idx ON referrals (referrer_id);
+
+CREATE TABLE IF NOT EXISTS ledger (
+    id integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+    uid bigint NOT NULL,
+    amount int NOT NULL,
+    kind varchar(16) NOT NULL,
+    order_id int,
+    created_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ledger_uid_idx ON ledger (uid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ledger;
+DROP TABLE IF EXISTS referrals;
+-- +goose StatementEnd
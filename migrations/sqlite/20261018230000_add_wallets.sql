@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS topups (
+    id integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+    uid bigint NOT NULL,
+    username varchar(32),
+    first_name varchar(64),
+    last_name varchar(64),
+    amount int NOT NULL,
+    status varchar(16) NOT NULL,
+    created_at timestamp NOT NULL,
+    closed_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS topups_uid_idx ON topups (uid);
+
+CREATE TABLE IF NOT EXISTS auto_renewals (
+    uid bigint PRIMARY KEY NOT NULL,
+    enabled_at timestamp NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS auto_renewals;
+DROP TABLE IF EXISTS topups;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- wireguard client configs with private keys were stored as key urls, they were already sent to users
+UPDATE access_keys SET url = 'redacted'
+WHERE order_id IN (SELECT id FROM orders WHERE backend = 'wireguard');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- redacted configs can't be restored
+SELECT 1;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE invites
+    ADD COLUMN slot int NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE
+    invites
+SET
+    slot = (
+        SELECT
+            count(*)
+        FROM
+            invites i
+        WHERE
+            i.created_by = invites.created_by
+            AND (i.created_at < invites.created_at
+                OR (i.created_at = invites.created_at AND i.code < invites.code)));
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS invites_created_by_slot_idx ON invites (created_by, slot);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS invites_created_by_slot_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE invites
+    DROP COLUMN slot;
+-- +goose StatementEnd